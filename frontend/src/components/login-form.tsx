@@ -0,0 +1,58 @@
+import { type FormEvent, useState } from "react";
+import { ApiService } from "../services/api";
+import { Button } from "./button";
+import styles from "./login-form.module.css";
+
+export default function LoginForm() {
+	const [username, setUsername] = useState("");
+	const [password, setPassword] = useState("");
+	const [submitting, setSubmitting] = useState(false);
+	const [error, setError] = useState<string | null>(null);
+
+	const handleSubmit = async (e: FormEvent<HTMLFormElement>) => {
+		e.preventDefault();
+		setSubmitting(true);
+		setError(null);
+		try {
+			await ApiService.login(username, password);
+		} catch {
+			setError("Invalid username or password");
+		} finally {
+			setSubmitting(false);
+		}
+	};
+
+	return (
+		<div className={styles.container}>
+			<form className={styles.form} onSubmit={handleSubmit}>
+				<h1>
+					B<sup>3</sup>
+				</h1>
+				<label>
+					Username
+					<input
+						type="text"
+						autoComplete="username"
+						value={username}
+						onChange={(e) => setUsername(e.target.value)}
+						required
+					/>
+				</label>
+				<label>
+					Password
+					<input
+						type="password"
+						autoComplete="current-password"
+						value={password}
+						onChange={(e) => setPassword(e.target.value)}
+						required
+					/>
+				</label>
+				{error && <div className={styles.error}>{error}</div>}
+				<Button type="submit" disabled={submitting}>
+					{submitting ? "..." : "Log in"}
+				</Button>
+			</form>
+		</div>
+	);
+}
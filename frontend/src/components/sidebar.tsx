@@ -2,6 +2,7 @@ import { Link } from "@tanstack/react-router";
 import { useEffect, useRef, useState } from "react";
 import { useLockStatus } from "../hooks/useLockStatus";
 import { useRides } from "../hooks/useRides";
+import { ApiService } from "../services/api";
 import { getEffectiveEndTime } from "../utils/rideUtils";
 import { Button } from "./button";
 import styles from "./sidebar.module.css";
@@ -59,6 +60,14 @@ export default function Sidebar() {
 				<h1>
 					B<sup>3</sup>
 				</h1>
+				<Button
+					variant="ghost"
+					size="sm"
+					onClick={() => ApiService.logout()}
+					className={styles.logoutButton}
+				>
+					Log out
+				</Button>
 			</div>
 			<hr />
 			<div className={styles.lockSection}>
@@ -0,0 +1,9 @@
+import { useSyncExternalStore } from "react";
+import { getSessionToken, subscribeSession } from "../services/session";
+
+/**
+ * The current session token, or null when logged out
+ */
+export function useSession(): string | null {
+	return useSyncExternalStore(subscribeSession, getSessionToken);
+}
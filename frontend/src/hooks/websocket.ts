@@ -17,7 +17,14 @@ export interface UseWebSocketResult {
 	readyState: ReadyStateString;
 }
 
-export function useWebSocket(url: string): UseWebSocketResult {
+/**
+ * Connect to a WebSocket. getTicket, if given, is called on every connect for a
+ * single-use ticket, which is sent as the ticket query parameter.
+ */
+export function useWebSocket(
+	url: string,
+	getTicket?: () => Promise<string>,
+): UseWebSocketResult {
 	const socketRef = useRef<WebSocket | null>(null);
 	const [lastMessage, setLastMessage] = useState<string | null>(null);
 	const [readyState, setReadyState] = useState<ReadyStateString>("CLOSED");
@@ -38,31 +45,49 @@ export function useWebSocket(url: string): UseWebSocketResult {
 	);
 
 	useEffect(() => {
-		const socket = new WebSocket(url);
-		socketRef.current = socket;
-		setReadyState(ReadyState[socket.readyState]);
+		let socket: WebSocket | null = null;
+		let cancelled = false;
 
-		const handleOpen = (): void => setReadyState(ReadyState[socket.readyState]);
+		const handleOpen = (e: Event): void =>
+			setReadyState(ReadyState[(e.target as WebSocket).readyState]);
 		const handleMessage = (e: MessageEvent): void =>
 			setLastMessage(e.data as string);
-		const handleClose = (): void =>
-			setReadyState(ReadyState[socket.readyState]);
+		const handleClose = (e: CloseEvent): void =>
+			setReadyState(ReadyState[(e.target as WebSocket).readyState]);
 		const handleError = (e: Event): void =>
 			console.error("[WebSocket error]", e);
 
-		socket.addEventListener("open", handleOpen);
-		socket.addEventListener("message", handleMessage);
-		socket.addEventListener("close", handleClose);
-		socket.addEventListener("error", handleError);
+		const connect = async (): Promise<void> => {
+			let connectUrl = url;
+			if (getTicket) {
+				const ticket = await getTicket();
+				connectUrl = `${url}?ticket=${encodeURIComponent(ticket)}`;
+			}
+			if (cancelled) return;
+
+			socket = new WebSocket(connectUrl);
+			socketRef.current = socket;
+			setReadyState(ReadyState[socket.readyState]);
+
+			socket.addEventListener("open", handleOpen);
+			socket.addEventListener("message", handleMessage);
+			socket.addEventListener("close", handleClose);
+			socket.addEventListener("error", handleError);
+		};
+		connect().catch((err) =>
+			console.error("[useWebSocket] failed to connect:", err),
+		);
 
 		return () => {
+			cancelled = true;
+			if (!socket) return;
 			socket.removeEventListener("open", handleOpen);
 			socket.removeEventListener("message", handleMessage);
 			socket.removeEventListener("close", handleClose);
 			socket.removeEventListener("error", handleError);
 			socket.close();
 		};
-	}, [url]);
+	}, [url, getTicket]);
 
 	return { sendMessage, lastMessage, readyState };
 }
@@ -2,14 +2,18 @@ import type React from "react";
 import type { ReactNode } from "react";
 import { WSContext } from "../contexts/ws-context";
 import { useWebSocket } from "../hooks/websocket";
+import { ApiService } from "../services/api";
 
 interface WSProviderProps {
 	url: string;
 	children: ReactNode;
 }
 
+// Called on every connect, as each ticket only opens one connection
+const getTicket = () => ApiService.getWebSocketTicket();
+
 export const WSProvider: React.FC<WSProviderProps> = ({ url, children }) => {
-	const ws = useWebSocket(url);
+	const ws = useWebSocket(url, getTicket);
 	return <WSContext.Provider value={ws}>{children}</WSContext.Provider>;
 };
 export { WSContext };
@@ -1,6 +1,8 @@
 import { Outlet, createFileRoute } from "@tanstack/react-router";
 import { APIProvider } from "@vis.gl/react-google-maps";
+import LoginForm from "../components/login-form";
 import Sidebar from "../components/sidebar";
+import { useSession } from "../hooks/useSession";
 import { LatLngProvider } from "../providers/LatLngProvider";
 import { WSProvider } from "../providers/ws";
 import styles from "./rides.module.css";
@@ -10,11 +12,16 @@ export const Route = createFileRoute("/rides")({
 });
 
 function RouteComponent() {
+	const session = useSession();
 	const wsUrl = import.meta.env.VITE_API_BASE
 		? `ws${import.meta.env.VITE_API_BASE}/ws`
 		: "ws://localhost:8080/ws";
 	const gmapsApiKey = import.meta.env.VITE_GOOGLE_MAPS_API_KEY;
 
+	if (!session) {
+		return <LoginForm />;
+	}
+
 	return (
 		<WSProvider url={wsUrl}>
 			<div className={styles.container}>
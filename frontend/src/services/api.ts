@@ -1,4 +1,5 @@
-import type { RideDetail, RideSummary } from "../types";
+import type { LoginResponse, RideDetail, RideSummary } from "../types";
+import { clearSession, getSessionToken, setSessionToken } from "./session";
 
 const API_BASE_URL = import.meta.env.VITE_API_BASE
 	? `http${import.meta.env.VITE_API_BASE}/api`
@@ -10,19 +11,28 @@ export class ApiService {
 		options?: RequestInit,
 	): Promise<T> {
 		const url = `${API_BASE_URL}${endpoint}`;
+		const token = getSessionToken();
 
 		try {
 			const response = await fetch(url, {
+				...options,
 				headers: {
 					"Content-Type": "application/json",
+					...(token ? { Authorization: `Bearer ${token}` } : {}),
 					...options?.headers,
 				},
-				...options,
 			});
 
+			if (response.status === 401) {
+				// The session expired or was ended elsewhere, so show the login form again
+				clearSession();
+			}
 			if (!response.ok) {
 				throw new Error(`HTTP error! status: ${response.status}`);
 			}
+			if (response.status === 204) {
+				return undefined as T;
+			}
 
 			return await response.json();
 		} catch (error) {
@@ -31,6 +41,44 @@ export class ApiService {
 		}
 	}
 
+	/**
+	 * Log in and keep the session token for the following requests
+	 * @param username - The account's username
+	 * @param password - The account's password
+	 */
+	static async login(username: string, password: string): Promise<void> {
+		const response = await this.request<LoginResponse>("/auth/login", {
+			method: "POST",
+			body: JSON.stringify({ username, password }),
+		});
+		setSessionToken(response.token);
+	}
+
+	/**
+	 * End the session on the server and forget it. The session is forgotten even if the
+	 * server can't be reached.
+	 */
+	static async logout(): Promise<void> {
+		try {
+			await this.request<void>("/auth/logout", { method: "POST" });
+		} catch {
+			// Already logged
+		} finally {
+			clearSession();
+		}
+	}
+
+	/**
+	 * Get a single-use ticket for opening the WebSocket, which can't send the session token
+	 */
+	static async getWebSocketTicket(): Promise<string> {
+		const response = await this.request<{ ticket: string }>(
+			"/auth/ws-ticket",
+			{ method: "POST" },
+		);
+		return response.ticket;
+	}
+
 	/**
 	 * Get all rides summary
 	 * @param page - Page number (1-based)
@@ -0,0 +1,39 @@
+// Session token from POST /api/auth/login. It is kept in sessionStorage, so it is gone
+// when the tab is closed, and is never part of the build.
+const STORAGE_KEY = "b3.session";
+
+type Listener = () => void;
+
+const listeners = new Set<Listener>();
+
+export function getSessionToken(): string | null {
+	return sessionStorage.getItem(STORAGE_KEY);
+}
+
+export function setSessionToken(token: string): void {
+	sessionStorage.setItem(STORAGE_KEY, token);
+	notify();
+}
+
+/**
+ * Forget the session, e.g. after logging out or when the server answers 401
+ */
+export function clearSession(): void {
+	if (getSessionToken() === null) return;
+	sessionStorage.removeItem(STORAGE_KEY);
+	notify();
+}
+
+/**
+ * Subscribe to logins and logouts, for useSyncExternalStore
+ */
+export function subscribeSession(listener: Listener): () => void {
+	listeners.add(listener);
+	return () => listeners.delete(listener);
+}
+
+function notify(): void {
+	for (const listener of listeners) {
+		listener();
+	}
+}
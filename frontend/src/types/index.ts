@@ -26,6 +26,18 @@ export interface RideDetail {
 	positions: Position[];
 }
 
+export interface User {
+	id: number;
+	username: string;
+	is_admin: boolean;
+}
+
+export interface LoginResponse {
+	token: string;
+	expires_at: string; // ISO 8601 format
+	user: User;
+}
+
 export interface WebSocketMessage {
 	type:
 		| "current_location"
@@ -0,0 +1,117 @@
+package alerts
+
+import (
+	"b3/server/models"
+	"b3/server/notify"
+	"fmt"
+	"log"
+	"time"
+)
+
+// notifyContacts sends msg to every contact of the device's owner receiving alerts of the
+// alert's type, one delivery record each. Devices without an owner go to the contacts
+// without one. It assumes m.mu is held.
+func (m *Manager) notifyContacts(alert models.Alert, msg notify.Message) {
+	ownerID, err := m.store.GetDeviceOwner(alert.DeviceID)
+	if err != nil {
+		log.Printf("Alerts: failed to look up the owner of %s for alert %d: %v", alert.DeviceID, alert.ID, err)
+		m.record(alert.ID, models.AlertEventNotifyFailed, "contacts: "+err.Error())
+		return
+	}
+	contacts, err := m.store.GetContacts()
+	if err != nil {
+		log.Printf("Alerts: failed to load contacts for alert %d: %v", alert.ID, err)
+		m.record(alert.ID, models.AlertEventNotifyFailed, "contacts: "+err.Error())
+		return
+	}
+	now := time.Now().UTC()
+	for _, contact := range contacts {
+		if contact.OwnerID != ownerID || !contact.Receives(alert.Type) {
+			continue
+		}
+		delivery := models.AlertDelivery{
+			AlertID:       alert.ID,
+			ContactID:     contact.ID,
+			ContactName:   contact.Name,
+			Channel:       contact.Channel,
+			Address:       contact.Address,
+			Subject:       msg.Subject,
+			Body:          msg.Body,
+			Status:        models.DeliveryPending,
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		}
+		id, err := m.store.CreateAlertDelivery(delivery)
+		if err != nil {
+			log.Printf("Alerts: failed to record delivery of alert %d to contact %d: %v", alert.ID, contact.ID, err)
+			continue
+		}
+		delivery.ID = id
+		m.deliver(delivery)
+	}
+}
+
+// deliver sends a delivery in the background, retrying with a doubling wait until it is
+// sent or out of attempts. Retries stop when the manager closes and pick up again on the
+// next Start.
+func (m *Manager) deliver(delivery models.AlertDelivery) {
+	m.sending.Add(1)
+	go func() {
+		defer m.sending.Done()
+		for delivery.Status == models.DeliveryPending {
+			if wait := time.Until(delivery.NextAttemptAt); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-m.stop:
+					return
+				}
+			}
+			m.attempt(&delivery)
+		}
+	}()
+}
+
+// attempt makes one try at sending a delivery and saves the outcome.
+func (m *Manager) attempt(delivery *models.AlertDelivery) {
+	err := m.dispatcher.Deliver(delivery.Channel, delivery.Address, notify.Message{Subject: delivery.Subject, Body: delivery.Body})
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.NextAttemptAt = time.Time{}
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliverySent
+		delivery.LastError = ""
+		delivery.SentAt = now
+		log.Printf("Alerts: delivered alert %d to contact %q by %s.", delivery.AlertID, delivery.ContactName, delivery.Channel)
+		m.record(delivery.AlertID, models.AlertEventNotified, "contact "+delivery.ContactName)
+	case delivery.Attempts >= m.opts.DeliveryAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+		log.Printf("Alerts: giving up on delivering alert %d to contact %q after %d attempts: %v", delivery.AlertID, delivery.ContactName, delivery.Attempts, err)
+		m.record(delivery.AlertID, models.AlertEventNotifyFailed, fmt.Sprintf("contact %s: %v", delivery.ContactName, err))
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(m.opts.DeliveryRetry << (delivery.Attempts - 1))
+		log.Printf("Alerts: failed to deliver alert %d to contact %q (attempt %d/%d), retrying at %s: %v", delivery.AlertID, delivery.ContactName,
+			delivery.Attempts, m.opts.DeliveryAttempts, delivery.NextAttemptAt.Format(time.RFC3339), err)
+	}
+	if err := m.store.UpdateAlertDelivery(*delivery); err != nil {
+		log.Printf("Alerts: failed to save delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// resumeDeliveries picks up the deliveries a previous run left unsent.
+func (m *Manager) resumeDeliveries() {
+	deliveries, err := m.store.GetPendingAlertDeliveries()
+	if err != nil {
+		log.Printf("Alerts: failed to load pending deliveries: %v", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		m.deliver(delivery)
+	}
+	if len(deliveries) > 0 {
+		log.Printf("Alerts: resuming %d pending contact deliveries.", len(deliveries))
+	}
+}
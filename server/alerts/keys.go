@@ -0,0 +1,26 @@
+package alerts
+
+import (
+	"b3/server/models"
+	"fmt"
+)
+
+// CrashKey groups crash alerts by device. Devices repeat CRASH_DETECTED until they are
+// reset, and all of those messages belong to one alert until it is resolved.
+func CrashKey(deviceID string) string {
+	return "crash:" + deviceID
+}
+
+// TheftKey groups theft alerts by theft incident, or by device if the incident could not be opened.
+func TheftKey(deviceID string, incidentID int64) string {
+	if incidentID == 0 {
+		return "theft:device:" + deviceID
+	}
+	return fmt.Sprintf("theft:%d", incidentID)
+}
+
+// GeofenceKey groups the crossings of one boundary in one direction by one device, so a
+// bike parked on the edge of a zone doesn't flood phones.
+func GeofenceKey(event models.GeofenceEvent) string {
+	return fmt.Sprintf("geofence:%d:%s:%s", event.GeofenceID, event.DeviceID, event.Type)
+}
@@ -0,0 +1,445 @@
+// Package alerts turns crash, theft and geofence triggers into alert records, so one
+// incident sends one notification instead of one per message, repeats are held back for
+// a cooldown and alerts nobody acknowledges are sent again.
+package alerts
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/notify"
+	"b3/server/ws"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrAlertClosed is returned when changing an alert that is already resolved or cancelled.
+	ErrAlertClosed = errors.New("alert is already resolved or cancelled")
+	// ErrAlertPending is returned when acknowledging an alert nobody has been notified of yet.
+	ErrAlertPending = errors.New("alert is still pending")
+	// ErrAlertNotPending is returned when cancelling an alert whose cancellation window has passed.
+	ErrAlertNotPending = errors.New("alert is no longer pending")
+)
+
+// Trigger is one report of something worth alerting about.
+type Trigger struct {
+	Type      string // models.AlertCrash, models.AlertTheft or models.AlertGeofence
+	DeviceID  string
+	Key       string // Identifies the incident, triggers sharing it are merged into one alert
+	Title     string
+	Message   string
+	Latitude  float64
+	Longitude float64
+}
+
+// Options controls how often an alert is sent.
+type Options struct {
+	Cooldown           time.Duration // Repeats within this time of the last notification are recorded but not sent
+	EscalationInterval time.Duration // Resend open alerts this long after their last notification, 0 disables escalation
+	MaxEscalations     int           // Stop resending after this many escalations
+	CrashCancelWindow  time.Duration // Crash alerts stay pending this long so the rider can cancel them, 0 sends them right away
+	PromptChannels     []string      // Names of the notification channels asking the rider whether they are OK
+	DeliveryAttempts   int           // Tries per contact before a delivery is given up
+	DeliveryRetry      time.Duration // Wait before retrying a failed delivery, doubled for each retry after the first
+}
+
+// OptionsFromConfig reads the alert settings from the app config.
+func OptionsFromConfig(cfg config.Config) Options {
+	return Options{
+		Cooldown:           time.Duration(max(cfg.AlertCooldownSecs, 0)) * time.Second,
+		EscalationInterval: time.Duration(max(cfg.AlertEscalationSecs, 0)) * time.Second,
+		MaxEscalations:     max(cfg.AlertMaxEscalations, 0),
+		CrashCancelWindow:  time.Duration(max(cfg.CrashCancelWindowSecs, 0)) * time.Second,
+		PromptChannels:     cfg.CrashPromptChannels,
+		DeliveryAttempts:   max(cfg.ContactMaxAttempts, 1),
+		DeliveryRetry:      time.Duration(max(cfg.ContactRetrySecs, 1)) * time.Second,
+	}
+}
+
+// Store is the part of the database the manager uses: alerts, the contacts they are
+// delivered to and who owns the devices they are about.
+type Store interface {
+	database.AlertStore
+	database.ContactStore
+	GetDeviceOwner(deviceID string) (int64, error)
+}
+
+// Manager is the single path every alert source goes through. Alerts that are not
+// resolved are kept in memory by key, in sync with the store.
+type Manager struct {
+	store      Store
+	hub        *ws.Hub
+	opts       Options
+	notifiers  []notify.Notifier
+	prompts    []notify.Notifier
+	dispatcher *notify.Dispatcher // Delivers to emergency contacts
+
+	mu     sync.Mutex
+	active map[string]*models.Alert // Pending, open and acknowledged alerts by key
+	timers map[int64]*time.Timer    // Cancellation deadlines of pending alerts by ID
+
+	sending   sync.WaitGroup
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewManager loads the alerts a previous run left unresolved. Notifications are sent
+// through every one of notifiers and to the contacts in the store, the crash prompt
+// through the ones named in opts.PromptChannels. State changes are broadcast on hub.
+func NewManager(store Store, hub *ws.Hub, opts Options, notifiers []notify.Notifier) (*Manager, error) {
+	var prompts []notify.Notifier
+	for _, name := range opts.PromptChannels {
+		notifier, ok := notify.Find(notifiers, name)
+		if !ok {
+			return nil, fmt.Errorf("crash prompt channel %q is not a configured notification channel", name)
+		}
+		prompts = append(prompts, notifier)
+	}
+	alerts, err := store.GetAlerts("", true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load unresolved alerts: %w", err)
+	}
+	m := &Manager{
+		store:      store,
+		hub:        hub,
+		opts:       opts,
+		notifiers:  notifiers,
+		prompts:    prompts,
+		dispatcher: notify.NewDispatcher(notifiers),
+		active:     make(map[string]*models.Alert),
+		timers:     make(map[int64]*time.Timer),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i := range alerts {
+		m.active[alerts[i].Key] = &alerts[i]
+	}
+	return m, nil
+}
+
+// Start runs the background escalation loop, restarts the cancellation windows of
+// pending alerts and retries unsent contact deliveries. Pending alerts whose deadline
+// passed while the server was down are sent now.
+func (m *Manager) Start() {
+	m.mu.Lock()
+	for _, alert := range m.active {
+		if alert.Status == models.AlertPending {
+			m.schedule(*alert)
+		}
+	}
+	m.mu.Unlock()
+	m.resumeDeliveries()
+	go m.run()
+}
+
+// Close stops the escalation loop, the cancellation windows and delivery retries, and
+// waits for notifications being sent. Pending alerts and deliveries stay pending until
+// the next Start.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+		m.mu.Lock()
+		for id, timer := range m.timers {
+			timer.Stop()
+			delete(m.timers, id)
+		}
+		m.mu.Unlock()
+	})
+	m.sending.Wait()
+}
+
+// Raise records a trigger. It opens a new alert and notifies unless an unresolved alert
+// with the same key exists; that alert is then updated and only notified again if it is
+// still open and its cooldown has passed. New crash alerts are pending instead: the rider
+// is asked whether they are OK and nobody is notified until the cancellation window passes.
+func (m *Manager) Raise(trigger Trigger) (models.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	if alert, ok := m.active[trigger.Key]; ok {
+		updated := *alert
+		updated.TriggerCount++
+		updated.LastTriggeredAt = now
+		updated.Message = trigger.Message
+		updated.Latitude, updated.Longitude = trigger.Latitude, trigger.Longitude
+		send := updated.Status == models.AlertOpen && now.Sub(updated.LastNotifiedAt) >= m.opts.Cooldown
+		if send {
+			updated.NotificationCount++
+			updated.LastNotifiedAt = now
+		}
+		if err := m.store.UpdateAlert(updated); err != nil {
+			return models.Alert{}, err
+		}
+		*alert = updated
+		m.record(updated.ID, models.AlertEventTriggered, "")
+		if send {
+			m.send(updated, "")
+		} else {
+			log.Printf("Alerts: suppressed repeat %d of alert %d (%s) for %s.", updated.TriggerCount, updated.ID, updated.Status, updated.DeviceID)
+		}
+		return updated, nil
+	}
+
+	alert := models.Alert{
+		Type:            trigger.Type,
+		DeviceID:        trigger.DeviceID,
+		Key:             trigger.Key,
+		Status:          models.AlertOpen,
+		Title:           trigger.Title,
+		Message:         trigger.Message,
+		Latitude:        trigger.Latitude,
+		Longitude:       trigger.Longitude,
+		TriggerCount:    1,
+		OpenedAt:        now,
+		LastTriggeredAt: now,
+	}
+	pending := trigger.Type == models.AlertCrash && m.opts.CrashCancelWindow > 0
+	if pending {
+		alert.Status = models.AlertPending
+		alert.CancelDeadline = now.Add(m.opts.CrashCancelWindow)
+	} else {
+		alert.NotificationCount = 1
+		alert.LastNotifiedAt = now
+	}
+	id, err := m.store.CreateAlert(alert)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	alert.ID = id
+	m.active[alert.Key] = &alert
+	m.record(alert.ID, models.AlertEventOpened, "")
+	if pending {
+		log.Printf("Alerts: opened pending %s alert %d for %s, sending it at %s unless cancelled.", alert.Type, alert.ID, alert.DeviceID, alert.CancelDeadline.Format(time.RFC3339))
+		m.schedule(alert)
+		m.prompt(alert)
+		m.hub.BroadcastAlert("ALERT_PENDING", alert)
+		return alert, nil
+	}
+	log.Printf("Alerts: opened %s alert %d for %s.", alert.Type, alert.ID, alert.DeviceID)
+	m.send(alert, "")
+	m.hub.BroadcastAlert("ALERT_OPENED", alert)
+	return alert, nil
+}
+
+// Get returns an alert by its ID.
+func (m *Manager) Get(id int64) (models.Alert, error) {
+	alert, err := m.store.GetAlert(id)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	return *alert, nil
+}
+
+// Detail returns an alert with its timeline.
+func (m *Manager) Detail(id int64) (models.AlertDetail, error) {
+	alert, err := m.Get(id)
+	if err != nil {
+		return models.AlertDetail{}, err
+	}
+	timeline, err := m.store.GetAlertEvents(id)
+	if err != nil {
+		return models.AlertDetail{}, err
+	}
+	deliveries, err := m.store.GetAlertDeliveries(id)
+	if err != nil {
+		return models.AlertDetail{}, err
+	}
+	if timeline == nil {
+		timeline = []models.AlertEvent{}
+	}
+	if deliveries == nil {
+		deliveries = []models.AlertDelivery{}
+	}
+	return models.AlertDetail{Alert: alert, Timeline: timeline, Deliveries: deliveries}, nil
+}
+
+// List returns alerts, newest first, optionally only unresolved ones and only those of one device.
+func (m *Manager) List(deviceID string, activeOnly bool) ([]models.Alert, error) {
+	return m.store.GetAlerts(deviceID, activeOnly)
+}
+
+// Acknowledge stops an open alert from escalating and from sending repeats. Acknowledging
+// an acknowledged alert changes nothing, a pending one is either cancelled or sent first.
+func (m *Manager) Acknowledge(id int64) (models.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alert, err := m.unresolved(id)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	if alert.Status == models.AlertAcknowledged {
+		return *alert, nil
+	}
+	if alert.Status == models.AlertPending {
+		return models.Alert{}, fmt.Errorf("alert with ID %d: %w", id, ErrAlertPending)
+	}
+	updated := *alert
+	updated.Status = models.AlertAcknowledged
+	updated.AcknowledgedAt = time.Now().UTC()
+	if err := m.store.UpdateAlert(updated); err != nil {
+		return models.Alert{}, err
+	}
+	*alert = updated
+	log.Printf("Alerts: alert %d acknowledged.", id)
+	m.record(id, models.AlertEventAcknowledged, "")
+	m.hub.BroadcastAlert("ALERT_ACKNOWLEDGED", updated)
+	return updated, nil
+}
+
+// Resolve closes an alert. The next trigger with its key opens a new alert. Resolving a
+// pending alert closes it without notifying anyone.
+func (m *Manager) Resolve(id int64) (models.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alert, err := m.unresolved(id)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	return m.resolve(alert)
+}
+
+// ResolveKey closes the unresolved alert with the given key, if there is one, e.g. when
+// the theft incident it was raised for is resolved.
+func (m *Manager) ResolveKey(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alert, ok := m.active[key]
+	if !ok {
+		return nil
+	}
+	_, err := m.resolve(alert)
+	return err
+}
+
+// resolve closes an unresolved alert. It assumes m.mu is held.
+func (m *Manager) resolve(alert *models.Alert) (models.Alert, error) {
+	updated := *alert
+	updated.Status = models.AlertResolved
+	updated.ResolvedAt = time.Now().UTC()
+	if err := m.store.UpdateAlert(updated); err != nil {
+		return models.Alert{}, err
+	}
+	delete(m.active, updated.Key)
+	m.stopTimer(updated.ID)
+	log.Printf("Alerts: alert %d resolved.", updated.ID)
+	m.record(updated.ID, models.AlertEventResolved, "")
+	m.hub.BroadcastAlert("ALERT_RESOLVED", updated)
+	return updated, nil
+}
+
+// unresolved returns the in-memory copy of an unresolved alert. It assumes m.mu is held.
+func (m *Manager) unresolved(id int64) (*models.Alert, error) {
+	for _, alert := range m.active {
+		if alert.ID == id {
+			return alert, nil
+		}
+	}
+	// Not active, so it is either closed or does not exist
+	if _, err := m.store.GetAlert(id); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("alert with ID %d: %w", id, ErrAlertClosed)
+}
+
+func (m *Manager) run() {
+	defer close(m.done)
+	if m.opts.EscalationInterval == 0 || m.opts.MaxEscalations == 0 {
+		<-m.stop
+		return
+	}
+
+	// Check a few times per interval so escalations are not sent much later than due
+	ticker := time.NewTicker(min(max(m.opts.EscalationInterval/4, time.Second), 30*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.escalate()
+		}
+	}
+}
+
+// escalate resends open alerts whose last notification is older than the escalation
+// interval. Geofence alerts are informational and never escalate.
+func (m *Manager) escalate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, alert := range m.active {
+		if alert.Status != models.AlertOpen || alert.Type == models.AlertGeofence ||
+			alert.EscalationLevel >= m.opts.MaxEscalations || now.Sub(alert.LastNotifiedAt) < m.opts.EscalationInterval {
+			continue
+		}
+		updated := *alert
+		updated.EscalationLevel++
+		updated.NotificationCount++
+		updated.LastNotifiedAt = now
+		if err := m.store.UpdateAlert(updated); err != nil {
+			log.Printf("Alerts: failed to escalate alert %d: %v", alert.ID, err)
+			continue
+		}
+		*alert = updated
+		log.Printf("Alerts: escalating unacknowledged alert %d (%d/%d).", updated.ID, updated.EscalationLevel, m.opts.MaxEscalations)
+		m.record(updated.ID, models.AlertEventEscalated, fmt.Sprintf("%d/%d", updated.EscalationLevel, m.opts.MaxEscalations))
+		m.send(updated, fmt.Sprintf("UNACKNOWLEDGED (%d/%d): ", updated.EscalationLevel, m.opts.MaxEscalations))
+	}
+}
+
+// send delivers an alert's notification through the channels and to the contacts in the
+// background so slow ones don't hold up the caller, and records how it went in the
+// alert's timeline. It assumes m.mu is held.
+func (m *Manager) send(alert models.Alert, prefix string) {
+	subject := prefix + alert.Title
+	msg := notify.Message{
+		Subject: subject,
+		Body:    fmt.Sprintf("%s\n\n%s\n\nAlert #%d, acknowledge it to stop reminders.", subject, alert.Message, alert.ID),
+	}
+	if len(m.notifiers) == 0 {
+		m.record(alert.ID, models.AlertEventNotifySkipped, "no notification channels configured")
+		m.notifyContacts(alert, msg)
+		return
+	}
+	m.sending.Add(1)
+	go func() {
+		defer m.sending.Done()
+		if err := notify.Broadcast(m.notifiers, msg); err != nil {
+			log.Printf("Alerts: failed to send alert %d: %v", alert.ID, err)
+			m.record(alert.ID, models.AlertEventNotifyFailed, err.Error())
+			return
+		}
+		m.record(alert.ID, models.AlertEventNotified, channelNames(m.notifiers))
+	}()
+	m.notifyContacts(alert, msg)
+}
+
+// record adds a step to an alert's timeline. A failure is only logged, the alert itself
+// has already changed.
+func (m *Manager) record(alertID int64, eventType, detail string) {
+	event := models.AlertEvent{AlertID: alertID, Type: eventType, Detail: detail, Timestamp: time.Now().UTC()}
+	if err := m.store.AddAlertEvent(event); err != nil {
+		log.Printf("Alerts: failed to record %s event of alert %d: %v", eventType, alertID, err)
+	}
+}
+
+// channelNames lists notifiers for a timeline event.
+func channelNames(notifiers []notify.Notifier) string {
+	names := make([]string, len(notifiers))
+	for i, notifier := range notifiers {
+		names[i] = notifier.Name()
+	}
+	return strings.Join(names, ", ")
+}
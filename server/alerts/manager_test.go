@@ -0,0 +1,109 @@
+package alerts
+
+import (
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/notify"
+	"b3/server/ws"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// fakeNotifier pretends to send messages, failing them with err if it is set.
+type fakeNotifier struct {
+	name string
+	err  error
+}
+
+func (f *fakeNotifier) Name() string { return f.name }
+
+func (f *fakeNotifier) Type() string { return notify.TypePush }
+
+func (f *fakeNotifier) Send(notify.Message) error { return f.err }
+
+// TestRaiseRecordsNotification checks the timeline event an alert gets depending on the
+// notification channels it is sent through.
+func TestRaiseRecordsNotification(t *testing.T) {
+	tests := []struct {
+		name       string
+		notifiers  []notify.Notifier
+		wantEvent  string
+		wantDetail string
+	}{
+		{"no channels", nil, models.AlertEventNotifySkipped, "no notification channels configured"},
+		{"sent", []notify.Notifier{&fakeNotifier{name: "phone"}, &fakeNotifier{name: "mail"}}, models.AlertEventNotified, "phone, mail"},
+		{"failed", []notify.Notifier{&fakeNotifier{name: "phone", err: errors.New("unreachable")}}, models.AlertEventNotifyFailed, "phone: unreachable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryStore()
+			m, err := NewManager(store, ws.NewHub(), Options{DeliveryAttempts: 1}, tt.notifiers)
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			m.Start()
+			alert, err := m.Raise(Trigger{Type: models.AlertTheft, DeviceID: "bike1", Key: "theft:1", Title: "Theft", Message: "bike1 moved"})
+			if err != nil {
+				t.Fatalf("Raise: %v", err)
+			}
+			m.Close() // Waits for notifications to be sent
+
+			events, err := store.GetAlertEvents(alert.ID)
+			if err != nil {
+				t.Fatalf("GetAlertEvents: %v", err)
+			}
+			var types []string
+			for _, event := range events {
+				types = append(types, event.Type)
+				if event.Type == tt.wantEvent && event.Detail != tt.wantDetail {
+					t.Errorf("%s detail %q, want %q", event.Type, event.Detail, tt.wantDetail)
+				}
+			}
+			if want := []string{models.AlertEventOpened, tt.wantEvent}; !slices.Equal(types, want) {
+				t.Errorf("timeline %v, want %v", types, want)
+			}
+		})
+	}
+}
+
+// TestContactsOfOwner checks that an alert only reaches the contacts of its device's owner.
+func TestContactsOfOwner(t *testing.T) {
+	store := database.NewMemoryStore()
+	if err := store.SetDeviceOwner("bike1", 1); err != nil {
+		t.Fatalf("SetDeviceOwner: %v", err)
+	}
+	for _, contact := range []models.Contact{
+		{OwnerID: 1, Name: "owner's", Channel: models.ContactEmail, Address: "a@example.com", AlertTypes: []string{models.AlertTheft}},
+		{OwnerID: 1, Name: "crash only", Channel: models.ContactEmail, Address: "b@example.com", AlertTypes: []string{models.AlertCrash}},
+		{OwnerID: 2, Name: "other user's", Channel: models.ContactEmail, Address: "c@example.com", AlertTypes: []string{models.AlertTheft}},
+		{Name: "without owner", Channel: models.ContactEmail, Address: "d@example.com", AlertTypes: []string{models.AlertTheft}},
+	} {
+		if _, err := store.CreateContact(contact); err != nil {
+			t.Fatalf("CreateContact: %v", err)
+		}
+	}
+
+	m, err := NewManager(store, ws.NewHub(), Options{DeliveryAttempts: 1}, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	m.Start()
+	alert, err := m.Raise(Trigger{Type: models.AlertTheft, DeviceID: "bike1", Key: "theft:1", Title: "Theft", Message: "bike1 moved"})
+	if err != nil {
+		t.Fatalf("Raise: %v", err)
+	}
+	m.Close()
+
+	deliveries, err := store.GetAlertDeliveries(alert.ID)
+	if err != nil {
+		t.Fatalf("GetAlertDeliveries: %v", err)
+	}
+	var names []string
+	for _, delivery := range deliveries {
+		names = append(names, delivery.ContactName)
+	}
+	if want := []string{"owner's"}; !slices.Equal(names, want) {
+		t.Errorf("delivered to %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,140 @@
+package alerts
+
+import (
+	"b3/server/models"
+	"b3/server/notify"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Cancel calls off a pending alert before anyone is notified. by says who cancelled it,
+// e.g. "api" or "device", and ends up in the timeline.
+func (m *Manager) Cancel(id int64, by string) (models.Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alert, err := m.unresolved(id)
+	if err != nil {
+		return models.Alert{}, err
+	}
+	if alert.Status != models.AlertPending {
+		return models.Alert{}, fmt.Errorf("alert with ID %d: %w", id, ErrAlertNotPending)
+	}
+	return m.cancel(alert, by)
+}
+
+// CancelKey calls off the pending alert with the given key, e.g. when the rider resets a
+// device that reported a crash. It returns false if there is no unresolved alert with the key.
+func (m *Manager) CancelKey(key, by string) (models.Alert, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alert, ok := m.active[key]
+	if !ok {
+		return models.Alert{}, false, nil
+	}
+	if alert.Status != models.AlertPending {
+		return models.Alert{}, true, fmt.Errorf("alert with ID %d: %w", alert.ID, ErrAlertNotPending)
+	}
+	cancelled, err := m.cancel(alert, by)
+	return cancelled, true, err
+}
+
+// cancel closes a pending alert. It assumes m.mu is held.
+func (m *Manager) cancel(alert *models.Alert, by string) (models.Alert, error) {
+	updated := *alert
+	updated.Status = models.AlertCancelled
+	updated.ResolvedAt = time.Now().UTC()
+	if err := m.store.UpdateAlert(updated); err != nil {
+		return models.Alert{}, err
+	}
+	delete(m.active, updated.Key)
+	m.stopTimer(updated.ID)
+	log.Printf("Alerts: pending alert %d cancelled by %s.", updated.ID, by)
+	m.record(updated.ID, models.AlertEventCancelled, by)
+	m.hub.BroadcastAlert("ALERT_CANCELLED", updated)
+	return updated, nil
+}
+
+// schedule sends a pending alert when its cancellation window passes. It assumes m.mu is held.
+func (m *Manager) schedule(alert models.Alert) {
+	m.stopTimer(alert.ID)
+	id := alert.ID
+	m.timers[id] = time.AfterFunc(time.Until(alert.CancelDeadline), func() { m.expire(id) })
+}
+
+// stopTimer stops the cancellation window of an alert, if it has one. It assumes m.mu is held.
+func (m *Manager) stopTimer(id int64) {
+	if timer, ok := m.timers[id]; ok {
+		timer.Stop()
+		delete(m.timers, id)
+	}
+}
+
+// expire opens a pending alert nobody cancelled and notifies.
+func (m *Manager) expire(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.timers, id)
+	var alert *models.Alert
+	for _, active := range m.active {
+		if active.ID == id {
+			alert = active
+			break
+		}
+	}
+	// Cancelled or resolved just before the timer fired
+	if alert == nil || alert.Status != models.AlertPending {
+		return
+	}
+
+	now := time.Now().UTC()
+	updated := *alert
+	updated.Status = models.AlertOpen
+	updated.NotificationCount++
+	updated.LastNotifiedAt = now
+	if err := m.store.UpdateAlert(updated); err != nil {
+		// Try again shortly rather than never notifying
+		log.Printf("Alerts: failed to open pending alert %d, retrying: %v", id, err)
+		m.timers[id] = time.AfterFunc(10*time.Second, func() { m.expire(id) })
+		return
+	}
+	*alert = updated
+	log.Printf("Alerts: pending alert %d was not cancelled, sending it.", id)
+	m.record(id, models.AlertEventExpired, "")
+	m.send(updated, "")
+	m.hub.BroadcastAlert("ALERT_OPENED", updated)
+}
+
+// prompt asks the rider whether they are OK through the prompt channels. The WebSocket
+// prompt is the ALERT_PENDING broadcast. It assumes m.mu is held.
+func (m *Manager) prompt(alert models.Alert) {
+	channels := []string{"websocket"}
+	for _, notifier := range m.prompts {
+		channels = append(channels, notifier.Name())
+	}
+	m.record(alert.ID, models.AlertEventPrompted, strings.Join(channels, ", "))
+	if len(m.prompts) == 0 {
+		return
+	}
+
+	msg := notify.Message{
+		Subject: fmt.Sprintf("Are you OK? Crash detected on %s", alert.DeviceID),
+		Body: fmt.Sprintf("Are you OK? A crash was detected on %s.\n\n"+
+			"If you are, cancel alert #%d before %s (POST /api/alerts/%d/cancel or reset the device). "+
+			"Otherwise it is sent to your notification channels.",
+			alert.DeviceID, alert.ID, alert.CancelDeadline.Format("15:04:05 MST"), alert.ID),
+	}
+	prompts := m.prompts
+	m.sending.Add(1)
+	go func() {
+		defer m.sending.Done()
+		if err := notify.Broadcast(prompts, msg); err != nil {
+			log.Printf("Alerts: failed to prompt for alert %d: %v", alert.ID, err)
+			m.record(alert.ID, models.AlertEventNotifyFailed, "prompt: "+err.Error())
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"b3/server/alerts"
+	"b3/server/database"
+	"b3/server/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterAlertHandlers sets up the alert API routes. Users only see the alerts of their own devices.
+func RegisterAlertHandlers(router *gin.RouterGroup, alertManager *alerts.Manager) {
+	router.GET("/alerts", func(c *gin.Context) { listAlertsHandler(c, alertManager) })
+	router.GET("/alerts/:id", func(c *gin.Context) { getAlertHandler(c, alertManager) })
+	router.POST("/alerts/:id/ack", func(c *gin.Context) {
+		updateAlertHandler(c, alertManager, "acknowledge", alertManager.Acknowledge)
+	})
+	router.POST("/alerts/:id/resolve", func(c *gin.Context) {
+		updateAlertHandler(c, alertManager, "resolve", alertManager.Resolve)
+	})
+	router.POST("/alerts/:id/cancel", func(c *gin.Context) {
+		updateAlertHandler(c, alertManager, "cancel", func(id int64) (models.Alert, error) { return alertManager.Cancel(id, "api") })
+	})
+}
+
+// listAlertsHandler returns alerts, newest first. The optional device_id, type and status
+// ("pending", "open", "acknowledged", "resolved", "cancelled" or "active" for the first
+// three) query parameters filter them.
+func listAlertsHandler(c *gin.Context, alertManager *alerts.Manager) {
+	status := c.Query("status")
+	switch status {
+	case "", "active", models.AlertPending, models.AlertOpen, models.AlertAcknowledged, models.AlertResolved, models.AlertCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status. Must be 'pending', 'open', 'acknowledged', 'resolved', 'cancelled' or 'active'"})
+		return
+	}
+	alertType := c.Query("type")
+
+	activeOnly := status != "" && status != models.AlertResolved && status != models.AlertCancelled
+	list, err := alertManager.List(c.Query("device_id"), activeOnly)
+	if err != nil {
+		log.Printf("Error fetching alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve alerts"})
+		return
+	}
+	filtered := []models.Alert{}
+	for _, alert := range list {
+		if (status == "" || status == "active" || alert.Status == status) && (alertType == "" || alert.Type == alertType) &&
+			canAccessDevice(c, alert.DeviceID) {
+			filtered = append(filtered, alert)
+		}
+	}
+	c.JSON(http.StatusOK, filtered)
+}
+
+// getAlertHandler returns an alert with its timeline.
+func getAlertHandler(c *gin.Context, alertManager *alerts.Manager) {
+	id, ok := parseAlertID(c)
+	if !ok {
+		return
+	}
+	alert, err := alertManager.Detail(id)
+	if err == nil && !canAccessDevice(c, alert.DeviceID) {
+		err = fmt.Errorf("alert with ID %d: %w", id, database.ErrAlertNotFound)
+	}
+	if err != nil {
+		respondAlertError(c, id, "retrieve", err)
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+// updateAlertHandler acknowledges, resolves or cancels an alert with update. Resolved and
+// cancelled alerts can't be changed any more.
+func updateAlertHandler(c *gin.Context, alertManager *alerts.Manager, action string, update func(id int64) (models.Alert, error)) {
+	id, ok := parseAlertID(c)
+	if !ok {
+		return
+	}
+	alert, err := alertManager.Get(id)
+	if err == nil && !canAccessDevice(c, alert.DeviceID) {
+		err = fmt.Errorf("alert with ID %d: %w", id, database.ErrAlertNotFound)
+	}
+	if err == nil {
+		alert, err = update(id)
+	}
+	if err != nil {
+		respondAlertError(c, id, action, err)
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+func parseAlertID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID format"})
+		return 0, false
+	}
+	return id, true
+}
+
+// respondAlertError maps an error from the alert manager to a response.
+func respondAlertError(c *gin.Context, id int64, action string, err error) {
+	switch {
+	case errors.Is(err, database.ErrAlertNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+	case errors.Is(err, alerts.ErrAlertClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Alert is already resolved or cancelled"})
+	case errors.Is(err, alerts.ErrAlertPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Alert is still pending, cancel it or wait until it is sent"})
+	case errors.Is(err, alerts.ErrAlertNotPending):
+		c.JSON(http.StatusConflict, gin.H{"error": "Alert is no longer pending, it has already been sent"})
+	default:
+		log.Printf("Error trying to %s alert %d: %v", action, id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " alert"})
+	}
+}
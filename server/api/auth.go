@@ -0,0 +1,288 @@
+package api
+
+import (
+	"b3/server/auth"
+	"b3/server/database"
+	"b3/server/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterAuthHandlers sets up login, accounts and API tokens. It applies the auth
+// middleware itself: logging in is public and creating the first account is too.
+func RegisterAuthHandlers(router *gin.RouterGroup, service *auth.Service) {
+	router.POST("/auth/login", func(c *gin.Context) { loginHandler(c, service) })
+	router.POST("/users", auth.Optional(service), func(c *gin.Context) { createUserHandler(c, service) })
+
+	authenticated := router.Group("", auth.Middleware(service))
+	authenticated.POST("/auth/logout", func(c *gin.Context) { logoutHandler(c, service) })
+	authenticated.GET("/auth/me", meHandler)
+	authenticated.POST("/auth/ws-ticket", func(c *gin.Context) { wsTicketHandler(c, service) })
+	authenticated.GET("/tokens", func(c *gin.Context) { listTokensHandler(c, service) })
+	authenticated.POST("/tokens", func(c *gin.Context) { createTokenHandler(c, service) })
+	authenticated.DELETE("/tokens/:id", func(c *gin.Context) { revokeTokenHandler(c, service) })
+
+	admin := authenticated.Group("", auth.RequireAdmin())
+	admin.GET("/users", func(c *gin.Context) { listUsersHandler(c, service) })
+	admin.PUT("/devices/:id/owner", func(c *gin.Context) { setDeviceOwnerHandler(c, service) })
+}
+
+// LoginRequest is the body of a login or account creation.
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// LoginResponse carries a new session token. Send it as "Authorization: Bearer <token>".
+type LoginResponse struct {
+	Token     string      `json:"token"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	User      models.User `json:"user"`
+}
+
+// CreateUserRequest is the body of an account creation.
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	IsAdmin  bool   `json:"is_admin"` // Ignored for the first account, which is always an admin
+}
+
+// CreateTokenRequest is the body of an API token creation.
+type CreateTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes"`          // e.g. ["rides:read", "lock:write"]
+	ExpiresInDays int      `json:"expires_in_days"` // 0 for a token that never expires
+}
+
+// CreateTokenResponse carries a new API token. The token is only ever shown here.
+type CreateTokenResponse struct {
+	models.AuthToken
+	Token string `json:"token"`
+}
+
+// DeviceOwnerRequest is the body of a device owner change.
+type DeviceOwnerRequest struct {
+	UserID int64 `json:"user_id"` // 0 removes the owner
+}
+
+func loginHandler(c *gin.Context, service *auth.Service) {
+	var request LoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	secret, token, user, err := service.Login(request.Username, request.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			return
+		}
+		log.Printf("Error logging in %q: %v", request.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	c.JSON(http.StatusOK, LoginResponse{Token: secret, ExpiresAt: token.ExpiresAt, User: *user})
+}
+
+// logoutHandler ends the session, or revokes the API token, the request was made with.
+func logoutHandler(c *gin.Context, service *auth.Service) {
+	principal := auth.FromContext(c)
+	if err := service.Logout(principal.Token.ID); err != nil && !errors.Is(err, database.ErrAuthTokenNotFound) {
+		log.Printf("Error logging out token %d: %v", principal.Token.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// meHandler returns the user making the request and how they authenticated.
+func meHandler(c *gin.Context) {
+	principal := auth.FromContext(c)
+	c.JSON(http.StatusOK, gin.H{"user": principal.User, "token": principal.Token})
+}
+
+// wsTicketHandler issues a single-use ticket for opening the WebSocket as /ws?ticket=<ticket>.
+// The connection gets the same access as the request.
+func wsTicketHandler(c *gin.Context, service *auth.Service) {
+	ticket, expiresAt, err := service.IssueTicket(auth.FromContext(c))
+	if err != nil {
+		log.Printf("Error issuing WebSocket ticket: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"ticket": ticket, "expires_at": expiresAt})
+}
+
+// createUserHandler creates an account. Anyone may create the first one, which is made an
+// admin; after that only admins may.
+func createUserHandler(c *gin.Context, service *auth.Service) {
+	var request CreateUserRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	if err := auth.ValidateUser(request.Username, request.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user", "details": err.Error()})
+		return
+	}
+
+	var user models.User
+	var err error
+	if principal := auth.FromContext(c); principal == nil {
+		// Without a login this can only be the first account
+		user, err = service.RegisterFirst(request.Username, request.Password)
+		if errors.Is(err, auth.ErrHasUsers) {
+			c.Header("WWW-Authenticate", `Bearer realm="b3"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+	} else if !principal.IsAdmin() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	} else {
+		user, err = service.Register(request.Username, request.Password, request.IsAdmin)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
+			return
+		}
+		log.Printf("Error creating user %q: %v", request.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+func listUsersHandler(c *gin.Context, service *auth.Service) {
+	users, err := service.Users()
+	if err != nil {
+		log.Printf("Error fetching users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// setDeviceOwnerHandler gives a device to a user. The device's rides without an owner go
+// to the new owner as well.
+func setDeviceOwnerHandler(c *gin.Context, service *auth.Service) {
+	deviceID := strings.TrimSpace(c.Param("id"))
+	var request DeviceOwnerRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	if err := service.SetDeviceOwner(deviceID, request.UserID); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error setting owner of device %s: %v", deviceID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set device owner"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"device_id": deviceID, "user_id": request.UserID})
+}
+
+// listTokensHandler returns the API tokens of the user making the request, without the tokens themselves.
+func listTokensHandler(c *gin.Context, service *auth.Service) {
+	principal := auth.FromContext(c)
+	tokens, err := service.APITokens(principal.User.ID)
+	if err != nil {
+		log.Printf("Error fetching API tokens of user %d: %v", principal.User.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tokens"})
+		return
+	}
+	if tokens == nil {
+		tokens = []models.AuthToken{}
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// createTokenHandler creates a scoped API token for scripts. Only sessions may create
+// tokens, so a leaked token can't be used to mint more.
+func createTokenHandler(c *gin.Context, service *auth.Service) {
+	principal := auth.FromContext(c)
+	if principal.Token.Kind != models.TokenSession {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API tokens can only be created after logging in"})
+		return
+	}
+	var request CreateTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return
+	}
+	scopes, err := auth.ValidateScopes(request.Scopes, principal.User.IsAdmin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token", "details": err.Error()})
+		return
+	}
+	if request.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token", "details": "expires_in_days must not be negative"})
+		return
+	}
+	var expiresAt time.Time
+	if request.ExpiresInDays > 0 {
+		expiresAt = time.Now().UTC().AddDate(0, 0, request.ExpiresInDays)
+	}
+
+	secret, token, err := service.CreateAPIToken(principal.User.ID, strings.TrimSpace(request.Name), scopes, expiresAt)
+	if err != nil {
+		log.Printf("Error creating API token for user %d: %v", principal.User.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	c.JSON(http.StatusCreated, CreateTokenResponse{AuthToken: token, Token: secret})
+}
+
+func revokeTokenHandler(c *gin.Context, service *auth.Service) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID format"})
+		return
+	}
+	principal := auth.FromContext(c)
+	if err := service.RevokeAPIToken(principal.User.ID, id); err != nil {
+		if errors.Is(err, database.ErrAuthTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		log.Printf("Error revoking API token %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// requireDevice responds with 403 and returns false if the request may not access a device.
+func requireDevice(c *gin.Context, deviceID string) bool {
+	if principal := auth.FromContext(c); principal == nil || !principal.CanAccessDevice(deviceID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access to device " + deviceID + " denied"})
+		return false
+	}
+	return true
+}
+
+// canAccessDevice reports whether the request may access a device, for filtering lists and
+// hiding records of other users' devices.
+func canAccessDevice(c *gin.Context, deviceID string) bool {
+	principal := auth.FromContext(c)
+	return principal != nil && principal.CanAccessDevice(deviceID)
+}
+
+// canSeeRide reports whether the request may see a ride owned by ownerID. Rides of other
+// users are reported as not found rather than forbidden.
+func canSeeRide(c *gin.Context, ownerID int64) bool {
+	principal := auth.FromContext(c)
+	return principal != nil && principal.CanSeeRide(ownerID)
+}
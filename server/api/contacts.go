@@ -0,0 +1,194 @@
+package api
+
+import (
+	"b3/server/auth"
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/notify"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterContactHandlers sets up the emergency contact API routes. Users manage their own
+// contacts; admins see and change everyone's.
+func RegisterContactHandlers(router *gin.RouterGroup, store database.ContactStore, dispatcher *notify.Dispatcher) {
+	router.GET("/contacts", func(c *gin.Context) { listContactsHandler(c, store) })
+	router.POST("/contacts", func(c *gin.Context) { createContactHandler(c, store) })
+	router.GET("/contacts/:id", func(c *gin.Context) { getContactHandler(c, store) })
+	router.PUT("/contacts/:id", func(c *gin.Context) { updateContactHandler(c, store) })
+	router.DELETE("/contacts/:id", func(c *gin.Context) { deleteContactHandler(c, store) })
+	router.POST("/contacts/:id/test", func(c *gin.Context) { testContactHandler(c, store, dispatcher) })
+}
+
+// ContactRequest is the body of a contact create or update.
+type ContactRequest struct {
+	Name       string   `json:"name"`
+	Channel    string   `json:"channel"`     // "email", "sms" or "push"
+	Address    string   `json:"address"`     // Email address, phone number such as +14155550100, or push URL
+	AlertTypes []string `json:"alert_types"` // "crash", "theft" and/or "geofence", every type if empty
+}
+
+// listContactsHandler returns the contacts of the user making the request, or every
+// contact for admins, oldest first.
+func listContactsHandler(c *gin.Context, store database.ContactStore) {
+	contacts, err := store.GetContacts()
+	if err != nil {
+		log.Printf("Error fetching contacts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve contacts"})
+		return
+	}
+	principal := auth.FromContext(c)
+	visible := []models.Contact{}
+	for _, contact := range contacts {
+		if principal.IsAdmin() || contact.OwnerID == principal.User.ID {
+			visible = append(visible, contact)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+func createContactHandler(c *gin.Context, store database.ContactStore) {
+	contact, ok := bindContact(c, 0)
+	if !ok {
+		return
+	}
+	contact.OwnerID = auth.FromContext(c).User.ID
+	contact.CreatedAt = contact.UpdatedAt
+	id, err := store.CreateContact(contact)
+	if err != nil {
+		log.Printf("Error creating contact %q: %v", contact.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contact"})
+		return
+	}
+	contact.ID = id
+	c.JSON(http.StatusCreated, contact)
+}
+
+func getContactHandler(c *gin.Context, store database.ContactStore) {
+	contact, ok := loadContact(c, store)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, contact)
+}
+
+func updateContactHandler(c *gin.Context, store database.ContactStore) {
+	existing, ok := loadContact(c, store)
+	if !ok {
+		return
+	}
+	id := existing.ID
+	contact, ok := bindContact(c, id)
+	if !ok {
+		return
+	}
+	if err := store.UpdateContact(contact); err != nil {
+		respondContactError(c, id, "update", err)
+		return
+	}
+	updated, err := store.GetContact(id)
+	if err != nil {
+		respondContactError(c, id, "retrieve", err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func deleteContactHandler(c *gin.Context, store database.ContactStore) {
+	contact, ok := loadContact(c, store)
+	if !ok {
+		return
+	}
+	if err := store.DeleteContact(contact.ID); err != nil {
+		respondContactError(c, contact.ID, "delete", err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// testContactHandler sends a test message to a contact, once and without retries, and
+// reports how it went.
+func testContactHandler(c *gin.Context, store database.ContactStore, dispatcher *notify.Dispatcher) {
+	contact, ok := loadContact(c, store)
+	if !ok {
+		return
+	}
+	msg := notify.Message{
+		Subject: "B³ test notification",
+		Body:    "B³ test notification\n\nYou are set up as an emergency contact and will receive " + alertTypeList(contact.AlertTypes) + " alerts.",
+	}
+	if err := dispatcher.Deliver(contact.Channel, contact.Address, msg); err != nil {
+		c.JSON(http.StatusOK, gin.H{"sent": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sent": true})
+}
+
+// alertTypeList joins alert types for a sentence, e.g. "crash and theft".
+func alertTypeList(alertTypes []string) string {
+	switch len(alertTypes) {
+	case 0:
+		return "no"
+	case 1:
+		return alertTypes[0]
+	}
+	return strings.Join(alertTypes[:len(alertTypes)-1], ", ") + " and " + alertTypes[len(alertTypes)-1]
+}
+
+// loadContact fetches the contact named in the path, responding with 404 if it does not
+// exist or belongs to another user and the request is not made by an admin.
+func loadContact(c *gin.Context, store database.ContactStore) (*models.Contact, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID format"})
+		return nil, false
+	}
+	contact, err := store.GetContact(id)
+	if principal := auth.FromContext(c); err == nil && contact.OwnerID != principal.User.ID && !principal.IsAdmin() {
+		err = fmt.Errorf("contact with ID %d: %w", id, database.ErrContactNotFound)
+	}
+	if err != nil {
+		respondContactError(c, id, "retrieve", err)
+		return nil, false
+	}
+	return contact, true
+}
+
+// bindContact reads and validates a ContactRequest, responding with 400 if it is invalid.
+func bindContact(c *gin.Context, id int64) (models.Contact, bool) {
+	var request ContactRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return models.Contact{}, false
+	}
+	contact := models.Contact{
+		ID:         id,
+		Name:       request.Name,
+		Channel:    request.Channel,
+		Address:    request.Address,
+		AlertTypes: request.AlertTypes,
+		UpdatedAt:  time.Now().UTC(),
+	}
+	if err := notify.ValidateContact(&contact); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact", "details": err.Error()})
+		return models.Contact{}, false
+	}
+	return contact, true
+}
+
+// respondContactError maps an error from the store to a response.
+func respondContactError(c *gin.Context, id int64, action string, err error) {
+	if errors.Is(err, database.ErrContactNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contact not found"})
+		return
+	}
+	log.Printf("Error trying to %s contact %d: %v", action, id, err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to " + action + " contact"})
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"b3/server/database"
+	"b3/server/geofence"
+	"b3/server/models"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterGeofenceHandlers sets up the geofence API routes.
+func RegisterGeofenceHandlers(router *gin.RouterGroup, zones *geofence.Registry) {
+	router.GET("/geofences", func(c *gin.Context) { listGeofencesHandler(c, zones) })
+	router.POST("/geofences", func(c *gin.Context) { createGeofenceHandler(c, zones) })
+	router.GET("/geofences/:id", func(c *gin.Context) { getGeofenceHandler(c, zones) })
+	router.PUT("/geofences/:id", func(c *gin.Context) { updateGeofenceHandler(c, zones) })
+	router.DELETE("/geofences/:id", func(c *gin.Context) { deleteGeofenceHandler(c, zones) })
+	router.GET("/geofences/:id/events", func(c *gin.Context) { getGeofenceEventsHandler(c, zones) })
+}
+
+// GeofenceRequest is the body of a geofence create or update. Circles need center and
+// radius_meters, polygons need at least 3 vertices in polygon.
+type GeofenceRequest struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"` // "circle" or "polygon"
+	Center       *models.GeoPoint  `json:"center"`
+	RadiusMeters float64           `json:"radius_meters"`
+	Polygon      []models.GeoPoint `json:"polygon"`
+	Notify       bool              `json:"notify"` // Send a notification when a device enters or exits
+}
+
+func (r GeofenceRequest) geofence(id int64) models.Geofence {
+	return models.Geofence{
+		ID:           id,
+		Name:         r.Name,
+		Type:         r.Type,
+		Center:       r.Center,
+		RadiusMeters: r.RadiusMeters,
+		Polygon:      r.Polygon,
+		Notify:       r.Notify,
+	}
+}
+
+func listGeofencesHandler(c *gin.Context, zones *geofence.Registry) {
+	geofences := zones.List()
+	if geofences == nil {
+		geofences = []models.Geofence{}
+	}
+	c.JSON(http.StatusOK, geofences)
+}
+
+func createGeofenceHandler(c *gin.Context, zones *geofence.Registry) {
+	zone, ok := bindGeofence(c, 0)
+	if !ok {
+		return
+	}
+	created, err := zones.Create(zone)
+	if err != nil {
+		log.Printf("Error creating geofence %q: %v", zone.Name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create geofence"})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func getGeofenceHandler(c *gin.Context, zones *geofence.Registry) {
+	id, ok := parseGeofenceID(c)
+	if !ok {
+		return
+	}
+	zone, ok := zones.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		return
+	}
+	c.JSON(http.StatusOK, zone)
+}
+
+func updateGeofenceHandler(c *gin.Context, zones *geofence.Registry) {
+	id, ok := parseGeofenceID(c)
+	if !ok {
+		return
+	}
+	zone, ok := bindGeofence(c, id)
+	if !ok {
+		return
+	}
+	updated, err := zones.Update(zone)
+	if err != nil {
+		if errors.Is(err, database.ErrGeofenceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		} else {
+			log.Printf("Error updating geofence %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update geofence"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func deleteGeofenceHandler(c *gin.Context, zones *geofence.Registry) {
+	id, ok := parseGeofenceID(c)
+	if !ok {
+		return
+	}
+	if err := zones.Delete(id); err != nil {
+		if errors.Is(err, database.ErrGeofenceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		} else {
+			log.Printf("Error deleting geofence %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete geofence"})
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getGeofenceEventsHandler returns the most recent enter and exit events of a geofence,
+// newest first. The optional limit query parameter defaults to 50, at most 500.
+func getGeofenceEventsHandler(c *gin.Context, zones *geofence.Registry) {
+	id, ok := parseGeofenceID(c)
+	if !ok {
+		return
+	}
+	if _, ok := zones.Get(id); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	events, err := zones.Events(id, limit)
+	if err != nil {
+		log.Printf("Error fetching events of geofence %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve geofence events"})
+		return
+	}
+	if events == nil {
+		events = []models.GeofenceEvent{}
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+func parseGeofenceID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid geofence ID format"})
+		return 0, false
+	}
+	return id, true
+}
+
+// bindGeofence reads and validates a GeofenceRequest, responding with 400 if it is invalid.
+func bindGeofence(c *gin.Context, id int64) (models.Geofence, bool) {
+	var request GeofenceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+		return models.Geofence{}, false
+	}
+	zone := request.geofence(id)
+	if err := geofence.Validate(zone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid geofence", "details": err.Error()})
+		return models.Geofence{}, false
+	}
+	return zone, true
+}
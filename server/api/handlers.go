@@ -1,11 +1,17 @@
 package api
 
 import (
+	"b3/server/auth"
+	"b3/server/config"
 	"b3/server/database"
+	"b3/server/export"
 	"b3/server/models"
 	"b3/server/mqttsubscriber"
 	"b3/server/ride"
-	"database/sql"
+	"b3/server/simplify"
+	"bytes"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -14,23 +20,39 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// RegisterRideHandlers sets up the ride-related API routes.
-func RegisterRideHandlers(router *gin.RouterGroup, db *sql.DB) {
-	router.GET("/rides", func(c *gin.Context) { getRidesListHandler(c, db) })
-	router.GET("/rides/:id", func(c *gin.Context) { getRideDetailHandler(c, db) })
+// simplifiedRideCacheSize is the number of simplified ride versions kept in memory.
+const simplifiedRideCacheSize = 256
+
+// RegisterRideHandlers sets up the ride-related API routes. Users only see the rides of their
+// own devices, admins see every ride.
+func RegisterRideHandlers(router *gin.RouterGroup, store database.RideStore, appConfig config.Config) {
+	simplifiedRides := simplify.NewCache(simplifiedRideCacheSize)
+
+	router.GET("/rides", func(c *gin.Context) { getRidesListHandler(c, store) })
+	router.GET("/rides/:id", func(c *gin.Context) { getRideDetailHandler(c, store, appConfig, simplifiedRides) })
+	router.GET("/rides/:id/export", func(c *gin.Context) { exportRideHandler(c, store) })
+	router.GET("/rides/:id/events", func(c *gin.Context) { getRideEventsHandler(c, store) })
+}
+
+// RegisterLockHandlers sets up the lock-related API routes. Users can only lock and unlock
+// their own devices.
+func RegisterLockHandlers(router *gin.RouterGroup, fleet *ride.Fleet, shadow *mqttsubscriber.Client) {
+	router.POST("/setLockStatus", func(c *gin.Context) { setLockStatusHandler(c, fleet, shadow) })
+	router.GET("/getLockStatus", func(c *gin.Context) { getLockStatusHandler(c, fleet) })
+	router.GET("/lock/commands/:id", func(c *gin.Context) { getLockCommandHandler(c, fleet) })
 }
 
-// RegisterLockHandlers sets up the lock-related API routes.
-func RegisterLockHandlers(router *gin.RouterGroup, rideManager *ride.RideManager, publisher *mqttsubscriber.Publisher) {
-	router.POST("/setLockStatus", func(c *gin.Context) { setLockStatusHandler(c, rideManager, publisher) })
-	router.GET("/getLockStatus", func(c *gin.Context) { getLockStatusHandler(c, rideManager) })
+// RegisterDeviceHandlers sets up the device-related API routes. Users only see their own devices.
+func RegisterDeviceHandlers(router *gin.RouterGroup, fleet *ride.Fleet) {
+	router.GET("/devices", func(c *gin.Context) { getDevicesHandler(c, fleet) })
 }
 
-func getRidesListHandler(c *gin.Context, db *sql.DB) {
+func getRidesListHandler(c *gin.Context, store database.RideStore) {
 	// Parse pagination parameters
 	pageStr := c.DefaultQuery("page", "1")
 	limitStr := c.DefaultQuery("limit", "10")
-	dateStr := c.Query("date") // Optional date filter in YYYY-MM-DD format
+	dateStr := c.Query("date")       // Optional date filter in YYYY-MM-DD format
+	deviceID := c.Query("device_id") // Optional device filter
 
 	page, err := strconv.Atoi(pageStr)
 	if err != nil || page < 1 {
@@ -52,7 +74,15 @@ func getRidesListHandler(c *gin.Context, db *sql.DB) {
 		dateFilter = &parsedDate
 	}
 
-	rides, err := database.GetAllRidesSummaryWithPagination(db, page, limit, dateFilter)
+	// Users only see their own rides, admins every ride
+	ownerID := int64(-1) // Matches no ride
+	if principal := auth.FromContext(c); principal != nil && principal.IsAdmin() {
+		ownerID = 0
+	} else if principal != nil {
+		ownerID = principal.User.ID
+	}
+
+	rides, err := store.GetAllRidesSummaryWithPagination(page, limit, dateFilter, deviceID, ownerID)
 	if err != nil {
 		log.Printf("Error fetching ride summaries: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rides"})
@@ -64,7 +94,9 @@ func getRidesListHandler(c *gin.Context, db *sql.DB) {
 	c.JSON(http.StatusOK, rides)
 }
 
-func getRideDetailHandler(c *gin.Context, db *sql.DB) {
+// getRideDetailHandler returns a ride with its positions. The optional simplify (tolerance in meters),
+// method (dp or vw) and max_points query parameters reduce the number of positions returned.
+func getRideDetailHandler(c *gin.Context, store database.RideStore, appConfig config.Config, cache *simplify.Cache) {
 	idStr := c.Param("id")
 	rideID, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
@@ -72,9 +104,25 @@ func getRideDetailHandler(c *gin.Context, db *sql.DB) {
 		return
 	}
 
-	rideDetail, err := database.GetRideDetails(db, rideID)
+	opts, simplifyRequested, err := parseSimplifyOptions(c, appConfig)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cacheKey := simplify.CacheKey{RideID: rideID, Options: opts}
+	if simplifyRequested {
+		if cached, ok := cache.Get(cacheKey); ok && canSeeRide(c, cached.OwnerID) {
+			c.JSON(http.StatusOK, cached)
+			return
+		}
+	}
+
+	rideDetail, err := store.GetRideDetails(rideID)
+	if err == nil && !canSeeRide(c, rideDetail.OwnerID) {
+		err = fmt.Errorf("ride with ID %d: %w", rideID, database.ErrRideNotFound)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRideNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
 		} else {
 			log.Printf("Error fetching ride detail for ID %d: %v", rideID, err)
@@ -82,20 +130,147 @@ func getRideDetailHandler(c *gin.Context, db *sql.DB) {
 		}
 		return
 	}
+
+	if simplifyRequested {
+		rideDetail.OriginalPointCount = len(rideDetail.Positions)
+		rideDetail.Positions = simplify.Track(rideDetail.Positions, opts)
+		// Ongoing rides still gain points, and a ride that just ended may still have points
+		// in the position buffer. Its stats are stored once they are written, so only rides
+		// with stats are cached.
+		if rideDetail.Stats != nil {
+			cache.Put(cacheKey, rideDetail)
+		}
+	}
 	c.JSON(http.StatusOK, rideDetail)
 }
 
+// parseSimplifyOptions reads the simplification query parameters. The returned bool
+// is false if neither simplify nor max_points was given.
+func parseSimplifyOptions(c *gin.Context, appConfig config.Config) (simplify.Options, bool, error) {
+	opts := simplify.Options{MovingSpeedKnots: appConfig.MovingSpeedKnots}
+	toleranceStr := c.Query("simplify")
+	maxPointsStr := c.Query("max_points")
+	if toleranceStr == "" && maxPointsStr == "" {
+		return opts, false, nil
+	}
+
+	method, err := simplify.ParseMethod(c.Query("method"))
+	if err != nil {
+		return opts, false, err
+	}
+	opts.Method = method
+
+	if toleranceStr != "" {
+		tolerance, err := strconv.ParseFloat(toleranceStr, 64)
+		if err != nil || tolerance < 0 {
+			return opts, false, errors.New("Invalid simplify tolerance, must be a non-negative number of meters")
+		}
+		opts.ToleranceMeters = tolerance
+	}
+	if maxPointsStr != "" {
+		maxPoints, err := strconv.Atoi(maxPointsStr)
+		if err != nil || maxPoints < 2 {
+			return opts, false, errors.New("Invalid max_points, must be an integer of at least 2")
+		}
+		opts.MaxPoints = maxPoints
+	}
+	return opts, true, nil
+}
+
+func exportRideHandler(c *gin.Context, store database.RideStore) {
+	idStr := c.Param("id")
+	rideID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID format"})
+		return
+	}
+
+	format, err := export.ParseFormat(c.DefaultQuery("format", "gpx"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rideDetail, err := store.GetRideDetails(rideID)
+	if err == nil && !canSeeRide(c, rideDetail.OwnerID) {
+		err = fmt.Errorf("ride with ID %d: %w", rideID, database.ErrRideNotFound)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRideNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+		} else {
+			log.Printf("Error fetching ride %d for export: %v", rideID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ride details"})
+		}
+		return
+	}
+
+	// Encode into a buffer first so an encoding error can still produce a JSON error response
+	var buf bytes.Buffer
+	if err := export.Write(&buf, rideDetail, format); err != nil {
+		log.Printf("Error exporting ride %d as %s: %v", rideID, format, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export ride"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename(rideDetail, format)))
+	c.Data(http.StatusOK, format.ContentType(), buf.Bytes())
+}
+
+// getRideEventsHandler returns the crashes that happened during a ride, oldest first.
+func getRideEventsHandler(c *gin.Context, store database.RideStore) {
+	rideID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID format"})
+		return
+	}
+	rideDetail, err := store.GetRideDetails(rideID)
+	if err == nil && !canSeeRide(c, rideDetail.OwnerID) {
+		err = fmt.Errorf("ride with ID %d: %w", rideID, database.ErrRideNotFound)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRideNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+		} else {
+			log.Printf("Error fetching ride %d for its events: %v", rideID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ride details"})
+		}
+		return
+	}
+
+	events, err := store.GetRideCrashEvents(rideID)
+	if err != nil {
+		log.Printf("Error fetching crash events of ride %d: %v", rideID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ride events"})
+		return
+	}
+	if events == nil {
+		events = []models.CrashEvent{}
+	}
+	c.JSON(http.StatusOK, events)
+}
+
 // LockStatusRequest represents the request body for setting lock status
 type LockStatusRequest struct {
-	Status string `json:"status" binding:"required"`
+	DeviceID string `json:"device_id"` // Optional, defaults to the configured device
+	Status   string `json:"status" binding:"required"`
 }
 
 // LockStatusResponse represents the response for lock status operations
 type LockStatusResponse struct {
-	Status string `json:"status"`
+	DeviceID       string              `json:"device_id"`
+	Status         string              `json:"status"`
+	ReportedStatus string              `json:"reported_status,omitempty"` // Last status reported by the device
+	Command        *models.LockCommand `json:"command,omitempty"`         // Latest lock command and whether the device confirmed it
+}
+
+// DeviceResponse represents a known device in the devices list
+type DeviceResponse struct {
+	DeviceID   string `json:"device_id"`
+	LockStatus string `json:"lock_status"`
 }
 
-func setLockStatusHandler(c *gin.Context, rideManager *ride.RideManager, publisher *mqttsubscriber.Publisher) {
+func setLockStatusHandler(c *gin.Context, fleet *ride.Fleet, shadow *mqttsubscriber.Client) {
 	var request LockStatusRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
@@ -108,23 +283,82 @@ func setLockStatusHandler(c *gin.Context, rideManager *ride.RideManager, publish
 		return
 	}
 
-	// Update the lock status in the ride manager
+	deviceID := request.DeviceID
+	if deviceID == "" {
+		deviceID = fleet.DefaultDeviceID()
+	}
+	if !requireDevice(c, deviceID) {
+		return
+	}
+
+	// Publish the update to the IoT shadow; a rejected update leaves the lock status unchanged
+	if err := shadow.UpdateLockStatus(deviceID, request.Status); err != nil {
+		log.Printf("Failed to publish lock status to IoT shadow: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update IoT shadow", "details": err.Error()})
+		return
+	}
+
+	// Update the lock status in the device's ride manager
+	rideManager := fleet.Manager(deviceID)
 	rideManager.SetLockStatus(request.Status)
 
-	// Publish the update to the IoT shadow
-	if publisher != nil {
-		if err := publisher.UpdateLockStatus(request.Status); err != nil {
-			log.Printf("Failed to publish lock status to IoT shadow: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update IoT shadow"})
-			return
+	// The command stays pending until the device reports the new status
+	command := fleet.RequestLock(deviceID, request.Status)
+	log.Printf("Lock status for %s updated to: %s, command %d %s", deviceID, request.Status, command.ID, command.State)
+
+	statusCode := http.StatusAccepted
+	if command.State == models.LockCommandConfirmed {
+		statusCode = http.StatusOK
+	}
+	c.JSON(statusCode, LockStatusResponse{
+		DeviceID:       deviceID,
+		Status:         request.Status,
+		ReportedStatus: rideManager.ReportedLockStatus(),
+		Command:        &command,
+	})
+}
+
+func getLockStatusHandler(c *gin.Context, fleet *ride.Fleet) {
+	deviceID := c.DefaultQuery("device_id", fleet.DefaultDeviceID())
+	if !requireDevice(c, deviceID) {
+		return
+	}
+
+	// Devices that have not reported yet are unlocked until told otherwise
+	response := LockStatusResponse{DeviceID: deviceID, Status: "UNLOCKED"}
+	if rideManager, ok := fleet.Lookup(deviceID); ok {
+		response.Status = rideManager.GetLockStatus()
+		response.ReportedStatus = rideManager.ReportedLockStatus()
+		if command, ok := rideManager.LatestLockCommand(); ok {
+			response.Command = &command
 		}
 	}
+	c.JSON(http.StatusOK, response)
+}
+
+func getLockCommandHandler(c *gin.Context, fleet *ride.Fleet) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lock command ID format"})
+		return
+	}
 
-	log.Printf("Lock status updated to: %s", request.Status)
-	c.JSON(http.StatusOK, LockStatusResponse{Status: request.Status})
+	command, ok := fleet.LockCommand(id)
+	if !ok || !canAccessDevice(c, command.DeviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lock command not found"})
+		return
+	}
+	c.JSON(http.StatusOK, command)
 }
 
-func getLockStatusHandler(c *gin.Context, rideManager *ride.RideManager) {
-	status := rideManager.GetLockStatus()
-	c.JSON(http.StatusOK, LockStatusResponse{Status: status})
+func getDevicesHandler(c *gin.Context, fleet *ride.Fleet) {
+	devices := []DeviceResponse{}
+	for _, deviceID := range fleet.DeviceIDs() {
+		rideManager, ok := fleet.Lookup(deviceID)
+		if !ok || !canAccessDevice(c, deviceID) {
+			continue
+		}
+		devices = append(devices, DeviceResponse{DeviceID: deviceID, LockStatus: rideManager.GetLockStatus()})
+	}
+	c.JSON(http.StatusOK, devices)
 }
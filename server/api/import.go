@@ -0,0 +1,83 @@
+package api
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/importer"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterImportHandlers sets up the ride import API route.
+func RegisterImportHandlers(router *gin.RouterGroup, store database.RideStore, appConfig config.Config) {
+	router.POST("/rides/import", func(c *gin.Context) { importRidesHandler(c, store, appConfig) })
+}
+
+// ImportFileResult reports what happened to one uploaded file
+type ImportFileResult struct {
+	Filename       string           `json:"filename"`
+	Status         string           `json:"status"` // "imported", "duplicate" or "failed"
+	Ride           *importer.Result `json:"ride,omitempty"`
+	ExistingRideID int64            `json:"existing_ride_id,omitempty"`
+	Error          string           `json:"error,omitempty"`
+}
+
+// importRidesHandler accepts one or more GPX or FIT files in the multipart "file" field.
+// An optional "device_id" form field assigns the rides to a device.
+func importRidesHandler(c *gin.Context, store database.RideStore, appConfig config.Config) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form", "details": err.Error()})
+		return
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No files uploaded in the 'file' field"})
+		return
+	}
+
+	deviceID := c.DefaultPostForm("device_id", appConfig.DefaultDeviceID)
+	if !requireDevice(c, deviceID) {
+		return
+	}
+	results := make([]ImportFileResult, 0, len(files))
+	for _, fileHeader := range files {
+		result := ImportFileResult{Filename: fileHeader.Filename}
+
+		f, err := fileHeader.Open()
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		track, err := importer.Parse(fileHeader.Filename, f)
+		f.Close()
+		if err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		imported, err := importer.Import(store, appConfig, deviceID, track)
+		var dupErr *importer.DuplicateRideError
+		switch {
+		case errors.As(err, &dupErr):
+			result.Status = "duplicate"
+			result.ExistingRideID = dupErr.RideID
+		case err != nil:
+			log.Printf("Error importing %s: %v", fileHeader.Filename, err)
+			result.Status = "failed"
+			result.Error = err.Error()
+		default:
+			result.Status = "imported"
+			result.Ride = imported
+		}
+		results = append(results, result)
+	}
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"b3/server/alerts"
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/ride"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterIncidentHandlers sets up the theft incident API routes. Users only see the incidents of their own devices.
+func RegisterIncidentHandlers(router *gin.RouterGroup, store database.IncidentStore, fleet *ride.Fleet, alertManager *alerts.Manager) {
+	router.GET("/incidents", func(c *gin.Context) { listIncidentsHandler(c, store) })
+	router.GET("/incidents/:id", func(c *gin.Context) { getIncidentHandler(c, store) })
+	router.POST("/incidents/:id/resolve", func(c *gin.Context) { resolveIncidentHandler(c, store, fleet, alertManager) })
+}
+
+// ResolveIncidentRequest is the optional body of a resolve request
+type ResolveIncidentRequest struct {
+	Note string `json:"note"` // e.g. "Recovered by police"
+}
+
+// listIncidentsHandler returns theft incidents, newest first. The optional device_id and
+// status ("open" or "resolved") query parameters filter them.
+func listIncidentsHandler(c *gin.Context, store database.IncidentStore) {
+	status := c.Query("status")
+	if status != "" && status != models.IncidentOpen && status != models.IncidentResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status. Must be 'open' or 'resolved'"})
+		return
+	}
+
+	incidents, err := store.GetTheftIncidents(c.Query("device_id"), status == models.IncidentOpen)
+	if err != nil {
+		log.Printf("Error fetching theft incidents: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve theft incidents"})
+		return
+	}
+	filtered := []models.TheftIncident{}
+	for _, incident := range incidents {
+		if (status == "" || incident.Status == status) && canAccessDevice(c, incident.DeviceID) {
+			filtered = append(filtered, incident)
+		}
+	}
+	c.JSON(http.StatusOK, filtered)
+}
+
+func getIncidentHandler(c *gin.Context, store database.IncidentStore) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+	incident, ok := loadIncident(c, store, id)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, incident)
+}
+
+// resolveIncidentHandler closes an open theft incident and its alert. Incidents are never
+// closed automatically.
+func resolveIncidentHandler(c *gin.Context, store database.IncidentStore, fleet *ride.Fleet, alertManager *alerts.Manager) {
+	id, ok := parseIncidentID(c)
+	if !ok {
+		return
+	}
+	var request ResolveIncidentRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+	}
+
+	incident, ok := loadIncident(c, store, id)
+	if !ok {
+		return
+	}
+	if incident.Status != models.IncidentOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Theft incident is already resolved"})
+		return
+	}
+
+	if err := fleet.ResolveTheftIncident(incident.DeviceID, id, request.Note); err != nil {
+		log.Printf("Error resolving theft incident %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve theft incident"})
+		return
+	}
+	if err := alertManager.ResolveKey(alerts.TheftKey(incident.DeviceID, id)); err != nil {
+		log.Printf("Error resolving the alert of theft incident %d: %v", id, err)
+	}
+	if incident, ok = loadIncident(c, store, id); ok {
+		c.JSON(http.StatusOK, incident)
+	}
+}
+
+func parseIncidentID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid incident ID format"})
+		return 0, false
+	}
+	return id, true
+}
+
+// loadIncident fetches a theft incident with its positions, responding with an error if it can't.
+func loadIncident(c *gin.Context, store database.IncidentStore, id int64) (*models.TheftIncidentDetail, bool) {
+	incident, err := store.GetTheftIncident(id)
+	if err == nil && !canAccessDevice(c, incident.DeviceID) {
+		err = fmt.Errorf("theft incident with ID %d: %w", id, database.ErrIncidentNotFound)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrIncidentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Theft incident not found"})
+		} else {
+			log.Printf("Error fetching theft incident %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve theft incident"})
+		}
+		return nil, false
+	}
+	return incident, true
+}
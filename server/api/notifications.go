@@ -0,0 +1,64 @@
+package api
+
+import (
+	"b3/server/notify"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterNotificationHandlers sets up the routes for inspecting and testing notification channels.
+func RegisterNotificationHandlers(router *gin.RouterGroup, notifiers []notify.Notifier) {
+	router.GET("/notifications/channels", func(c *gin.Context) { listChannelsHandler(c, notifiers) })
+	router.POST("/notifications/test", func(c *gin.Context) { testChannelsHandler(c, notifiers) })
+}
+
+// ChannelResponse describes a configured notification channel
+type ChannelResponse struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "sns", "email" or "push"
+}
+
+// ChannelTestResult is the outcome of sending a test notification through one channel
+type ChannelTestResult struct {
+	ChannelResponse
+	Sent  bool   `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+func listChannelsHandler(c *gin.Context, notifiers []notify.Notifier) {
+	channels := []ChannelResponse{}
+	for _, notifier := range notifiers {
+		channels = append(channels, ChannelResponse{Name: notifier.Name(), Type: notifier.Type()})
+	}
+	c.JSON(http.StatusOK, channels)
+}
+
+// testChannelsHandler sends a test notification through every channel, or only the one
+// named by the channel query parameter, and reports how each went. It waits for every
+// channel, so it can take a while if a server is slow to answer.
+func testChannelsHandler(c *gin.Context, notifiers []notify.Notifier) {
+	targets := notifiers
+	if name := c.Query("channel"); name != "" {
+		notifier, ok := notify.Find(notifiers, name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification channel not found"})
+			return
+		}
+		targets = []notify.Notifier{notifier}
+	}
+
+	msg := notify.Message{
+		Subject: "B³ test notification",
+		Body:    "B³ test notification\n\nThis channel is set up correctly and will receive crash, theft and geofence alerts.",
+	}
+	results := []ChannelTestResult{}
+	for _, notifier := range targets {
+		result := ChannelTestResult{ChannelResponse: ChannelResponse{Name: notifier.Name(), Type: notifier.Type()}, Sent: true}
+		if err := notifier.Send(msg); err != nil {
+			result.Sent, result.Error = false, err.Error()
+		}
+		results = append(results, result)
+	}
+	c.JSON(http.StatusOK, results)
+}
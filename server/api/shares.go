@@ -0,0 +1,214 @@
+package api
+
+import (
+	"b3/server/auth"
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/share"
+	"b3/server/simplify"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterShareHandlers sets up the routes creating, listing and revoking ride share links.
+// Users can share and see the links of their own rides, admins of every ride.
+func RegisterShareHandlers(router *gin.RouterGroup, store database.RideStore, signer *share.Signer, appConfig config.Config) {
+	router.POST("/rides/:id/share", func(c *gin.Context) { createShareHandler(c, store, signer, appConfig) })
+	router.GET("/shares", func(c *gin.Context) { listSharesHandler(c, store, signer) })
+	router.DELETE("/shares/:id", func(c *gin.Context) { revokeShareHandler(c, store) })
+}
+
+// RegisterSharedRideHandlers sets up the public route serving shared rides. It needs no authentication.
+func RegisterSharedRideHandlers(router *gin.RouterGroup, store database.RideStore, signer *share.Signer, appConfig config.Config) {
+	router.GET("/shared/:token", func(c *gin.Context) { getSharedRideHandler(c, store, signer, appConfig) })
+}
+
+// ShareRequest is the optional body of a share link creation.
+type ShareRequest struct {
+	ExpiresInHours  int     `json:"expires_in_hours"`  // Defaults to share_ttl_hours
+	TrimStartMeters float64 `json:"trim_start_meters"` // Distance hidden at the start of the ride, e.g. to keep a home address private
+	TrimEndMeters   float64 `json:"trim_end_meters"`   // Distance hidden at the end of the ride
+}
+
+// ShareResponse is a share link with its token and the path it is served at.
+type ShareResponse struct {
+	models.RideShare
+	Token string `json:"token,omitempty"` // Left out once the link has expired or was revoked
+	URL   string `json:"url,omitempty"`
+}
+
+func shareResponse(signer *share.Signer, rideShare models.RideShare) ShareResponse {
+	response := ShareResponse{RideShare: rideShare}
+	if rideShare.Active(time.Now()) {
+		response.Token = signer.Token(rideShare)
+		response.URL = "/api/shared/" + response.Token
+	}
+	return response
+}
+
+// createShareHandler creates a share link for a completed ride.
+func createShareHandler(c *gin.Context, store database.RideStore, signer *share.Signer, appConfig config.Config) {
+	rideID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ride ID format"})
+		return
+	}
+	var request ShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body", "details": err.Error()})
+			return
+		}
+	}
+	if request.ExpiresInHours < 0 || request.TrimStartMeters < 0 || request.TrimEndMeters < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link", "details": "expires_in_hours, trim_start_meters and trim_end_meters must not be negative"})
+		return
+	}
+
+	rideDetail, err := store.GetRideDetails(rideID)
+	if err == nil && !canSeeRide(c, rideDetail.OwnerID) {
+		err = fmt.Errorf("ride with ID %d: %w", rideID, database.ErrRideNotFound)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrRideNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ride not found"})
+		} else {
+			log.Printf("Error fetching ride %d to share: %v", rideID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ride details"})
+		}
+		return
+	}
+	if rideDetail.EndTime.IsZero() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only completed rides can be shared"})
+		return
+	}
+
+	ttl := time.Duration(max(appConfig.ShareTTLHours, 1)) * time.Hour
+	if request.ExpiresInHours > 0 {
+		ttl = time.Duration(request.ExpiresInHours) * time.Hour
+	}
+	now := time.Now().UTC()
+	rideShare := models.RideShare{
+		RideID:          rideID,
+		UserID:          auth.FromContext(c).User.ID,
+		TrimStartMeters: request.TrimStartMeters,
+		TrimEndMeters:   request.TrimEndMeters,
+		CreatedAt:       now,
+		// Whole seconds, as carried by the token
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+	}
+	id, err := store.CreateRideShare(rideShare)
+	if err != nil {
+		log.Printf("Error sharing ride %d: %v", rideID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+	rideShare.ID = id
+	c.JSON(http.StatusCreated, shareResponse(signer, rideShare))
+}
+
+// listSharesHandler returns the share links of the user making the request, or every link
+// for admins, newest first. Links that still work include their token.
+func listSharesHandler(c *gin.Context, store database.ShareStore, signer *share.Signer) {
+	principal := auth.FromContext(c)
+	var userID int64
+	if !principal.IsAdmin() {
+		userID = principal.User.ID
+	}
+	shares, err := store.GetRideShares(userID)
+	if err != nil {
+		log.Printf("Error fetching share links: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve share links"})
+		return
+	}
+	responses := make([]ShareResponse, 0, len(shares))
+	for _, rideShare := range shares {
+		responses = append(responses, shareResponse(signer, rideShare))
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// revokeShareHandler stops a share link from working. The link stays in the list as revoked.
+func revokeShareHandler(c *gin.Context, store database.ShareStore) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID format"})
+		return
+	}
+	principal := auth.FromContext(c)
+	rideShare, err := store.GetRideShare(id)
+	if err == nil && rideShare.UserID != principal.User.ID && !principal.IsAdmin() {
+		err = fmt.Errorf("share link with ID %d: %w", id, database.ErrShareNotFound)
+	}
+	if err == nil {
+		err = store.RevokeRideShare(id, time.Now().UTC())
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrShareNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+			return
+		}
+		log.Printf("Error revoking share link %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getSharedRideHandler serves the read-only version of a shared ride. It takes the same
+// simplify, method and max_points query parameters as the ride detail.
+func getSharedRideHandler(c *gin.Context, store database.RideStore, signer *share.Signer, appConfig config.Config) {
+	// Links can be revoked at any time, so nothing along the way should keep a copy
+	c.Header("Cache-Control", "no-store")
+
+	id, _, err := signer.Parse(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	rideShare, err := store.GetRideShare(id)
+	if err != nil {
+		if errors.Is(err, database.ErrShareNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		} else {
+			log.Printf("Error fetching share link %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shared ride"})
+		}
+		return
+	}
+	if !rideShare.Active(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has expired or was revoked"})
+		return
+	}
+
+	opts, simplifyRequested, err := parseSimplifyOptions(c, appConfig)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rideDetail, err := store.GetRideDetails(rideShare.RideID)
+	if err != nil {
+		if errors.Is(err, database.ErrRideNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		} else {
+			log.Printf("Error fetching shared ride %d: %v", rideShare.RideID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve shared ride"})
+		}
+		return
+	}
+
+	view := share.View(rideDetail, *rideShare, appConfig)
+	if simplifyRequested {
+		view.OriginalPointCount = len(view.Positions)
+		view.Positions = simplify.Track(view.Positions, opts)
+	}
+	c.JSON(http.StatusOK, view)
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"b3/server/ingest"
+	"b3/server/mqttsubscriber"
+	"b3/server/writebuffer"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusResponse reports the health of the server's background components.
+type StatusResponse struct {
+	PositionBuffer writebuffer.Stats     `json:"position_buffer"`
+	MQTT           mqttsubscriber.Status `json:"mqtt"`
+	Ingest         ingest.Stats          `json:"ingest"`
+}
+
+// RegisterStatusHandlers sets up the server status API route.
+func RegisterStatusHandlers(router *gin.RouterGroup, positionBuffer *writebuffer.Buffer, mqttClient *mqttsubscriber.Client, sequencer *ingest.Sequencer) {
+	router.GET("/status", func(c *gin.Context) { getStatusHandler(c, positionBuffer, mqttClient, sequencer) })
+}
+
+func getStatusHandler(c *gin.Context, positionBuffer *writebuffer.Buffer, mqttClient *mqttsubscriber.Client, sequencer *ingest.Sequencer) {
+	c.JSON(http.StatusOK, StatusResponse{
+		PositionBuffer: positionBuffer.Stats(),
+		MQTT:           mqttClient.Status(),
+		Ingest:         sequencer.Stats(),
+	})
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"b3/server/auth"
+	"b3/server/database"
+	"b3/server/ws"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterWebSocketHandlers sets up the live event feed. The router must authenticate
+// requests with auth.WebSocket.
+func RegisterWebSocketHandlers(router *gin.RouterGroup, hub *ws.Hub, store database.IncidentStore) {
+	router.GET("/ws", func(c *gin.Context) { webSocketHandler(c, hub, store) })
+}
+
+// webSocketHandler opens a WebSocket connection, after checking that the user may follow
+// the device or theft incident it asks for.
+func webSocketHandler(c *gin.Context, hub *ws.Hub, store database.IncidentStore) {
+	if deviceID := c.Query("device_id"); deviceID != "" && !requireDevice(c, deviceID) {
+		return
+	}
+	if value := c.Query("incident_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid incident ID format"})
+			return
+		}
+		if _, ok := loadIncident(c, store, id); !ok {
+			return
+		}
+	}
+	ws.ServeWs(hub, auth.FromContext(c), c.Writer, c.Request)
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned by Login when the username or password is wrong.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrInvalidToken is returned by Authenticate when a token is unknown or has expired.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// ErrHasUsers is returned by RegisterFirst when an account already exists.
+var ErrHasUsers = errors.New("an account already exists")
+
+// Token prefixes tell sessions and API tokens apart at a glance, e.g. in a leaked log.
+const (
+	sessionPrefix  = "b3s_"
+	apiTokenPrefix = "b3t_"
+)
+
+// Scopes an API token can be limited to. Write scopes include reading.
+const (
+	ScopeRidesRead    = "rides:read"
+	ScopeRidesWrite   = "rides:write"
+	ScopeLockRead     = "lock:read"
+	ScopeLockWrite    = "lock:write"
+	ScopeDevicesRead  = "devices:read"
+	ScopeDevicesWrite = "devices:write"
+	ScopeAlertsRead   = "alerts:read"
+	ScopeAlertsWrite  = "alerts:write"
+	ScopeAdmin        = "admin" // Everything, only for tokens of admins
+)
+
+// Scopes lists every scope an API token can be given.
+var Scopes = []string{
+	ScopeRidesRead, ScopeRidesWrite, ScopeLockRead, ScopeLockWrite, ScopeDevicesRead,
+	ScopeDevicesWrite, ScopeAlertsRead, ScopeAlertsWrite, ScopeAdmin,
+}
+
+// usernamePattern is what a username may look like.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// minPasswordLength is the shortest password accepted.
+const minPasswordLength = 8
+
+// Service manages users, their login sessions and API tokens.
+type Service struct {
+	store      database.UserStore
+	sessionTTL time.Duration
+
+	mu         sync.Mutex
+	tickets    map[string]ticket   // Unredeemed WebSocket tickets by hash
+	revokeFunc func(tokenID int64) // Called after a token is revoked, e.g. to close its WebSocket connections
+
+	firstUserMu sync.Mutex // Held while creating the first account, so only one is made an admin
+}
+
+// NewService creates a Service storing its users and tokens in store.
+func NewService(store database.UserStore, cfg config.Config) *Service {
+	return &Service{
+		store:      store,
+		sessionTTL: time.Duration(max(cfg.SessionTTLHours, 1)) * time.Hour,
+		tickets:    make(map[string]ticket),
+	}
+}
+
+// ValidateUser checks a username and password before an account is created with them.
+func ValidateUser(username, password string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username must be 3 to 32 letters, digits, '_', '.' or '-'")
+	}
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", minPasswordLength)
+	}
+	return nil
+}
+
+// ValidateScopes checks that scopes are known and that only admins give out the admin scope.
+// It returns the scopes sorted and without duplicates.
+func ValidateScopes(scopes []string, isAdmin bool) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required, out of %s", strings.Join(Scopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, must be one of %s", scope, strings.Join(Scopes, ", "))
+		}
+		if scope == ScopeAdmin && !isAdmin {
+			return nil, errors.New("only admins can create tokens with the admin scope")
+		}
+	}
+	scopes = append([]string(nil), scopes...)
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// HasUsers reports whether any account exists yet. Until one does, anyone may create the
+// first account, which becomes an admin.
+func (s *Service) HasUsers() (bool, error) {
+	users, err := s.store.GetUsers()
+	if err != nil {
+		return false, err
+	}
+	return len(users) > 0, nil
+}
+
+// RegisterFirst creates the first account, which is always an admin. It returns ErrHasUsers
+// if an account exists, including one created by a concurrent call. The username and
+// password must have passed ValidateUser.
+func (s *Service) RegisterFirst(username, password string) (models.User, error) {
+	s.firstUserMu.Lock()
+	defer s.firstUserMu.Unlock()
+
+	hasUsers, err := s.HasUsers()
+	if err != nil {
+		return models.User{}, err
+	}
+	if hasUsers {
+		return models.User{}, ErrHasUsers
+	}
+	return s.Register(username, password, true)
+}
+
+// Register creates an account. The username and password must have passed ValidateUser.
+func (s *Service) Register(username, password string, isAdmin bool) (models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+	user := models.User{
+		Username:     username,
+		PasswordHash: string(hash),
+		IsAdmin:      isAdmin,
+		CreatedAt:    time.Now().UTC(),
+	}
+	id, err := s.store.CreateUser(user)
+	if err != nil {
+		return models.User{}, err
+	}
+	user.ID = id
+	return user, nil
+}
+
+// Login checks a username and password and starts a session. It returns the session token,
+// which is not stored anywhere and can't be recovered later.
+func (s *Service) Login(username, password string) (string, models.AuthToken, *models.User, error) {
+	user, err := s.store.GetUserByUsername(username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		// Compare anyway so unknown usernames take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", models.AuthToken{}, nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", models.AuthToken{}, nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", models.AuthToken{}, nil, ErrInvalidCredentials
+	}
+
+	now := time.Now().UTC()
+	secret, token, err := s.issue(models.AuthToken{
+		UserID:    user.ID,
+		Kind:      models.TokenSession,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.sessionTTL),
+	})
+	if err != nil {
+		return "", models.AuthToken{}, nil, err
+	}
+	return secret, token, user, nil
+}
+
+// dummyHash is compared against when a username does not exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// CreateAPIToken creates a token for scripts, limited to scopes, which must have passed
+// ValidateScopes. A zero expiresAt makes a token that never expires. It returns the token,
+// which is not stored anywhere and can't be recovered later.
+func (s *Service) CreateAPIToken(userID int64, name string, scopes []string, expiresAt time.Time) (string, models.AuthToken, error) {
+	return s.issue(models.AuthToken{
+		UserID:    userID,
+		Kind:      models.TokenAPI,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// issue generates the secret of a token and stores its hash.
+func (s *Service) issue(token models.AuthToken) (string, models.AuthToken, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", models.AuthToken{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	prefix := sessionPrefix
+	if token.Kind == models.TokenAPI {
+		prefix = apiTokenPrefix
+	}
+	secret := prefix + base64.RawURLEncoding.EncodeToString(random)
+	token.TokenHash = hashToken(secret)
+
+	id, err := s.store.CreateAuthToken(token)
+	if err != nil {
+		return "", models.AuthToken{}, err
+	}
+	token.ID = id
+	return secret, token, nil
+}
+
+// hashToken returns the hex SHA-256 of a token, which is what the store keeps. Tokens are
+// long and random, so a fast hash is enough.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate looks up the user behind a session or API token, along with what it may
+// access. Expired tokens are deleted.
+func (s *Service) Authenticate(secret string) (*Principal, error) {
+	if !strings.HasPrefix(secret, sessionPrefix) && !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	token, err := s.store.GetAuthToken(hashToken(secret))
+	if errors.Is(err, database.ErrAuthTokenNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+		if err := s.store.DeleteAuthToken(token.ID); err != nil && !errors.Is(err, database.ErrAuthTokenNotFound) {
+			return nil, err
+		}
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.store.GetUser(token.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	deviceIDs, err := s.store.GetOwnedDeviceIDs(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return newPrincipal(*user, *token, deviceIDs), nil
+}
+
+// Logout ends a session or revokes an API token.
+func (s *Service) Logout(tokenID int64) error {
+	return s.revoke(tokenID)
+}
+
+// APITokens returns the API tokens of a user, oldest first.
+func (s *Service) APITokens(userID int64) ([]models.AuthToken, error) {
+	return s.store.GetAuthTokens(userID, models.TokenAPI)
+}
+
+// RevokeAPIToken deletes one of a user's API tokens. Tokens of other users are reported as
+// not found.
+func (s *Service) RevokeAPIToken(userID, tokenID int64) error {
+	tokens, err := s.APITokens(userID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(tokens, func(token models.AuthToken) bool { return token.ID == tokenID }) {
+		return fmt.Errorf("token with ID %d: %w", tokenID, database.ErrAuthTokenNotFound)
+	}
+	return s.revoke(tokenID)
+}
+
+// SetRevokeFunc sets the function to call after a session ends or an API token is revoked.
+func (s *Service) SetRevokeFunc(revokeFunc func(tokenID int64)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeFunc = revokeFunc
+}
+
+// revoke deletes a token along with the WebSocket tickets issued for it, and lets the
+// revoke function know.
+func (s *Service) revoke(tokenID int64) error {
+	if err := s.store.DeleteAuthToken(tokenID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for key, t := range s.tickets {
+		if t.principal.Token.ID == tokenID {
+			delete(s.tickets, key)
+		}
+	}
+	revokeFunc := s.revokeFunc
+	s.mu.Unlock()
+	if revokeFunc != nil {
+		revokeFunc(tokenID)
+	}
+	return nil
+}
+
+// Users returns every account, oldest first.
+func (s *Service) Users() ([]models.User, error) {
+	return s.store.GetUsers()
+}
+
+// SetDeviceOwner gives a device to a user, or to nobody if userID is 0. The device's
+// rides that have no owner yet go to the new owner.
+func (s *Service) SetDeviceOwner(deviceID string, userID int64) error {
+	if userID != 0 {
+		if _, err := s.store.GetUser(userID); err != nil {
+			return err
+		}
+	}
+	return s.store.SetDeviceOwner(deviceID, userID)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestRegisterFirstConcurrently checks that of many simultaneous attempts to create the first
+// account, exactly one succeeds and becomes an admin.
+func TestRegisterFirstConcurrently(t *testing.T) {
+	store := database.NewMemoryStore()
+	service := NewService(store, config.Config{})
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = service.RegisterFirst(fmt.Sprintf("user%d", i), "correct horse")
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrHasUsers):
+			t.Errorf("attempt %d: %v, want nil or ErrHasUsers", i, err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d attempts succeeded, want 1", created)
+	}
+	users, err := store.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 1 || !users[0].IsAdmin {
+		t.Errorf("users %+v, want a single admin", users)
+	}
+
+	if _, err := service.RegisterFirst("latecomer", "correct horse"); !errors.Is(err, ErrHasUsers) {
+		t.Errorf("RegisterFirst with an existing account: %v, want ErrHasUsers", err)
+	}
+}
+
+// TestLogoutRevokes checks that ending a session tells the revoke function and invalidates
+// the WebSocket tickets issued for it.
+func TestLogoutRevokes(t *testing.T) {
+	service := NewService(database.NewMemoryStore(), config.Config{})
+	if _, err := service.RegisterFirst("alice", "correct horse"); err != nil {
+		t.Fatalf("RegisterFirst: %v", err)
+	}
+	secret, _, _, err := service.Login("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	principal, err := service.Authenticate(secret)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	ticket, _, err := service.IssueTicket(principal)
+	if err != nil {
+		t.Fatalf("IssueTicket: %v", err)
+	}
+	var revoked []int64
+	service.SetRevokeFunc(func(tokenID int64) { revoked = append(revoked, tokenID) })
+
+	if err := service.Logout(principal.Token.ID); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != principal.Token.ID {
+		t.Errorf("revoked %v, want [%d]", revoked, principal.Token.ID)
+	}
+	if _, err := service.Authenticate(secret); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Authenticate after logout: %v, want ErrInvalidToken", err)
+	}
+	if _, err := service.RedeemTicket(ticket); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("RedeemTicket after logout: %v, want ErrInvalidToken", err)
+	}
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// principalKey is the gin context key the principal of a request is kept under.
+const principalKey = "auth.principal"
+
+// Middleware rejects requests without a valid "Authorization: Bearer <token>" header with
+// 401 and makes the principal available to handlers through FromContext.
+func Middleware(service *Service) gin.HandlerFunc {
+	return require(func(c *gin.Context) (*Principal, error) { return authenticate(c, service) })
+}
+
+// WebSocket is like Middleware but also accepts a ticket from Service.IssueTicket in the
+// ticket query parameter, since browsers can't send headers when opening a WebSocket.
+func WebSocket(service *Service) gin.HandlerFunc {
+	return require(func(c *gin.Context) (*Principal, error) {
+		if secret := c.Query("ticket"); secret != "" {
+			return service.RedeemTicket(secret)
+		}
+		return authenticate(c, service)
+	})
+}
+
+// require rejects requests that authenticate fails for and stores the principal of the others.
+func require(authenticate func(c *gin.Context) (*Principal, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticate(c)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidToken) {
+				log.Printf("Error authenticating request: %v", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+				return
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="b3"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// Optional is like Middleware but lets requests without a token through, with no principal.
+// Requests with an invalid token are still rejected.
+func Optional(service *Service) gin.HandlerFunc {
+	required := Middleware(service)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		required(c)
+	}
+}
+
+// authenticate reads the bearer token of a request and looks up its principal.
+func authenticate(c *gin.Context, service *Service) (*Principal, error) {
+	scheme, secret, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrInvalidToken
+	}
+	return service.Authenticate(strings.TrimSpace(secret))
+}
+
+// RequireScope rejects requests whose token lacks the scope to use a resource, e.g. "rides",
+// with 403. Reads (GET and HEAD) need "<resource>:read" and everything else "<resource>:write".
+// It must run after Middleware.
+func RequireScope(resource string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := resource + ":write"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = resource + ":read"
+		}
+		if principal := FromContext(c); principal == nil || !principal.Can(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token lacks the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects requests that are not made by an admin with 403. It must run after
+// Middleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal := FromContext(c); principal == nil || !principal.IsAdmin() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// FromContext returns the principal of a request, or nil if it was not authenticated.
+func FromContext(c *gin.Context) *Principal {
+	principal, _ := c.Get(principalKey)
+	p, _ := principal.(*Principal)
+	return p
+}
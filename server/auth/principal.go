@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"b3/server/models"
+	"slices"
+	"strings"
+)
+
+// Principal is who a request is made by: a user, the session or API token they used and
+// the devices they own.
+type Principal struct {
+	User    models.User
+	Token   models.AuthToken
+	devices map[string]bool
+}
+
+func newPrincipal(user models.User, token models.AuthToken, deviceIDs []string) *Principal {
+	devices := make(map[string]bool, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		devices[deviceID] = true
+	}
+	return &Principal{User: user, Token: token, devices: devices}
+}
+
+// IsAdmin reports whether the request may manage the server and see every user's data.
+// API tokens of admins only may if they have the admin scope.
+func (p *Principal) IsAdmin() bool {
+	return p.User.IsAdmin && (p.Token.Kind == models.TokenSession || slices.Contains(p.Token.Scopes, ScopeAdmin))
+}
+
+// Can reports whether the request may use scope, e.g. "rides:read". Sessions may use every
+// scope but admin, which IsAdmin covers. A write scope includes the matching read scope.
+func (p *Principal) Can(scope string) bool {
+	if p.IsAdmin() {
+		return true
+	}
+	if p.Token.Kind == models.TokenSession {
+		return scope != ScopeAdmin
+	}
+	if slices.Contains(p.Token.Scopes, scope) {
+		return true
+	}
+	if resource, ok := strings.CutSuffix(scope, ":read"); ok {
+		return slices.Contains(p.Token.Scopes, resource+":write")
+	}
+	return false
+}
+
+// CanAccessDevice reports whether the request may see and control a device.
+func (p *Principal) CanAccessDevice(deviceID string) bool {
+	return p.IsAdmin() || p.devices[deviceID]
+}
+
+// CanSeeRide reports whether the request may see a ride owned by ownerID, 0 for rides
+// without an owner, which only admins see.
+func (p *Principal) CanSeeRide(ownerID int64) bool {
+	return p.IsAdmin() || (ownerID != 0 && ownerID == p.User.ID)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// ticketTTL is how long a WebSocket ticket can be redeemed after it was issued.
+const ticketTTL = 30 * time.Second
+
+// ticketPrefix starts every WebSocket ticket.
+const ticketPrefix = "b3w_"
+
+// ticket is an unredeemed WebSocket ticket.
+type ticket struct {
+	principal *Principal
+	expiresAt time.Time
+}
+
+// IssueTicket returns a single-use ticket that opens a WebSocket connection as principal.
+// Browsers can't send headers when opening a WebSocket, so they pass the ticket in the
+// URL instead of their session token, which would end up in access logs. Tickets are only
+// kept in memory and expire after ticketTTL.
+func (s *Service) IssueTicket(principal *Principal) (string, time.Time, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate ticket: %w", err)
+	}
+	secret := ticketPrefix + base64.RawURLEncoding.EncodeToString(random)
+	now := time.Now()
+	expiresAt := now.Add(ticketTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, t := range s.tickets {
+		if now.After(t.expiresAt) {
+			delete(s.tickets, key)
+		}
+	}
+	s.tickets[hashToken(secret)] = ticket{principal: principal, expiresAt: expiresAt}
+	return secret, expiresAt.UTC(), nil
+}
+
+// RedeemTicket returns the principal a ticket was issued to and invalidates the ticket.
+func (s *Service) RedeemTicket(secret string) (*Principal, error) {
+	key := hashToken(secret)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tickets[key]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	delete(s.tickets, key)
+	if time.Now().After(t.expiresAt) {
+		return nil, ErrInvalidToken
+	}
+	return t.principal, nil
+}
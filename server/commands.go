@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/importer"
+	"b3/server/ride"
+)
+
+// runCommand runs a one-off maintenance subcommand instead of the server.
+// Usage: server [flags] <command> [command flags]
+func runCommand(args []string, store database.RideStore, appConfig config.Config) error {
+	switch args[0] {
+	case "backfill-stats":
+		return runBackfillStats(args[1:], store, appConfig)
+	case "import":
+		return runImport(args[1:], store, appConfig)
+	case "migrate":
+		return runMigrate(args[1:], store)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runBackfillStats computes stats for rides that ended before stats were recorded.
+func runBackfillStats(args []string, store database.RideStore, appConfig config.Config) error {
+	fs := flag.NewFlagSet("backfill-stats", flag.ExitOnError)
+	all := fs.Bool("all", false, "Recompute stats for every ended ride, not only rides without stats")
+	fs.Parse(args)
+
+	rideIDs, err := store.GetEndedRideIDs(!*all)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backfilling stats for %d rides", len(rideIDs))
+
+	failed := 0
+	for _, rideID := range rideIDs {
+		stats, err := ride.UpdateRideStats(store, rideID, appConfig)
+		if err != nil {
+			log.Printf("Failed to compute stats for ride %d: %v", rideID, err)
+			failed++
+			continue
+		}
+		log.Printf("Ride %d: %.0fm, %ds moving, %d points", rideID, stats.DistanceMeters, stats.MovingSeconds, stats.PointCount)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to backfill %d of %d rides", failed, len(rideIDs))
+	}
+	log.Printf("Backfilled stats for %d rides", len(rideIDs))
+	return nil
+}
+
+// runImport imports historical rides from GPX or FIT files.
+func runImport(args []string, store database.RideStore, appConfig config.Config) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	deviceID := fs.String("device", appConfig.DefaultDeviceID, "Device the imported rides belong to")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: import [-device id] file.gpx|file.fit ...")
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		if err := importFile(store, appConfig, *deviceID, path); err != nil {
+			var dupErr *importer.DuplicateRideError
+			if errors.As(err, &dupErr) {
+				log.Printf("Skipping %s: %v", path, err)
+				continue
+			}
+			log.Printf("Failed to import %s: %v", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to import %d of %d files", failed, fs.NArg())
+	}
+	return nil
+}
+
+func importFile(store database.RideStore, appConfig config.Config, deviceID, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	track, err := importer.Parse(path, f)
+	if err != nil {
+		return err
+	}
+	result, err := importer.Import(store, appConfig, deviceID, track)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %s as ride %d (%s, %d points)", path, result.RideID, result.Name, result.PointCount)
+	return nil
+}
+
+// runMigrate applies, reverts or lists schema migrations.
+// Usage: migrate up | migrate down [-steps n] | migrate status
+func runMigrate(args []string, store database.RideStore) error {
+	const usage = "usage: migrate up | migrate down [-steps n] | migrate status"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	migratable, ok := store.(database.Migratable)
+	if !ok {
+		return fmt.Errorf("the configured database backend has no schema migrations")
+	}
+	migrator, err := migratable.Migrator()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migrations", applied)
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "Number of migrations to revert, newest first")
+		fs.Parse(args[1:])
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		reverted, err := migrator.Down(ctx, *steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reverted %d migrations", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-20s  %s\n", status.Version, status.Name, state)
+		}
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
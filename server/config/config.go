@@ -14,8 +14,8 @@ import (
 type Config struct {
 	MQTTBrokerURL     string         `json:"mqtt_broker_url"`
 	MQTTClientID      string         `json:"mqtt_client_id"`
-	MQTTTopic         string         `json:"mqtt_topic"`
-	MQTTUpdateTopic   string         `json:"mqtt_update_topic"` // Topic for shadow updates
+	MQTTShadowTopic   string         `json:"mqtt_shadow_topic"` // e.g. "$aws/things/<thing>/shadow", may use "+" in place of the thing name to follow every device
+	DefaultDeviceID   string         `json:"default_device_id"` // Device used when a request or topic does not name one
 	MQTTCertPath      string         `json:"mqtt_cert_path"`
 	MQTTKeyPath       string         `json:"mqtt_key_path"`
 	MQTTRootCAPath    string         `json:"mqtt_root_ca_path"`
@@ -23,14 +23,68 @@ type Config struct {
 	MQTTKeyPEM        string         `json:"-"`                           // Loaded from env, not json
 	MQTTRootCAPEM     string         `json:"-"`                           // Loaded from env, not json
 	PostgresConnStr   string         `json:"-"`                           // Loaded from env, not json
+	DatabaseBackend   string         `json:"database_backend"`            // "postgres", "sqlite" or "memory"; empty picks postgres if PostgresConnStr is set, else sqlite
+	DatabasePath      string         `json:"database_path"`               // SQLite database file
 	ServerAddress     string         `json:"server_address"`              // e.g., ":8080"
 	RideStartDistance float64        `json:"ride_start_distance_meters"`  // meters
 	RideEndInactivity int            `json:"ride_end_inactivity_seconds"` // seconds
 	RideEndStaticSecs int            `json:"ride_end_static_seconds"`     // seconds
 	RideEndStaticDist float64        `json:"ride_end_static_dist_meters"` // meters
+	MovingSpeedKnots  float64        `json:"moving_speed_knots"`          // knots, segments slower than this count as stopped in ride stats
 	Timezone          string         `json:"timezone"`                    // e.g., "America/Los_Angeles" for ride naming
 	PSTLocation       *time.Location // Loaded based on Timezone or default to PST
 
+	// MQTT connection configuration
+	MQTTMaxReconnectSecs int    `json:"mqtt_max_reconnect_interval_seconds"` // Longest wait between reconnect attempts, which back off from 1s
+	MQTTNMEATopic        string `json:"mqtt_nmea_topic"`                     // Optional topic of raw NMEA sentences, "+" in place of the device ID; empty disables it
+
+	// Lock command configuration
+	LockCommandTimeoutSecs int `json:"lock_command_timeout_seconds"` // How long a lock/unlock waits for the device to report it
+
+	// Ingest configuration, applied before the GPS filter
+	IngestReorderWindowMillis int    `json:"ingest_reorder_window_millis"` // How long points are held so late ones can be put back in order, 0 disables reordering
+	IngestLatePolicy          string `json:"ingest_late_point_policy"`     // Points older than ones already processed: "store" in the current ride without state transitions, or "drop"
+
+	// GPS filter configuration, applied before points reach ride detection
+	GPSFilterEnabled         bool    `json:"gps_filter_enabled"`                      // Reject points that imply impossible speed
+	GPSMaxSpeedKnots         float64 `json:"gps_max_speed_knots"`                     // knots, faster jumps between points are rejected
+	GPSMaxConsecutiveRejects int     `json:"gps_max_consecutive_rejects"`             // Accept anyway after this many rejections in a row
+	GPSKalmanEnabled         bool    `json:"gps_kalman_enabled"`                      // Smooth lat/lon/speed with a Kalman filter
+	GPSKalmanPositionNoise   float64 `json:"gps_kalman_position_noise_meters"`        // meters, expected GPS position error
+	GPSKalmanProcessNoise    float64 `json:"gps_kalman_process_noise_meters_per_sec"` // m/s, how quickly the true position may drift
+	GPSKalmanSpeedNoise      float64 `json:"gps_kalman_speed_noise_knots"`            // knots, expected GPS speed error
+	GPSStoreRawPoints        bool    `json:"gps_store_raw_points"`                    // Keep every received point, accepted or not, for audit
+
+	// Position write buffer configuration
+	PositionBatchSize           int    `json:"position_batch_size"`                // Points per batch insert
+	PositionFlushIntervalMillis int    `json:"position_flush_interval_millis"`     // How often buffered points are written
+	PositionRetryMaxSeconds     int    `json:"position_retry_max_backoff_seconds"` // Longest wait between retries while the database is down
+	PositionBufferMaxPoints     int    `json:"position_buffer_max_points"`         // Points kept in memory before spilling to disk
+	PositionQueuePath           string `json:"position_queue_path"`                // On-disk queue for points that couldn't be written, empty keeps them in memory only
+
+	// Alert configuration
+	AlertCooldownSecs   int `json:"alert_cooldown_seconds"`   // Repeats of an alert within this time of its last notification are not sent
+	AlertEscalationSecs int `json:"alert_escalation_seconds"` // Resend alerts nobody acknowledged this often, 0 disables escalation
+	AlertMaxEscalations int `json:"alert_max_escalations"`    // Stop resending an alert after this many escalations
+
+	// Crash cancellation window
+	CrashCancelWindowSecs int      `json:"crash_cancel_window_seconds"` // The rider can cancel a crash alert this long before anyone is notified, 0 notifies right away
+	CrashPromptChannels   []string `json:"crash_prompt_channels"`       // Notification channels asking the rider whether they are OK, e.g. their phone
+
+	// Delivery to emergency contacts
+	ContactMaxAttempts int `json:"contact_max_attempts"`  // Tries per contact and alert notification before giving up
+	ContactRetrySecs   int `json:"contact_retry_seconds"` // Wait before the first retry, doubled for each one after
+
+	// Authentication
+	SessionTTLHours int `json:"session_ttl_hours"` // How long a login session lasts
+
+	// Ride share links
+	ShareSigningKey string `json:"share_signing_key"` // Secret share links are signed with, random per run if empty, which breaks links on restart
+	ShareTTLHours   int    `json:"share_ttl_hours"`   // How long a share link lasts unless another lifetime is asked for
+
+	// Notification channels alerts are sent through. When empty, the SNS topic below is used if SNS is enabled.
+	NotificationChannels []NotificationChannel `json:"notification_channels"`
+
 	// SNS Configuration
 	SNSTopicArn string `json:"sns_topic_arn,omitempty"` // Default SNS topic ARN for notifications
 	SNSRegion   string `json:"sns_region,omitempty"`    // AWS region for SNS (optional, uses default AWS config if empty)
@@ -38,21 +92,93 @@ type Config struct {
 	TestMode    bool   `json:"test_mode"`               // Whether to run in test mode with mock MQTT
 }
 
+// NotificationChannel configures one way of sending alerts. Which fields apply depends on Type.
+type NotificationChannel struct {
+	Type string `json:"type"` // "sns", "email" or "push"
+	Name string `json:"name"` // Identifies the channel in logs and the test endpoint, defaults to Type
+
+	// SNS
+	TopicArn string `json:"topic_arn,omitempty"`
+	Region   string `json:"region,omitempty"`   // Empty uses the AWS config region
+	Endpoint string `json:"endpoint,omitempty"` // Overrides the SNS endpoint, e.g. for LocalStack
+
+	// Email
+	SMTPHost     string   `json:"smtp_host,omitempty"`
+	SMTPPort     int      `json:"smtp_port,omitempty"`     // Default 587; 465 uses implicit TLS, other ports STARTTLS when the server offers it
+	SMTPUsername string   `json:"smtp_username,omitempty"` // Empty sends without authentication
+	SMTPPassword string   `json:"smtp_password,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+
+	// HTTP push
+	URL      string `json:"url,omitempty"`      // ntfy topic URL, or the Gotify message endpoint
+	Format   string `json:"format,omitempty"`   // "ntfy" (default) or "gotify"
+	Token    string `json:"token,omitempty"`    // Sent as a bearer token to ntfy, as X-Gotify-Key to Gotify
+	Priority int    `json:"priority,omitempty"` // 1-5 for ntfy, 0-10 for Gotify; 0 leaves the server's default
+}
+
 var defaultConfig = Config{
 	MQTTBrokerURL:     "tls://a1edew9tp1yb1x-ats.iot.us-east-1.amazonaws.com:8883",
 	MQTTClientID:      "server-ride-tracker",
-	MQTTTopic:         "$aws/things/akshat_cc3200board/shadow/update/accepted",
-	MQTTUpdateTopic:   "$aws/things/akshat_cc3200board/shadow/update",
+	MQTTShadowTopic:   "$aws/things/+/shadow",
+	DefaultDeviceID:   "akshat_cc3200board",
 	MQTTCertPath:      "certs/certificate.pem.crt", // Relative to executable or defined base path
 	MQTTKeyPath:       "certs/private.pem.key",     // Relative
 	MQTTRootCAPath:    "certs/AmazonRootCA1.pem",   // Relative
+	DatabasePath:      "data/rides.db",             // Relative
 	ServerAddress:     ":8080",
 	RideStartDistance: 8.0,                   // meters
 	RideEndInactivity: 120,                   // seconds (2 minutes)
 	RideEndStaticSecs: 120,                   // seconds (2 minutes)
 	RideEndStaticDist: 8.0,                   // meters
+	MovingSpeedKnots:  1.0,                   // knots (~1.9 km/h)
 	Timezone:          "America/Los_Angeles", // Default to PST as discussed
 
+	// MQTT connection defaults
+	MQTTMaxReconnectSecs: 60,
+
+	// Lock command defaults
+	LockCommandTimeoutSecs: 30,
+
+	// Ingest defaults
+	IngestReorderWindowMillis: 2000, // 2 seconds
+	IngestLatePolicy:          "store",
+
+	// GPS filter defaults
+	GPSFilterEnabled:         true,
+	GPSMaxSpeedKnots:         50.0, // knots (~93 km/h), well above any bike ride
+	GPSMaxConsecutiveRejects: 5,
+	GPSKalmanEnabled:         false,
+	GPSKalmanPositionNoise:   10.0, // meters
+	GPSKalmanProcessNoise:    3.0,  // m/s
+	GPSKalmanSpeedNoise:      1.0,  // knots
+	GPSStoreRawPoints:        true,
+
+	// Position write buffer defaults
+	PositionBatchSize:           100,
+	PositionFlushIntervalMillis: 1000, // 1 second
+	PositionRetryMaxSeconds:     60,
+	PositionBufferMaxPoints:     10000,
+	PositionQueuePath:           "data/position_queue.jsonl",
+
+	// Alert defaults
+	AlertCooldownSecs:   300, // 5 minutes
+	AlertEscalationSecs: 600, // 10 minutes
+	AlertMaxEscalations: 3,
+
+	// Crash cancellation defaults
+	CrashCancelWindowSecs: 60,
+
+	// Contact delivery defaults
+	ContactMaxAttempts: 4,
+	ContactRetrySecs:   30,
+
+	// Authentication defaults
+	SessionTTLHours: 720,
+
+	// Share link defaults
+	ShareTTLHours: 168,
+
 	// SNS defaults
 	SNSTopicArn: "",    // To be set via config file or environment variable
 	SNSRegion:   "",    // Uses default AWS config region if empty
@@ -117,6 +243,12 @@ func init() {
 		log.Println("PostgreSQL connection string loaded from environment")
 	}
 
+	// Load the share link signing key from environment if available
+	if shareKey := os.Getenv("SHARE_SIGNING_KEY"); shareKey != "" {
+		AppConfig.ShareSigningKey = shareKey
+		log.Println("Share signing key loaded from environment")
+	}
+
 	// For now, load defaults. Later, we can load from a file or env vars.
 	// AppConfig = defaultConfig // This line is now redundant due to above assignments
 
@@ -139,7 +271,8 @@ func LoadConfigFromFile(filePath string) (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
-	var cfg Config
+	// Start from the defaults so fields missing from older config files keep sensible values
+	cfg := defaultConfig
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return Config{}, err
 	}
@@ -162,6 +295,10 @@ func LoadConfigFromFile(filePath string) (Config, error) {
 	if AppConfig.PostgresConnStr != "" {
 		cfg.PostgresConnStr = AppConfig.PostgresConnStr
 	}
+	// Preserve the share signing key from environment
+	if AppConfig.ShareSigningKey != "" {
+		cfg.ShareSigningKey = AppConfig.ShareSigningKey
+	}
 
 	// Ensure PSTLocation is loaded after reading from file
 	loc, err := time.LoadLocation(cfg.Timezone)
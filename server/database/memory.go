@@ -0,0 +1,974 @@
+package database
+
+import (
+	"b3/server/models"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryRide is a ride as kept by MemoryStore.
+type memoryRide struct {
+	id        int64
+	deviceID  string
+	source    string
+	trackHash string
+	name      string
+	startTime time.Time
+	endTime   time.Time // Zero while the ride is in progress
+	stats     *models.RideStats
+	positions []models.Position
+	startZone string
+	endZone   string
+	ownerID   int64 // 0 if the ride has no owner
+}
+
+// rawPosition is a point as received from a device, kept by MemoryStore.
+type rawPosition struct {
+	deviceID     string
+	position     models.Position
+	receivedAt   time.Time
+	accepted     bool
+	rejectReason string
+}
+
+// MemoryStore is a RideStore that keeps everything in memory. Data is lost when the
+// server stops, which makes it handy for test mode and local development.
+type MemoryStore struct {
+	mu             sync.RWMutex
+	nextID         int64
+	rides          map[int64]*memoryRide
+	rawPositions   []rawPosition
+	geofences      map[int64]models.Geofence
+	geofenceEvents []models.GeofenceEvent // Oldest first
+	incidents      map[int64]*memoryIncident
+	crashEvents    []models.CrashEvent // Oldest first
+	alerts         map[int64]models.Alert
+	alertEvents    []models.AlertEvent // Oldest first
+	contacts       map[int64]models.Contact
+	deliveries     map[int64]models.AlertDelivery
+	users          map[int64]models.User
+	authTokens     map[int64]models.AuthToken
+	deviceOwners   map[string]int64
+	shares         map[int64]models.RideShare
+}
+
+// memoryIncident is a theft incident as kept by MemoryStore.
+type memoryIncident struct {
+	incident  models.TheftIncident
+	positions []models.Position
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:       1,
+		rides:        make(map[int64]*memoryRide),
+		geofences:    make(map[int64]models.Geofence),
+		incidents:    make(map[int64]*memoryIncident),
+		alerts:       make(map[int64]models.Alert),
+		contacts:     make(map[int64]models.Contact),
+		deliveries:   make(map[int64]models.AlertDelivery),
+		users:        make(map[int64]models.User),
+		authTokens:   make(map[int64]models.AuthToken),
+		deviceOwners: make(map[string]int64),
+		shares:       make(map[int64]models.RideShare),
+	}
+}
+
+// Close does nothing; it exists to satisfy RideStore.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) CreateRide(deviceID, name string, startTime time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.rides[id] = &memoryRide{id: id, deviceID: deviceID, source: "device", name: name, startTime: startTime.UTC(), ownerID: s.deviceOwners[deviceID]}
+	return id, nil
+}
+
+func (s *MemoryStore) AddPositionToRide(rideID int64, position models.Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ride, ok := s.rides[rideID]
+	if !ok {
+		return fmt.Errorf("failed to add position to ride %d: %w", rideID, ErrRideNotFound)
+	}
+	position.Timestamp = position.Timestamp.UTC()
+	ride.positions = append(ride.positions, position)
+	return nil
+}
+
+func (s *MemoryStore) AddPositions(positions []RidePosition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check every ride first so a failed call stores nothing, like the SQL backends
+	for _, rp := range positions {
+		if _, ok := s.rides[rp.RideID]; !ok {
+			return fmt.Errorf("failed to add position to ride %d: %w", rp.RideID, ErrRideNotFound)
+		}
+	}
+	for _, rp := range positions {
+		position := rp.Position
+		position.Timestamp = position.Timestamp.UTC()
+		ride := s.rides[rp.RideID]
+		ride.positions = append(ride.positions, position)
+	}
+	return nil
+}
+
+func (s *MemoryStore) EndRide(rideID int64, endTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ride, ok := s.rides[rideID]; ok {
+		ride.endTime = endTime.UTC()
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteRide(rideID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.rides, rideID)
+	for id, share := range s.shares {
+		if share.RideID == rideID {
+			delete(s.shares, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkRideImported(rideID int64, source, trackHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ride, ok := s.rides[rideID]; ok {
+		ride.source = source
+		ride.trackHash = trackHash
+	}
+	return nil
+}
+
+func (s *MemoryStore) FindDuplicateRide(deviceID string, startTime time.Time, trackHash string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found int64
+	for id, ride := range s.rides {
+		if ride.ownerID != s.deviceOwners[deviceID] {
+			continue
+		}
+		sameStart := ride.deviceID == deviceID && ride.startTime.Equal(startTime)
+		if ((ride.trackHash != "" && ride.trackHash == trackHash) || sameStart) && (found == 0 || id < found) {
+			found = id
+		}
+	}
+	return found, nil
+}
+
+func (s *MemoryStore) SaveRideStats(rideID int64, stats models.RideStats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ride, ok := s.rides[rideID]; ok {
+		ride.stats = &stats
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetEndedRideIDs(onlyMissingStats bool) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rides []*memoryRide
+	for _, ride := range s.rides {
+		if ride.endTime.IsZero() || (onlyMissingStats && ride.stats != nil) {
+			continue
+		}
+		rides = append(rides, ride)
+	}
+	sort.Slice(rides, func(i, j int) bool { return rides[i].startTime.Before(rides[j].startTime) })
+
+	ids := make([]int64, 0, len(rides))
+	for _, ride := range rides {
+		ids = append(ids, ride.id)
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) GetRideDetails(rideID int64) (*models.RideDetail, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ride, ok := s.rides[rideID]
+	if !ok {
+		return nil, fmt.Errorf("ride with ID %d: %w", rideID, ErrRideNotFound)
+	}
+	positions := make([]models.Position, len(ride.positions))
+	copy(positions, ride.positions)
+	sort.SliceStable(positions, func(i, j int) bool { return positions[i].Timestamp.Before(positions[j].Timestamp) })
+
+	summary := ride.summary()
+	return &models.RideDetail{
+		ID:        summary.ID,
+		DeviceID:  summary.DeviceID,
+		Source:    summary.Source,
+		Name:      summary.Name,
+		StartTime: summary.StartTime,
+		EndTime:   summary.EndTime,
+		Stats:     summary.Stats,
+		Positions: positions,
+		StartZone: summary.StartZone,
+		EndZone:   summary.EndZone,
+		OwnerID:   summary.OwnerID,
+	}, nil
+}
+
+func (s *MemoryStore) GetAllRidesSummary() ([]models.RideSummary, error) {
+	return s.summaries(func(*memoryRide) bool { return true }), nil
+}
+
+func (s *MemoryStore) GetAllRidesSummaryWithPagination(page, limit int, dateFilter *time.Time, deviceID string, ownerID int64) ([]models.RideSummary, error) {
+	var startOfDay, endOfDay time.Time
+	if dateFilter != nil {
+		startOfDay, endOfDay = utcDayBounds(*dateFilter)
+	}
+	rides := s.summaries(func(ride *memoryRide) bool {
+		if dateFilter != nil && (ride.startTime.Before(startOfDay) || !ride.startTime.Before(endOfDay)) {
+			return false
+		}
+		if ownerID != 0 && ride.ownerID != ownerID {
+			return false
+		}
+		return deviceID == "" || ride.deviceID == deviceID
+	})
+
+	offset := (page - 1) * limit
+	if offset >= len(rides) {
+		return nil, nil
+	}
+	end := offset + limit
+	if end > len(rides) {
+		end = len(rides)
+	}
+	return rides[offset:end], nil
+}
+
+// summaries returns the rides matching keep, newest first.
+func (s *MemoryStore) summaries(keep func(*memoryRide) bool) []models.RideSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var rides []models.RideSummary
+	for _, ride := range s.rides {
+		if keep(ride) {
+			rides = append(rides, ride.summary())
+		}
+	}
+	sort.Slice(rides, func(i, j int) bool { return rides[i].StartTime.After(rides[j].StartTime) })
+	return rides
+}
+
+func (s *MemoryStore) GetDeviceIDs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var deviceIDs []string
+	for _, ride := range s.rides {
+		if ride.deviceID != "" && !seen[ride.deviceID] {
+			seen[ride.deviceID] = true
+			deviceIDs = append(deviceIDs, ride.deviceID)
+		}
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs, nil
+}
+
+func (s *MemoryStore) BackfillDeviceID(deviceID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, ride := range s.rides {
+		if ride.deviceID == "" {
+			ride.deviceID = deviceID
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) AddRawPosition(deviceID string, position models.Position, accepted bool, rejectReason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	position.Timestamp = position.Timestamp.UTC()
+	s.rawPositions = append(s.rawPositions, rawPosition{
+		deviceID:     deviceID,
+		position:     position,
+		receivedAt:   time.Now().UTC(),
+		accepted:     accepted,
+		rejectReason: rejectReason,
+	})
+	return nil
+}
+
+func (r *memoryRide) summary() models.RideSummary {
+	summary := models.RideSummary{
+		ID:        r.id,
+		DeviceID:  r.deviceID,
+		Source:    r.source,
+		Name:      r.name,
+		StartTime: r.startTime,
+		EndTime:   r.endTime,
+		StartZone: r.startZone,
+		EndZone:   r.endZone,
+		OwnerID:   r.ownerID,
+	}
+	if r.stats != nil {
+		stats := *r.stats
+		summary.Stats = &stats
+	}
+	return summary
+}
+
+func (s *MemoryStore) GetOpenRides() ([]models.RideSummary, error) {
+	rides := s.summaries(func(ride *memoryRide) bool { return ride.endTime.IsZero() })
+	sort.Slice(rides, func(i, j int) bool { return rides[i].StartTime.Before(rides[j].StartTime) })
+	return rides, nil
+}
+
+func (s *MemoryStore) GetLastRidePosition(rideID int64) (*models.Position, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ride, ok := s.rides[rideID]
+	if !ok || len(ride.positions) == 0 {
+		return nil, nil
+	}
+	last := ride.positions[0]
+	for _, pos := range ride.positions[1:] {
+		if !pos.Timestamp.Before(last.Timestamp) {
+			last = pos
+		}
+	}
+	return &last, nil
+}
+
+func (s *MemoryStore) SetRideStartZone(rideID int64, zone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ride, ok := s.rides[rideID]; ok {
+		ride.startZone = zone
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetRideEndZone(rideID int64, zone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ride, ok := s.rides[rideID]; ok {
+		ride.endZone = zone
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateGeofence(geofence models.Geofence) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	geofence.ID = s.nextID
+	s.nextID++
+	s.geofences[geofence.ID] = cloneGeofence(geofence)
+	return geofence.ID, nil
+}
+
+func (s *MemoryStore) UpdateGeofence(geofence models.Geofence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.geofences[geofence.ID]
+	if !ok {
+		return fmt.Errorf("geofence with ID %d: %w", geofence.ID, ErrGeofenceNotFound)
+	}
+	geofence.CreatedAt = existing.CreatedAt
+	s.geofences[geofence.ID] = cloneGeofence(geofence)
+	return nil
+}
+
+func (s *MemoryStore) DeleteGeofence(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.geofences[id]; !ok {
+		return fmt.Errorf("geofence with ID %d: %w", id, ErrGeofenceNotFound)
+	}
+	delete(s.geofences, id)
+	events := s.geofenceEvents[:0]
+	for _, event := range s.geofenceEvents {
+		if event.GeofenceID != id {
+			events = append(events, event)
+		}
+	}
+	s.geofenceEvents = events
+	return nil
+}
+
+func (s *MemoryStore) GetGeofences() ([]models.Geofence, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	geofences := make([]models.Geofence, 0, len(s.geofences))
+	for _, geofence := range s.geofences {
+		geofences = append(geofences, cloneGeofence(geofence))
+	}
+	sort.Slice(geofences, func(i, j int) bool { return geofences[i].ID < geofences[j].ID })
+	return geofences, nil
+}
+
+func (s *MemoryStore) AddGeofenceEvent(event models.GeofenceEvent) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.geofences[event.GeofenceID]; !ok {
+		return 0, fmt.Errorf("failed to add event to geofence %d: %w", event.GeofenceID, ErrGeofenceNotFound)
+	}
+	event.ID = s.nextID
+	s.nextID++
+	event.Timestamp = event.Timestamp.UTC()
+	s.geofenceEvents = append(s.geofenceEvents, event)
+	return event.ID, nil
+}
+
+func (s *MemoryStore) GetGeofenceEvents(geofenceID int64, limit int) ([]models.GeofenceEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.GeofenceEvent
+	for i := len(s.geofenceEvents) - 1; i >= 0 && len(events) < limit; i-- {
+		event := s.geofenceEvents[i]
+		if event.GeofenceID != geofenceID {
+			continue
+		}
+		// Names are looked up like the SQL join, so a renamed zone shows its current name
+		event.GeofenceName = s.geofences[geofenceID].Name
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// cloneGeofence copies the center and polygon so stored geofences can't be changed through the caller's slices.
+func cloneGeofence(geofence models.Geofence) models.Geofence {
+	if geofence.Center != nil {
+		center := *geofence.Center
+		geofence.Center = &center
+	}
+	geofence.Polygon = append([]models.GeoPoint(nil), geofence.Polygon...)
+	return geofence
+}
+
+func (s *MemoryStore) CreateTheftIncident(deviceID string, openedAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.incidents[id] = &memoryIncident{incident: models.TheftIncident{
+		ID:       id,
+		DeviceID: deviceID,
+		Status:   models.IncidentOpen,
+		OpenedAt: openedAt.UTC(),
+	}}
+	return id, nil
+}
+
+func (s *MemoryStore) AddTheftIncidentPosition(incidentID int64, position models.Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incident, ok := s.incidents[incidentID]
+	if !ok {
+		return fmt.Errorf("failed to add position to theft incident %d: %w", incidentID, ErrIncidentNotFound)
+	}
+	position.Timestamp = position.Timestamp.UTC()
+	incident.positions = append(incident.positions, position)
+	return nil
+}
+
+func (s *MemoryStore) ResolveTheftIncident(incidentID int64, resolvedAt time.Time, note string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incident, ok := s.incidents[incidentID]
+	if !ok {
+		return fmt.Errorf("theft incident with ID %d: %w", incidentID, ErrIncidentNotFound)
+	}
+	incident.incident.Status = models.IncidentResolved
+	incident.incident.ResolvedAt = resolvedAt.UTC()
+	incident.incident.ResolutionNote = note
+	return nil
+}
+
+func (s *MemoryStore) GetTheftIncident(incidentID int64) (*models.TheftIncidentDetail, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	incident, ok := s.incidents[incidentID]
+	if !ok {
+		return nil, fmt.Errorf("theft incident with ID %d: %w", incidentID, ErrIncidentNotFound)
+	}
+	positions := make([]models.Position, len(incident.positions))
+	copy(positions, incident.positions)
+	sort.SliceStable(positions, func(i, j int) bool { return positions[i].Timestamp.Before(positions[j].Timestamp) })
+	return &models.TheftIncidentDetail{TheftIncident: incident.summary(), Positions: positions}, nil
+}
+
+func (s *MemoryStore) GetTheftIncidents(deviceID string, openOnly bool) ([]models.TheftIncident, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var incidents []models.TheftIncident
+	for _, incident := range s.incidents {
+		if (deviceID != "" && incident.incident.DeviceID != deviceID) || (openOnly && incident.incident.Status != models.IncidentOpen) {
+			continue
+		}
+		incidents = append(incidents, incident.summary())
+	}
+	sort.Slice(incidents, func(i, j int) bool {
+		if !incidents[i].OpenedAt.Equal(incidents[j].OpenedAt) {
+			return incidents[i].OpenedAt.After(incidents[j].OpenedAt)
+		}
+		return incidents[i].ID > incidents[j].ID
+	})
+	return incidents, nil
+}
+
+func (i *memoryIncident) summary() models.TheftIncident {
+	summary := i.incident
+	summary.PositionCount = len(i.positions)
+	return summary
+}
+
+func (s *MemoryStore) CreateAlert(alert models.Alert) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alert.ID = s.nextID
+	s.nextID++
+	s.alerts[alert.ID] = alert
+	return alert.ID, nil
+}
+
+func (s *MemoryStore) UpdateAlert(alert models.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.alerts[alert.ID]
+	if !ok {
+		return fmt.Errorf("alert with ID %d: %w", alert.ID, ErrAlertNotFound)
+	}
+	// Only the fields UpdateAlert saves in the database may change
+	alert.Type, alert.DeviceID, alert.Key, alert.Title, alert.OpenedAt = stored.Type, stored.DeviceID, stored.Key, stored.Title, stored.OpenedAt
+	alert.CancelDeadline = stored.CancelDeadline
+	s.alerts[alert.ID] = alert
+	return nil
+}
+
+func (s *MemoryStore) GetAlert(id int64) (*models.Alert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	alert, ok := s.alerts[id]
+	if !ok {
+		return nil, fmt.Errorf("alert with ID %d: %w", id, ErrAlertNotFound)
+	}
+	return &alert, nil
+}
+
+func (s *MemoryStore) GetAlerts(deviceID string, activeOnly bool) ([]models.Alert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var alerts []models.Alert
+	for _, alert := range s.alerts {
+		if (deviceID != "" && alert.DeviceID != deviceID) || (activeOnly && (alert.Status == models.AlertResolved || alert.Status == models.AlertCancelled)) {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].OpenedAt.Equal(alerts[j].OpenedAt) {
+			return alerts[i].OpenedAt.After(alerts[j].OpenedAt)
+		}
+		return alerts[i].ID > alerts[j].ID
+	})
+	return alerts, nil
+}
+
+func (s *MemoryStore) AddAlertEvent(event models.AlertEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.alerts[event.AlertID]; !ok {
+		return fmt.Errorf("failed to add event to alert %d: %w", event.AlertID, ErrAlertNotFound)
+	}
+	event.ID = s.nextID
+	s.nextID++
+	event.Timestamp = event.Timestamp.UTC()
+	s.alertEvents = append(s.alertEvents, event)
+	return nil
+}
+
+func (s *MemoryStore) GetAlertEvents(alertID int64) ([]models.AlertEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.AlertEvent
+	for _, event := range s.alertEvents {
+		if event.AlertID == alertID {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+func (s *MemoryStore) AddCrashEvent(event models.CrashEvent) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = s.nextID
+	s.nextID++
+	event.Timestamp = event.Timestamp.UTC()
+	s.crashEvents = append(s.crashEvents, event)
+	return event.ID, nil
+}
+
+func (s *MemoryStore) GetRideCrashEvents(rideID int64) ([]models.CrashEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.CrashEvent
+	for _, event := range s.crashEvents {
+		if event.RideID == rideID {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	return events, nil
+}
+
+func (s *MemoryStore) CreateContact(contact models.Contact) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contact.ID = s.nextID
+	s.nextID++
+	contact.AlertTypes = append([]string(nil), contact.AlertTypes...)
+	s.contacts[contact.ID] = contact
+	return contact.ID, nil
+}
+
+func (s *MemoryStore) UpdateContact(contact models.Contact) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.contacts[contact.ID]
+	if !ok {
+		return fmt.Errorf("contact with ID %d: %w", contact.ID, ErrContactNotFound)
+	}
+	contact.OwnerID = existing.OwnerID
+	contact.CreatedAt = existing.CreatedAt
+	contact.AlertTypes = append([]string(nil), contact.AlertTypes...)
+	s.contacts[contact.ID] = contact
+	return nil
+}
+
+func (s *MemoryStore) DeleteContact(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.contacts[id]; !ok {
+		return fmt.Errorf("contact with ID %d: %w", id, ErrContactNotFound)
+	}
+	delete(s.contacts, id)
+	return nil
+}
+
+func (s *MemoryStore) GetContact(id int64) (*models.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contact, ok := s.contacts[id]
+	if !ok {
+		return nil, fmt.Errorf("contact with ID %d: %w", id, ErrContactNotFound)
+	}
+	contact.AlertTypes = append([]string(nil), contact.AlertTypes...)
+	return &contact, nil
+}
+
+func (s *MemoryStore) GetContacts() ([]models.Contact, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contacts := make([]models.Contact, 0, len(s.contacts))
+	for _, contact := range s.contacts {
+		contact.AlertTypes = append([]string(nil), contact.AlertTypes...)
+		contacts = append(contacts, contact)
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].ID < contacts[j].ID })
+	return contacts, nil
+}
+
+func (s *MemoryStore) CreateAlertDelivery(delivery models.AlertDelivery) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery.ID = s.nextID
+	s.nextID++
+	s.deliveries[delivery.ID] = delivery
+	return delivery.ID, nil
+}
+
+func (s *MemoryStore) UpdateAlertDelivery(delivery models.AlertDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.deliveries[delivery.ID]
+	if !ok {
+		return fmt.Errorf("alert delivery with ID %d not found", delivery.ID)
+	}
+	existing.Status = delivery.Status
+	existing.Attempts = delivery.Attempts
+	existing.LastError = delivery.LastError
+	existing.LastAttemptAt = delivery.LastAttemptAt
+	existing.NextAttemptAt = delivery.NextAttemptAt
+	existing.SentAt = delivery.SentAt
+	s.deliveries[delivery.ID] = existing
+	return nil
+}
+
+func (s *MemoryStore) GetAlertDeliveries(alertID int64) ([]models.AlertDelivery, error) {
+	return s.filterDeliveries(func(delivery models.AlertDelivery) bool { return delivery.AlertID == alertID }), nil
+}
+
+func (s *MemoryStore) GetPendingAlertDeliveries() ([]models.AlertDelivery, error) {
+	return s.filterDeliveries(func(delivery models.AlertDelivery) bool { return delivery.Status == models.DeliveryPending }), nil
+}
+
+func (s *MemoryStore) filterDeliveries(keep func(models.AlertDelivery) bool) []models.AlertDelivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var deliveries []models.AlertDelivery
+	for _, delivery := range s.deliveries {
+		if keep(delivery) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries
+}
+
+func (s *MemoryStore) CreateUser(user models.User) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return 0, fmt.Errorf("user %q: %w", user.Username, ErrUsernameTaken)
+		}
+	}
+	user.ID = s.nextID
+	s.nextID++
+	s.users[user.ID] = user
+	return user.ID, nil
+}
+
+func (s *MemoryStore) GetUser(id int64) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user with ID %d: %w", id, ErrUserNotFound)
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUserByUsername(username string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("user %q: %w", username, ErrUserNotFound)
+}
+
+func (s *MemoryStore) GetUsers() ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (s *MemoryStore) CreateAuthToken(token models.AuthToken) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = s.nextID
+	s.nextID++
+	token.Scopes = append([]string(nil), token.Scopes...)
+	s.authTokens[token.ID] = token
+	return token.ID, nil
+}
+
+func (s *MemoryStore) GetAuthToken(tokenHash string) (*models.AuthToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.authTokens {
+		if token.TokenHash == tokenHash {
+			token.Scopes = append([]string(nil), token.Scopes...)
+			return &token, nil
+		}
+	}
+	return nil, ErrAuthTokenNotFound
+}
+
+func (s *MemoryStore) GetAuthTokens(userID int64, kind string) ([]models.AuthToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []models.AuthToken
+	for _, token := range s.authTokens {
+		if token.UserID == userID && token.Kind == kind {
+			token.Scopes = append([]string(nil), token.Scopes...)
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func (s *MemoryStore) DeleteAuthToken(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authTokens[id]; !ok {
+		return fmt.Errorf("token with ID %d: %w", id, ErrAuthTokenNotFound)
+	}
+	delete(s.authTokens, id)
+	return nil
+}
+
+func (s *MemoryStore) SetDeviceOwner(deviceID string, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if userID == 0 {
+		delete(s.deviceOwners, deviceID)
+		return nil
+	}
+	s.deviceOwners[deviceID] = userID
+	for _, ride := range s.rides {
+		if ride.deviceID == deviceID && ride.ownerID == 0 {
+			ride.ownerID = userID
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetDeviceOwner(deviceID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.deviceOwners[deviceID], nil
+}
+
+func (s *MemoryStore) GetOwnedDeviceIDs(userID int64) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var deviceIDs []string
+	for deviceID, ownerID := range s.deviceOwners {
+		if ownerID == userID {
+			deviceIDs = append(deviceIDs, deviceID)
+		}
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs, nil
+}
+
+func (s *MemoryStore) CreateRideShare(share models.RideShare) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rides[share.RideID]; !ok {
+		return 0, fmt.Errorf("failed to share ride %d: %w", share.RideID, ErrRideNotFound)
+	}
+	share.ID = s.nextID
+	s.nextID++
+	s.shares[share.ID] = share
+	return share.ID, nil
+}
+
+func (s *MemoryStore) GetRideShare(id int64) (*models.RideShare, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	share, ok := s.shares[id]
+	if !ok {
+		return nil, fmt.Errorf("share link with ID %d: %w", id, ErrShareNotFound)
+	}
+	return &share, nil
+}
+
+func (s *MemoryStore) GetRideShares(userID int64) ([]models.RideShare, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var shares []models.RideShare
+	for _, share := range s.shares {
+		if userID == 0 || share.UserID == userID {
+			shares = append(shares, share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].ID > shares[j].ID })
+	return shares, nil
+}
+
+func (s *MemoryStore) RevokeRideShare(id int64, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	share, ok := s.shares[id]
+	if !ok {
+		return fmt.Errorf("share link with ID %d: %w", id, ErrShareNotFound)
+	}
+	if share.RevokedAt.IsZero() {
+		share.RevokedAt = revokedAt.UTC()
+		s.shares[id] = share
+	}
+	return nil
+}
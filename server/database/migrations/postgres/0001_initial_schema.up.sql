@@ -0,0 +1,18 @@
+-- Tables as originally created by createTables. IF NOT EXISTS keeps this a no-op
+-- on databases that predate schema_migrations.
+CREATE TABLE IF NOT EXISTS rides (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	start_time TIMESTAMP NOT NULL,
+	end_time TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ride_positions (
+	id SERIAL PRIMARY KEY,
+	ride_id INTEGER NOT NULL,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	speed_knots REAL,
+	timestamp TIMESTAMP NOT NULL,
+	FOREIGN KEY (ride_id) REFERENCES rides(id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+-- Rides created before multi-device support have no device_id; see BackfillDeviceID.
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS device_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_rides_device_start ON rides(device_id, start_time DESC);
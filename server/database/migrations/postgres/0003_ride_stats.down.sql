@@ -0,0 +1,7 @@
+ALTER TABLE rides
+	DROP COLUMN IF EXISTS distance_meters,
+	DROP COLUMN IF EXISTS elapsed_seconds,
+	DROP COLUMN IF EXISTS moving_seconds,
+	DROP COLUMN IF EXISTS avg_speed_knots,
+	DROP COLUMN IF EXISTS max_speed_knots,
+	DROP COLUMN IF EXISTS point_count;
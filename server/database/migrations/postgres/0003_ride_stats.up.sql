@@ -0,0 +1,8 @@
+-- Ride statistics are filled in when a ride ends, or by the backfill-stats command.
+ALTER TABLE rides
+	ADD COLUMN IF NOT EXISTS distance_meters DOUBLE PRECISION,
+	ADD COLUMN IF NOT EXISTS elapsed_seconds BIGINT,
+	ADD COLUMN IF NOT EXISTS moving_seconds BIGINT,
+	ADD COLUMN IF NOT EXISTS avg_speed_knots DOUBLE PRECISION,
+	ADD COLUMN IF NOT EXISTS max_speed_knots DOUBLE PRECISION,
+	ADD COLUMN IF NOT EXISTS point_count INTEGER;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_rides_track_hash;
+ALTER TABLE rides
+	DROP COLUMN IF EXISTS source,
+	DROP COLUMN IF EXISTS track_hash;
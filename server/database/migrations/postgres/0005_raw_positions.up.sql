@@ -0,0 +1,13 @@
+-- Every point received from a device, before filtering, kept for audit.
+CREATE TABLE IF NOT EXISTS raw_positions (
+	id BIGSERIAL PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	speed_knots REAL,
+	timestamp TIMESTAMP NOT NULL,
+	received_at TIMESTAMP NOT NULL,
+	accepted BOOLEAN NOT NULL,
+	reject_reason TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_raw_positions_device_time ON raw_positions(device_id, timestamp);
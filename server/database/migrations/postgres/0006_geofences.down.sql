@@ -0,0 +1,4 @@
+ALTER TABLE rides DROP COLUMN start_zone;
+ALTER TABLE rides DROP COLUMN end_zone;
+DROP TABLE IF EXISTS geofence_events;
+DROP TABLE IF EXISTS geofences;
@@ -0,0 +1,31 @@
+-- Named zones, either a circle (center and radius) or a polygon stored as a JSON array of points.
+CREATE TABLE IF NOT EXISTS geofences (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	center_latitude DOUBLE PRECISION,
+	center_longitude DOUBLE PRECISION,
+	radius_meters DOUBLE PRECISION,
+	polygon TEXT,
+	notify BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+-- A device entering or leaving a zone.
+CREATE TABLE IF NOT EXISTS geofence_events (
+	id BIGSERIAL PRIMARY KEY,
+	geofence_id BIGINT NOT NULL REFERENCES geofences(id) ON DELETE CASCADE,
+	device_id TEXT NOT NULL,
+	ride_id BIGINT,
+	event_type TEXT NOT NULL,
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	timestamp TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_geofence_events_geofence_time ON geofence_events(geofence_id, timestamp);
+
+-- Zones a ride started and ended in, by name at the time of the ride.
+ALTER TABLE rides
+	ADD COLUMN IF NOT EXISTS start_zone TEXT,
+	ADD COLUMN IF NOT EXISTS end_zone TEXT;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS theft_incident_positions;
+DROP TABLE IF EXISTS theft_incidents;
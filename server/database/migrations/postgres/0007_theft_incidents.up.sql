@@ -0,0 +1,21 @@
+-- A theft incident is opened by movement while a bike is locked and stays open until the owner resolves it.
+CREATE TABLE IF NOT EXISTS theft_incidents (
+	id BIGSERIAL PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	opened_at TIMESTAMP NOT NULL,
+	resolved_at TIMESTAMP,
+	resolution_note TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_theft_incidents_device ON theft_incidents(device_id, opened_at);
+
+-- Every position reported while an incident is open.
+CREATE TABLE IF NOT EXISTS theft_incident_positions (
+	id BIGSERIAL PRIMARY KEY,
+	incident_id BIGINT NOT NULL REFERENCES theft_incidents(id) ON DELETE CASCADE,
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	speed_knots REAL,
+	timestamp TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_theft_incident_positions_incident_time ON theft_incident_positions(incident_id, timestamp);
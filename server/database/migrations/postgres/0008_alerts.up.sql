@@ -0,0 +1,23 @@
+-- An alert groups every trigger of one incident (a crash, a theft, a geofence crossing)
+-- so notifications are sent once, repeated after a cooldown and escalated until acknowledged.
+CREATE TABLE IF NOT EXISTS alerts (
+	id BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	alert_key TEXT NOT NULL,
+	status TEXT NOT NULL,
+	title TEXT NOT NULL,
+	message TEXT NOT NULL,
+	latitude DOUBLE PRECISION,
+	longitude DOUBLE PRECISION,
+	trigger_count INTEGER NOT NULL DEFAULT 1,
+	notification_count INTEGER NOT NULL DEFAULT 0,
+	escalation_level INTEGER NOT NULL DEFAULT 0,
+	opened_at TIMESTAMP NOT NULL,
+	last_triggered_at TIMESTAMP NOT NULL,
+	last_notified_at TIMESTAMP,
+	acknowledged_at TIMESTAMP,
+	resolved_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_alerts_status ON alerts(status, opened_at);
+CREATE INDEX IF NOT EXISTS idx_alerts_device ON alerts(device_id, opened_at);
@@ -0,0 +1,11 @@
+-- Every crash reported by a device, with the ride in progress when it happened.
+CREATE TABLE IF NOT EXISTS crash_events (
+	id BIGSERIAL PRIMARY KEY,
+	device_id TEXT NOT NULL,
+	ride_id BIGINT,
+	latitude DOUBLE PRECISION NOT NULL,
+	longitude DOUBLE PRECISION NOT NULL,
+	timestamp TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_crash_events_ride ON crash_events(ride_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_crash_events_device ON crash_events(device_id, timestamp);
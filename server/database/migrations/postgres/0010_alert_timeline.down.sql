@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS alert_events;
+ALTER TABLE alerts DROP COLUMN cancel_deadline;
@@ -0,0 +1,12 @@
+-- Crash alerts wait for the rider to cancel them until cancel_deadline before anyone is notified.
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS cancel_deadline TIMESTAMP;
+
+-- Everything that happened to an alert, from opening to resolution.
+CREATE TABLE IF NOT EXISTS alert_events (
+	id BIGSERIAL PRIMARY KEY,
+	alert_id BIGINT NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+	event_type TEXT NOT NULL,
+	detail TEXT,
+	timestamp TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_alert_events_alert_time ON alert_events(alert_id, timestamp);
@@ -0,0 +1,33 @@
+-- People alerts are delivered to directly, each through one channel. alert_types is a
+-- comma-separated list of the alert types they receive.
+CREATE TABLE IF NOT EXISTS contacts (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	address TEXT NOT NULL,
+	alert_types TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+-- One alert notification to one contact. The contact's name, channel and address are
+-- copied so the record survives the contact being changed or deleted.
+CREATE TABLE IF NOT EXISTS alert_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	alert_id BIGINT NOT NULL REFERENCES alerts(id) ON DELETE CASCADE,
+	contact_id BIGINT NOT NULL,
+	contact_name TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	address TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	body TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMP NOT NULL,
+	last_attempt_at TIMESTAMP,
+	next_attempt_at TIMESTAMP,
+	sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_alert ON alert_deliveries(alert_id, id);
+CREATE INDEX IF NOT EXISTS idx_alert_deliveries_status ON alert_deliveries(status);
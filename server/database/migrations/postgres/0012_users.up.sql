@@ -0,0 +1,33 @@
+-- Accounts that log in to the API. Passwords are stored as bcrypt hashes.
+CREATE TABLE IF NOT EXISTS users (
+	id BIGSERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL
+);
+
+-- Login sessions and API tokens. Only a SHA-256 hash of each token is kept; scopes is a
+-- comma-separated list, empty for sessions, which may do everything their user may.
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	name TEXT,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user ON auth_tokens(user_id, kind);
+
+-- Which user a device belongs to.
+CREATE TABLE IF NOT EXISTS device_owners (
+	device_id TEXT PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	assigned_at TIMESTAMP NOT NULL
+);
+
+-- Rides belong to the owner of their device when they were recorded.
+ALTER TABLE rides ADD COLUMN IF NOT EXISTS owner_id BIGINT;
+CREATE INDEX IF NOT EXISTS idx_rides_owner_start ON rides(owner_id, start_time);
@@ -0,0 +1,13 @@
+-- Public links to a single ride. The link itself is signed rather than stored; this table
+-- holds what it shows, when it expires and whether it was revoked.
+CREATE TABLE IF NOT EXISTS ride_shares (
+	id BIGSERIAL PRIMARY KEY,
+	ride_id BIGINT NOT NULL REFERENCES rides(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	trim_start_meters DOUBLE PRECISION NOT NULL DEFAULT 0,
+	trim_end_meters DOUBLE PRECISION NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_ride_shares_user ON ride_shares(user_id);
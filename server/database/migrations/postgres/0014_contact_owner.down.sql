@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_contacts_owner;
+ALTER TABLE contacts DROP COLUMN owner_id;
@@ -0,0 +1,4 @@
+-- Contacts belong to a user and only receive the alerts of that user's devices. Contacts
+-- added before accounts existed have no owner and receive alerts of devices without one.
+ALTER TABLE contacts ADD COLUMN IF NOT EXISTS owner_id BIGINT REFERENCES users(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_contacts_owner ON contacts(owner_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ride_positions;
+DROP TABLE IF EXISTS rides;
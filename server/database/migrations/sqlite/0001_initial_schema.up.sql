@@ -0,0 +1,17 @@
+-- Same tables as the original SQLite server, so its databases can be opened as-is.
+CREATE TABLE IF NOT EXISTS rides (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	start_time DATETIME NOT NULL,
+	end_time DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS ride_positions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ride_id INTEGER NOT NULL,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	speed_knots REAL,
+	timestamp DATETIME NOT NULL,
+	FOREIGN KEY (ride_id) REFERENCES rides(id) ON DELETE CASCADE
+);
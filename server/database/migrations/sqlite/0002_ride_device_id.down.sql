@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_rides_device_start;
+ALTER TABLE rides DROP COLUMN device_id;
@@ -0,0 +1,6 @@
+ALTER TABLE rides DROP COLUMN distance_meters;
+ALTER TABLE rides DROP COLUMN elapsed_seconds;
+ALTER TABLE rides DROP COLUMN moving_seconds;
+ALTER TABLE rides DROP COLUMN avg_speed_knots;
+ALTER TABLE rides DROP COLUMN max_speed_knots;
+ALTER TABLE rides DROP COLUMN point_count;
@@ -0,0 +1,7 @@
+-- Ride statistics are filled in when a ride ends, or by the backfill-stats command.
+ALTER TABLE rides ADD COLUMN distance_meters REAL;
+ALTER TABLE rides ADD COLUMN elapsed_seconds INTEGER;
+ALTER TABLE rides ADD COLUMN moving_seconds INTEGER;
+ALTER TABLE rides ADD COLUMN avg_speed_knots REAL;
+ALTER TABLE rides ADD COLUMN max_speed_knots REAL;
+ALTER TABLE rides ADD COLUMN point_count INTEGER;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_rides_track_hash;
+ALTER TABLE rides DROP COLUMN source;
+ALTER TABLE rides DROP COLUMN track_hash;
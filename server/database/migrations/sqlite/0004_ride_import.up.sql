@@ -0,0 +1,4 @@
+-- Imported rides record where they came from and a hash of their track for duplicate detection.
+ALTER TABLE rides ADD COLUMN source TEXT NOT NULL DEFAULT 'device';
+ALTER TABLE rides ADD COLUMN track_hash TEXT;
+CREATE INDEX IF NOT EXISTS idx_rides_track_hash ON rides(track_hash);
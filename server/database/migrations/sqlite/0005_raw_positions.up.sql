@@ -0,0 +1,13 @@
+-- Every point received from a device, before filtering, kept for audit.
+CREATE TABLE IF NOT EXISTS raw_positions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_id TEXT NOT NULL,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	speed_knots REAL,
+	timestamp DATETIME NOT NULL,
+	received_at DATETIME NOT NULL,
+	accepted BOOLEAN NOT NULL,
+	reject_reason TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_raw_positions_device_time ON raw_positions(device_id, timestamp);
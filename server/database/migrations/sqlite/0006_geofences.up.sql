@@ -0,0 +1,31 @@
+-- Named zones, either a circle (center and radius) or a polygon stored as a JSON array of points.
+CREATE TABLE IF NOT EXISTS geofences (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	center_latitude REAL,
+	center_longitude REAL,
+	radius_meters REAL,
+	polygon TEXT,
+	notify BOOLEAN NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+-- A device entering or leaving a zone.
+CREATE TABLE IF NOT EXISTS geofence_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	geofence_id INTEGER NOT NULL,
+	device_id TEXT NOT NULL,
+	ride_id INTEGER,
+	event_type TEXT NOT NULL,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	timestamp DATETIME NOT NULL,
+	FOREIGN KEY (geofence_id) REFERENCES geofences(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_geofence_events_geofence_time ON geofence_events(geofence_id, timestamp);
+
+-- Zones a ride started and ended in, by name at the time of the ride.
+ALTER TABLE rides ADD COLUMN start_zone TEXT;
+ALTER TABLE rides ADD COLUMN end_zone TEXT;
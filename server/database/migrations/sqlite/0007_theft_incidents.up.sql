@@ -0,0 +1,22 @@
+-- A theft incident is opened by movement while a bike is locked and stays open until the owner resolves it.
+CREATE TABLE IF NOT EXISTS theft_incidents (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	opened_at DATETIME NOT NULL,
+	resolved_at DATETIME,
+	resolution_note TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_theft_incidents_device ON theft_incidents(device_id, opened_at);
+
+-- Every position reported while an incident is open.
+CREATE TABLE IF NOT EXISTS theft_incident_positions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	incident_id INTEGER NOT NULL,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	speed_knots REAL,
+	timestamp DATETIME NOT NULL,
+	FOREIGN KEY (incident_id) REFERENCES theft_incidents(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_theft_incident_positions_incident_time ON theft_incident_positions(incident_id, timestamp);
@@ -0,0 +1,11 @@
+-- Every crash reported by a device, with the ride in progress when it happened.
+CREATE TABLE IF NOT EXISTS crash_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device_id TEXT NOT NULL,
+	ride_id INTEGER,
+	latitude REAL NOT NULL,
+	longitude REAL NOT NULL,
+	timestamp DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_crash_events_ride ON crash_events(ride_id, timestamp);
+CREATE INDEX IF NOT EXISTS idx_crash_events_device ON crash_events(device_id, timestamp);
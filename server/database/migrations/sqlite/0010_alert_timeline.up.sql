@@ -0,0 +1,13 @@
+-- Crash alerts wait for the rider to cancel them until cancel_deadline before anyone is notified.
+ALTER TABLE alerts ADD COLUMN cancel_deadline DATETIME;
+
+-- Everything that happened to an alert, from opening to resolution.
+CREATE TABLE IF NOT EXISTS alert_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	alert_id INTEGER NOT NULL,
+	event_type TEXT NOT NULL,
+	detail TEXT,
+	timestamp DATETIME NOT NULL,
+	FOREIGN KEY (alert_id) REFERENCES alerts(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_alert_events_alert_time ON alert_events(alert_id, timestamp);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_rides_owner_start;
+ALTER TABLE rides DROP COLUMN owner_id;
+DROP TABLE IF EXISTS device_owners;
+DROP TABLE IF EXISTS auth_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,35 @@
+-- Accounts that log in to the API. Passwords are stored as bcrypt hashes.
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	is_admin BOOLEAN NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL
+);
+
+-- Login sessions and API tokens. Only a SHA-256 hash of each token is kept; scopes is a
+-- comma-separated list, empty for sessions, which may do everything their user may.
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	name TEXT,
+	token_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_user ON auth_tokens(user_id, kind);
+
+-- Which user a device belongs to.
+CREATE TABLE IF NOT EXISTS device_owners (
+	device_id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	assigned_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Rides belong to the owner of their device when they were recorded.
+ALTER TABLE rides ADD COLUMN owner_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_rides_owner_start ON rides(owner_id, start_time);
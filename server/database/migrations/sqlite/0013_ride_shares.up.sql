@@ -0,0 +1,15 @@
+-- Public links to a single ride. The link itself is signed rather than stored; this table
+-- holds what it shows, when it expires and whether it was revoked.
+CREATE TABLE IF NOT EXISTS ride_shares (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	ride_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	trim_start_meters REAL NOT NULL DEFAULT 0,
+	trim_end_meters REAL NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL,
+	revoked_at DATETIME,
+	FOREIGN KEY (ride_id) REFERENCES rides(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_ride_shares_user ON ride_shares(user_id);
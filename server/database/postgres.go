@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // PostgreSQL driver
+)
+
+// NewPostgresStore initializes the PostgreSQL database connection. The schema is
+// managed by migrations; see NewStore and Migrator.
+func NewPostgresStore(postgresConnStr string) (RideStore, error) {
+	if postgresConnStr == "" {
+		return nil, fmt.Errorf("PostgreSQL connection string not provided. Please set POSTGRES_CONNECTION_STRING environment variable")
+	}
+
+	connStr := postgresConnStr
+	// Add binary_parameters=yes to enable binary encoding of parameters [pg bouncer race condition fix]
+	if !containsBinaryParams(connStr) {
+		separator := "&"
+		if !containsParams(connStr) {
+			separator = "?"
+		}
+		connStr += separator + "binary_parameters=yes"
+	}
+
+	var err error
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL database: %w", err)
+	}
+
+	// Configure connection pool to prevent prepared statement conflicts
+	db.SetMaxOpenConns(25)                 // Maximum number of open connections
+	db.SetMaxIdleConns(5)                  // Maximum number of idle connections
+	db.SetConnMaxLifetime(5 * time.Minute) // Maximum connection lifetime
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping PostgreSQL database: %w", err)
+	}
+
+	log.Println("PostgreSQL database connection initialized successfully")
+	return &sqlStore{db: db, dialect: BackendPostgres}, nil
+}
+
+// Helper function to check if connection string contains query parameters
+func containsParams(connStr string) bool {
+	return strings.Contains(connStr, "?")
+}
+
+// Helper function to check if the connection string contains binary_parameters=no
+func containsBinaryParams(connStr string) bool {
+	return strings.Contains(connStr, "binary_parameters=no")
+}
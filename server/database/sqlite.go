@@ -0,0 +1,43 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite" // Pure Go SQLite driver, no cgo needed
+)
+
+// NewSQLiteStore opens (or creates) the SQLite database at path. It is meant for development
+// and test mode, where no PostgreSQL instance is available. The schema is managed by
+// migrations; see NewStore and Migrator.
+func NewSQLiteStore(path string) (RideStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("SQLite database path not provided. Please set database_path in config.json")
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create SQLite database directory: %w", err)
+		}
+	}
+
+	// _time_format=sqlite stores times as "2006-01-02 15:04:05.999999999-07:00", which sorts
+	// correctly as text and matches databases written by the original SQLite server.
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	// SQLite allows a single writer; serialise access instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open SQLite database %s: %w", path, err)
+	}
+
+	log.Printf("SQLite database %s opened successfully", path)
+	return &sqlStore{db: db, dialect: BackendSQLite}, nil
+}
@@ -0,0 +1,1459 @@
+package database
+
+import (
+	"b3/server/models"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// sqlStore implements RideStore on top of database/sql. The queries are shared by the
+// Postgres and SQLite backends, which only differ in how they connect and in their migrations.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string // BackendPostgres or BackendSQLite, selects the migrations to run
+}
+
+// Migrator returns the schema migrator for the store's dialect.
+func (s *sqlStore) Migrator() (*Migrator, error) {
+	return newMigrator(s.db, s.dialect)
+}
+
+// Close closes the underlying database connection pool.
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// AddRawPosition records a point as received from a device, along with whether the GPS filter accepted it.
+func (s *sqlStore) AddRawPosition(deviceID string, position models.Position, accepted bool, rejectReason string) error {
+	query := `INSERT INTO raw_positions(device_id, latitude, longitude, speed_knots, timestamp, received_at, accepted, reject_reason)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)`
+	var reason sql.NullString
+	if rejectReason != "" {
+		reason = sql.NullString{String: rejectReason, Valid: true}
+	}
+	_, err := s.db.Exec(query, deviceID, position.Latitude, position.Longitude, position.SpeedKnots,
+		position.Timestamp.UTC(), time.Now().UTC(), accepted, reason)
+	if err != nil {
+		return fmt.Errorf("failed to execute AddRawPosition statement: %w", err)
+	}
+	return nil
+}
+
+// rideStatsColumns lists the stats columns in the order scanned by nullRideStats.
+const rideStatsColumns = "distance_meters, elapsed_seconds, moving_seconds, avg_speed_knots, max_speed_knots, point_count"
+
+// nullRideStats holds scan destinations for the nullable stats columns of a ride.
+type nullRideStats struct {
+	distanceMeters sql.NullFloat64
+	elapsedSeconds sql.NullInt64
+	movingSeconds  sql.NullInt64
+	avgSpeedKnots  sql.NullFloat64
+	maxSpeedKnots  sql.NullFloat64
+	pointCount     sql.NullInt64
+}
+
+func (n *nullRideStats) dest() []interface{} {
+	return []interface{}{&n.distanceMeters, &n.elapsedSeconds, &n.movingSeconds, &n.avgSpeedKnots, &n.maxSpeedKnots, &n.pointCount}
+}
+
+// stats returns nil if the ride has no stats yet.
+func (n *nullRideStats) stats() *models.RideStats {
+	if !n.distanceMeters.Valid {
+		return nil
+	}
+	return &models.RideStats{
+		DistanceMeters: n.distanceMeters.Float64,
+		ElapsedSeconds: n.elapsedSeconds.Int64,
+		MovingSeconds:  n.movingSeconds.Int64,
+		AvgSpeedKnots:  n.avgSpeedKnots.Float64,
+		MaxSpeedKnots:  n.maxSpeedKnots.Float64,
+		PointCount:     int(n.pointCount.Int64),
+	}
+}
+
+// BackfillDeviceID assigns rides that were recorded before multi-device support to deviceID.
+func (s *sqlStore) BackfillDeviceID(deviceID string) (int64, error) {
+	result, err := s.db.Exec("UPDATE rides SET device_id = $1 WHERE device_id IS NULL", deviceID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill ride device IDs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetDeviceIDs returns every device that has recorded at least one ride.
+func (s *sqlStore) GetDeviceIDs() ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT device_id FROM rides WHERE device_id IS NOT NULL ORDER BY device_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device IDs: %w", err)
+	}
+	defer rows.Close()
+
+	var deviceIDs []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, fmt.Errorf("failed to scan device ID: %w", err)
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for device IDs: %w", err)
+	}
+	return deviceIDs, nil
+}
+
+// CreateRide inserts a new ride for the given device into the database. The ride belongs
+// to the device's owner, if it has one.
+func (s *sqlStore) CreateRide(deviceID, name string, startTime time.Time) (int64, error) {
+	// PostgreSQL doesn't support LastInsertId, use RETURNING instead
+	var id int64
+	query := "INSERT INTO rides(device_id, name, start_time, owner_id) VALUES($1, $2, $3, (SELECT user_id FROM device_owners WHERE device_id = $1)) RETURNING id"
+	err := s.db.QueryRow(query, deviceID, name, startTime.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateRide statement: %w", err)
+	}
+	return id, nil
+}
+
+// MarkRideImported records the source and track hash of a ride created from an imported file.
+func (s *sqlStore) MarkRideImported(rideID int64, source, trackHash string) error {
+	query := "UPDATE rides SET source = $1, track_hash = $2 WHERE id = $3"
+	_, err := s.db.Exec(query, source, trackHash, rideID)
+	if err != nil {
+		return fmt.Errorf("failed to execute MarkRideImported statement: %w", err)
+	}
+	return nil
+}
+
+// FindDuplicateRide returns the ID of an existing ride with the same track hash, or of
+// the same device with the same start time, or 0 if there is none. Only rides of the
+// device's owner count, so a user never learns of another user's ride.
+func (s *sqlStore) FindDuplicateRide(deviceID string, startTime time.Time, trackHash string) (int64, error) {
+	var id int64
+	query := `SELECT id FROM rides
+		WHERE (track_hash = $1 OR (device_id = $2 AND start_time = $3))
+		AND COALESCE(owner_id, 0) = COALESCE((SELECT user_id FROM device_owners WHERE device_id = $2), 0)
+		ORDER BY id LIMIT 1`
+	err := s.db.QueryRow(query, trackHash, deviceID, startTime.UTC()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query duplicate rides: %w", err)
+	}
+	return id, nil
+}
+
+// DeleteRide removes a ride and, through the foreign key, all its positions.
+func (s *sqlStore) DeleteRide(rideID int64) error {
+	_, err := s.db.Exec("DELETE FROM rides WHERE id = $1", rideID)
+	if err != nil {
+		return fmt.Errorf("failed to execute DeleteRide statement: %w", err)
+	}
+	return nil
+}
+
+// AddPositionToRide adds a new GPS position to an existing ride.
+func (s *sqlStore) AddPositionToRide(rideID int64, position models.Position) error {
+	query := "INSERT INTO ride_positions(ride_id, latitude, longitude, speed_knots, timestamp) VALUES($1, $2, $3, $4, $5)"
+	_, err := s.db.Exec(query, rideID, position.Latitude, position.Longitude, position.SpeedKnots, position.Timestamp.UTC()) // Ensure storing in UTC
+	if err != nil {
+		return fmt.Errorf("failed to execute AddPositionToRide statement: %w", err)
+	}
+	return nil
+}
+
+// maxPositionsPerInsert keeps multi-row inserts well below the bind parameter limits
+// of Postgres (65535) and SQLite (32766), at 5 parameters per row.
+const maxPositionsPerInsert = 1000
+
+// AddPositions inserts positions with multi-row INSERT statements in a single transaction.
+func (s *sqlStore) AddPositions(positions []RidePosition) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin AddPositions transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	for start := 0; start < len(positions); start += maxPositionsPerInsert {
+		end := start + maxPositionsPerInsert
+		if end > len(positions) {
+			end = len(positions)
+		}
+
+		var query strings.Builder
+		query.WriteString("INSERT INTO ride_positions(ride_id, latitude, longitude, speed_knots, timestamp) VALUES ")
+		args := make([]interface{}, 0, (end-start)*5)
+		for i, rp := range positions[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+			args = append(args, rp.RideID, rp.Position.Latitude, rp.Position.Longitude, rp.Position.SpeedKnots, rp.Position.Timestamp.UTC())
+		}
+		if _, err := tx.Exec(query.String(), args...); err != nil {
+			return fmt.Errorf("failed to execute AddPositions statement: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit AddPositions transaction: %w", err)
+	}
+	return nil
+}
+
+// EndRide updates the end_time of a ride.
+func (s *sqlStore) EndRide(rideID int64, endTime time.Time) error {
+	query := "UPDATE rides SET end_time = $1 WHERE id = $2"
+	_, err := s.db.Exec(query, endTime.UTC(), rideID) // Ensure storing in UTC
+	if err != nil {
+		return fmt.Errorf("failed to execute EndRide statement: %w", err)
+	}
+	return nil
+}
+
+// SaveRideStats stores the computed statistics of a ride.
+func (s *sqlStore) SaveRideStats(rideID int64, stats models.RideStats) error {
+	query := `UPDATE rides SET distance_meters = $1, elapsed_seconds = $2, moving_seconds = $3,
+		avg_speed_knots = $4, max_speed_knots = $5, point_count = $6 WHERE id = $7`
+	_, err := s.db.Exec(query, stats.DistanceMeters, stats.ElapsedSeconds, stats.MovingSeconds,
+		stats.AvgSpeedKnots, stats.MaxSpeedKnots, stats.PointCount, rideID)
+	if err != nil {
+		return fmt.Errorf("failed to execute SaveRideStats statement: %w", err)
+	}
+	return nil
+}
+
+// GetEndedRideIDs returns the IDs of all finished rides, oldest first.
+// If onlyMissingStats is true, rides that already have stats are skipped.
+func (s *sqlStore) GetEndedRideIDs(onlyMissingStats bool) ([]int64, error) {
+	query := "SELECT id FROM rides WHERE end_time IS NOT NULL"
+	if onlyMissingStats {
+		query += " AND distance_meters IS NULL"
+	}
+	query += " ORDER BY start_time ASC"
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ended rides: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan ride ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for ended rides: %w", err)
+	}
+	return ids, nil
+}
+
+// GetRideDetails retrieves a specific ride and all its positions.
+func (s *sqlStore) GetRideDetails(rideID int64) (*models.RideDetail, error) {
+	ride := &models.RideDetail{}
+	var endTime sql.NullTime // Handle NULL end_time
+	var deviceID, startZone, endZone sql.NullString
+	var ownerID sql.NullInt64
+	var stats nullRideStats
+
+	// First query: Get ride details
+	rideQuery := "SELECT id, device_id, source, name, start_time, end_time, start_zone, end_zone, owner_id, " + rideStatsColumns + " FROM rides WHERE id = $1"
+	row := s.db.QueryRow(rideQuery, rideID)
+	if err := row.Scan(append([]interface{}{&ride.ID, &deviceID, &ride.Source, &ride.Name, &ride.StartTime, &endTime, &startZone, &endZone, &ownerID}, stats.dest()...)...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("ride with ID %d: %w", rideID, ErrRideNotFound)
+		}
+		return nil, fmt.Errorf("failed to scan ride details: %w", err)
+	}
+	if endTime.Valid {
+		ride.EndTime = endTime.Time
+	}
+	ride.DeviceID = deviceID.String
+	ride.StartZone, ride.EndZone = startZone.String, endZone.String
+	ride.OwnerID = ownerID.Int64
+	ride.Stats = stats.stats()
+
+	// Second query: Get ride positions with a different variable name
+	positionsQuery := "SELECT latitude, longitude, speed_knots, timestamp FROM ride_positions WHERE ride_id = $1 ORDER BY timestamp ASC"
+	log.Printf("Positions Query: %s", positionsQuery)
+	log.Printf("RideID: %d", rideID)
+
+	rows, err := s.db.Query(positionsQuery, rideID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ride positions for ride_id %d: %w", rideID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pos models.Position
+		var speedKnots sql.NullFloat64
+		if err := rows.Scan(&pos.Latitude, &pos.Longitude, &speedKnots, &pos.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan ride position: %w", err)
+		}
+		if speedKnots.Valid {
+			pos.SpeedKnots = speedKnots.Float64
+		}
+		ride.Positions = append(ride.Positions, pos)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for ride positions: %w", err)
+	}
+
+	// Ensure times are UTC
+	ride.StartTime = ride.StartTime.UTC()
+	if endTime.Valid {
+		ride.EndTime = ride.EndTime.UTC()
+	}
+	for i := range ride.Positions {
+		ride.Positions[i].Timestamp = ride.Positions[i].Timestamp.UTC()
+	}
+
+	log.Printf("Successfully retrieved ride %d with %d positions", rideID, len(ride.Positions))
+	return ride, nil
+}
+
+// GetAllRidesSummary retrieves a summary of all rides.
+func (s *sqlStore) GetAllRidesSummary() ([]models.RideSummary, error) {
+	rows, err := s.db.Query("SELECT id, device_id, source, name, start_time, end_time, start_zone, end_zone, owner_id, " + rideStatsColumns + " FROM rides ORDER BY start_time DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query all rides summary: %w", err)
+	}
+	defer rows.Close()
+
+	var rides []models.RideSummary
+	for rows.Next() {
+		var ride models.RideSummary
+		var endTime sql.NullTime // Handle NULL end_time
+		var deviceID, startZone, endZone sql.NullString
+		var ownerID sql.NullInt64
+		var stats nullRideStats
+		if err := rows.Scan(append([]interface{}{&ride.ID, &deviceID, &ride.Source, &ride.Name, &ride.StartTime, &endTime, &startZone, &endZone, &ownerID}, stats.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan ride summary: %w", err)
+		}
+		if endTime.Valid {
+			ride.EndTime = endTime.Time
+		}
+		ride.DeviceID = deviceID.String
+		ride.StartZone, ride.EndZone = startZone.String, endZone.String
+		ride.OwnerID = ownerID.Int64
+		ride.Stats = stats.stats()
+		// Ensure times are UTC
+		ride.StartTime = ride.StartTime.UTC()
+		if endTime.Valid {
+			ride.EndTime = ride.EndTime.UTC()
+		}
+		rides = append(rides, ride)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for all rides summary: %w", err)
+	}
+	return rides, nil
+}
+
+// GetAllRidesSummaryWithPagination retrieves a summary of rides with pagination and optional date, device and owner filtering.
+// An empty deviceID returns rides from every device and an ownerID of 0 rides of every owner.
+func (s *sqlStore) GetAllRidesSummaryWithPagination(page, limit int, dateFilter *time.Time, deviceID string, ownerID int64) ([]models.RideSummary, error) {
+	offset := (page - 1) * limit
+
+	var conditions []string
+	var args []interface{}
+
+	if dateFilter != nil {
+		// Filter by start date (same day)
+		startOfDay, endOfDay := utcDayBounds(*dateFilter)
+		args = append(args, startOfDay, endOfDay)
+		conditions = append(conditions, fmt.Sprintf("start_time >= $%d AND start_time < $%d", len(args)-1, len(args)))
+	}
+	if deviceID != "" {
+		args = append(args, deviceID)
+		conditions = append(conditions, fmt.Sprintf("device_id = $%d", len(args)))
+	}
+	if ownerID != 0 {
+		args = append(args, ownerID)
+		conditions = append(conditions, fmt.Sprintf("owner_id = $%d", len(args)))
+	}
+
+	query := "SELECT id, device_id, source, name, start_time, end_time, start_zone, end_zone, owner_id, " + rideStatsColumns + " FROM rides"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY start_time DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rides summary with pagination: %w", err)
+	}
+	defer rows.Close()
+
+	var rides []models.RideSummary
+	for rows.Next() {
+		var ride models.RideSummary
+		var endTime sql.NullTime // Handle NULL end_time
+		var deviceID, startZone, endZone sql.NullString
+		var ownerID sql.NullInt64
+		var stats nullRideStats
+		if err := rows.Scan(append([]interface{}{&ride.ID, &deviceID, &ride.Source, &ride.Name, &ride.StartTime, &endTime, &startZone, &endZone, &ownerID}, stats.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan ride summary: %w", err)
+		}
+		if endTime.Valid {
+			ride.EndTime = endTime.Time
+		}
+		ride.DeviceID = deviceID.String
+		ride.StartZone, ride.EndZone = startZone.String, endZone.String
+		ride.OwnerID = ownerID.Int64
+		ride.Stats = stats.stats()
+		// Ensure times are UTC
+		ride.StartTime = ride.StartTime.UTC()
+		if endTime.Valid {
+			ride.EndTime = ride.EndTime.UTC()
+		}
+		rides = append(rides, ride)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for paginated rides summary: %w", err)
+	}
+	return rides, nil
+}
+
+// GetOpenRides returns every ride that has not ended, oldest first.
+func (s *sqlStore) GetOpenRides() ([]models.RideSummary, error) {
+	rows, err := s.db.Query("SELECT id, device_id, source, name, start_time, end_time, start_zone, end_zone, owner_id, " + rideStatsColumns + " FROM rides WHERE end_time IS NULL ORDER BY start_time ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query open rides: %w", err)
+	}
+	defer rows.Close()
+
+	var rides []models.RideSummary
+	for rows.Next() {
+		var ride models.RideSummary
+		var endTime sql.NullTime // Always NULL here, but scanned like every other summary
+		var deviceID, startZone, endZone sql.NullString
+		var ownerID sql.NullInt64
+		var stats nullRideStats
+		if err := rows.Scan(append([]interface{}{&ride.ID, &deviceID, &ride.Source, &ride.Name, &ride.StartTime, &endTime, &startZone, &endZone, &ownerID}, stats.dest()...)...); err != nil {
+			return nil, fmt.Errorf("failed to scan open ride: %w", err)
+		}
+		ride.DeviceID = deviceID.String
+		ride.StartZone, ride.EndZone = startZone.String, endZone.String
+		ride.OwnerID = ownerID.Int64
+		ride.Stats = stats.stats()
+		ride.StartTime = ride.StartTime.UTC()
+		rides = append(rides, ride)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for open rides: %w", err)
+	}
+	return rides, nil
+}
+
+// GetLastRidePosition returns the most recent position of a ride, or nil if it has none.
+func (s *sqlStore) GetLastRidePosition(rideID int64) (*models.Position, error) {
+	query := "SELECT latitude, longitude, speed_knots, timestamp FROM ride_positions WHERE ride_id = $1 ORDER BY timestamp DESC LIMIT 1"
+	var pos models.Position
+	var speedKnots sql.NullFloat64
+	err := s.db.QueryRow(query, rideID).Scan(&pos.Latitude, &pos.Longitude, &speedKnots, &pos.Timestamp)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query last position of ride %d: %w", rideID, err)
+	}
+	pos.SpeedKnots = speedKnots.Float64
+	pos.Timestamp = pos.Timestamp.UTC()
+	return &pos, nil
+}
+
+// SetRideStartZone records the name of the geofence a ride started in.
+func (s *sqlStore) SetRideStartZone(rideID int64, zone string) error {
+	_, err := s.db.Exec("UPDATE rides SET start_zone = $1 WHERE id = $2", zone, rideID)
+	if err != nil {
+		return fmt.Errorf("failed to execute SetRideStartZone statement: %w", err)
+	}
+	return nil
+}
+
+// SetRideEndZone records the name of the geofence a ride ended in.
+func (s *sqlStore) SetRideEndZone(rideID int64, zone string) error {
+	_, err := s.db.Exec("UPDATE rides SET end_zone = $1 WHERE id = $2", zone, rideID)
+	if err != nil {
+		return fmt.Errorf("failed to execute SetRideEndZone statement: %w", err)
+	}
+	return nil
+}
+
+// geofenceShape returns the nullable shape columns of a geofence: center latitude,
+// center longitude, radius and the polygon as JSON.
+func geofenceShape(geofence models.Geofence) ([]interface{}, error) {
+	var centerLat, centerLon, radius sql.NullFloat64
+	var polygon sql.NullString
+	if geofence.Center != nil {
+		centerLat = sql.NullFloat64{Float64: geofence.Center.Latitude, Valid: true}
+		centerLon = sql.NullFloat64{Float64: geofence.Center.Longitude, Valid: true}
+		radius = sql.NullFloat64{Float64: geofence.RadiusMeters, Valid: true}
+	}
+	if len(geofence.Polygon) > 0 {
+		data, err := json.Marshal(geofence.Polygon)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode geofence polygon: %w", err)
+		}
+		polygon = sql.NullString{String: string(data), Valid: true}
+	}
+	return []interface{}{centerLat, centerLon, radius, polygon}, nil
+}
+
+// CreateGeofence inserts a geofence and returns its ID.
+func (s *sqlStore) CreateGeofence(geofence models.Geofence) (int64, error) {
+	shape, err := geofenceShape(geofence)
+	if err != nil {
+		return 0, err
+	}
+	query := `INSERT INTO geofences(name, kind, notify, created_at, updated_at, center_latitude, center_longitude, radius_meters, polygon)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	args := append([]interface{}{geofence.Name, geofence.Type, geofence.Notify, geofence.CreatedAt.UTC(), geofence.UpdatedAt.UTC()}, shape...)
+	var id int64
+	if err := s.db.QueryRow(query, args...).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to execute CreateGeofence statement: %w", err)
+	}
+	return id, nil
+}
+
+// UpdateGeofence replaces the name, shape and notify flag of an existing geofence.
+func (s *sqlStore) UpdateGeofence(geofence models.Geofence) error {
+	shape, err := geofenceShape(geofence)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE geofences SET name = $1, kind = $2, notify = $3, updated_at = $4,
+		center_latitude = $5, center_longitude = $6, radius_meters = $7, polygon = $8 WHERE id = $9`
+	args := append([]interface{}{geofence.Name, geofence.Type, geofence.Notify, geofence.UpdatedAt.UTC()}, shape...)
+	result, err := s.db.Exec(query, append(args, geofence.ID)...)
+	if err != nil {
+		return fmt.Errorf("failed to execute UpdateGeofence statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("geofence with ID %d: %w", geofence.ID, ErrGeofenceNotFound)
+	}
+	return nil
+}
+
+// DeleteGeofence removes a geofence and, through the foreign key, its events.
+func (s *sqlStore) DeleteGeofence(id int64) error {
+	result, err := s.db.Exec("DELETE FROM geofences WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to execute DeleteGeofence statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("geofence with ID %d: %w", id, ErrGeofenceNotFound)
+	}
+	return nil
+}
+
+// GetGeofences returns every geofence, oldest first.
+func (s *sqlStore) GetGeofences() ([]models.Geofence, error) {
+	rows, err := s.db.Query(`SELECT id, name, kind, notify, created_at, updated_at, center_latitude, center_longitude, radius_meters, polygon
+		FROM geofences ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query geofences: %w", err)
+	}
+	defer rows.Close()
+
+	var geofences []models.Geofence
+	for rows.Next() {
+		var geofence models.Geofence
+		var centerLat, centerLon, radius sql.NullFloat64
+		var polygon sql.NullString
+		if err := rows.Scan(&geofence.ID, &geofence.Name, &geofence.Type, &geofence.Notify, &geofence.CreatedAt, &geofence.UpdatedAt,
+			&centerLat, &centerLon, &radius, &polygon); err != nil {
+			return nil, fmt.Errorf("failed to scan geofence: %w", err)
+		}
+		if centerLat.Valid && centerLon.Valid {
+			geofence.Center = &models.GeoPoint{Latitude: centerLat.Float64, Longitude: centerLon.Float64}
+			geofence.RadiusMeters = radius.Float64
+		}
+		if polygon.Valid {
+			if err := json.Unmarshal([]byte(polygon.String), &geofence.Polygon); err != nil {
+				return nil, fmt.Errorf("failed to decode polygon of geofence %d: %w", geofence.ID, err)
+			}
+		}
+		geofence.CreatedAt = geofence.CreatedAt.UTC()
+		geofence.UpdatedAt = geofence.UpdatedAt.UTC()
+		geofences = append(geofences, geofence)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for geofences: %w", err)
+	}
+	return geofences, nil
+}
+
+// AddGeofenceEvent records a device entering or exiting a geofence and returns the event's ID.
+func (s *sqlStore) AddGeofenceEvent(event models.GeofenceEvent) (int64, error) {
+	query := `INSERT INTO geofence_events(geofence_id, device_id, ride_id, event_type, latitude, longitude, timestamp)
+		VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	var rideID sql.NullInt64
+	if event.RideID != 0 {
+		rideID = sql.NullInt64{Int64: event.RideID, Valid: true}
+	}
+	var id int64
+	err := s.db.QueryRow(query, event.GeofenceID, event.DeviceID, rideID, event.Type,
+		event.Latitude, event.Longitude, event.Timestamp.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute AddGeofenceEvent statement: %w", err)
+	}
+	return id, nil
+}
+
+// GetGeofenceEvents returns up to limit of the most recent events of a geofence, newest first.
+func (s *sqlStore) GetGeofenceEvents(geofenceID int64, limit int) ([]models.GeofenceEvent, error) {
+	query := `SELECT e.id, e.geofence_id, g.name, e.device_id, e.ride_id, e.event_type, e.latitude, e.longitude, e.timestamp
+		FROM geofence_events e JOIN geofences g ON g.id = e.geofence_id
+		WHERE e.geofence_id = $1 ORDER BY e.timestamp DESC, e.id DESC LIMIT $2`
+	rows, err := s.db.Query(query, geofenceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events of geofence %d: %w", geofenceID, err)
+	}
+	defer rows.Close()
+
+	var events []models.GeofenceEvent
+	for rows.Next() {
+		var event models.GeofenceEvent
+		var rideID sql.NullInt64
+		if err := rows.Scan(&event.ID, &event.GeofenceID, &event.GeofenceName, &event.DeviceID, &rideID, &event.Type,
+			&event.Latitude, &event.Longitude, &event.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan geofence event: %w", err)
+		}
+		event.RideID = rideID.Int64
+		event.Timestamp = event.Timestamp.UTC()
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for geofence events: %w", err)
+	}
+	return events, nil
+}
+
+// CreateTheftIncident opens a theft incident for a device and returns its ID.
+func (s *sqlStore) CreateTheftIncident(deviceID string, openedAt time.Time) (int64, error) {
+	var id int64
+	query := "INSERT INTO theft_incidents(device_id, status, opened_at) VALUES($1, $2, $3) RETURNING id"
+	err := s.db.QueryRow(query, deviceID, models.IncidentOpen, openedAt.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateTheftIncident statement: %w", err)
+	}
+	return id, nil
+}
+
+// AddTheftIncidentPosition records a position of a bike with an open theft incident.
+func (s *sqlStore) AddTheftIncidentPosition(incidentID int64, position models.Position) error {
+	query := "INSERT INTO theft_incident_positions(incident_id, latitude, longitude, speed_knots, timestamp) VALUES($1, $2, $3, $4, $5)"
+	_, err := s.db.Exec(query, incidentID, position.Latitude, position.Longitude, position.SpeedKnots, position.Timestamp.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to execute AddTheftIncidentPosition statement: %w", err)
+	}
+	return nil
+}
+
+// ResolveTheftIncident closes a theft incident with an optional note from the owner.
+func (s *sqlStore) ResolveTheftIncident(incidentID int64, resolvedAt time.Time, note string) error {
+	var resolutionNote sql.NullString
+	if note != "" {
+		resolutionNote = sql.NullString{String: note, Valid: true}
+	}
+	query := "UPDATE theft_incidents SET status = $1, resolved_at = $2, resolution_note = $3 WHERE id = $4"
+	result, err := s.db.Exec(query, models.IncidentResolved, resolvedAt.UTC(), resolutionNote, incidentID)
+	if err != nil {
+		return fmt.Errorf("failed to execute ResolveTheftIncident statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("theft incident with ID %d: %w", incidentID, ErrIncidentNotFound)
+	}
+	return nil
+}
+
+// theftIncidentColumns lists the columns scanned by scanTheftIncident.
+const theftIncidentColumns = `i.id, i.device_id, i.status, i.opened_at, i.resolved_at, i.resolution_note,
+	(SELECT COUNT(*) FROM theft_incident_positions p WHERE p.incident_id = i.id)`
+
+// scanTheftIncident reads a row selected with theftIncidentColumns.
+func scanTheftIncident(row interface{ Scan(...interface{}) error }) (models.TheftIncident, error) {
+	var incident models.TheftIncident
+	var resolvedAt sql.NullTime
+	var note sql.NullString
+	err := row.Scan(&incident.ID, &incident.DeviceID, &incident.Status, &incident.OpenedAt, &resolvedAt, &note, &incident.PositionCount)
+	if err != nil {
+		return incident, err
+	}
+	incident.OpenedAt = incident.OpenedAt.UTC()
+	if resolvedAt.Valid {
+		incident.ResolvedAt = resolvedAt.Time.UTC()
+	}
+	incident.ResolutionNote = note.String
+	return incident, nil
+}
+
+// GetTheftIncident retrieves a theft incident and all its positions, oldest first.
+func (s *sqlStore) GetTheftIncident(incidentID int64) (*models.TheftIncidentDetail, error) {
+	row := s.db.QueryRow("SELECT "+theftIncidentColumns+" FROM theft_incidents i WHERE i.id = $1", incidentID)
+	incident, err := scanTheftIncident(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("theft incident with ID %d: %w", incidentID, ErrIncidentNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan theft incident: %w", err)
+	}
+
+	query := "SELECT latitude, longitude, speed_knots, timestamp FROM theft_incident_positions WHERE incident_id = $1 ORDER BY timestamp ASC, id ASC"
+	rows, err := s.db.Query(query, incidentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query positions of theft incident %d: %w", incidentID, err)
+	}
+	defer rows.Close()
+
+	detail := &models.TheftIncidentDetail{TheftIncident: incident, Positions: []models.Position{}}
+	for rows.Next() {
+		var pos models.Position
+		var speedKnots sql.NullFloat64
+		if err := rows.Scan(&pos.Latitude, &pos.Longitude, &speedKnots, &pos.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan theft incident position: %w", err)
+		}
+		pos.SpeedKnots = speedKnots.Float64
+		pos.Timestamp = pos.Timestamp.UTC()
+		detail.Positions = append(detail.Positions, pos)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for theft incident positions: %w", err)
+	}
+	return detail, nil
+}
+
+// GetTheftIncidents returns theft incidents, newest first, optionally only open ones
+// and only those of one device. An empty deviceID matches every device.
+func (s *sqlStore) GetTheftIncidents(deviceID string, openOnly bool) ([]models.TheftIncident, error) {
+	var conditions []string
+	var args []interface{}
+	if deviceID != "" {
+		args = append(args, deviceID)
+		conditions = append(conditions, fmt.Sprintf("i.device_id = $%d", len(args)))
+	}
+	if openOnly {
+		args = append(args, models.IncidentOpen)
+		conditions = append(conditions, fmt.Sprintf("i.status = $%d", len(args)))
+	}
+	query := "SELECT " + theftIncidentColumns + " FROM theft_incidents i"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY i.opened_at DESC, i.id DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query theft incidents: %w", err)
+	}
+	defer rows.Close()
+
+	var incidents []models.TheftIncident
+	for rows.Next() {
+		incident, err := scanTheftIncident(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan theft incident: %w", err)
+		}
+		incidents = append(incidents, incident)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for theft incidents: %w", err)
+	}
+	return incidents, nil
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// CreateAlert stores a new alert and returns its ID.
+func (s *sqlStore) CreateAlert(alert models.Alert) (int64, error) {
+	var id int64
+	query := `INSERT INTO alerts(type, device_id, alert_key, status, title, message, latitude, longitude,
+		trigger_count, notification_count, escalation_level, opened_at, last_triggered_at, last_notified_at, acknowledged_at, resolved_at, cancel_deadline)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id`
+	err := s.db.QueryRow(query, alert.Type, alert.DeviceID, alert.Key, alert.Status, alert.Title, alert.Message,
+		alert.Latitude, alert.Longitude, alert.TriggerCount, alert.NotificationCount, alert.EscalationLevel,
+		alert.OpenedAt.UTC(), alert.LastTriggeredAt.UTC(), nullTime(alert.LastNotifiedAt),
+		nullTime(alert.AcknowledgedAt), nullTime(alert.ResolvedAt), nullTime(alert.CancelDeadline)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateAlert statement: %w", err)
+	}
+	return id, nil
+}
+
+// UpdateAlert saves the status, counters, latest trigger and timestamps of an existing alert.
+func (s *sqlStore) UpdateAlert(alert models.Alert) error {
+	query := `UPDATE alerts SET status = $1, message = $2, latitude = $3, longitude = $4, trigger_count = $5,
+		notification_count = $6, escalation_level = $7, last_triggered_at = $8, last_notified_at = $9,
+		acknowledged_at = $10, resolved_at = $11 WHERE id = $12`
+	result, err := s.db.Exec(query, alert.Status, alert.Message, alert.Latitude, alert.Longitude, alert.TriggerCount,
+		alert.NotificationCount, alert.EscalationLevel, alert.LastTriggeredAt.UTC(), nullTime(alert.LastNotifiedAt),
+		nullTime(alert.AcknowledgedAt), nullTime(alert.ResolvedAt), alert.ID)
+	if err != nil {
+		return fmt.Errorf("failed to execute UpdateAlert statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("alert with ID %d: %w", alert.ID, ErrAlertNotFound)
+	}
+	return nil
+}
+
+// alertColumns lists the columns scanned by scanAlert.
+const alertColumns = `id, type, device_id, alert_key, status, title, message, latitude, longitude, trigger_count,
+	notification_count, escalation_level, opened_at, last_triggered_at, last_notified_at, acknowledged_at, resolved_at, cancel_deadline`
+
+// scanAlert reads a row selected with alertColumns.
+func scanAlert(row interface{ Scan(...interface{}) error }) (models.Alert, error) {
+	var alert models.Alert
+	var latitude, longitude sql.NullFloat64
+	var notifiedAt, acknowledgedAt, resolvedAt, cancelDeadline sql.NullTime
+	err := row.Scan(&alert.ID, &alert.Type, &alert.DeviceID, &alert.Key, &alert.Status, &alert.Title, &alert.Message,
+		&latitude, &longitude, &alert.TriggerCount, &alert.NotificationCount, &alert.EscalationLevel,
+		&alert.OpenedAt, &alert.LastTriggeredAt, &notifiedAt, &acknowledgedAt, &resolvedAt, &cancelDeadline)
+	if err != nil {
+		return alert, err
+	}
+	alert.Latitude, alert.Longitude = latitude.Float64, longitude.Float64
+	alert.OpenedAt = alert.OpenedAt.UTC()
+	alert.LastTriggeredAt = alert.LastTriggeredAt.UTC()
+	if notifiedAt.Valid {
+		alert.LastNotifiedAt = notifiedAt.Time.UTC()
+	}
+	if acknowledgedAt.Valid {
+		alert.AcknowledgedAt = acknowledgedAt.Time.UTC()
+	}
+	if resolvedAt.Valid {
+		alert.ResolvedAt = resolvedAt.Time.UTC()
+	}
+	if cancelDeadline.Valid {
+		alert.CancelDeadline = cancelDeadline.Time.UTC()
+	}
+	return alert, nil
+}
+
+// GetAlert retrieves an alert by its ID.
+func (s *sqlStore) GetAlert(id int64) (*models.Alert, error) {
+	alert, err := scanAlert(s.db.QueryRow("SELECT "+alertColumns+" FROM alerts WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("alert with ID %d: %w", id, ErrAlertNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan alert: %w", err)
+	}
+	return &alert, nil
+}
+
+// GetAlerts returns alerts, newest first, optionally only those neither resolved nor
+// cancelled and only those of one device. An empty deviceID matches every device.
+func (s *sqlStore) GetAlerts(deviceID string, activeOnly bool) ([]models.Alert, error) {
+	var conditions []string
+	var args []interface{}
+	if deviceID != "" {
+		args = append(args, deviceID)
+		conditions = append(conditions, fmt.Sprintf("device_id = $%d", len(args)))
+	}
+	if activeOnly {
+		args = append(args, models.AlertResolved, models.AlertCancelled)
+		conditions = append(conditions, fmt.Sprintf("status NOT IN ($%d, $%d)", len(args)-1, len(args)))
+	}
+	query := "SELECT " + alertColumns + " FROM alerts"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY opened_at DESC, id DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var alerts []models.Alert
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, alert)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// AddAlertEvent records a step in the timeline of an alert.
+func (s *sqlStore) AddAlertEvent(event models.AlertEvent) error {
+	var detail sql.NullString
+	if event.Detail != "" {
+		detail = sql.NullString{String: event.Detail, Valid: true}
+	}
+	query := "INSERT INTO alert_events(alert_id, event_type, detail, timestamp) VALUES($1, $2, $3, $4)"
+	if _, err := s.db.Exec(query, event.AlertID, event.Type, detail, event.Timestamp.UTC()); err != nil {
+		return fmt.Errorf("failed to execute AddAlertEvent statement: %w", err)
+	}
+	return nil
+}
+
+// GetAlertEvents returns the timeline of an alert, oldest first.
+func (s *sqlStore) GetAlertEvents(alertID int64) ([]models.AlertEvent, error) {
+	query := "SELECT id, alert_id, event_type, detail, timestamp FROM alert_events WHERE alert_id = $1 ORDER BY timestamp ASC, id ASC"
+	rows, err := s.db.Query(query, alertID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events of alert %d: %w", alertID, err)
+	}
+	defer rows.Close()
+
+	var events []models.AlertEvent
+	for rows.Next() {
+		var event models.AlertEvent
+		var detail sql.NullString
+		if err := rows.Scan(&event.ID, &event.AlertID, &event.Type, &detail, &event.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan alert event: %w", err)
+		}
+		event.Detail = detail.String
+		event.Timestamp = event.Timestamp.UTC()
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for alert events: %w", err)
+	}
+	return events, nil
+}
+
+// AddCrashEvent records a crash reported by a device and returns the event's ID.
+func (s *sqlStore) AddCrashEvent(event models.CrashEvent) (int64, error) {
+	query := "INSERT INTO crash_events(device_id, ride_id, latitude, longitude, timestamp) VALUES($1, $2, $3, $4, $5) RETURNING id"
+	var rideID sql.NullInt64
+	if event.RideID != 0 {
+		rideID = sql.NullInt64{Int64: event.RideID, Valid: true}
+	}
+	var id int64
+	err := s.db.QueryRow(query, event.DeviceID, rideID, event.Latitude, event.Longitude, event.Timestamp.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute AddCrashEvent statement: %w", err)
+	}
+	return id, nil
+}
+
+// GetRideCrashEvents returns the crashes that happened during a ride, oldest first.
+func (s *sqlStore) GetRideCrashEvents(rideID int64) ([]models.CrashEvent, error) {
+	query := "SELECT id, device_id, ride_id, latitude, longitude, timestamp FROM crash_events WHERE ride_id = $1 ORDER BY timestamp ASC, id ASC"
+	rows, err := s.db.Query(query, rideID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crash events of ride %d: %w", rideID, err)
+	}
+	defer rows.Close()
+
+	var events []models.CrashEvent
+	for rows.Next() {
+		var event models.CrashEvent
+		var eventRideID sql.NullInt64
+		if err := rows.Scan(&event.ID, &event.DeviceID, &eventRideID, &event.Latitude, &event.Longitude, &event.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan crash event: %w", err)
+		}
+		event.RideID = eventRideID.Int64
+		event.Timestamp = event.Timestamp.UTC()
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for crash events: %w", err)
+	}
+	return events, nil
+}
+
+// CreateContact stores a new contact and returns its ID.
+func (s *sqlStore) CreateContact(contact models.Contact) (int64, error) {
+	query := `INSERT INTO contacts(owner_id, name, channel, address, alert_types, created_at, updated_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	ownerID := sql.NullInt64{Int64: contact.OwnerID, Valid: contact.OwnerID != 0}
+	var id int64
+	err := s.db.QueryRow(query, ownerID, contact.Name, contact.Channel, contact.Address, strings.Join(contact.AlertTypes, ","),
+		contact.CreatedAt.UTC(), contact.UpdatedAt.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateContact statement: %w", err)
+	}
+	return id, nil
+}
+
+// UpdateContact replaces the name, channel, address and alert types of an existing contact.
+// Its owner stays the same.
+func (s *sqlStore) UpdateContact(contact models.Contact) error {
+	query := "UPDATE contacts SET name = $1, channel = $2, address = $3, alert_types = $4, updated_at = $5 WHERE id = $6"
+	result, err := s.db.Exec(query, contact.Name, contact.Channel, contact.Address, strings.Join(contact.AlertTypes, ","),
+		contact.UpdatedAt.UTC(), contact.ID)
+	if err != nil {
+		return fmt.Errorf("failed to execute UpdateContact statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("contact with ID %d: %w", contact.ID, ErrContactNotFound)
+	}
+	return nil
+}
+
+// DeleteContact removes a contact. Its past deliveries are kept.
+func (s *sqlStore) DeleteContact(id int64) error {
+	result, err := s.db.Exec("DELETE FROM contacts WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to execute DeleteContact statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("contact with ID %d: %w", id, ErrContactNotFound)
+	}
+	return nil
+}
+
+const contactColumns = "id, owner_id, name, channel, address, alert_types, created_at, updated_at"
+
+func scanContact(row interface{ Scan(...interface{}) error }) (models.Contact, error) {
+	var contact models.Contact
+	var ownerID sql.NullInt64
+	var alertTypes string
+	err := row.Scan(&contact.ID, &ownerID, &contact.Name, &contact.Channel, &contact.Address, &alertTypes, &contact.CreatedAt, &contact.UpdatedAt)
+	if err != nil {
+		return contact, err
+	}
+	contact.OwnerID = ownerID.Int64
+	contact.AlertTypes = []string{}
+	if alertTypes != "" {
+		contact.AlertTypes = strings.Split(alertTypes, ",")
+	}
+	contact.CreatedAt = contact.CreatedAt.UTC()
+	contact.UpdatedAt = contact.UpdatedAt.UTC()
+	return contact, nil
+}
+
+// GetContact retrieves a contact by its ID.
+func (s *sqlStore) GetContact(id int64) (*models.Contact, error) {
+	contact, err := scanContact(s.db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("contact with ID %d: %w", id, ErrContactNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan contact: %w", err)
+	}
+	return &contact, nil
+}
+
+// GetContacts returns every contact, oldest first.
+func (s *sqlStore) GetContacts() ([]models.Contact, error) {
+	rows, err := s.db.Query("SELECT " + contactColumns + " FROM contacts ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contacts: %w", err)
+	}
+	defer rows.Close()
+
+	var contacts []models.Contact
+	for rows.Next() {
+		contact, err := scanContact(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		contacts = append(contacts, contact)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// CreateAlertDelivery stores a new delivery of an alert to a contact and returns its ID.
+func (s *sqlStore) CreateAlertDelivery(delivery models.AlertDelivery) (int64, error) {
+	query := `INSERT INTO alert_deliveries(alert_id, contact_id, contact_name, channel, address, subject, body, status, attempts,
+		last_error, created_at, last_attempt_at, next_attempt_at, sent_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14) RETURNING id`
+	var id int64
+	err := s.db.QueryRow(query, delivery.AlertID, delivery.ContactID, delivery.ContactName, delivery.Channel, delivery.Address,
+		delivery.Subject, delivery.Body, delivery.Status, delivery.Attempts, nullString(delivery.LastError),
+		delivery.CreatedAt.UTC(), nullTime(delivery.LastAttemptAt), nullTime(delivery.NextAttemptAt), nullTime(delivery.SentAt)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateAlertDelivery statement: %w", err)
+	}
+	return id, nil
+}
+
+// UpdateAlertDelivery saves the status, attempts, last error and timestamps of a delivery.
+func (s *sqlStore) UpdateAlertDelivery(delivery models.AlertDelivery) error {
+	query := `UPDATE alert_deliveries SET status = $1, attempts = $2, last_error = $3, last_attempt_at = $4,
+		next_attempt_at = $5, sent_at = $6 WHERE id = $7`
+	_, err := s.db.Exec(query, delivery.Status, delivery.Attempts, nullString(delivery.LastError),
+		nullTime(delivery.LastAttemptAt), nullTime(delivery.NextAttemptAt), nullTime(delivery.SentAt), delivery.ID)
+	if err != nil {
+		return fmt.Errorf("failed to execute UpdateAlertDelivery statement: %w", err)
+	}
+	return nil
+}
+
+// nullString stores an empty string as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+const deliveryColumns = `id, alert_id, contact_id, contact_name, channel, address, subject, body, status, attempts,
+	last_error, created_at, last_attempt_at, next_attempt_at, sent_at`
+
+// queryAlertDeliveries runs a query selecting deliveryColumns.
+func (s *sqlStore) queryAlertDeliveries(query string, args ...interface{}) ([]models.AlertDelivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query alert deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []models.AlertDelivery
+	for rows.Next() {
+		var delivery models.AlertDelivery
+		var lastError sql.NullString
+		var lastAttemptAt, nextAttemptAt, sentAt sql.NullTime
+		err := rows.Scan(&delivery.ID, &delivery.AlertID, &delivery.ContactID, &delivery.ContactName, &delivery.Channel,
+			&delivery.Address, &delivery.Subject, &delivery.Body, &delivery.Status, &delivery.Attempts,
+			&lastError, &delivery.CreatedAt, &lastAttemptAt, &nextAttemptAt, &sentAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert delivery: %w", err)
+		}
+		delivery.LastError = lastError.String
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		if lastAttemptAt.Valid {
+			delivery.LastAttemptAt = lastAttemptAt.Time.UTC()
+		}
+		if nextAttemptAt.Valid {
+			delivery.NextAttemptAt = nextAttemptAt.Time.UTC()
+		}
+		if sentAt.Valid {
+			delivery.SentAt = sentAt.Time.UTC()
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for alert deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// GetAlertDeliveries returns the deliveries of an alert, oldest first.
+func (s *sqlStore) GetAlertDeliveries(alertID int64) ([]models.AlertDelivery, error) {
+	return s.queryAlertDeliveries("SELECT "+deliveryColumns+" FROM alert_deliveries WHERE alert_id = $1 ORDER BY id ASC", alertID)
+}
+
+// GetPendingAlertDeliveries returns every delivery that is still to be sent or retried, oldest first.
+func (s *sqlStore) GetPendingAlertDeliveries() ([]models.AlertDelivery, error) {
+	return s.queryAlertDeliveries("SELECT "+deliveryColumns+" FROM alert_deliveries WHERE status = $1 ORDER BY id ASC", models.DeliveryPending)
+}
+
+// CreateUser stores a new user and returns its ID.
+func (s *sqlStore) CreateUser(user models.User) (int64, error) {
+	query := `INSERT INTO users(username, password_hash, is_admin, created_at) VALUES($1, $2, $3, $4)
+		ON CONFLICT (username) DO NOTHING RETURNING id`
+	var id int64
+	err := s.db.QueryRow(query, user.Username, user.PasswordHash, user.IsAdmin, user.CreatedAt.UTC()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("user %q: %w", user.Username, ErrUsernameTaken)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateUser statement: %w", err)
+	}
+	return id, nil
+}
+
+const userColumns = "id, username, password_hash, is_admin, created_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.IsAdmin, &user.CreatedAt)
+	user.CreatedAt = user.CreatedAt.UTC()
+	return user, err
+}
+
+// GetUser retrieves a user by its ID.
+func (s *sqlStore) GetUser(id int64) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user with ID %d: %w", id, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan user: %w", err)
+	}
+	return &user, nil
+}
+
+// GetUserByUsername retrieves a user by its username.
+func (s *sqlStore) GetUserByUsername(username string) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = $1", username))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %q: %w", username, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan user: %w", err)
+	}
+	return &user, nil
+}
+
+// GetUsers returns every user, oldest first.
+func (s *sqlStore) GetUsers() ([]models.User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY id ASC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for users: %w", err)
+	}
+	return users, nil
+}
+
+// CreateAuthToken stores a new session or API token and returns its ID.
+func (s *sqlStore) CreateAuthToken(token models.AuthToken) (int64, error) {
+	query := `INSERT INTO auth_tokens(user_id, kind, name, token_hash, scopes, created_at, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	var id int64
+	err := s.db.QueryRow(query, token.UserID, token.Kind, nullString(token.Name), token.TokenHash, strings.Join(token.Scopes, ","),
+		token.CreatedAt.UTC(), nullTime(token.ExpiresAt)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateAuthToken statement: %w", err)
+	}
+	return id, nil
+}
+
+const authTokenColumns = "id, user_id, kind, name, token_hash, scopes, created_at, expires_at"
+
+func scanAuthToken(row interface{ Scan(...interface{}) error }) (models.AuthToken, error) {
+	var token models.AuthToken
+	var name sql.NullString
+	var scopes string
+	var expiresAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Kind, &name, &token.TokenHash, &scopes, &token.CreatedAt, &expiresAt)
+	if err != nil {
+		return token, err
+	}
+	token.Name = name.String
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	if expiresAt.Valid {
+		token.ExpiresAt = expiresAt.Time.UTC()
+	}
+	return token, nil
+}
+
+// GetAuthToken retrieves a token by the hash of its secret.
+func (s *sqlStore) GetAuthToken(tokenHash string) (*models.AuthToken, error) {
+	token, err := scanAuthToken(s.db.QueryRow("SELECT "+authTokenColumns+" FROM auth_tokens WHERE token_hash = $1", tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, ErrAuthTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan auth token: %w", err)
+	}
+	return &token, nil
+}
+
+// GetAuthTokens returns the tokens of one kind belonging to a user, oldest first.
+func (s *sqlStore) GetAuthTokens(userID int64, kind string) ([]models.AuthToken, error) {
+	rows, err := s.db.Query("SELECT "+authTokenColumns+" FROM auth_tokens WHERE user_id = $1 AND kind = $2 ORDER BY id ASC", userID, kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query auth tokens of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var tokens []models.AuthToken
+	for rows.Next() {
+		token, err := scanAuthToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan auth token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for auth tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// DeleteAuthToken removes a token, which stops it from authenticating.
+func (s *sqlStore) DeleteAuthToken(id int64) error {
+	result, err := s.db.Exec("DELETE FROM auth_tokens WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to execute DeleteAuthToken statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("token with ID %d: %w", id, ErrAuthTokenNotFound)
+	}
+	return nil
+}
+
+// SetDeviceOwner makes a user the owner of a device, or removes its owner if userID is 0.
+// Rides of the device that have no owner yet are given to the new owner.
+func (s *sqlStore) SetDeviceOwner(deviceID string, userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin SetDeviceOwner transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op after Commit
+
+	if _, err := tx.Exec("DELETE FROM device_owners WHERE device_id = $1", deviceID); err != nil {
+		return fmt.Errorf("failed to remove owner of device %s: %w", deviceID, err)
+	}
+	if userID != 0 {
+		query := "INSERT INTO device_owners(device_id, user_id, assigned_at) VALUES($1, $2, $3)"
+		if _, err := tx.Exec(query, deviceID, userID, time.Now().UTC()); err != nil {
+			return fmt.Errorf("failed to set owner of device %s: %w", deviceID, err)
+		}
+		if _, err := tx.Exec("UPDATE rides SET owner_id = $1 WHERE device_id = $2 AND owner_id IS NULL", userID, deviceID); err != nil {
+			return fmt.Errorf("failed to assign rides of device %s: %w", deviceID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit SetDeviceOwner transaction: %w", err)
+	}
+	return nil
+}
+
+// GetDeviceOwner returns the ID of the user owning a device, or 0 if it has none.
+func (s *sqlStore) GetDeviceOwner(deviceID string) (int64, error) {
+	var userID int64
+	err := s.db.QueryRow("SELECT user_id FROM device_owners WHERE device_id = $1", deviceID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query owner of device %s: %w", deviceID, err)
+	}
+	return userID, nil
+}
+
+// GetOwnedDeviceIDs returns the devices a user owns.
+func (s *sqlStore) GetOwnedDeviceIDs(userID int64) ([]string, error) {
+	rows, err := s.db.Query("SELECT device_id FROM device_owners WHERE user_id = $1 ORDER BY device_id ASC", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var deviceIDs []string
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, fmt.Errorf("failed to scan device ID: %w", err)
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for owned devices: %w", err)
+	}
+	return deviceIDs, nil
+}
+
+// CreateRideShare stores a new share link of a ride and returns its ID.
+func (s *sqlStore) CreateRideShare(share models.RideShare) (int64, error) {
+	query := `INSERT INTO ride_shares(ride_id, user_id, trim_start_meters, trim_end_meters, created_at, expires_at)
+		VALUES($1, $2, $3, $4, $5, $6) RETURNING id`
+	var id int64
+	err := s.db.QueryRow(query, share.RideID, share.UserID, share.TrimStartMeters, share.TrimEndMeters,
+		share.CreatedAt.UTC(), share.ExpiresAt.UTC()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute CreateRideShare statement: %w", err)
+	}
+	return id, nil
+}
+
+const rideShareColumns = "id, ride_id, user_id, trim_start_meters, trim_end_meters, created_at, expires_at, revoked_at"
+
+func scanRideShare(row interface{ Scan(...interface{}) error }) (models.RideShare, error) {
+	var share models.RideShare
+	var revokedAt sql.NullTime
+	err := row.Scan(&share.ID, &share.RideID, &share.UserID, &share.TrimStartMeters, &share.TrimEndMeters,
+		&share.CreatedAt, &share.ExpiresAt, &revokedAt)
+	if err != nil {
+		return share, err
+	}
+	share.CreatedAt = share.CreatedAt.UTC()
+	share.ExpiresAt = share.ExpiresAt.UTC()
+	if revokedAt.Valid {
+		share.RevokedAt = revokedAt.Time.UTC()
+	}
+	return share, nil
+}
+
+// GetRideShare retrieves a share link by its ID.
+func (s *sqlStore) GetRideShare(id int64) (*models.RideShare, error) {
+	share, err := scanRideShare(s.db.QueryRow("SELECT "+rideShareColumns+" FROM ride_shares WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("share link with ID %d: %w", id, ErrShareNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan ride share: %w", err)
+	}
+	return &share, nil
+}
+
+// GetRideShares returns the share links created by a user, newest first. A userID of 0
+// returns every user's links.
+func (s *sqlStore) GetRideShares(userID int64) ([]models.RideShare, error) {
+	query := "SELECT " + rideShareColumns + " FROM ride_shares"
+	var args []interface{}
+	if userID != 0 {
+		query += " WHERE user_id = $1"
+		args = append(args, userID)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ride shares: %w", err)
+	}
+	defer rows.Close()
+
+	var shares []models.RideShare
+	for rows.Next() {
+		share, err := scanRideShare(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ride share: %w", err)
+		}
+		shares = append(shares, share)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during rows iteration for ride shares: %w", err)
+	}
+	return shares, nil
+}
+
+// RevokeRideShare stops a share link from working. Revoking it again keeps the first revocation time.
+func (s *sqlStore) RevokeRideShare(id int64, revokedAt time.Time) error {
+	result, err := s.db.Exec("UPDATE ride_shares SET revoked_at = COALESCE(revoked_at, $1) WHERE id = $2", revokedAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to execute RevokeRideShare statement: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("share link with ID %d: %w", id, ErrShareNotFound)
+	}
+	return nil
+}
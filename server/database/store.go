@@ -1,260 +1,277 @@
 package database
 
 import (
-	"b3/server/models" // Adjust import path if your module path is different
-	"database/sql"
+	"b3/server/config"
+	"b3/server/models"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"time"
-
-	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
-// NewStore initializes the PostgreSQL database connection and ensures tables are created.
-func NewStore(postgresConnStr string) (*sql.DB, error) {
-	if postgresConnStr == "" {
-		return nil, fmt.Errorf("PostgreSQL connection string not provided. Please set POSTGRES_CONNECTION_STRING environment variable")
-	}
-
-	connStr := postgresConnStr
-	// Add binary_parameters=yes to enable binary encoding of parameters [pg bouncer race condition fix]
-	if !containsBinaryParams(connStr) {
-		separator := "&"
-		if !containsParams(connStr) {
-			separator = "?"
-		}
-		connStr += separator + "binary_parameters=yes"
-	}
-
-	var err error
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open PostgreSQL database: %w", err)
-	}
+// Supported values for the database_backend config option.
+const (
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+	BackendMemory   = "memory"
+)
 
-	// Configure connection pool to prevent prepared statement conflicts
-	db.SetMaxOpenConns(25)                 // Maximum number of open connections
-	db.SetMaxIdleConns(5)                  // Maximum number of idle connections
-	db.SetConnMaxLifetime(5 * time.Minute) // Maximum connection lifetime
+// ErrRideNotFound is returned (wrapped) by every backend when a ride does not exist.
+var ErrRideNotFound = errors.New("ride not found")
 
-	if err = db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping PostgreSQL database: %w", err)
-	}
+// ErrIncidentNotFound is returned (wrapped) by every backend when a theft incident does not exist.
+var ErrIncidentNotFound = errors.New("theft incident not found")
 
-	if err = createTables(db); err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
-	}
+// ErrGeofenceNotFound is returned (wrapped) by every backend when a geofence does not exist.
+var ErrGeofenceNotFound = errors.New("geofence not found")
 
-	log.Println("PostgreSQL database initialized and tables created successfully")
-	return db, nil
-}
+// ErrAlertNotFound is returned (wrapped) by every backend when an alert does not exist.
+var ErrAlertNotFound = errors.New("alert not found")
 
-// Helper function to check if connection string contains query parameters
-func containsParams(connStr string) bool {
-	return strings.Contains(connStr, "?")
-}
+// ErrContactNotFound is returned (wrapped) by every backend when a contact does not exist.
+var ErrContactNotFound = errors.New("contact not found")
 
-// Helper function to check if the connection string contains binary_parameters=no
-func containsBinaryParams(connStr string) bool {
-	return strings.Contains(connStr, "binary_parameters=no")
-}
+// ErrUserNotFound is returned (wrapped) by every backend when a user does not exist.
+var ErrUserNotFound = errors.New("user not found")
 
-func createTables(db *sql.DB) error {
-	var ridesTableSQL string
-	var positionsTableSQL string
+// ErrUsernameTaken is returned (wrapped) by every backend when creating a user whose username is in use.
+var ErrUsernameTaken = errors.New("username already taken")
 
-	ridesTableSQL = `
-	CREATE TABLE IF NOT EXISTS rides (
-		id SERIAL PRIMARY KEY,
-		name TEXT NOT NULL,
-		start_time TIMESTAMP NOT NULL,
-		end_time TIMESTAMP
-	);`
+// ErrAuthTokenNotFound is returned (wrapped) by every backend when a session or API token does not exist.
+var ErrAuthTokenNotFound = errors.New("token not found")
 
-	positionsTableSQL = `
-	CREATE TABLE IF NOT EXISTS ride_positions (
-		id SERIAL PRIMARY KEY,
-		ride_id INTEGER NOT NULL,
-		latitude REAL NOT NULL,
-		longitude REAL NOT NULL,
-		speed_knots REAL,
-		timestamp TIMESTAMP NOT NULL,
-		FOREIGN KEY (ride_id) REFERENCES rides(id) ON DELETE CASCADE 
-	);`
+// ErrShareNotFound is returned (wrapped) by every backend when a ride share link does not exist.
+var ErrShareNotFound = errors.New("share link not found")
 
-	if _, err := db.Exec(ridesTableSQL); err != nil {
-		return fmt.Errorf("failed to create rides table: %w", err)
-	}
-	if _, err := db.Exec(positionsTableSQL); err != nil {
-		return fmt.Errorf("failed to create ride_positions table: %w", err)
-	}
-	return nil
+// RidePosition is a position waiting to be added to a ride.
+type RidePosition struct {
+	RideID   int64           `json:"ride_id"`
+	Position models.Position `json:"position"`
 }
 
-// CreateRide inserts a new ride into the database.
-func CreateRide(db *sql.DB, name string, startTime time.Time) (int64, error) {
-	// PostgreSQL doesn't support LastInsertId, use RETURNING instead
-	var id int64
-	query := "INSERT INTO rides(name, start_time) VALUES($1, $2) RETURNING id"
-	err := db.QueryRow(query, name, startTime.UTC()).Scan(&id)
-	if err != nil {
-		return 0, fmt.Errorf("failed to execute CreateRide statement: %w", err)
-	}
-	return id, nil
+// RideStore is a storage backend: it persists rides, their positions, crash events and raw
+// device points, and everything else the server keeps through the interfaces it embeds.
+// The backend can be swapped through config; packages that only need part of it take the
+// narrower interface.
+type RideStore interface {
+	UserStore
+	GeofenceStore
+	IncidentStore
+	AlertStore
+	ContactStore
+	ShareStore
+
+	// CreateRide inserts a new ride for the given device and returns its ID.
+	CreateRide(deviceID, name string, startTime time.Time) (int64, error)
+	// AddPositionToRide adds a new GPS position to an existing ride.
+	AddPositionToRide(rideID int64, position models.Position) error
+	// AddPositions adds positions to one or more rides. Either all of them are stored or none are.
+	AddPositions(positions []RidePosition) error
+	// EndRide sets the end time of a ride.
+	EndRide(rideID int64, endTime time.Time) error
+	// DeleteRide removes a ride and all its positions.
+	DeleteRide(rideID int64) error
+	// MarkRideImported records the source and track hash of a ride created from an imported file.
+	MarkRideImported(rideID int64, source, trackHash string) error
+	// FindDuplicateRide returns the ID of an existing ride with the same track hash, or of
+	// the same device with the same start time, or 0 if there is none. Only rides of the
+	// device's owner count, so a user never learns of another user's ride.
+	FindDuplicateRide(deviceID string, startTime time.Time, trackHash string) (int64, error)
+	// SaveRideStats stores the computed statistics of a ride.
+	SaveRideStats(rideID int64, stats models.RideStats) error
+	// GetEndedRideIDs returns the IDs of all finished rides, oldest first.
+	// If onlyMissingStats is true, rides that already have stats are skipped.
+	GetEndedRideIDs(onlyMissingStats bool) ([]int64, error)
+	// GetRideDetails retrieves a ride and all its positions, oldest first.
+	GetRideDetails(rideID int64) (*models.RideDetail, error)
+	// GetAllRidesSummary retrieves a summary of all rides, newest first.
+	GetAllRidesSummary() ([]models.RideSummary, error)
+	// GetAllRidesSummaryWithPagination retrieves a page of ride summaries, newest first, optionally
+	// limited to rides started on the day of dateFilter, to one device and to one owner. An empty deviceID
+	// matches every device and an ownerID of 0 every owner.
+	GetAllRidesSummaryWithPagination(page, limit int, dateFilter *time.Time, deviceID string, ownerID int64) ([]models.RideSummary, error)
+	// GetOpenRides returns every ride that has not ended, oldest first.
+	GetOpenRides() ([]models.RideSummary, error)
+	// GetLastRidePosition returns the most recent position of a ride, or nil if it has none.
+	GetLastRidePosition(rideID int64) (*models.Position, error)
+	// GetDeviceIDs returns every device that has recorded at least one ride.
+	GetDeviceIDs() ([]string, error)
+	// BackfillDeviceID assigns rides recorded before multi-device support to deviceID.
+	BackfillDeviceID(deviceID string) (int64, error)
+	// SetRideStartZone records the name of the geofence a ride started in.
+	SetRideStartZone(rideID int64, zone string) error
+	// SetRideEndZone records the name of the geofence a ride ended in.
+	SetRideEndZone(rideID int64, zone string) error
+	// AddCrashEvent records a crash reported by a device and returns the event's ID.
+	AddCrashEvent(event models.CrashEvent) (int64, error)
+	// GetRideCrashEvents returns the crashes that happened during a ride, oldest first.
+	GetRideCrashEvents(rideID int64) ([]models.CrashEvent, error)
+	// AddRawPosition records a point as received from a device, along with whether the GPS filter accepted it.
+	AddRawPosition(deviceID string, position models.Position, accepted bool, rejectReason string) error
+	// Close releases the backend's resources.
+	Close() error
 }
 
-// AddPositionToRide adds a new GPS position to an existing ride.
-func AddPositionToRide(db *sql.DB, rideID int64, position models.Position) error {
-	query := "INSERT INTO ride_positions(ride_id, latitude, longitude, speed_knots, timestamp) VALUES($1, $2, $3, $4, $5)"
-	_, err := db.Exec(query, rideID, position.Latitude, position.Longitude, position.SpeedKnots, position.Timestamp.UTC()) // Ensure storing in UTC
-	if err != nil {
-		return fmt.Errorf("failed to execute AddPositionToRide statement: %w", err)
-	}
-	return nil
+// UserStore persists user accounts, their sessions and API tokens, and which devices they own.
+type UserStore interface {
+	// CreateUser stores a new user and returns its ID.
+	CreateUser(user models.User) (int64, error)
+	// GetUser retrieves a user by its ID.
+	GetUser(id int64) (*models.User, error)
+	// GetUserByUsername retrieves a user by its username.
+	GetUserByUsername(username string) (*models.User, error)
+	// GetUsers returns every user, oldest first.
+	GetUsers() ([]models.User, error)
+	// CreateAuthToken stores a new session or API token and returns its ID.
+	CreateAuthToken(token models.AuthToken) (int64, error)
+	// GetAuthToken retrieves a token by the hash of its secret.
+	GetAuthToken(tokenHash string) (*models.AuthToken, error)
+	// GetAuthTokens returns the tokens of one kind belonging to a user, oldest first.
+	GetAuthTokens(userID int64, kind string) ([]models.AuthToken, error)
+	// DeleteAuthToken removes a token, which stops it from authenticating.
+	DeleteAuthToken(id int64) error
+	// SetDeviceOwner makes a user the owner of a device, or removes its owner if userID is 0.
+	// Rides of the device that have no owner yet are given to the new owner.
+	SetDeviceOwner(deviceID string, userID int64) error
+	// GetDeviceOwner returns the ID of the user owning a device, or 0 if it has none.
+	GetDeviceOwner(deviceID string) (int64, error)
+	// GetOwnedDeviceIDs returns the devices a user owns.
+	GetOwnedDeviceIDs(userID int64) ([]string, error)
 }
 
-// EndRide updates the end_time of a ride.
-func EndRide(db *sql.DB, rideID int64, endTime time.Time) error {
-	query := "UPDATE rides SET end_time = $1 WHERE id = $2"
-	_, err := db.Exec(query, endTime.UTC(), rideID) // Ensure storing in UTC
-	if err != nil {
-		return fmt.Errorf("failed to execute EndRide statement: %w", err)
-	}
-	return nil
+// GeofenceStore persists geofences and the devices entering and exiting them.
+type GeofenceStore interface {
+	// CreateGeofence inserts a geofence and returns its ID.
+	CreateGeofence(geofence models.Geofence) (int64, error)
+	// UpdateGeofence replaces the name, shape and notify flag of an existing geofence.
+	UpdateGeofence(geofence models.Geofence) error
+	// DeleteGeofence removes a geofence and its events.
+	DeleteGeofence(id int64) error
+	// GetGeofences returns every geofence, oldest first.
+	GetGeofences() ([]models.Geofence, error)
+	// AddGeofenceEvent records a device entering or exiting a geofence and returns the event's ID.
+	AddGeofenceEvent(event models.GeofenceEvent) (int64, error)
+	// GetGeofenceEvents returns up to limit of the most recent events of a geofence, newest first.
+	GetGeofenceEvents(geofenceID int64, limit int) ([]models.GeofenceEvent, error)
 }
 
-// GetRideDetails retrieves a specific ride and all its positions.
-func GetRideDetails(db *sql.DB, rideID int64) (*models.RideDetail, error) {
-	ride := &models.RideDetail{}
-	var endTime sql.NullTime // Handle NULL end_time
-
-	// First query: Get ride details
-	rideQuery := "SELECT id, name, start_time, end_time FROM rides WHERE id = $1"
-	row := db.QueryRow(rideQuery, rideID)
-	if err := row.Scan(&ride.ID, &ride.Name, &ride.StartTime, &endTime); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("ride with ID %d not found", rideID)
-		}
-		return nil, fmt.Errorf("failed to scan ride details: %w", err)
-	}
-	if endTime.Valid {
-		ride.EndTime = endTime.Time
-	}
-
-	// Second query: Get ride positions with a different variable name
-	positionsQuery := "SELECT latitude, longitude, speed_knots, timestamp FROM ride_positions WHERE ride_id = $1 ORDER BY timestamp ASC"
-	log.Printf("Positions Query: %s", positionsQuery)
-	log.Printf("RideID: %d", rideID)
-
-	rows, err := db.Query(positionsQuery, rideID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query ride positions for ride_id %d: %w", rideID, err)
-	}
-	defer rows.Close()
+// IncidentStore persists theft incidents and the positions recorded while they are open.
+type IncidentStore interface {
+	// CreateTheftIncident opens a theft incident for a device and returns its ID.
+	CreateTheftIncident(deviceID string, openedAt time.Time) (int64, error)
+	// AddTheftIncidentPosition records a position of a bike with an open theft incident.
+	AddTheftIncidentPosition(incidentID int64, position models.Position) error
+	// ResolveTheftIncident closes a theft incident with an optional note from the owner.
+	ResolveTheftIncident(incidentID int64, resolvedAt time.Time, note string) error
+	// GetTheftIncident retrieves a theft incident and all its positions, oldest first.
+	GetTheftIncident(incidentID int64) (*models.TheftIncidentDetail, error)
+	// GetTheftIncidents returns theft incidents, newest first, optionally only open ones
+	// and only those of one device. An empty deviceID matches every device.
+	GetTheftIncidents(deviceID string, openOnly bool) ([]models.TheftIncident, error)
+}
 
-	for rows.Next() {
-		var pos models.Position
-		var speedKnots sql.NullFloat64
-		if err := rows.Scan(&pos.Latitude, &pos.Longitude, &speedKnots, &pos.Timestamp); err != nil {
-			return nil, fmt.Errorf("failed to scan ride position: %w", err)
-		}
-		if speedKnots.Valid {
-			pos.SpeedKnots = speedKnots.Float64
-		}
-		ride.Positions = append(ride.Positions, pos)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration for ride positions: %w", err)
-	}
+// AlertStore persists alerts, their timelines and their deliveries to emergency contacts.
+type AlertStore interface {
+	// CreateAlert stores a new alert and returns its ID.
+	CreateAlert(alert models.Alert) (int64, error)
+	// UpdateAlert saves the status, counters, latest trigger and timestamps of an existing alert.
+	UpdateAlert(alert models.Alert) error
+	// GetAlert retrieves an alert by its ID.
+	GetAlert(id int64) (*models.Alert, error)
+	// GetAlerts returns alerts, newest first, optionally only those neither resolved nor
+	// cancelled and only those of one device. An empty deviceID matches every device.
+	GetAlerts(deviceID string, activeOnly bool) ([]models.Alert, error)
+	// AddAlertEvent records a step in the timeline of an alert.
+	AddAlertEvent(event models.AlertEvent) error
+	// GetAlertEvents returns the timeline of an alert, oldest first.
+	GetAlertEvents(alertID int64) ([]models.AlertEvent, error)
+	// CreateAlertDelivery stores a new delivery of an alert to a contact and returns its ID.
+	CreateAlertDelivery(delivery models.AlertDelivery) (int64, error)
+	// UpdateAlertDelivery saves the status, attempts, last error and timestamps of a delivery.
+	UpdateAlertDelivery(delivery models.AlertDelivery) error
+	// GetAlertDeliveries returns the deliveries of an alert, oldest first.
+	GetAlertDeliveries(alertID int64) ([]models.AlertDelivery, error)
+	// GetPendingAlertDeliveries returns every delivery that is still to be sent or retried, oldest first.
+	GetPendingAlertDeliveries() ([]models.AlertDelivery, error)
+}
 
-	// Ensure times are UTC
-	ride.StartTime = ride.StartTime.UTC()
-	if endTime.Valid {
-		ride.EndTime = ride.EndTime.UTC()
-	}
-	for i := range ride.Positions {
-		ride.Positions[i].Timestamp = ride.Positions[i].Timestamp.UTC()
-	}
+// ContactStore persists emergency contacts.
+type ContactStore interface {
+	// CreateContact stores a new contact and returns its ID.
+	CreateContact(contact models.Contact) (int64, error)
+	// UpdateContact replaces the name, channel, address and alert types of an existing contact.
+	// Its owner stays the same.
+	UpdateContact(contact models.Contact) error
+	// DeleteContact removes a contact. Its past deliveries are kept.
+	DeleteContact(id int64) error
+	// GetContact retrieves a contact by its ID.
+	GetContact(id int64) (*models.Contact, error)
+	// GetContacts returns every contact, oldest first.
+	GetContacts() ([]models.Contact, error)
+}
 
-	log.Printf("Successfully retrieved ride %d with %d positions", rideID, len(ride.Positions))
-	return ride, nil
+// ShareStore persists public share links of rides.
+type ShareStore interface {
+	// CreateRideShare stores a new share link of a ride and returns its ID.
+	CreateRideShare(share models.RideShare) (int64, error)
+	// GetRideShare retrieves a share link by its ID.
+	GetRideShare(id int64) (*models.RideShare, error)
+	// GetRideShares returns the share links created by a user, newest first. A userID of 0
+	// returns every user's links.
+	GetRideShares(userID int64) ([]models.RideShare, error)
+	// RevokeRideShare stops a share link from working. Revoking it again keeps the first revocation time.
+	RevokeRideShare(id int64, revokedAt time.Time) error
 }
 
-// GetAllRidesSummary retrieves a summary of all rides.
-func GetAllRidesSummary(db *sql.DB) ([]models.RideSummary, error) {
-	rows, err := db.Query("SELECT id, name, start_time, end_time FROM rides ORDER BY start_time DESC")
+// NewStore opens the backend selected by cfg and brings its schema up to date.
+func NewStore(cfg config.Config) (RideStore, error) {
+	store, err := OpenStore(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query all rides summary: %w", err)
+		return nil, err
 	}
-	defer rows.Close()
-
-	var rides []models.RideSummary
-	for rows.Next() {
-		var ride models.RideSummary
-		var endTime sql.NullTime // Handle NULL end_time
-		if err := rows.Scan(&ride.ID, &ride.Name, &ride.StartTime, &endTime); err != nil {
-			return nil, fmt.Errorf("failed to scan ride summary: %w", err)
-		}
-		if endTime.Valid {
-			ride.EndTime = endTime.Time
+	if migratable, ok := store.(Migratable); ok {
+		migrator, err := migratable.Migrator()
+		if err == nil {
+			var applied int
+			applied, err = migrator.Up(context.Background())
+			if applied > 0 {
+				log.Printf("Applied %d schema migrations", applied)
+			}
 		}
-		// Ensure times are UTC
-		ride.StartTime = ride.StartTime.UTC()
-		if endTime.Valid {
-			ride.EndTime = ride.EndTime.UTC()
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to migrate database: %w", err)
 		}
-		rides = append(rides, ride)
 	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration for all rides summary: %w", err)
-	}
-	return rides, nil
+	return store, nil
 }
 
-// GetAllRidesSummaryWithPagination retrieves a summary of rides with pagination and optional date filtering.
-func GetAllRidesSummaryWithPagination(db *sql.DB, page, limit int, dateFilter *time.Time) ([]models.RideSummary, error) {
-	offset := (page - 1) * limit
-
-	var query string
-	var args []interface{}
-
-	if dateFilter != nil {
-		// Filter by start date (same day)
-		startOfDay := time.Date(dateFilter.Year(), dateFilter.Month(), dateFilter.Day(), 0, 0, 0, 0, time.UTC)
-		endOfDay := startOfDay.Add(24 * time.Hour)
-		query = "SELECT id, name, start_time, end_time FROM rides WHERE start_time >= $1 AND start_time < $2 ORDER BY start_time DESC LIMIT $3 OFFSET $4"
-		args = []interface{}{startOfDay, endOfDay, limit, offset}
-	} else {
-		query = "SELECT id, name, start_time, end_time FROM rides ORDER BY start_time DESC LIMIT $1 OFFSET $2"
-		args = []interface{}{limit, offset}
+// OpenStore opens the backend selected by cfg.DatabaseBackend without running migrations.
+// When no backend is configured, Postgres is used if a connection string is set and SQLite otherwise.
+func OpenStore(cfg config.Config) (RideStore, error) {
+	backend := cfg.DatabaseBackend
+	if backend == "" {
+		backend = BackendSQLite
+		if cfg.PostgresConnStr != "" {
+			backend = BackendPostgres
+		}
 	}
 
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query rides summary with pagination: %w", err)
+	switch backend {
+	case BackendPostgres:
+		return NewPostgresStore(cfg.PostgresConnStr)
+	case BackendSQLite:
+		return NewSQLiteStore(cfg.DatabasePath)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported database backend %q, must be postgres, sqlite or memory", backend)
 	}
-	defer rows.Close()
+}
 
-	var rides []models.RideSummary
-	for rows.Next() {
-		var ride models.RideSummary
-		var endTime sql.NullTime // Handle NULL end_time
-		if err := rows.Scan(&ride.ID, &ride.Name, &ride.StartTime, &endTime); err != nil {
-			return nil, fmt.Errorf("failed to scan ride summary: %w", err)
-		}
-		if endTime.Valid {
-			ride.EndTime = endTime.Time
-		}
-		// Ensure times are UTC
-		ride.StartTime = ride.StartTime.UTC()
-		if endTime.Valid {
-			ride.EndTime = ride.EndTime.UTC()
-		}
-		rides = append(rides, ride)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during rows iteration for paginated rides summary: %w", err)
-	}
-	return rides, nil
+// utcDayBounds returns the start and end of the UTC day containing t, as used by the date filter of ride listings.
+func utcDayBounds(t time.Time) (time.Time, time.Time) {
+	startOfDay := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return startOfDay, startOfDay.Add(24 * time.Hour)
 }
@@ -0,0 +1,100 @@
+package export
+
+import (
+	"b3/server/models"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Format is a file format a ride can be exported to.
+type Format string
+
+const (
+	FormatGPX     Format = "gpx"
+	FormatTCX     Format = "tcx"
+	FormatKML     Format = "kml"
+	FormatGeoJSON Format = "geojson"
+)
+
+// timeLayout is used for every timestamp written to an export file.
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+// ParseFormat validates a format name such as "gpx" or "GeoJSON".
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatGPX, FormatTCX, FormatKML, FormatGeoJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q, must be one of gpx, tcx, kml, geojson", name)
+	}
+}
+
+// ContentType returns the MIME type served for the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatGPX:
+		return "application/gpx+xml"
+	case FormatTCX:
+		return "application/vnd.garmin.tcx+xml"
+	case FormatKML:
+		return "application/vnd.google-earth.kml+xml"
+	case FormatGeoJSON:
+		return "application/geo+json"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Write encodes the ride in the given format.
+func Write(w io.Writer, ride *models.RideDetail, format Format) error {
+	switch format {
+	case FormatGPX:
+		return WriteGPX(w, ride)
+	case FormatTCX:
+		return WriteTCX(w, ride)
+	case FormatKML:
+		return WriteKML(w, ride)
+	case FormatGeoJSON:
+		return WriteGeoJSON(w, ride)
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// Filename builds a download filename from the ride name and start time,
+// e.g. "Morning_Ride_2023-10-27_1000.gpx".
+func Filename(ride *models.RideDetail, format Format) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-':
+			return r
+		case unicode.IsSpace(r) || r == '_':
+			return '_'
+		default:
+			return -1
+		}
+	}, ride.Name)
+	if name == "" {
+		name = fmt.Sprintf("ride_%d", ride.ID)
+	}
+	return fmt.Sprintf("%s_%s.%s", name, ride.StartTime.UTC().Format("2006-01-02_1504"), format)
+}
+
+// formatTime formats a timestamp in UTC with millisecond precision.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// endTime returns the ride's end time, falling back to the last position for ongoing rides.
+func endTime(ride *models.RideDetail) time.Time {
+	if !ride.EndTime.IsZero() {
+		return ride.EndTime
+	}
+	if len(ride.Positions) > 0 {
+		return ride.Positions[len(ride.Positions)-1].Timestamp
+	}
+	return ride.StartTime
+}
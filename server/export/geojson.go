@@ -0,0 +1,61 @@
+package export
+
+import (
+	"b3/server/models"
+	"encoding/json"
+	"io"
+)
+
+// geoJSONFeature is a GeoJSON Feature holding the ride as a LineString.
+// Per-point timestamps follow the widely used "coordTimes" property convention.
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONGeometry   `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"` // [lon, lat]
+}
+
+type geoJSONProperties struct {
+	ID          int64             `json:"id"`
+	DeviceID    string            `json:"device_id,omitempty"`
+	Name        string            `json:"name"`
+	StartTime   string            `json:"start_time"`
+	EndTime     string            `json:"end_time"`
+	Stats       *models.RideStats `json:"stats,omitempty"`
+	CoordTimes  []string          `json:"coordTimes"`
+	SpeedsKnots []float64         `json:"speed_knots"`
+}
+
+// WriteGeoJSON encodes the ride as a GeoJSON Feature.
+func WriteGeoJSON(w io.Writer, ride *models.RideDetail) error {
+	feature := geoJSONFeature{
+		Type: "Feature",
+		Geometry: geoJSONGeometry{
+			Type:        "LineString",
+			Coordinates: make([][2]float64, 0, len(ride.Positions)),
+		},
+		Properties: geoJSONProperties{
+			ID:          ride.ID,
+			DeviceID:    ride.DeviceID,
+			Name:        ride.Name,
+			StartTime:   formatTime(ride.StartTime),
+			EndTime:     formatTime(endTime(ride)),
+			Stats:       ride.Stats,
+			CoordTimes:  make([]string, 0, len(ride.Positions)),
+			SpeedsKnots: make([]float64, 0, len(ride.Positions)),
+		},
+	}
+	for _, pos := range ride.Positions {
+		feature.Geometry.Coordinates = append(feature.Geometry.Coordinates, [2]float64{pos.Longitude, pos.Latitude})
+		feature.Properties.CoordTimes = append(feature.Properties.CoordTimes, formatTime(pos.Timestamp))
+		feature.Properties.SpeedsKnots = append(feature.Properties.SpeedsKnots, pos.SpeedKnots)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(feature)
+}
@@ -0,0 +1,85 @@
+package export
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"encoding/xml"
+	"io"
+)
+
+// gpx is the root element of a GPX 1.1 document. Speed is written using the
+// Garmin TrackPointExtension, which most tools understand.
+type gpx struct {
+	XMLName     xml.Name    `xml:"gpx"`
+	Version     string      `xml:"version,attr"`
+	Creator     string      `xml:"creator,attr"`
+	Xmlns       string      `xml:"xmlns,attr"`
+	XmlnsGpxTpx string      `xml:"xmlns:gpxtpx,attr"`
+	Metadata    gpxMetadata `xml:"metadata"`
+	Track       gpxTrack    `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name"`
+	Time string `xml:"time"`
+}
+
+type gpxTrack struct {
+	Name    string     `xml:"name"`
+	Type    string     `xml:"type"`
+	Segment gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat        float64       `xml:"lat,attr"`
+	Lon        float64       `xml:"lon,attr"`
+	Time       string        `xml:"time"`
+	Extensions gpxExtensions `xml:"extensions"`
+}
+
+type gpxExtensions struct {
+	TrackPoint gpxTrackPointExtension `xml:"gpxtpx:TrackPointExtension"`
+}
+
+type gpxTrackPointExtension struct {
+	Speed float64 `xml:"gpxtpx:speed"` // m/s
+}
+
+// WriteGPX encodes the ride as a GPX 1.1 track.
+func WriteGPX(w io.Writer, ride *models.RideDetail) error {
+	doc := gpx{
+		Version:     "1.1",
+		Creator:     "B3 Ride Tracker",
+		Xmlns:       "http://www.topografix.com/GPX/1/1",
+		XmlnsGpxTpx: "http://www.garmin.com/xmlschemas/TrackPointExtension/v2",
+		Metadata: gpxMetadata{
+			Name: ride.Name,
+			Time: formatTime(ride.StartTime),
+		},
+		Track: gpxTrack{
+			Name: ride.Name,
+			Type: "cycling",
+		},
+	}
+	for _, pos := range ride.Positions {
+		doc.Track.Segment.Points = append(doc.Track.Segment.Points, gpxPoint{
+			Lat:  pos.Latitude,
+			Lon:  pos.Longitude,
+			Time: formatTime(pos.Timestamp),
+			Extensions: gpxExtensions{
+				TrackPoint: gpxTrackPointExtension{Speed: pos.SpeedKnots / util.MetersPerSecondToKnots},
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"b3/server/models"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// kml is the root element of a KML 2.2 document. The track is written as a
+// gx:Track so each coordinate keeps its timestamp, with speed as extended data.
+type kml struct {
+	XMLName  xml.Name    `xml:"kml"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	XmlnsGx  string      `xml:"xmlns:gx,attr"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name      string       `xml:"name"`
+	Schema    kmlSchema    `xml:"Schema"`
+	Placemark kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlSchema struct {
+	ID    string              `xml:"id,attr"`
+	Field kmlSimpleArrayField `xml:"gx:SimpleArrayField"`
+}
+
+type kmlSimpleArrayField struct {
+	Name        string `xml:"name,attr"`
+	Type        string `xml:"type,attr"`
+	DisplayName string `xml:"displayName"`
+}
+
+type kmlPlacemark struct {
+	Name     string       `xml:"name"`
+	TimeSpan kmlTimeSpan  `xml:"TimeSpan"`
+	Style    kmlLineStyle `xml:"Style>LineStyle"`
+	Track    kmlTrack     `xml:"gx:Track"`
+}
+
+type kmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type kmlLineStyle struct {
+	Color string `xml:"color"`
+	Width int    `xml:"width"`
+}
+
+type kmlTrack struct {
+	When         []string        `xml:"when"`
+	Coords       []string        `xml:"gx:coord"`
+	ExtendedData kmlExtendedData `xml:"ExtendedData"`
+}
+
+type kmlExtendedData struct {
+	SchemaData kmlSchemaData `xml:"SchemaData"`
+}
+
+type kmlSchemaData struct {
+	SchemaURL string             `xml:"schemaUrl,attr"`
+	Array     kmlSimpleArrayData `xml:"gx:SimpleArrayData"`
+}
+
+type kmlSimpleArrayData struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"gx:value"`
+}
+
+// WriteKML encodes the ride as a KML placemark with a timestamped gx:Track.
+func WriteKML(w io.Writer, ride *models.RideDetail) error {
+	track := kmlTrack{
+		ExtendedData: kmlExtendedData{
+			SchemaData: kmlSchemaData{
+				SchemaURL: "#ride",
+				Array:     kmlSimpleArrayData{Name: "speed_knots"},
+			},
+		},
+	}
+	for _, pos := range ride.Positions {
+		track.When = append(track.When, formatTime(pos.Timestamp))
+		// gx:coord is "lon lat alt"; the tracker does not report altitude
+		track.Coords = append(track.Coords, fmt.Sprintf("%f %f 0", pos.Longitude, pos.Latitude))
+		track.ExtendedData.SchemaData.Array.Values = append(track.ExtendedData.SchemaData.Array.Values, fmt.Sprintf("%.2f", pos.SpeedKnots))
+	}
+
+	doc := kml{
+		Xmlns:   "http://www.opengis.net/kml/2.2",
+		XmlnsGx: "http://www.google.com/kml/ext/2.2",
+		Document: kmlDocument{
+			Name: ride.Name,
+			Schema: kmlSchema{
+				ID:    "ride",
+				Field: kmlSimpleArrayField{Name: "speed_knots", Type: "float", DisplayName: "Speed (knots)"},
+			},
+			Placemark: kmlPlacemark{
+				Name:     ride.Name,
+				TimeSpan: kmlTimeSpan{Begin: formatTime(ride.StartTime), End: formatTime(endTime(ride))},
+				Track:    track,
+				Style:    kmlLineStyle{Color: "ff0000ff", Width: 4},
+			},
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
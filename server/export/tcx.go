@@ -0,0 +1,125 @@
+package export
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"encoding/xml"
+	"io"
+)
+
+// tcx is the root element of a Garmin Training Center (TCX) v2 document.
+// Speed is written using the ActivityExtension v2 TPX element.
+type tcx struct {
+	XMLName    xml.Name      `xml:"TrainingCenterDatabase"`
+	Xmlns      string        `xml:"xmlns,attr"`
+	XmlnsNs3   string        `xml:"xmlns:ns3,attr"`
+	Activities tcxActivities `xml:"Activities"`
+}
+
+type tcxActivities struct {
+	Activity tcxActivity `xml:"Activity"`
+}
+
+type tcxActivity struct {
+	Sport string `xml:"Sport,attr"`
+	ID    string `xml:"Id"`
+	Lap   tcxLap `xml:"Lap"`
+	Notes string `xml:"Notes,omitempty"`
+}
+
+type tcxLap struct {
+	StartTime        string        `xml:"StartTime,attr"`
+	TotalTimeSeconds float64       `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64       `xml:"DistanceMeters"`
+	MaximumSpeed     float64       `xml:"MaximumSpeed,omitempty"` // m/s
+	Calories         int           `xml:"Calories"`               // Required by the schema, not measured
+	Intensity        string        `xml:"Intensity"`
+	TriggerMethod    string        `xml:"TriggerMethod"`
+	Track            tcxTrack      `xml:"Track"`
+	Extensions       *tcxLapExtras `xml:"Extensions,omitempty"`
+}
+
+type tcxLapExtras struct {
+	LX tcxLX `xml:"ns3:LX"`
+}
+
+type tcxLX struct {
+	AvgSpeed float64 `xml:"ns3:AvgSpeed"` // m/s
+}
+
+type tcxTrack struct {
+	Points []tcxTrackpoint `xml:"Trackpoint"`
+}
+
+type tcxTrackpoint struct {
+	Time           string        `xml:"Time"`
+	Position       tcxPosition   `xml:"Position"`
+	DistanceMeters float64       `xml:"DistanceMeters"`
+	Extensions     tcxExtensions `xml:"Extensions"`
+}
+
+type tcxPosition struct {
+	Lat float64 `xml:"LatitudeDegrees"`
+	Lon float64 `xml:"LongitudeDegrees"`
+}
+
+type tcxExtensions struct {
+	TPX tcxTPX `xml:"ns3:TPX"`
+}
+
+type tcxTPX struct {
+	Speed float64 `xml:"ns3:Speed"` // m/s
+}
+
+// WriteTCX encodes the ride as a single-lap TCX biking activity.
+func WriteTCX(w io.Writer, ride *models.RideDetail) error {
+	lap := tcxLap{
+		StartTime:        formatTime(ride.StartTime),
+		TotalTimeSeconds: endTime(ride).Sub(ride.StartTime).Seconds(),
+		Intensity:        "Active",
+		TriggerMethod:    "Manual",
+	}
+
+	var distance, maxSpeed float64
+	for i, pos := range ride.Positions {
+		if i > 0 {
+			prev := ride.Positions[i-1]
+			distance += util.HaversineDistance(prev.Latitude, prev.Longitude, pos.Latitude, pos.Longitude)
+		}
+		speed := pos.SpeedKnots / util.MetersPerSecondToKnots
+		if speed > maxSpeed {
+			maxSpeed = speed
+		}
+		lap.Track.Points = append(lap.Track.Points, tcxTrackpoint{
+			Time:           formatTime(pos.Timestamp),
+			Position:       tcxPosition{Lat: pos.Latitude, Lon: pos.Longitude},
+			DistanceMeters: distance,
+			Extensions:     tcxExtensions{TPX: tcxTPX{Speed: speed}},
+		})
+	}
+	lap.DistanceMeters = distance
+	lap.MaximumSpeed = maxSpeed
+	if ride.Stats != nil {
+		lap.Extensions = &tcxLapExtras{LX: tcxLX{AvgSpeed: ride.Stats.AvgSpeedKnots / util.MetersPerSecondToKnots}}
+	}
+
+	doc := tcx{
+		Xmlns:    "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2",
+		XmlnsNs3: "http://www.garmin.com/xmlschemas/ActivityExtension/v2",
+		Activities: tcxActivities{
+			Activity: tcxActivity{
+				Sport: "Biking",
+				ID:    formatTime(ride.StartTime),
+				Lap:   lap,
+				Notes: ride.Name,
+			},
+		},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
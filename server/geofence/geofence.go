@@ -0,0 +1,119 @@
+// Package geofence checks positions against named zones, circles or polygons, and keeps
+// the zones cached in memory so every GPS point can be checked without a database query.
+package geofence
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// metersPerDegree is the length of one degree of latitude, used to project small polygons onto a plane.
+const metersPerDegree = 111320.0
+
+// Validate checks that a geofence has a name and a well-formed shape for its type.
+func Validate(geofence models.Geofence) error {
+	if strings.TrimSpace(geofence.Name) == "" {
+		return errors.New("name is required")
+	}
+	switch geofence.Type {
+	case models.GeofenceCircle:
+		if geofence.Center == nil {
+			return errors.New("circle geofences need a center")
+		}
+		if err := validatePoint(*geofence.Center); err != nil {
+			return fmt.Errorf("invalid center: %w", err)
+		}
+		if geofence.RadiusMeters <= 0 {
+			return errors.New("circle geofences need a positive radius_meters")
+		}
+		if len(geofence.Polygon) > 0 {
+			return errors.New("circle geofences can't have a polygon")
+		}
+	case models.GeofencePolygon:
+		if len(geofence.Polygon) < 3 {
+			return errors.New("polygon geofences need at least 3 vertices")
+		}
+		for i, vertex := range geofence.Polygon {
+			if err := validatePoint(vertex); err != nil {
+				return fmt.Errorf("invalid polygon vertex %d: %w", i, err)
+			}
+		}
+		if geofence.Center != nil || geofence.RadiusMeters != 0 {
+			return errors.New("polygon geofences can't have a center or radius_meters")
+		}
+	default:
+		return fmt.Errorf("invalid type %q, must be %s or %s", geofence.Type, models.GeofenceCircle, models.GeofencePolygon)
+	}
+	return nil
+}
+
+func validatePoint(point models.GeoPoint) error {
+	if point.Latitude < -90 || point.Latitude > 90 {
+		return fmt.Errorf("latitude %f out of range", point.Latitude)
+	}
+	if point.Longitude < -180 || point.Longitude > 180 {
+		return fmt.Errorf("longitude %f out of range", point.Longitude)
+	}
+	return nil
+}
+
+// Contains reports whether the point at lat, lon is inside the geofence. Points on the
+// boundary of a circle are inside; polygons use the even-odd rule.
+func Contains(geofence models.Geofence, lat, lon float64) bool {
+	switch geofence.Type {
+	case models.GeofenceCircle:
+		if geofence.Center == nil {
+			return false
+		}
+		return util.HaversineDistance(geofence.Center.Latitude, geofence.Center.Longitude, lat, lon) <= geofence.RadiusMeters
+	case models.GeofencePolygon:
+		return polygonContains(geofence.Polygon, lat, lon)
+	}
+	return false
+}
+
+// polygonContains casts a ray towards increasing longitude and counts the edges it
+// crosses. Zones are small enough that treating degrees as planar coordinates is fine.
+func polygonContains(polygon []models.GeoPoint, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Latitude > lat) == (b.Latitude > lat) {
+			continue
+		}
+		crossLon := a.Longitude + (lat-a.Latitude)*(b.Longitude-a.Longitude)/(b.Latitude-a.Latitude)
+		if lon < crossLon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// AreaSquareMeters approximates the area of the geofence. It is used to pick the
+// most specific zone when several overlap, e.g. a bike rack inside a campus.
+func AreaSquareMeters(geofence models.Geofence) float64 {
+	switch geofence.Type {
+	case models.GeofenceCircle:
+		return math.Pi * geofence.RadiusMeters * geofence.RadiusMeters
+	case models.GeofencePolygon:
+		if len(geofence.Polygon) < 3 {
+			return 0
+		}
+		// Shoelace formula on an equirectangular projection around the first vertex
+		origin := geofence.Polygon[0]
+		lonScale := metersPerDegree * math.Cos(origin.Latitude*math.Pi/180)
+		var sum float64
+		for i := range geofence.Polygon {
+			a, b := geofence.Polygon[i], geofence.Polygon[(i+1)%len(geofence.Polygon)]
+			ax, ay := (a.Longitude-origin.Longitude)*lonScale, (a.Latitude-origin.Latitude)*metersPerDegree
+			bx, by := (b.Longitude-origin.Longitude)*lonScale, (b.Latitude-origin.Latitude)*metersPerDegree
+			sum += ax*by - bx*ay
+		}
+		return math.Abs(sum) / 2
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package geofence
+
+import (
+	"b3/server/database"
+	"b3/server/models"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registry keeps every geofence in memory, in sync with the store. Changes made through
+// it are written to the store first and only then become visible to Match and ZoneAt.
+type Registry struct {
+	store database.GeofenceStore
+
+	mu    sync.RWMutex
+	zones []models.Geofence // Sorted by ID
+}
+
+// NewRegistry loads the geofences from the store.
+func NewRegistry(store database.GeofenceStore) (*Registry, error) {
+	zones, err := store.GetGeofences()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load geofences: %w", err)
+	}
+	return &Registry{store: store, zones: zones}, nil
+}
+
+// List returns every geofence, oldest first.
+func (r *Registry) List() []models.Geofence {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]models.Geofence(nil), r.zones...)
+}
+
+// Get returns the geofence with the given ID.
+func (r *Registry) Get(id int64) (models.Geofence, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i, ok := r.index(id)
+	if !ok {
+		return models.Geofence{}, false
+	}
+	return r.zones[i], true
+}
+
+// Create stores a new geofence. It must already have passed Validate.
+func (r *Registry) Create(geofence models.Geofence) (models.Geofence, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	geofence.CreatedAt, geofence.UpdatedAt = now, now
+	id, err := r.store.CreateGeofence(geofence)
+	if err != nil {
+		return models.Geofence{}, err
+	}
+	geofence.ID = id
+	r.zones = append(r.zones, geofence)
+	sort.Slice(r.zones, func(i, j int) bool { return r.zones[i].ID < r.zones[j].ID })
+	return geofence, nil
+}
+
+// Update replaces an existing geofence. It must already have passed Validate.
+func (r *Registry) Update(geofence models.Geofence) (models.Geofence, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index(geofence.ID)
+	if !ok {
+		return models.Geofence{}, fmt.Errorf("geofence with ID %d: %w", geofence.ID, database.ErrGeofenceNotFound)
+	}
+	geofence.CreatedAt = r.zones[i].CreatedAt
+	geofence.UpdatedAt = time.Now().UTC()
+	if err := r.store.UpdateGeofence(geofence); err != nil {
+		return models.Geofence{}, err
+	}
+	r.zones[i] = geofence
+	return geofence, nil
+}
+
+// Delete removes a geofence and its events.
+func (r *Registry) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.store.DeleteGeofence(id); err != nil {
+		return err
+	}
+	if i, ok := r.index(id); ok {
+		r.zones = append(r.zones[:i:i], r.zones[i+1:]...)
+	}
+	return nil
+}
+
+// Events returns up to limit of the most recent events of a geofence, newest first.
+func (r *Registry) Events(id int64, limit int) ([]models.GeofenceEvent, error) {
+	return r.store.GetGeofenceEvents(id, limit)
+}
+
+// Match returns every geofence containing the point at lat, lon.
+func (r *Registry) Match(lat, lon float64) []models.Geofence {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []models.Geofence
+	for _, zone := range r.zones {
+		if Contains(zone, lat, lon) {
+			matches = append(matches, zone)
+		}
+	}
+	return matches
+}
+
+// ZoneAt returns the smallest geofence containing the point at lat, lon, so a bike rack
+// wins over the campus around it.
+func (r *Registry) ZoneAt(lat, lon float64) (models.Geofence, bool) {
+	var best models.Geofence
+	bestArea := 0.0
+	found := false
+	for _, zone := range r.Match(lat, lon) {
+		if area := AreaSquareMeters(zone); !found || area < bestArea {
+			best, bestArea, found = zone, area, true
+		}
+	}
+	return best, found
+}
+
+// index returns the position of the geofence with the given ID in r.zones. It assumes r.mu is held.
+func (r *Registry) index(id int64) (int, bool) {
+	i := sort.Search(len(r.zones), func(i int) bool { return r.zones[i].ID >= id })
+	return i, i < len(r.zones) && r.zones[i].ID == id
+}
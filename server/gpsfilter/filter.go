@@ -0,0 +1,149 @@
+package gpsfilter
+
+import (
+	"b3/server/config"
+	"b3/server/models"
+	"b3/server/util"
+	"fmt"
+	"time"
+)
+
+// Decision describes what the filter did with a point.
+type Decision struct {
+	Accepted bool
+	Reason   string // Why the point was rejected, empty if accepted
+	Smoothed bool   // Whether the returned point was adjusted by the Kalman smoother
+}
+
+// Filter rejects GPS outliers and optionally smooths the points of a single device.
+// It is not safe for concurrent use; each device gets its own Filter.
+type Filter struct {
+	cfg                config.Config
+	lastAccepted       *models.Position // Last raw point that passed the outlier check
+	consecutiveRejects int
+	kalman             kalman
+}
+
+// NewFilter creates a Filter for one device.
+func NewFilter(cfg config.Config) *Filter {
+	return &Filter{cfg: cfg, kalman: kalman{variance: -1}}
+}
+
+// Process checks a raw point against the previous accepted point and returns the
+// point to hand to ride detection, possibly smoothed.
+func (f *Filter) Process(point models.Position) (models.Position, Decision) {
+	// Invalid fixes are never accepted, no matter how often they repeat
+	if reason := invalidFix(point); reason != "" {
+		return point, Decision{Accepted: false, Reason: reason}
+	}
+	if reason := f.outlier(point); reason != "" {
+		f.consecutiveRejects++
+		if f.cfg.GPSMaxConsecutiveRejects <= 0 || f.consecutiveRejects < f.cfg.GPSMaxConsecutiveRejects {
+			return point, Decision{Accepted: false, Reason: reason}
+		}
+		// The device has consistently reported this area, so it most likely really moved
+		// (e.g. GPS came back after a long gap). Start over from this point.
+		f.kalman.reset()
+	}
+	f.consecutiveRejects = 0
+	accepted := point
+	f.lastAccepted = &accepted
+
+	if !f.cfg.GPSKalmanEnabled {
+		return point, Decision{Accepted: true}
+	}
+	return f.kalman.update(point, f.cfg), Decision{Accepted: true, Smoothed: true}
+}
+
+// invalidFix returns the reason a point can't be a real fix, or "" if it can.
+func invalidFix(point models.Position) string {
+	if point.Latitude < -90 || point.Latitude > 90 || point.Longitude < -180 || point.Longitude > 180 {
+		return fmt.Sprintf("coordinates out of range (%f, %f)", point.Latitude, point.Longitude)
+	}
+	if point.Latitude == 0 && point.Longitude == 0 {
+		return "null island (0, 0) fix"
+	}
+	return ""
+}
+
+// outlier returns the reason a point should be rejected as a jump from the last
+// accepted point, or "" if it looks plausible.
+func (f *Filter) outlier(point models.Position) string {
+	if !f.cfg.GPSFilterEnabled || f.lastAccepted == nil {
+		return ""
+	}
+
+	last := f.lastAccepted
+	distance := util.HaversineDistance(last.Latitude, last.Longitude, point.Latitude, point.Longitude)
+	dt := point.Timestamp.Sub(last.Timestamp)
+
+	// After a long gap anything is possible; don't compare against a stale point.
+	if dt > time.Duration(f.cfg.RideEndInactivity)*time.Second {
+		return ""
+	}
+	// Same or earlier timestamp: only a duplicate fix at (nearly) the same place is plausible.
+	if dt <= 0 {
+		if distance > f.cfg.RideEndStaticDist {
+			return fmt.Sprintf("moved %.0fm with no time elapsed", distance)
+		}
+		return ""
+	}
+
+	speedKnots := distance / dt.Seconds() * util.MetersPerSecondToKnots
+	if speedKnots > f.cfg.GPSMaxSpeedKnots {
+		return fmt.Sprintf("implied speed %.1f knots over %.0fm exceeds %.1f knots", speedKnots, distance, f.cfg.GPSMaxSpeedKnots)
+	}
+	return ""
+}
+
+// kalman is a minimal Kalman smoother for lat/lon and speed. Position uses a single
+// variance in meters² shared by both axes, which grows with elapsed time by the
+// configured process noise and shrinks with each measurement.
+type kalman struct {
+	lat, lon      float64
+	speedKnots    float64
+	variance      float64 // meters², negative when uninitialised
+	speedVariance float64 // knots²
+	lastTimestamp time.Time
+}
+
+func (k *kalman) reset() {
+	k.variance = -1
+}
+
+func (k *kalman) update(point models.Position, cfg config.Config) models.Position {
+	posNoise := cfg.GPSKalmanPositionNoise * cfg.GPSKalmanPositionNoise
+	speedNoise := cfg.GPSKalmanSpeedNoise * cfg.GPSKalmanSpeedNoise
+
+	dt := point.Timestamp.Sub(k.lastTimestamp).Seconds()
+	if k.variance < 0 || dt > float64(cfg.RideEndInactivity) {
+		k.lat, k.lon, k.speedKnots = point.Latitude, point.Longitude, point.SpeedKnots
+		k.variance = posNoise
+		k.speedVariance = speedNoise
+		k.lastTimestamp = point.Timestamp
+		return point
+	}
+
+	if dt > 0 {
+		q := cfg.GPSKalmanProcessNoise
+		k.variance += dt * q * q
+		qKnots := q * util.MetersPerSecondToKnots
+		k.speedVariance += dt * qKnots * qKnots
+		k.lastTimestamp = point.Timestamp
+	}
+
+	gain := k.variance / (k.variance + posNoise)
+	k.lat += gain * (point.Latitude - k.lat)
+	k.lon += gain * (point.Longitude - k.lon)
+	k.variance *= 1 - gain
+
+	speedGain := k.speedVariance / (k.speedVariance + speedNoise)
+	k.speedKnots += speedGain * (point.SpeedKnots - k.speedKnots)
+	k.speedVariance *= 1 - speedGain
+
+	smoothed := point
+	smoothed.Latitude = k.lat
+	smoothed.Longitude = k.lon
+	smoothed.SpeedKnots = k.speedKnots
+	return smoothed
+}
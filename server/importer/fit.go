@@ -0,0 +1,44 @@
+package importer
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"fmt"
+	"io"
+
+	"github.com/muktihari/fit/decoder"
+	"github.com/muktihari/fit/profile/basetype"
+	"github.com/muktihari/fit/profile/filedef"
+)
+
+// ParseFIT reads the GPS records of a Garmin FIT activity file.
+// Records without a position fix are skipped.
+func ParseFIT(r io.Reader) (*Track, error) {
+	fit, err := decoder.New(r).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FIT: %w", err)
+	}
+	activity := filedef.NewActivity(fit.Messages...)
+
+	track := &Track{Source: SourceFIT}
+	for _, rec := range activity.Records {
+		if rec.PositionLat == basetype.Sint32Invalid || rec.PositionLong == basetype.Sint32Invalid || rec.Timestamp.IsZero() {
+			track.Skipped++
+			continue
+		}
+
+		pos := models.Position{
+			Latitude:  rec.PositionLatDegrees(),
+			Longitude: rec.PositionLongDegrees(),
+			Timestamp: rec.Timestamp.UTC(),
+		}
+		switch {
+		case rec.EnhancedSpeed != basetype.Uint32Invalid:
+			pos.SpeedKnots = rec.EnhancedSpeedScaled() * util.MetersPerSecondToKnots
+		case rec.Speed != basetype.Uint16Invalid:
+			pos.SpeedKnots = rec.SpeedScaled() * util.MetersPerSecondToKnots
+		}
+		track.Positions = append(track.Positions, pos)
+	}
+	return track, nil
+}
@@ -0,0 +1,88 @@
+package importer
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// gpxFile holds the parts of a GPX 1.0/1.1 document used for import.
+// Element names are matched without namespaces so both plain <speed> (GPX 1.0)
+// and the Garmin TrackPointExtension <gpxtpx:speed> are picked up.
+type gpxFile struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Name   string     `xml:"name"` // GPX 1.0 keeps the name at the top level
+	Tracks []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat          float64  `xml:"lat,attr"`
+	Lon          float64  `xml:"lon,attr"`
+	Time         string   `xml:"time"`
+	Speed        *float64 `xml:"speed"`                                // m/s, GPX 1.0
+	SpeedTPX     *float64 `xml:"extensions>TrackPointExtension>speed"` // m/s, Garmin extension
+	SpeedPlainEx *float64 `xml:"extensions>speed"`                     // m/s, used by some phone apps
+}
+
+// ParseGPX reads the track points of a GPX file. Points without a timestamp are skipped.
+func ParseGPX(r io.Reader) (*Track, error) {
+	var doc gpxFile
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse GPX: %w", err)
+	}
+
+	track := &Track{Source: SourceGPX, Name: doc.Metadata.Name}
+	if track.Name == "" {
+		track.Name = doc.Name
+	}
+
+	skipped := 0
+	for _, trk := range doc.Tracks {
+		if track.Name == "" {
+			track.Name = trk.Name
+		}
+		for _, seg := range trk.Segments {
+			for _, pt := range seg.Points {
+				if pt.Time == "" {
+					skipped++
+					continue
+				}
+				ts, err := time.Parse(time.RFC3339Nano, pt.Time)
+				if err != nil {
+					return nil, fmt.Errorf("invalid GPX point time %q: %w", pt.Time, err)
+				}
+
+				pos := models.Position{
+					Latitude:  pt.Lat,
+					Longitude: pt.Lon,
+					Timestamp: ts.UTC(),
+				}
+				switch {
+				case pt.SpeedTPX != nil:
+					pos.SpeedKnots = *pt.SpeedTPX * util.MetersPerSecondToKnots
+				case pt.SpeedPlainEx != nil:
+					pos.SpeedKnots = *pt.SpeedPlainEx * util.MetersPerSecondToKnots
+				case pt.Speed != nil:
+					pos.SpeedKnots = *pt.Speed * util.MetersPerSecondToKnots
+				}
+				track.Positions = append(track.Positions, pos)
+			}
+		}
+	}
+	track.Skipped = skipped
+	return track, nil
+}
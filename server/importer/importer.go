@@ -0,0 +1,155 @@
+package importer
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/ride"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Sources recorded on imported rides. Rides from the tracker use "device".
+const (
+	SourceGPX = "gpx"
+	SourceFIT = "fit"
+)
+
+// Track is a ride parsed from an imported file, before it is stored.
+type Track struct {
+	Source    string
+	Name      string // Name from the file, may be empty
+	Positions []models.Position
+	Skipped   int // Points dropped because they had no time or position
+}
+
+// Result describes the outcome of importing one track.
+type Result struct {
+	RideID     int64  `json:"ride_id"`
+	Name       string `json:"name"`
+	Source     string `json:"source"`
+	PointCount int    `json:"point_count"`
+	Skipped    int    `json:"skipped_points"`
+}
+
+// DuplicateRideError is returned when an imported track matches a ride that is already stored.
+type DuplicateRideError struct {
+	RideID int64
+}
+
+func (e *DuplicateRideError) Error() string {
+	return fmt.Sprintf("ride already exists with ID %d", e.RideID)
+}
+
+// Parse detects the format of an uploaded file from its name or contents and parses it.
+func Parse(filename string, r io.Reader) (*Track, error) {
+	br := bufio.NewReader(r)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gpx":
+		return ParseGPX(br)
+	case ".fit":
+		return ParseFIT(br)
+	}
+
+	// FIT files carry ".FIT" at bytes 8-11 of the header
+	header, _ := br.Peek(12)
+	if len(header) == 12 && string(header[8:12]) == ".FIT" {
+		return ParseFIT(br)
+	}
+	if bytes.Contains(header, []byte("<")) {
+		return ParseGPX(br)
+	}
+	return nil, fmt.Errorf("unrecognized file format for %q, expected GPX or FIT", filename)
+}
+
+// TrackHash returns a hash of the track's timestamps and coordinates, rounded to
+// roughly 10cm so re-exports of the same ride hash the same.
+func TrackHash(positions []models.Position) string {
+	h := sha256.New()
+	buf := make([]byte, 8)
+	for _, pos := range positions {
+		binary.BigEndian.PutUint64(buf, uint64(pos.Timestamp.Unix()))
+		h.Write(buf)
+		binary.BigEndian.PutUint64(buf, uint64(int64(math.Round(pos.Latitude*1e6))))
+		h.Write(buf)
+		binary.BigEndian.PutUint64(buf, uint64(int64(math.Round(pos.Longitude*1e6))))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Import stores a parsed track as a finished ride for deviceID, using the same
+// database calls as live tracking, and computes its stats.
+// A *DuplicateRideError is returned if the ride was imported or recorded before.
+func Import(store database.RideStore, cfg config.Config, deviceID string, track *Track) (*Result, error) {
+	if len(track.Positions) == 0 {
+		return nil, fmt.Errorf("no timestamped GPS points found in %s file", track.Source)
+	}
+
+	positions := track.Positions
+	sort.SliceStable(positions, func(i, j int) bool { return positions[i].Timestamp.Before(positions[j].Timestamp) })
+	startTime := positions[0].Timestamp
+	endTime := positions[len(positions)-1].Timestamp
+	trackHash := TrackHash(positions)
+
+	existingID, err := store.FindDuplicateRide(deviceID, startTime, trackHash)
+	if err != nil {
+		return nil, err
+	}
+	if existingID != 0 {
+		return nil, &DuplicateRideError{RideID: existingID}
+	}
+
+	name := track.Name
+	if name == "" {
+		name = ride.DetermineRideName(startTime, cfg)
+	}
+
+	rideID, err := store.CreateRide(deviceID, name, startTime)
+	if err != nil {
+		return nil, err
+	}
+	// Remove the partial ride if anything below fails so the import can be retried
+	fail := func(err error) (*Result, error) {
+		if delErr := store.DeleteRide(rideID); delErr != nil {
+			log.Printf("Failed to remove partially imported ride %d: %v", rideID, delErr)
+		}
+		return nil, err
+	}
+
+	if err := store.MarkRideImported(rideID, track.Source, trackHash); err != nil {
+		return fail(err)
+	}
+	batch := make([]database.RidePosition, 0, len(positions))
+	for _, pos := range positions {
+		batch = append(batch, database.RidePosition{RideID: rideID, Position: pos})
+	}
+	if err := store.AddPositions(batch); err != nil {
+		return fail(err)
+	}
+	if err := store.EndRide(rideID, endTime); err != nil {
+		return fail(err)
+	}
+	if _, err := ride.UpdateRideStats(store, rideID, cfg); err != nil {
+		log.Printf("Error computing stats for imported ride %d: %v", rideID, err)
+	}
+
+	log.Printf("Imported %s ride %d (%s) with %d points for device %s", track.Source, rideID, name, len(positions), deviceID)
+	return &Result{
+		RideID:     rideID,
+		Name:       name,
+		Source:     track.Source,
+		PointCount: len(positions),
+		Skipped:    track.Skipped,
+	}, nil
+}
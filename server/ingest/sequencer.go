@@ -0,0 +1,346 @@
+// Package ingest puts incoming GPS points back in order before ride detection sees them.
+package ingest
+
+import (
+	"b3/server/config"
+	"b3/server/models"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policies for points older than the last point released for their device.
+const (
+	LatePolicyStore = "store" // Add the point to the current ride without running state transitions
+	LatePolicyDrop  = "drop"  // Discard the point
+)
+
+// seenKeysPerDevice is how many recent points each device remembers for deduplication.
+const seenKeysPerDevice = 512
+
+// Point is a GPS point as received from a device.
+type Point struct {
+	DeviceID string
+	Position models.Position
+	Version  int64 // Shadow document version, 0 if unknown
+}
+
+// Options controls reordering and the late point policy.
+type Options struct {
+	Window     time.Duration // How long points are held to let earlier ones catch up, 0 disables reordering
+	LatePolicy string        // LatePolicyStore or LatePolicyDrop
+}
+
+// OptionsFromConfig reads the ingest settings from the app config.
+func OptionsFromConfig(cfg config.Config) Options {
+	opts := Options{
+		Window:     time.Duration(cfg.IngestReorderWindowMillis) * time.Millisecond,
+		LatePolicy: cfg.IngestLatePolicy,
+	}
+	if opts.Window < 0 {
+		opts.Window = 0
+	}
+	if opts.LatePolicy != LatePolicyDrop {
+		opts.LatePolicy = LatePolicyStore
+	}
+	return opts
+}
+
+// Stats counts what happened to received points.
+type Stats struct {
+	Received    int64 `json:"received"`
+	Duplicates  int64 `json:"duplicates"`   // Dropped as a repeat of a recent point or shadow version
+	Reordered   int64 `json:"reordered"`    // Arrived before a later point and were moved ahead of it
+	Late        int64 `json:"late"`         // Arrived after a later point had already been released
+	LateStored  int64 `json:"late_stored"`  // Late points added to a ride
+	LateDropped int64 `json:"late_dropped"` // Late points discarded, by policy or because no ride was open
+	Pending     int   `json:"pending"`      // Points held in the reorder window or waiting to be processed
+}
+
+// pendingPoint is a point waiting in the reorder window.
+type pendingPoint struct {
+	point      Point
+	receivedAt time.Time
+}
+
+// delivery is a point released from the reorder window, or a late point, waiting for
+// the delivery goroutine.
+type delivery struct {
+	point        Point
+	late         bool
+	lastReleased time.Time // For late points, the newest point released before them
+}
+
+// deviceWindow is the reorder state of one device.
+type deviceWindow struct {
+	pending      []pendingPoint // Sorted by timestamp, then version
+	lastReleased time.Time      // Timestamp of the newest point released
+	seen         map[string]bool
+	seenOrder    []string // Oldest first, to evict from seen
+}
+
+// Sequencer holds each device's points for a short window and releases them in
+// timestamp order, dropping duplicates. Points older than what was already released
+// are handled by the late policy.
+//
+// process and late are called from a single goroutine, in release order and never
+// concurrently, so they may share state without locking. They may be slow: Add and
+// Stats don't wait for them.
+type Sequencer struct {
+	opts    Options
+	process func(Point)      // Receives points in order
+	late    func(Point) bool // Receives late points with LatePolicyStore, reports whether it stored the point
+
+	mu         sync.Mutex
+	devices    map[string]*deviceWindow
+	stats      Stats
+	deliveries []delivery // Released and late points for the delivery goroutine, oldest first
+	queued     *sync.Cond // Signalled when deliveries grows or closing is set
+	closing    bool       // Set by Close, the delivery goroutine exits once deliveries is empty
+
+	stop      chan struct{}
+	done      chan struct{}
+	delivered chan struct{} // Closed when the delivery goroutine exits
+	closeOnce sync.Once
+}
+
+// NewSequencer creates a Sequencer. Call Start to hand points over and release held points
+// as their window expires.
+func NewSequencer(opts Options, process func(Point), late func(Point) bool) *Sequencer {
+	s := &Sequencer{
+		opts:      opts,
+		process:   process,
+		late:      late,
+		devices:   make(map[string]*deviceWindow),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		delivered: make(chan struct{}),
+	}
+	s.queued = sync.NewCond(&s.mu)
+	return s
+}
+
+// Start runs the background release loop and the delivery goroutine.
+func (s *Sequencer) Start() {
+	go s.run()
+	go s.deliver()
+}
+
+// Add receives a point. It is released right away if reordering is disabled, and
+// otherwise once it has spent the window in the buffer.
+func (s *Sequencer) Add(point Point) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Received++
+	window := s.device(point.DeviceID)
+
+	if window.isDuplicate(point) {
+		s.stats.Duplicates++
+		log.Printf("Ingest: dropped duplicate point from %s at %v (version %d)", point.DeviceID, point.Position.Timestamp, point.Version)
+		return
+	}
+
+	if !window.lastReleased.IsZero() && point.Position.Timestamp.Before(window.lastReleased) {
+		s.handleLate(point, window.lastReleased)
+		return
+	}
+
+	i := sort.Search(len(window.pending), func(i int) bool { return later(window.pending[i].point, point) })
+	if i < len(window.pending) {
+		s.stats.Reordered++
+		log.Printf("Ingest: point from %s at %v arrived out of order, moved ahead of %d held points",
+			point.DeviceID, point.Position.Timestamp, len(window.pending)-i)
+	}
+	window.pending = append(window.pending, pendingPoint{})
+	copy(window.pending[i+1:], window.pending[i:])
+	window.pending[i] = pendingPoint{point: point, receivedAt: time.Now()}
+
+	if s.opts.Window == 0 {
+		s.releaseDue(window, time.Time{})
+	}
+}
+
+// Stats returns the counters for every device combined.
+func (s *Sequencer) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Pending = len(s.deliveries)
+	for _, window := range s.devices {
+		stats.Pending += len(window.pending)
+	}
+	return stats
+}
+
+// Close stops the release loop, releases every held point and waits until every point
+// has been handed to process or late.
+func (s *Sequencer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+
+		s.mu.Lock()
+		for _, window := range s.devices {
+			s.releaseDue(window, time.Time{})
+		}
+		s.closing = true
+		s.queued.Signal()
+		s.mu.Unlock()
+		<-s.delivered
+	})
+}
+
+func (s *Sequencer) run() {
+	defer close(s.done)
+	if s.opts.Window == 0 {
+		<-s.stop
+		return
+	}
+
+	// Check a few times per window so no point is held much longer than the window
+	ticker := time.NewTicker(max(s.opts.Window/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for _, window := range s.devices {
+				s.releaseDue(window, now.Add(-s.opts.Window))
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// deliver hands released and late points to process and late, in order, without holding
+// s.mu, until Close.
+func (s *Sequencer) deliver() {
+	defer close(s.delivered)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for len(s.deliveries) == 0 && !s.closing {
+			s.queued.Wait()
+		}
+		if len(s.deliveries) == 0 {
+			return
+		}
+		batch := s.deliveries
+		s.deliveries = nil
+
+		s.mu.Unlock()
+		stored := make([]bool, len(batch))
+		for i, d := range batch {
+			if d.late {
+				stored[i] = s.opts.LatePolicy == LatePolicyStore && s.late != nil && s.late(d.point)
+			} else {
+				s.process(d.point)
+			}
+		}
+		s.mu.Lock()
+
+		for i, d := range batch {
+			if d.late {
+				s.countLate(d, stored[i])
+			}
+		}
+	}
+}
+
+// enqueue passes a point to the delivery goroutine. It assumes s.mu is held.
+func (s *Sequencer) enqueue(d delivery) {
+	s.deliveries = append(s.deliveries, d)
+	s.queued.Signal()
+}
+
+// releaseDue releases, in order, the held points received before cutoff. A zero
+// cutoff releases everything. It assumes s.mu is held.
+func (s *Sequencer) releaseDue(window *deviceWindow, cutoff time.Time) {
+	// Points are sorted by timestamp, not arrival, so stop at the first one still held:
+	// releasing a later one first would make the earlier one late.
+	n := 0
+	for n < len(window.pending) && (cutoff.IsZero() || window.pending[n].receivedAt.Before(cutoff)) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	released := window.pending[:n]
+	window.pending = append([]pendingPoint(nil), window.pending[n:]...)
+
+	for _, p := range released {
+		window.lastReleased = p.point.Position.Timestamp
+		s.enqueue(delivery{point: p.point})
+	}
+}
+
+// handleLate passes a late point on for the late policy. It assumes s.mu is held.
+func (s *Sequencer) handleLate(point Point, lastReleased time.Time) {
+	s.stats.Late++
+	s.enqueue(delivery{point: point, late: true, lastReleased: lastReleased})
+}
+
+// countLate records what the late policy did with a point. It assumes s.mu is held.
+func (s *Sequencer) countLate(d delivery, stored bool) {
+	behind := d.lastReleased.Sub(d.point.Position.Timestamp)
+	if stored {
+		s.stats.LateStored++
+		log.Printf("Ingest: stored late point from %s at %v, %v behind the last released point",
+			d.point.DeviceID, d.point.Position.Timestamp, behind)
+		return
+	}
+	s.stats.LateDropped++
+	log.Printf("Ingest: dropped late point from %s at %v, %v behind the last released point",
+		d.point.DeviceID, d.point.Position.Timestamp, behind)
+}
+
+// device returns the reorder state of deviceID, creating it if needed. It assumes s.mu is held.
+func (s *Sequencer) device(deviceID string) *deviceWindow {
+	window, ok := s.devices[deviceID]
+	if !ok {
+		window = &deviceWindow{seen: make(map[string]bool)}
+		s.devices[deviceID] = window
+	}
+	return window
+}
+
+// isDuplicate reports whether the point, or its shadow version, was received recently,
+// and remembers it otherwise.
+func (w *deviceWindow) isDuplicate(point Point) bool {
+	pos := point.Position
+	keys := []string{fmt.Sprintf("p:%d:%f:%f", pos.Timestamp.UnixNano(), pos.Latitude, pos.Longitude)}
+	if point.Version != 0 {
+		keys = append(keys, fmt.Sprintf("v:%d", point.Version))
+	}
+	for _, key := range keys {
+		if w.seen[key] {
+			return true
+		}
+	}
+
+	for _, key := range keys {
+		w.seen[key] = true
+		w.seenOrder = append(w.seenOrder, key)
+	}
+	if excess := len(w.seenOrder) - seenKeysPerDevice; excess > 0 {
+		for _, key := range w.seenOrder[:excess] {
+			delete(w.seen, key)
+		}
+		w.seenOrder = append([]string(nil), w.seenOrder[excess:]...)
+	}
+	return false
+}
+
+// later reports whether a sorts after b: by timestamp, then by shadow version.
+func later(a, b Point) bool {
+	if !a.Position.Timestamp.Equal(b.Position.Timestamp) {
+		return a.Position.Timestamp.After(b.Position.Timestamp)
+	}
+	return a.Version > b.Version
+}
@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"b3/server/models"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC)
+
+// point returns a point of deviceID sec seconds after base, with a position derived from
+// sec so points at different times are never duplicates.
+func point(deviceID string, sec int, version int64) Point {
+	return Point{
+		DeviceID: deviceID,
+		Position: models.Position{
+			Latitude:  52 + float64(sec)/1e4,
+			Longitude: 4,
+			Timestamp: base.Add(time.Duration(sec) * time.Second),
+		},
+		Version: version,
+	}
+}
+
+// label names a point in expectations, e.g. "bike1@3v7".
+func label(p Point) string {
+	s := fmt.Sprintf("%s@%d", p.DeviceID, int(p.Position.Timestamp.Sub(base)/time.Second))
+	if p.Version != 0 {
+		s += fmt.Sprintf("v%d", p.Version)
+	}
+	return s
+}
+
+func TestSequencer(t *testing.T) {
+	// Long enough that only Close releases held points, so runs are deterministic
+	const held = time.Hour
+
+	tests := []struct {
+		name        string
+		window      time.Duration
+		latePolicy  string
+		lateStores  bool // What the late callback reports
+		points      []Point
+		wantProcess []string
+		wantLate    []string // Points passed to the late callback
+		wantStats   Stats
+	}{
+		{
+			name:        "in order",
+			window:      held,
+			points:      []Point{point("bike1", 1, 0), point("bike1", 2, 0), point("bike1", 3, 0)},
+			wantProcess: []string{"bike1@1", "bike1@2", "bike1@3"},
+			wantStats:   Stats{Received: 3},
+		},
+		{
+			name:        "out of order within the window",
+			window:      held,
+			points:      []Point{point("bike1", 3, 0), point("bike1", 1, 0), point("bike1", 2, 0)},
+			wantProcess: []string{"bike1@1", "bike1@2", "bike1@3"},
+			wantStats:   Stats{Received: 3, Reordered: 2},
+		},
+		{
+			name:        "equal timestamps are ordered by version",
+			window:      held,
+			points:      []Point{withPosition(point("bike1", 1, 8), 1), point("bike1", 1, 7)},
+			wantProcess: []string{"bike1@1v7", "bike1@1v8"},
+			wantStats:   Stats{Received: 2, Reordered: 1},
+		},
+		{
+			name:        "repeated point is a duplicate",
+			window:      held,
+			points:      []Point{point("bike1", 1, 0), point("bike1", 2, 0), point("bike1", 1, 0)},
+			wantProcess: []string{"bike1@1", "bike1@2"},
+			wantStats:   Stats{Received: 3, Duplicates: 1},
+		},
+		{
+			name:        "repeated shadow version is a duplicate",
+			window:      held,
+			points:      []Point{point("bike1", 1, 5), point("bike1", 2, 5)},
+			wantProcess: []string{"bike1@1v5"},
+			wantStats:   Stats{Received: 2, Duplicates: 1},
+		},
+		{
+			name:        "duplicate of an already released point",
+			window:      0,
+			points:      []Point{point("bike1", 1, 0), point("bike1", 1, 0)},
+			wantProcess: []string{"bike1@1"},
+			wantStats:   Stats{Received: 2, Duplicates: 1},
+		},
+		{
+			name:        "late point stored",
+			window:      0,
+			latePolicy:  LatePolicyStore,
+			lateStores:  true,
+			points:      []Point{point("bike1", 2, 0), point("bike1", 1, 0)},
+			wantProcess: []string{"bike1@2"},
+			wantLate:    []string{"bike1@1"},
+			wantStats:   Stats{Received: 2, Late: 1, LateStored: 1},
+		},
+		{
+			name:        "late point with no ride open",
+			window:      0,
+			latePolicy:  LatePolicyStore,
+			lateStores:  false,
+			points:      []Point{point("bike1", 2, 0), point("bike1", 1, 0)},
+			wantProcess: []string{"bike1@2"},
+			wantLate:    []string{"bike1@1"},
+			wantStats:   Stats{Received: 2, Late: 1, LateDropped: 1},
+		},
+		{
+			name:        "late point dropped by policy",
+			window:      0,
+			latePolicy:  LatePolicyDrop,
+			lateStores:  true,
+			points:      []Point{point("bike1", 2, 0), point("bike1", 1, 0)},
+			wantProcess: []string{"bike1@2"},
+			wantStats:   Stats{Received: 2, Late: 1, LateDropped: 1},
+		},
+		{
+			name:        "point at the last released time is not late",
+			window:      0,
+			latePolicy:  LatePolicyStore,
+			points:      []Point{point("bike1", 2, 1), withPosition(point("bike1", 2, 2), 3)},
+			wantProcess: []string{"bike1@2v1", "bike1@2v2"},
+			wantStats:   Stats{Received: 2},
+		},
+		{
+			name:        "devices are ordered independently",
+			window:      held,
+			points:      []Point{point("bike1", 2, 0), point("bike2", 1, 0), point("bike1", 1, 0)},
+			wantProcess: []string{"bike1@1", "bike1@2", "bike2@1"},
+			wantStats:   Stats{Received: 3, Reordered: 1},
+		},
+		{
+			name:        "a released point of one device does not make another's late",
+			window:      0,
+			latePolicy:  LatePolicyStore,
+			points:      []Point{point("bike1", 5, 0), point("bike2", 1, 0)},
+			wantProcess: []string{"bike1@5", "bike2@1"},
+			wantStats:   Stats{Received: 2},
+		},
+		{
+			name:        "duplicates are per device",
+			window:      held,
+			points:      []Point{point("bike1", 1, 3), point("bike2", 1, 3)},
+			wantProcess: []string{"bike1@1v3", "bike2@1v3"},
+			wantStats:   Stats{Received: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var processed, late []string
+			s := NewSequencer(Options{Window: tt.window, LatePolicy: tt.latePolicy},
+				func(p Point) { processed = append(processed, label(p)) },
+				func(p Point) bool { late = append(late, label(p)); return tt.lateStores })
+			s.Start()
+			for _, p := range tt.points {
+				s.Add(p)
+			}
+			s.Close()
+
+			// Close releases devices in no particular order, so only compare the order
+			// within each device
+			slices.SortStableFunc(processed, func(a, b string) int {
+				return strings.Compare(strings.Split(a, "@")[0], strings.Split(b, "@")[0])
+			})
+			if !slices.Equal(processed, tt.wantProcess) {
+				t.Errorf("processed %v, want %v", processed, tt.wantProcess)
+			}
+			if !slices.Equal(late, tt.wantLate) {
+				t.Errorf("late callback got %v, want %v", late, tt.wantLate)
+			}
+			if stats := s.Stats(); stats != tt.wantStats {
+				t.Errorf("stats %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+// TestSequencerReleasesAfterWindow checks that held points are released by the background
+// loop once the window has passed, without Close.
+func TestSequencerReleasesAfterWindow(t *testing.T) {
+	released := make(chan Point, 2)
+	s := NewSequencer(Options{Window: 20 * time.Millisecond, LatePolicy: LatePolicyStore},
+		func(p Point) { released <- p }, nil)
+	s.Start()
+	defer s.Close()
+
+	s.Add(point("bike1", 2, 0))
+	s.Add(point("bike1", 1, 0))
+	for _, want := range []string{"bike1@1", "bike1@2"} {
+		select {
+		case p := <-released:
+			if label(p) != want {
+				t.Fatalf("released %s, want %s", label(p), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s not released within a second", want)
+		}
+	}
+}
+
+// withPosition moves a point's position without changing its time, so it is not a
+// duplicate of another point at the same time.
+func withPosition(p Point, offset float64) Point {
+	p.Position.Longitude += offset
+	return p
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -12,14 +14,22 @@ import (
 	"syscall"
 	"time"
 
+	"b3/server/alerts"
 	"b3/server/api" // Added for API handlers
+	"b3/server/auth"
 	"b3/server/config"
 	"b3/server/database"
+	"b3/server/geofence"
+	"b3/server/gpsfilter"
+	"b3/server/ingest"
 	"b3/server/models"
 	"b3/server/mqttsubscriber"
+	"b3/server/nmea"
+	"b3/server/notify"
 	"b3/server/ride"
-	"b3/server/snsnotifier"
+	"b3/server/share"
 	"b3/server/util"
+	"b3/server/writebuffer"
 	"b3/server/ws"
 
 	"github.com/gin-contrib/cors"
@@ -37,6 +47,9 @@ type ShadowStateDesired struct {
 	ValidFix   bool    `json:"valid_fix"`
 	Status     string  `json:"status,omitempty"`      // For crash detection
 	LockStatus string  `json:"lock_status,omitempty"` // For lock mode: "LOCKED" or "UNLOCKED"
+
+	// Raw $GPRMC/$GPGGA/$GPVTG sentences, used instead of the fields above when present
+	NMEA nmea.Sentences `json:"nmea,omitempty"`
 }
 
 // ShadowState holds the overall state from the shadow document.
@@ -45,6 +58,12 @@ type ShadowState struct {
 	Reported map[string]interface{} `json:"reported"`
 }
 
+// ReportedLockStatus returns the lock status the device reported, or an empty string.
+func (s ShadowState) ReportedLockStatus() string {
+	status, _ := s.Reported["lock_status"].(string)
+	return status
+}
+
 // ShadowDocument is the top-level structure of the AWS IoT device shadow.
 type ShadowDocument struct {
 	State     ShadowState            `json:"state"`
@@ -71,74 +90,165 @@ func main() {
 		log.Println("Test mode enabled via command-line flag.")
 	}
 
-	// Initialize database
-	db, err := database.NewStore(appConfig.PostgresConnStr)
+	// Initialize the ride store (Postgres, SQLite or in-memory, depending on config)
+	// The migrate command manages the schema itself, so don't migrate up before it runs
+	openStore := database.NewStore
+	if flag.Arg(0) == "migrate" {
+		openStore = database.OpenStore
+	}
+	store, err := openStore(appConfig)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer db.Close()
+	defer store.Close()
+
+	// Run a one-off subcommand instead of the server if one was given
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args(), store, appConfig); err != nil {
+			log.Fatalf("Command %s failed: %v", flag.Arg(0), err)
+		}
+		return
+	}
+
+	// Positions are written in batches in the background, surviving database outages
+	positionBuffer, err := writebuffer.New(store, writebuffer.OptionsFromConfig(appConfig))
+	if err != nil {
+		log.Fatalf("Failed to initialize position buffer: %v", err)
+	}
+	positionBuffer.Start()
+	// Write points spilled by a previous run before looking at open rides
+	if err := positionBuffer.Flush(); err != nil {
+		log.Printf("Failed to write queued positions, will keep retrying: %v", err)
+	}
 
 	// Setup WebSocket Hub
 	wsHub := ws.NewHub()
 	go wsHub.Run()
 
-	// Initialize RideManager
-	rideManager := ride.NewRideManager(db, appConfig, wsHub)
+	// Rides recorded before multi-device support belong to the default device
+	if n, err := store.BackfillDeviceID(appConfig.DefaultDeviceID); err != nil {
+		log.Printf("Failed to backfill ride device IDs: %v", err)
+	} else if n > 0 {
+		log.Printf("Assigned %d existing rides to device %s", n, appConfig.DefaultDeviceID)
+	}
+
+	// Geofences are kept in memory so every point can be checked against them
+	zones, err := geofence.NewRegistry(store)
+	if err != nil {
+		log.Fatalf("Failed to initialize geofences: %v", err)
+	}
+
+	// Initialize a RideManager per device
+	fleet := ride.NewFleet(store, positionBuffer, appConfig, wsHub, zones)
+	knownDevices, err := store.GetDeviceIDs()
+	if err != nil {
+		log.Printf("Failed to load known devices: %v", err)
+	}
+	for _, deviceID := range knownDevices {
+		fleet.Manager(deviceID)
+	}
+	// Resume or close rides that were still open when the server last stopped
+	if err := fleet.RecoverOpenRides(); err != nil {
+		log.Printf("Failed to recover open rides: %v", err)
+	}
+	// Keep recording bikes whose theft incidents the owner has not resolved yet
+	if err := fleet.RecoverTheftIncidents(); err != nil {
+		log.Printf("Failed to recover open theft incidents: %v", err)
+	}
 	inactivityCheckInterval := time.Duration(appConfig.RideEndStaticSecs) * time.Second
 	if inactivityCheckInterval <= 0 {
 		inactivityCheckInterval = 30 * time.Second
 	}
-	go rideManager.CheckInactivityLoop(inactivityCheckInterval)
-	log.Println("RideManager initialized and inactivity checker started.")
+	go fleet.CheckInactivityLoop(inactivityCheckInterval)
+	log.Println("Ride fleet initialized and inactivity checker started.")
 
-	// Initialize Notifier only if SNS is enabled in config
-	var crashNotifier *snsnotifier.Notifier
-	if appConfig.SNSEnabled {
-		var err error
-		crashNotifier, err = snsnotifier.NewNotifier(context.Background())
+	// Notification channels from config; without any, alerts are only recorded
+	notifiers, err := notify.FromConfig(context.Background(), appConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up notification channels: %v", err)
+	}
+	for _, notifier := range notifiers {
+		log.Printf("Notification channel %s (%s) ready.", notifier.Name(), notifier.Type())
+	}
+
+	// Every alert goes through the alert manager, which sends it once per incident
+	alertManager, err := alerts.NewManager(store, wsHub, alerts.OptionsFromConfig(appConfig), notifiers)
+	if err != nil {
+		log.Fatalf("Failed to initialize alert manager: %v", err)
+	}
+	alertManager.Start()
+
+	// Set up theft alert function after the alert manager is initialized
+	theftAlertFunc := func(deviceID string, incidentID int64, lat, lon float64, timestamp time.Time) {
+		theftMessage := fmt.Sprintf(
+			"Unauthorized movement detected while bike %s is locked at %s.\nLocation: lat %f, lon %f.\n\nGoogle Maps: https://maps.google.com/?q=%f,%f",
+			deviceID,
+			timestamp.Format(time.RFC1123),
+			lat,
+			lon,
+			lat,
+			lon,
+		)
+		_, err := alertManager.Raise(alerts.Trigger{
+			Type:      models.AlertTheft,
+			DeviceID:  deviceID,
+			Key:       alerts.TheftKey(deviceID, incidentID),
+			Title:     "🚨 THEFT ALERT 🚨",
+			Message:   theftMessage,
+			Latitude:  lat,
+			Longitude: lon,
+		})
 		if err != nil {
-			log.Printf("Failed to initialize SNS Notifier: %v. Continuing without crash notifications.", err)
-			crashNotifier = nil // Ensure notifier is nil on failure
+			log.Printf("Failed to raise theft alert for %s: %v", deviceID, err)
 		}
 	}
+	fleet.SetTheftAlertFunc(theftAlertFunc)
 
-	// Set up theft alert function after SNS notifier is initialized
-	theftAlertFunc := func(lat, lon float64, timestamp time.Time) {
-		if crashNotifier != nil {
-			theftMessage := fmt.Sprintf(
-				"🚨 THEFT ALERT 🚨\n\nUnauthorized movement detected while bike is locked at %s.\nLocation: lat %f, lon %f.\n\nGoogle Maps: https://maps.google.com/?q=%f,%f",
-				timestamp.Format(time.RFC1123),
-				lat,
-				lon,
-				lat,
-				lon,
-			)
-
-			err := crashNotifier.PublishSimple(appConfig.SNSTopicArn, theftMessage)
-			if err != nil {
-				log.Printf("Failed to publish theft alert to SNS: %v", err)
-			} else {
-				log.Println("Successfully published theft alert to SNS.")
-			}
-		} else {
-			log.Println("Theft detected, but SNS notifier is not enabled.")
+	// Geofences with notify set send a notification when a device enters or exits them
+	fleet.SetGeofenceAlertFunc(func(event models.GeofenceEvent) {
+		action := "entered"
+		if event.Type == models.GeofenceExit {
+			action = "left"
 		}
-	}
-	rideManager.SetTheftAlertFunc(theftAlertFunc)
+		message := fmt.Sprintf(
+			"Bike %s %s %s at %s.\nLocation: lat %f, lon %f.\n\nGoogle Maps: https://maps.google.com/?q=%f,%f",
+			event.DeviceID,
+			action,
+			event.GeofenceName,
+			event.Timestamp.Format(time.RFC1123),
+			event.Latitude,
+			event.Longitude,
+			event.Latitude,
+			event.Longitude,
+		)
+		_, err := alertManager.Raise(alerts.Trigger{
+			Type:      models.AlertGeofence,
+			DeviceID:  event.DeviceID,
+			Key:       alerts.GeofenceKey(event),
+			Title:     fmt.Sprintf("📍 Bike %s %s %s", event.DeviceID, action, event.GeofenceName),
+			Message:   message,
+			Latitude:  event.Latitude,
+			Longitude: event.Longitude,
+		})
+		if err != nil {
+			log.Printf("Failed to raise geofence alert for %s: %v", event.DeviceID, err)
+		}
+	})
 
-	var msgChan <-chan []byte
-	var errChan <-chan error
-	var closeFn func()
+	var mqttClient *mqttsubscriber.Client
 
 	if appConfig.TestMode {
-		log.Println("Running in test mode. MQTT subscriber is mocked.")
-		msgChan, errChan, closeFn = mqttsubscriber.SubscribeToShadowUpdatesMock()
+		log.Println("Running in test mode. MQTT client is mocked.")
+		mqttClient = mqttsubscriber.NewMockClient(appConfig.MQTTShadowTopic, appConfig.MQTTNMEATopic)
 	} else {
 		var err error
-		msgChan, errChan, closeFn, err = mqttsubscriber.SubscribeToShadowUpdates(
+		mqttClient, err = mqttsubscriber.Connect(
 			appConfig.MQTTBrokerURL,
 			appConfig.MQTTClientID,
-			appConfig.MQTTTopic,
+			appConfig.MQTTShadowTopic,
+			appConfig.MQTTNMEATopic,
+			time.Duration(appConfig.MQTTMaxReconnectSecs)*time.Second,
+			fleet.DeviceIDs,
 			appConfig.MQTTCertPEM,
 			appConfig.MQTTKeyPEM,
 			appConfig.MQTTRootCAPEM,
@@ -147,34 +257,15 @@ func main() {
 			appConfig.MQTTRootCAPath,
 		)
 		if err != nil {
-			log.Fatalf("Failed to subscribe to shadow updates: %v", err)
+			log.Fatalf("Failed to connect to MQTT broker: %v", err)
 		}
 	}
 
-	// Initialize MQTT Publisher for shadow updates
-	var mqttPublisher *mqttsubscriber.Publisher
-	if !appConfig.TestMode {
-		var err error
-		mqttPublisher, err = mqttsubscriber.NewPublisher(
-			appConfig.MQTTBrokerURL,
-			appConfig.MQTTClientID,
-			appConfig.MQTTUpdateTopic,
-			appConfig.MQTTCertPEM,
-			appConfig.MQTTKeyPEM,
-			appConfig.MQTTRootCAPEM,
-			appConfig.MQTTCertPath,
-			appConfig.MQTTKeyPath,
-			appConfig.MQTTRootCAPath,
-		)
-		if err != nil {
-			log.Printf("Failed to initialize MQTT publisher: %v. Lock status updates will not be published.", err)
-			mqttPublisher = nil
-		} else {
-			log.Println("MQTT Publisher initialized successfully.")
-		}
-	}
+	processPoint, processLatePoint := newPointProcessor(fleet, store, appConfig)
+	sequencer := ingest.NewSequencer(ingest.OptionsFromConfig(appConfig), processPoint, processLatePoint)
+	sequencer.Start()
 
-	go handleMqttMessageProcessing(msgChan, errChan, rideManager, appConfig, crashNotifier)
+	go mqttClient.Run(newShadowHandlers(fleet, sequencer, appConfig, alertManager))
 	fmt.Println("MQTT Listener and processor started. Press Ctrl+C to stop server.")
 
 	router := gin.Default()
@@ -194,10 +285,32 @@ func main() {
 	}
 	router.Use(cors.New(corsConfig))
 
-	// Register API Handlers
+	// Register API Handlers. Everything but logging in, creating the first account and shared
+	// rides needs a session or API token; API tokens are further limited to their scopes.
+	authService := auth.NewService(store, appConfig)
+	authService.SetRevokeFunc(wsHub.DisconnectToken)
 	apiGroup := router.Group("/api")
-	api.RegisterRideHandlers(apiGroup, db)
-	api.RegisterLockHandlers(apiGroup, rideManager, mqttPublisher)
+	api.RegisterAuthHandlers(apiGroup, authService)
+	shareSigner := share.NewSigner(appConfig)
+	api.RegisterSharedRideHandlers(apiGroup, store, shareSigner, appConfig)
+
+	authenticated := apiGroup.Group("", auth.Middleware(authService))
+	rideGroup := authenticated.Group("", auth.RequireScope("rides"))
+	api.RegisterRideHandlers(rideGroup, store, appConfig)
+	api.RegisterImportHandlers(rideGroup, store, appConfig)
+	api.RegisterShareHandlers(rideGroup, store, shareSigner, appConfig)
+	api.RegisterLockHandlers(authenticated.Group("", auth.RequireScope("lock")), fleet, mqttClient)
+	api.RegisterDeviceHandlers(authenticated.Group("", auth.RequireScope("devices")), fleet)
+	alertGroup := authenticated.Group("", auth.RequireScope("alerts"))
+	api.RegisterIncidentHandlers(alertGroup, store, fleet, alertManager)
+	api.RegisterAlertHandlers(alertGroup, alertManager)
+	api.RegisterContactHandlers(alertGroup, store, notify.NewDispatcher(notifiers))
+
+	// Server-wide settings are for admins only
+	adminGroup := authenticated.Group("", auth.RequireAdmin())
+	api.RegisterGeofenceHandlers(adminGroup, zones)
+	api.RegisterNotificationHandlers(adminGroup, notifiers)
+	api.RegisterStatusHandlers(adminGroup, positionBuffer, mqttClient, sequencer)
 
 	// Add test-only endpoints if in test mode
 	if appConfig.TestMode {
@@ -210,8 +323,8 @@ func main() {
 					return
 				}
 
-				if shadowDoc.State.Desired.Timestamp == "" {
-					ctx.JSON(http.StatusBadRequest, gin.H{"error": "state.desired.timestamp is required"})
+				if shadowDoc.State.Desired.Timestamp == "" && len(shadowDoc.State.Desired.NMEA) == 0 {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": "state.desired.timestamp or state.desired.nmea is required"})
 					return
 				}
 
@@ -221,7 +334,9 @@ func main() {
 					return
 				}
 
-				err = mqttsubscriber.PublishMockMessage(payload)
+				deviceID := ctx.DefaultQuery("device_id", appConfig.DefaultDeviceID)
+				topic := mqttsubscriber.ShadowTopic(mqttsubscriber.TopicForDevice(appConfig.MQTTShadowTopic, deviceID), mqttsubscriber.ShadowUpdateAccepted)
+				err = mqttsubscriber.PublishMockMessage(topic, payload)
 				if err != nil {
 					ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 					return
@@ -229,6 +344,28 @@ func main() {
 
 				ctx.JSON(http.StatusOK, gin.H{"message": "Location update sent to mock channel"})
 			})
+
+			// Raw NMEA sentences as the request body, one per line, sent on the NMEA topic
+			testGroup.POST("/nmea", func(ctx *gin.Context) {
+				if appConfig.MQTTNMEATopic == "" {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": "mqtt_nmea_topic is not set"})
+					return
+				}
+				payload, err := io.ReadAll(ctx.Request.Body)
+				if err != nil || len(payload) == 0 {
+					ctx.JSON(http.StatusBadRequest, gin.H{"error": "request body must contain NMEA sentences"})
+					return
+				}
+
+				deviceID := ctx.DefaultQuery("device_id", appConfig.DefaultDeviceID)
+				topic := mqttsubscriber.TopicForDevice(appConfig.MQTTNMEATopic, deviceID)
+				if err := mqttsubscriber.PublishMockMessage(topic, payload); err != nil {
+					ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				ctx.JSON(http.StatusOK, gin.H{"message": "NMEA sentences sent to mock channel"})
+			})
 		}
 	}
 
@@ -236,9 +373,8 @@ func main() {
 		ctx.JSON(http.StatusOK, gin.H{"message": "pong"})
 	})
 
-	router.GET("/ws", func(ctx *gin.Context) {
-		ws.ServeWs(wsHub, ctx.Writer, ctx.Request)
-	})
+	// Browsers can't send headers when opening a WebSocket, so /ws also takes a ticket
+	api.RegisterWebSocketHandlers(router.Group("", auth.WebSocket(authService)), wsHub, store)
 
 	go func() {
 		log.Printf("Starting Gin server on %s", appConfig.ServerAddress)
@@ -252,93 +388,260 @@ func main() {
 	<-sigChan
 
 	fmt.Println("Shutting down gracefully...")
-	closeFn()
-	if crashNotifier != nil {
-		crashNotifier.Close()
-	}
-	if mqttPublisher != nil {
-		mqttPublisher.Close()
+	mqttClient.Close()
+	sequencer.Close()
+	alertManager.Close()
+	if err := positionBuffer.Close(); err != nil {
+		log.Printf("Failed to save queued positions: %v", err)
 	}
 	fmt.Println("Server shut down.")
 }
 
-func handleMqttMessageProcessing(msgChan <-chan []byte, errChan <-chan error, rideManager *ride.RideManager, appCfg config.Config, crashNotifier *snsnotifier.Notifier) {
-	go func() {
-		for {
-			select {
-			case message, ok := <-msgChan:
-				if !ok {
-					log.Println("MQTT message channel closed.")
-					return
-				}
-				log.Printf("Received raw MQTT message for processing: %s", string(message))
+// newPointProcessor returns the functions the ingest sequencer hands points to: process
+// for points in order, late for points older than ones already processed. The sequencer
+// never calls them concurrently, so the per-device GPS filters need no locking.
+func newPointProcessor(fleet *ride.Fleet, store database.RideStore, appCfg config.Config) (process func(ingest.Point), late func(ingest.Point) bool) {
+	// One GPS filter per device
+	filters := make(map[string]*gpsfilter.Filter)
+
+	process = func(point ingest.Point) {
+		deviceID, rawPosition := point.DeviceID, point.Position
+		filter, ok := filters[deviceID]
+		if !ok {
+			filter = gpsfilter.NewFilter(appCfg)
+			filters[deviceID] = filter
+		}
+		currentPosition, decision := filter.Process(rawPosition)
+		if appCfg.GPSStoreRawPoints {
+			if err := store.AddRawPosition(deviceID, rawPosition, decision.Accepted, decision.Reason); err != nil {
+				log.Printf("Error storing raw position for %s: %v", deviceID, err)
+			}
+		}
+		if !decision.Accepted {
+			log.Printf("GPS filter rejected point from %s at %v (%f, %f): %s",
+				deviceID, rawPosition.Timestamp, rawPosition.Latitude, rawPosition.Longitude, decision.Reason)
+			return
+		}
+		fleet.Manager(deviceID).HandleGPSData(currentPosition)
+	}
 
-				var shadowDoc ShadowDocument
-				if err := json.Unmarshal(message, &shadowDoc); err != nil {
-					log.Printf("Error unmarshalling shadow document: %v.", err)
-					continue
-				}
+	// Late points skip the GPS filter, whose state has moved past them, and ride state transitions
+	late = func(point ingest.Point) bool {
+		stored := fleet.Manager(point.DeviceID).AddLatePosition(point.Position)
+		if appCfg.GPSStoreRawPoints {
+			reason := "late"
+			if !stored {
+				reason = "late, no ride open at its time"
+			}
+			if err := store.AddRawPosition(point.DeviceID, point.Position, stored, reason); err != nil {
+				log.Printf("Error storing raw position for %s: %v", point.DeviceID, err)
+			}
+		}
+		return stored
+	}
+	return process, late
+}
 
-				// Check for lock status updates
-				if shadowDoc.State.Desired.LockStatus != "" {
-					log.Printf("Lock status update received: %s", shadowDoc.State.Desired.LockStatus)
-					rideManager.SetLockStatus(shadowDoc.State.Desired.LockStatus)
-				}
+// newShadowHandlers returns the handlers for device shadow messages. They all run on the
+// MQTT client's Run goroutine; points go through the sequencer to be put in order.
+func newShadowHandlers(fleet *ride.Fleet, sequencer *ingest.Sequencer, appCfg config.Config, alertManager *alerts.Manager) mqttsubscriber.Handlers {
 
-				// Check for crash detection
-				if shadowDoc.State.Desired.Status == "CRASH_DETECTED" {
-					if crashNotifier != nil {
-						// Constructing the message for SNS
-						crashMessage := fmt.Sprintf(
-							"🚨 CRASH DETECTED 🚨\n\nCrash detected for device at %s.\nLast known location: lat %f, lon %f.\n\nGoogle Maps: https://maps.google.com/?q=%f,%f",
-							time.Now().Format(time.RFC1123),
-							shadowDoc.State.Desired.Latitude,
-							shadowDoc.State.Desired.Longitude,
-							shadowDoc.State.Desired.Latitude,
-							shadowDoc.State.Desired.Longitude,
-						)
-
-						// Use PublishSimple for standard SNS topics (not FIFO)
-						err := crashNotifier.PublishSimple(appCfg.SNSTopicArn, crashMessage)
-						if err != nil {
-							log.Printf("Failed to publish crash notification to SNS: %v", err)
-						} else {
-							log.Println("Successfully published crash notification to SNS.")
-						}
-					} else {
-						log.Println("Crash detected, but SNS notifier is not enabled.")
-					}
-					continue // Don't process this as a regular GPS point for ride tracking
-				}
+	// shadowDevice returns the device a shadow message is for.
+	shadowDevice := func(message mqttsubscriber.Message) string {
+		deviceID, ok := mqttsubscriber.DeviceIDFromTopic(message.Topic)
+		if !ok {
+			log.Printf("Could not determine device from topic %s, using default device %s.", message.Topic, appCfg.DefaultDeviceID)
+			deviceID = appCfg.DefaultDeviceID
+		}
+		return deviceID
+	}
 
-				if shadowDoc.State.Desired.Timestamp == "" || !shadowDoc.State.Desired.ValidFix {
-					log.Printf("No 'desired' state, timestamp is empty, or fix is not valid. Desired: %+v", shadowDoc.State.Desired)
-					continue
-				}
+	// processPosition queues a point for the sequencer. version is the shadow document version, 0 if unknown.
+	processPosition := func(deviceID string, position models.Position, version int) {
+		sequencer.Add(ingest.Point{DeviceID: deviceID, Position: position, Version: int64(version)})
+	}
 
-				docTimestamp := time.Unix(shadowDoc.Timestamp, 0).UTC()
-				eventTime, err := util.CombineDateTime(docTimestamp, shadowDoc.State.Desired.Timestamp)
-				if err != nil {
-					log.Printf("Error combining date and time: %v. Using document timestamp as fallback.", err)
-					eventTime = docTimestamp
-				}
+	// handleNMEA decodes raw NMEA sentences. received dates the fix when no RMC sentence carries the date.
+	handleNMEA := func(deviceID string, sentences []string, received time.Time, version int) {
+		fix, err := nmea.Decode(sentences, received)
+		if err != nil {
+			log.Printf("Error decoding NMEA sentences from %s: %v.", deviceID, err)
+			return
+		}
+		if !fix.Valid {
+			log.Printf("NMEA fix from %s at %v is not valid.", deviceID, fix.Time)
+			return
+		}
+		if !fix.DateFromRMC {
+			log.Printf("NMEA fix from %s has no RMC date, dated %v from its arrival time.", deviceID, fix.Time)
+		}
+		processPosition(deviceID, models.Position{
+			Latitude:   fix.Latitude,
+			Longitude:  fix.Longitude,
+			SpeedKnots: fix.SpeedKnots,
+			Timestamp:  fix.Time,
+		}, version)
+	}
 
-				currentPosition := models.Position{
-					Latitude:   shadowDoc.State.Desired.Latitude,
-					Longitude:  shadowDoc.State.Desired.Longitude,
-					SpeedKnots: shadowDoc.State.Desired.SpeedKnots,
-					Timestamp:  eventTime,
-				}
-				rideManager.HandleGPSData(currentPosition)
+	handleUpdateAccepted := func(message mqttsubscriber.Message) {
+		log.Printf("Received raw MQTT message on %s for processing: %s", message.Topic, string(message.Payload))
 
-			case err, ok := <-errChan:
-				if !ok {
-					log.Println("MQTT error channel closed.")
-					return
-				}
-				log.Printf("Error from MQTT subscriber: %v.", err)
+		deviceID := shadowDevice(message)
+		rideManager := fleet.Manager(deviceID)
+
+		var shadowDoc ShadowDocument
+		if err := json.Unmarshal(message.Payload, &shadowDoc); err != nil {
+			log.Printf("Error unmarshalling shadow document: %v.", err)
+			return
+		}
+
+		// Check for lock status updates
+		if shadowDoc.State.Desired.LockStatus != "" {
+			log.Printf("Lock status update received: %s", shadowDoc.State.Desired.LockStatus)
+			rideManager.SetLockStatus(shadowDoc.State.Desired.LockStatus)
+		}
+		if reported := shadowDoc.State.ReportedLockStatus(); reported != "" {
+			log.Printf("Device %s reported lock status: %s", deviceID, reported)
+			rideManager.ReportLockStatus(reported)
+		}
+
+		// Documents without a timestamp (e.g. from test mode) are dated by their arrival
+		docTimestamp := time.Now().UTC()
+		if shadowDoc.Timestamp != 0 {
+			docTimestamp = time.Unix(shadowDoc.Timestamp, 0).UTC()
+		}
+
+		// Check for crash detection
+		if shadowDoc.State.Desired.Status == "CRASH_DETECTED" {
+			crash, recorded, err := rideManager.RecordCrash(models.Position{
+				Latitude:  shadowDoc.State.Desired.Latitude,
+				Longitude: shadowDoc.State.Desired.Longitude,
+				Timestamp: docTimestamp,
+			})
+			if err != nil {
+				log.Printf("Failed to record crash for %s: %v", deviceID, err)
+			} else if !recorded {
+				log.Printf("Crash report from %s repeats its last crash.", deviceID)
 				return
 			}
+
+			crashMessage := fmt.Sprintf(
+				"Crash detected for device %s at %s.\nLast known location: lat %f, lon %f.\n\nGoogle Maps: https://maps.google.com/?q=%f,%f",
+				deviceID,
+				crash.Timestamp.Format(time.RFC1123),
+				crash.Latitude,
+				crash.Longitude,
+				crash.Latitude,
+				crash.Longitude,
+			)
+			_, err = alertManager.Raise(alerts.Trigger{
+				Type:      models.AlertCrash,
+				DeviceID:  deviceID,
+				Key:       alerts.CrashKey(deviceID),
+				Title:     "🚨 CRASH DETECTED 🚨",
+				Message:   crashMessage,
+				Latitude:  crash.Latitude,
+				Longitude: crash.Longitude,
+			})
+			if err != nil {
+				log.Printf("Failed to raise crash alert for %s: %v", deviceID, err)
+			}
+			return // Don't process this as a regular GPS point for ride tracking
 		}
-	}()
+
+		// The rider pressed "I'm OK" on the device
+		if shadowDoc.State.Desired.Status == "CRASH_CANCELLED" {
+			rideManager.ResetCrash()
+			alert, found, err := alertManager.CancelKey(alerts.CrashKey(deviceID), "device")
+			switch {
+			case err != nil:
+				log.Printf("Failed to cancel crash alert for %s: %v", deviceID, err)
+			case found:
+				log.Printf("Device %s cancelled crash alert %d.", deviceID, alert.ID)
+			default:
+				log.Printf("Device %s cancelled a crash, but it has no pending crash alert.", deviceID)
+			}
+		}
+
+		if len(shadowDoc.State.Desired.NMEA) > 0 {
+			handleNMEA(deviceID, shadowDoc.State.Desired.NMEA, docTimestamp, shadowDoc.Version)
+			return
+		}
+
+		if shadowDoc.State.Desired.Timestamp == "" || !shadowDoc.State.Desired.ValidFix {
+			log.Printf("No 'desired' state, timestamp is empty, or fix is not valid. Desired: %+v", shadowDoc.State.Desired)
+			return
+		}
+
+		eventTime, err := util.CombineDateTime(docTimestamp, shadowDoc.State.Desired.Timestamp)
+		if err != nil {
+			log.Printf("Error combining date and time: %v. Using document timestamp as fallback.", err)
+			eventTime = docTimestamp
+		}
+
+		processPosition(deviceID, models.Position{
+			Latitude:   shadowDoc.State.Desired.Latitude,
+			Longitude:  shadowDoc.State.Desired.Longitude,
+			SpeedKnots: shadowDoc.State.Desired.SpeedKnots,
+			Timestamp:  eventTime,
+		}, shadowDoc.Version)
+	}
+
+	// The full shadow arrives on every connect. Only the lock status is taken from it;
+	// the last position it holds is stale and must not reach ride detection.
+	handleGetAccepted := func(message mqttsubscriber.Message) {
+		deviceID := shadowDevice(message)
+		var shadowDoc ShadowDocument
+		if err := json.Unmarshal(message.Payload, &shadowDoc); err != nil {
+			log.Printf("Error unmarshalling shadow document for %s: %v.", deviceID, err)
+			return
+		}
+		log.Printf("Received shadow for %s (version %d).", deviceID, shadowDoc.Version)
+		if shadowDoc.State.Desired.LockStatus != "" {
+			fleet.Manager(deviceID).SetLockStatus(shadowDoc.State.Desired.LockStatus)
+		}
+		if reported := shadowDoc.State.ReportedLockStatus(); reported != "" {
+			fleet.Manager(deviceID).ReportLockStatus(reported)
+		}
+	}
+
+	handleUpdateDelta := func(message mqttsubscriber.Message) {
+		deviceID := shadowDevice(message)
+		var delta struct {
+			State   map[string]interface{} `json:"state"`
+			Version int                    `json:"version"`
+		}
+		if err := json.Unmarshal(message.Payload, &delta); err != nil {
+			log.Printf("Error unmarshalling shadow delta for %s: %v.", deviceID, err)
+			return
+		}
+		log.Printf("Device %s has not yet applied desired state %v (version %d).", deviceID, delta.State, delta.Version)
+	}
+
+	handleError := func(err error) {
+		var rejectedErr *mqttsubscriber.RejectedError
+		if errors.As(err, &rejectedErr) && rejectedErr.Operation == mqttsubscriber.ShadowGet && rejectedErr.Code == http.StatusNotFound {
+			log.Printf("Device %s has no shadow yet.", rejectedErr.DeviceID)
+			return
+		}
+		// The client reconnects on its own; keep processing messages
+		log.Printf("Error from MQTT client: %v.", err)
+	}
+
+	// Sentences published on the NMEA topic, one per line
+	handleNMEATopic := func(message mqttsubscriber.Message) {
+		deviceID, ok := mqttsubscriber.MatchTopic(appCfg.MQTTNMEATopic, message.Topic)
+		if !ok || deviceID == "" {
+			deviceID = appCfg.DefaultDeviceID
+		}
+		handleNMEA(deviceID, nmea.Split(string(message.Payload)), time.Now(), 0)
+	}
+
+	return mqttsubscriber.Handlers{
+		UpdateAccepted: handleUpdateAccepted,
+		NMEA:           handleNMEATopic,
+		UpdateDelta:    handleUpdateDelta,
+		GetAccepted:    handleGetAccepted,
+		Error:          handleError,
+	}
 }
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 // Position represents a single GPS data point.
 type Position struct {
@@ -10,21 +13,54 @@ type Position struct {
 	Timestamp  time.Time `json:"timestamp"`             // UTC
 }
 
+// RideStats holds the statistics computed for a ride when it ends.
+type RideStats struct {
+	DistanceMeters float64 `json:"distance_meters"`
+	ElapsedSeconds int64   `json:"elapsed_seconds"` // Start to end, including stops
+	MovingSeconds  int64   `json:"moving_seconds"`  // Only segments above the moving speed threshold
+	AvgSpeedKnots  float64 `json:"avg_speed_knots"` // Distance over moving time
+	MaxSpeedKnots  float64 `json:"max_speed_knots"`
+	PointCount     int     `json:"point_count"`
+}
+
 // RideSummary provides a brief overview of a ride.
 type RideSummary struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	StartTime time.Time `json:"start_time"`         // UTC
-	EndTime   time.Time `json:"end_time,omitempty"` // UTC, omitempty if ride is ongoing
+	ID        int64      `json:"id"`
+	DeviceID  string     `json:"device_id"`
+	Source    string     `json:"source"` // "device" for tracked rides, or the imported file format
+	Name      string     `json:"name"`
+	StartTime time.Time  `json:"start_time"`         // UTC
+	EndTime   time.Time  `json:"end_time,omitempty"` // UTC, omitempty if ride is ongoing
+	Stats     *RideStats `json:"stats,omitempty"`    // nil until the ride has ended
+
+	// Geofences the ride started and ended in, empty outside every zone
+	StartZone string `json:"start_zone,omitempty"`
+	EndZone   string `json:"end_zone,omitempty"`
+
+	// The user owning the device when the ride was recorded, 0 if it had none
+	OwnerID int64 `json:"owner_id,omitempty"`
 }
 
 // RideDetail provides a comprehensive view of a ride, including all its positions.
 type RideDetail struct {
 	ID        int64      `json:"id"`
+	DeviceID  string     `json:"device_id"`
+	Source    string     `json:"source"` // "device" for tracked rides, or the imported file format
 	Name      string     `json:"name"`
 	StartTime time.Time  `json:"start_time"`         // UTC
 	EndTime   time.Time  `json:"end_time,omitempty"` // UTC, omitempty if ride is ongoing
+	Stats     *RideStats `json:"stats,omitempty"`    // nil until the ride has ended
 	Positions []Position `json:"positions"`
+
+	// Geofences the ride started and ended in, empty outside every zone
+	StartZone string `json:"start_zone,omitempty"`
+	EndZone   string `json:"end_zone,omitempty"`
+
+	// The user owning the device when the ride was recorded, 0 if it had none
+	OwnerID int64 `json:"owner_id,omitempty"`
+
+	// Set when the positions were simplified, the number of points before simplification
+	OriginalPointCount int `json:"original_point_count,omitempty"`
 }
 
 // WebSocketMessage is a generic structure for messages sent over WebSocket.
@@ -36,6 +72,7 @@ type WebSocketMessage struct {
 
 // WSLocationPayload is for the 'current_location' WebSocket message.
 type WSLocationPayload struct {
+	DeviceID   string    `json:"device_id"`
 	Latitude   float64   `json:"latitude"`
 	Longitude  float64   `json:"longitude"`
 	Timestamp  time.Time `json:"timestamp"` // UTC
@@ -61,3 +98,283 @@ type WSRideEndedPayload struct {
 	RideID  int64     `json:"ride_id"`
 	EndTime time.Time `json:"end_time"` // UTC
 }
+
+// Lock command states.
+const (
+	LockCommandPending   = "pending"   // Published, waiting for the device to report the status
+	LockCommandConfirmed = "confirmed" // The device reported the requested status
+	LockCommandTimedOut  = "timed_out" // The device did not report the status in time
+)
+
+// LockCommand is a lock or unlock request sent to a device, tracked until the
+// device's reported lock status matches it.
+type LockCommand struct {
+	ID          int64     `json:"id"`
+	DeviceID    string    `json:"device_id"`
+	Status      string    `json:"status"` // Requested lock status: "LOCKED" or "UNLOCKED"
+	State       string    `json:"state"`  // One of the LockCommand* states
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`            // Times out if not confirmed by then
+	ConfirmedAt time.Time `json:"confirmed_at,omitzero"` // UTC
+}
+
+// Geofence shapes.
+const (
+	GeofenceCircle  = "circle"
+	GeofencePolygon = "polygon"
+)
+
+// Geofence event types.
+const (
+	GeofenceEnter = "enter"
+	GeofenceExit  = "exit"
+)
+
+// GeoPoint is a coordinate in degrees.
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Geofence is a named zone, a circle or a polygon, that devices are checked against.
+type Geofence struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Type         string     `json:"type"`                    // GeofenceCircle or GeofencePolygon
+	Center       *GeoPoint  `json:"center,omitempty"`        // Circles only
+	RadiusMeters float64    `json:"radius_meters,omitempty"` // Circles only
+	Polygon      []GeoPoint `json:"polygon,omitempty"`       // Polygons only, at least 3 vertices
+	Notify       bool       `json:"notify"`                  // Send a notification when a device enters or exits
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// GeofenceEvent records a device entering or exiting a geofence.
+type GeofenceEvent struct {
+	ID           int64     `json:"id"`
+	GeofenceID   int64     `json:"geofence_id"`
+	GeofenceName string    `json:"geofence_name"`
+	DeviceID     string    `json:"device_id"`
+	RideID       int64     `json:"ride_id,omitempty"` // Ride in progress at the time, if any
+	Type         string    `json:"type"`              // GeofenceEnter or GeofenceExit
+	Latitude     float64   `json:"latitude"`
+	Longitude    float64   `json:"longitude"`
+	Timestamp    time.Time `json:"timestamp"` // UTC, of the point that crossed the boundary
+}
+
+// Theft incident states.
+const (
+	IncidentOpen     = "open"     // Recording the bike's positions
+	IncidentResolved = "resolved" // Closed by the owner
+)
+
+// TheftIncident is opened when a locked bike moves and records where it goes until the
+// owner resolves it.
+type TheftIncident struct {
+	ID             int64     `json:"id"`
+	DeviceID       string    `json:"device_id"`
+	Status         string    `json:"status"`    // IncidentOpen or IncidentResolved
+	OpenedAt       time.Time `json:"opened_at"` // UTC, of the movement that opened it
+	ResolvedAt     time.Time `json:"resolved_at,omitzero"`
+	ResolutionNote string    `json:"resolution_note,omitempty"`
+	PositionCount  int       `json:"position_count"`
+}
+
+// TheftIncidentDetail is a theft incident with every position recorded for it.
+type TheftIncidentDetail struct {
+	TheftIncident
+	Positions []Position `json:"positions"` // Oldest first
+}
+
+// CrashEvent is a crash reported by a device.
+type CrashEvent struct {
+	ID        int64     `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	RideID    int64     `json:"ride_id,omitempty"` // Ride in progress at the time, 0 if none
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"timestamp"` // UTC
+}
+
+// Alert types.
+const (
+	AlertCrash    = "crash"
+	AlertTheft    = "theft"
+	AlertGeofence = "geofence"
+)
+
+// Alert states.
+const (
+	AlertPending      = "pending"      // Waiting for the rider to cancel it before anyone is notified
+	AlertOpen         = "open"         // Notified and escalated until someone acknowledges it
+	AlertAcknowledged = "acknowledged" // Someone is on it, new triggers are recorded but not sent
+	AlertResolved     = "resolved"     // Closed, the next trigger opens a new alert
+	AlertCancelled    = "cancelled"    // Called off by the rider while pending, nobody was notified
+)
+
+// Alert groups every trigger of one incident, such as the repeated CRASH_DETECTED
+// messages of a single crash, so people are notified once rather than per message.
+type Alert struct {
+	ID                int64     `json:"id"`
+	Type              string    `json:"type"` // AlertCrash, AlertTheft or AlertGeofence
+	DeviceID          string    `json:"device_id"`
+	Key               string    `json:"key"` // Triggers with the same key are merged until the alert is resolved
+	Status            string    `json:"status"`
+	Title             string    `json:"title"`
+	Message           string    `json:"message"` // Of the latest trigger
+	Latitude          float64   `json:"latitude"`
+	Longitude         float64   `json:"longitude"`
+	TriggerCount      int       `json:"trigger_count"`
+	NotificationCount int       `json:"notification_count"`
+	EscalationLevel   int       `json:"escalation_level"` // Notifications resent because nobody acknowledged the alert
+	OpenedAt          time.Time `json:"opened_at"`
+	LastTriggeredAt   time.Time `json:"last_triggered_at"`
+	LastNotifiedAt    time.Time `json:"last_notified_at,omitzero"`
+	AcknowledgedAt    time.Time `json:"acknowledged_at,omitzero"`
+	ResolvedAt        time.Time `json:"resolved_at,omitzero"` // Also set when the alert is cancelled
+
+	// Pending alerts are sent when the deadline passes without the rider cancelling them
+	CancelDeadline time.Time `json:"cancel_deadline,omitzero"`
+}
+
+// Steps in the timeline of an alert.
+const (
+	AlertEventOpened        = "opened"
+	AlertEventPrompted      = "prompted"  // The rider was asked whether they are OK
+	AlertEventTriggered     = "triggered" // The alert's source reported it again
+	AlertEventCancelled     = "cancelled"
+	AlertEventExpired       = "expired" // The cancellation window passed
+	AlertEventNotified      = "notified"
+	AlertEventNotifyFailed  = "notify_failed"
+	AlertEventNotifySkipped = "notify_skipped" // No notification channels are configured
+	AlertEventEscalated     = "escalated"
+	AlertEventAcknowledged  = "acknowledged"
+	AlertEventResolved      = "resolved"
+)
+
+// AlertEvent is one step in the timeline of an alert.
+type AlertEvent struct {
+	ID        int64     `json:"id"`
+	AlertID   int64     `json:"alert_id"`
+	Type      string    `json:"type"`
+	Detail    string    `json:"detail,omitempty"` // e.g. who cancelled it, or which channels failed
+	Timestamp time.Time `json:"timestamp"`        // UTC
+}
+
+// AlertDetail is an alert with its timeline and its deliveries to contacts.
+type AlertDetail struct {
+	Alert
+	Timeline   []AlertEvent    `json:"timeline"`   // Oldest first
+	Deliveries []AlertDelivery `json:"deliveries"` // Oldest first
+}
+
+// Contact channels.
+const (
+	ContactEmail = "email"
+	ContactSMS   = "sms"  // Text message through SNS
+	ContactPush  = "push" // ntfy-style HTTP push to a URL
+)
+
+// Contact is a person alerts are delivered to directly, such as a family member.
+type Contact struct {
+	ID         int64     `json:"id"`
+	OwnerID    int64     `json:"owner_id,omitempty"` // The user whose devices' alerts they receive, 0 for contacts from before accounts existed
+	Name       string    `json:"name"`
+	Channel    string    `json:"channel"`     // ContactEmail, ContactSMS or ContactPush
+	Address    string    `json:"address"`     // Email address, phone number in E.164 format or push URL
+	AlertTypes []string  `json:"alert_types"` // The alert types they receive, e.g. ["crash", "theft"]
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Receives reports whether the contact gets alerts of the given type.
+func (c Contact) Receives(alertType string) bool {
+	return slices.Contains(c.AlertTypes, alertType)
+}
+
+// Delivery states.
+const (
+	DeliveryPending = "pending" // Not sent yet, or failed and waiting to be retried
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed" // Gave up after the last attempt
+)
+
+// AlertDelivery is one alert notification to one contact. The contact's details are
+// copied, so the record stays accurate when the contact is changed or deleted.
+type AlertDelivery struct {
+	ID            int64     `json:"id"`
+	AlertID       int64     `json:"alert_id"`
+	ContactID     int64     `json:"contact_id"`
+	ContactName   string    `json:"contact_name"`
+	Channel       string    `json:"channel"`
+	Address       string    `json:"address"`
+	Subject       string    `json:"subject"`
+	Body          string    `json:"-"` // Kept so retries after a restart send the same message
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at,omitzero"`
+	NextAttemptAt time.Time `json:"next_attempt_at,omitzero"` // When a pending delivery is tried next
+	SentAt        time.Time `json:"sent_at,omitzero"`
+}
+
+// User is an account that logs in to the API.
+type User struct {
+	ID           int64     `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	IsAdmin      bool      `json:"is_admin"` // Sees every device and ride and manages users, devices and fleet-wide settings
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Auth token kinds.
+const (
+	TokenSession = "session" // Issued by logging in, may do everything its user may
+	TokenAPI     = "api"     // Created by a user for scripts, limited to its scopes
+)
+
+// AuthToken is a login session or an API token. The token itself is only shown once,
+// when it is created; only its hash is stored.
+type AuthToken struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name,omitempty"`
+	TokenHash string    `json:"-"`
+	Scopes    []string  `json:"scopes,omitempty"` // API tokens only
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"` // Zero for API tokens that don't expire
+}
+
+// RideShare is a public link to one ride. The link is signed, so only the record of what
+// it shows and whether it still works is stored.
+type RideShare struct {
+	ID              int64     `json:"id"`
+	RideID          int64     `json:"ride_id"`
+	UserID          int64     `json:"user_id"`           // Who created the link
+	TrimStartMeters float64   `json:"trim_start_meters"` // Hidden distance at the start of the ride
+	TrimEndMeters   float64   `json:"trim_end_meters"`   // Hidden distance at the end of the ride
+	CreatedAt       time.Time `json:"created_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	RevokedAt       time.Time `json:"revoked_at,omitzero"`
+}
+
+// Active reports whether a share link still works at now.
+func (s RideShare) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
+
+// SharedRide is the read-only version of a ride served through a share link. It leaves out
+// the device, owner and zone names, and the trimmed start and end of the track.
+type SharedRide struct {
+	Name      string     `json:"name"`
+	StartTime time.Time  `json:"start_time,omitzero"` // UTC, of the first position shown
+	EndTime   time.Time  `json:"end_time,omitzero"`   // UTC, of the last position shown
+	Stats     *RideStats `json:"stats"`               // Of the positions shown
+	Positions []Position `json:"positions"`
+	ExpiresAt time.Time  `json:"expires_at"` // When the link stops working
+
+	// Set when the positions were simplified, the number of points before simplification
+	OriginalPointCount int `json:"original_point_count,omitempty"`
+}
@@ -0,0 +1,612 @@
+package mqttsubscriber
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	mockClient *Client
+)
+
+// updateResponseTimeout is how long UpdateDesired waits for the shadow service to
+// accept or reject an update.
+const updateResponseTimeout = 10 * time.Second
+
+// ErrNoResponse is returned by UpdateDesired when the shadow service neither
+// accepted nor rejected the update in time.
+var ErrNoResponse = errors.New("no response from the shadow service")
+
+// ConnectionState describes the client's link to the broker.
+type ConnectionState string
+
+const (
+	StateConnecting   ConnectionState = "connecting"   // First connection not made yet
+	StateConnected    ConnectionState = "connected"    // Connected and subscribed
+	StateReconnecting ConnectionState = "reconnecting" // Connection lost, retrying with backoff
+	StateClosed       ConnectionState = "closed"       // Close was called
+	StateMock         ConnectionState = "mock"         // Test mode, messages come from PublishMockMessage
+)
+
+// Status reports the health of the MQTT connection.
+type Status struct {
+	State             ConnectionState `json:"state"`
+	LastMessageAt     time.Time       `json:"last_message_at,omitzero"`
+	ConnectedAt       time.Time       `json:"connected_at,omitzero"`    // Start of the current (or last) connection
+	DisconnectedAt    time.Time       `json:"disconnected_at,omitzero"` // Last time the connection was lost
+	Reconnects        int             `json:"reconnects"`               // Successful reconnects since start
+	ReconnectAttempts int             `json:"reconnect_attempts"`       // Attempts since the connection was last lost
+	RejectedUpdates   int             `json:"rejected_updates"`         // Shadow updates and gets rejected since start
+	LastError         string          `json:"last_error,omitempty"`
+}
+
+// RejectedError is a shadow request rejected by the shadow service, as received
+// on an update/rejected or get/rejected topic.
+type RejectedError struct {
+	DeviceID    string `json:"-"`
+	Operation   string `json:"-"` // ShadowUpdate or ShadowGet
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	ClientToken string `json:"clientToken,omitempty"`
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("shadow %s for %s rejected with code %d: %s", e.Operation, e.DeviceID, e.Code, e.Message)
+}
+
+// Handlers receive the messages of each shadow topic. They are called one at a
+// time, in arrival order, from the goroutine running Client.Run. Nil handlers are skipped.
+type Handlers struct {
+	UpdateAccepted func(Message) // Desired or reported state changed
+	UpdateDelta    func(Message) // Desired state the device has not reported yet
+	GetAccepted    func(Message) // Full shadow document, requested on every connect
+	NMEA           func(Message) // Raw NMEA sentences, if an NMEA topic is set
+	Error          func(error)   // Connection problems and rejected requests (*RejectedError)
+}
+
+// Client owns the single MQTT connection used for device shadows. It subscribes to
+// the update/accepted, update/rejected, update/delta, get/accepted and get/rejected
+// topics, requests the full shadow of every known device whenever it connects, and
+// publishes desired state updates.
+//
+// The connection is re-established with exponential backoff whenever it is lost and
+// the topics are subscribed again, so Run keeps delivering to the same handlers until Close.
+type Client struct {
+	client       mqtt.Client // nil for the mock client
+	shadowTopic  string      // e.g. "$aws/things/+/shadow", "+" follows every device
+	nmeaTopic    string      // Optional topic of raw NMEA sentences, e.g. "b3/+/nmea"
+	knownDevices func() []string
+	messages     chan Message
+	errors       chan error
+	done         chan struct{}
+
+	mu      sync.Mutex
+	status  Status
+	pending map[string]chan error // Outstanding updates by client token
+	version int                   // Shadow version of the mock client
+
+	closeMu   sync.RWMutex // Held for reading while delivering, so Close never closes channels under a sender
+	closed    bool
+	closeOnce sync.Once
+}
+
+// NewTLSConfig sets up the TLS configuration for MQTT.
+// It tries to load certs from PEM strings first, then falls back to file paths.
+func NewTLSConfig(caPEM, certPEM, keyPEM, caPath, certPath, keyPath string) (*tls.Config, error) {
+	certpool := x509.NewCertPool()
+
+	if caPEM != "" {
+		if !certpool.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, fmt.Errorf("failed to append CA certificate from PEM string")
+		}
+	} else if caPath != "" {
+		pemCerts, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate from path %s: %w", caPath, err)
+		}
+		if !certpool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("failed to append CA certificate from file %s", caPath)
+		}
+	} else {
+		log.Println("Warning: No CA certificate PEM string or file path provided. System CAs will be used if available, or connection may be insecure.")
+	}
+
+	var clientCert tls.Certificate
+	var err error
+
+	if certPEM != "" && keyPEM != "" {
+		clientCert, err = tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair from PEM strings: %w", err)
+		}
+	} else if certPath != "" && keyPath != "" {
+		clientCert, err = tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair from paths %s, %s: %w", certPath, keyPath, err)
+		}
+	} else {
+		log.Println("Warning: No client certificate PEMs or file paths provided. Proceeding without client certificate.")
+		return &tls.Config{
+			RootCAs:    certpool,
+			ClientAuth: tls.NoClientCert,
+			ClientCAs:  nil,
+		}, nil
+	}
+
+	return &tls.Config{
+		RootCAs:      certpool,
+		ClientAuth:   tls.NoClientCert,
+		ClientCAs:    nil,
+		Certificates: []tls.Certificate{clientCert},
+	}, nil
+}
+
+// Connect connects to the MQTT broker and subscribes to the shadow topics under shadowTopic,
+// which may contain a "+" wildcard in place of the thing name to follow several devices.
+// If nmeaTopic is not empty, raw NMEA sentences published there are passed to Handlers.NMEA.
+// knownDevices lists the devices whose shadow is requested on every connect when
+// shadowTopic is a wildcard; it may be nil.
+//
+// Only the first connection has to succeed. After that, a lost connection is retried with
+// backoff doubling from one second up to maxReconnectInterval, and is reported to
+// Handlers.Error without interrupting Run.
+func Connect(brokerURL, clientID, shadowTopic, nmeaTopic string, maxReconnectInterval time.Duration, knownDevices func() []string, cfgMqttCertPEM, cfgMqttKeyPEM, cfgMqttRootCAPEM, cfgMqttCertPath, cfgMqttKeyPath, cfgMqttRootCAPath string) (*Client, error) {
+	tlsConfig, err := NewTLSConfig(cfgMqttRootCAPEM, cfgMqttCertPEM, cfgMqttKeyPEM, cfgMqttRootCAPath, cfgMqttCertPath, cfgMqttKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS config: %w", err)
+	}
+	if maxReconnectInterval <= 0 {
+		maxReconnectInterval = time.Minute
+	}
+
+	c := newClient(shadowTopic, nmeaTopic, StateConnecting)
+	c.knownDevices = knownDevices
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(brokerURL)
+	// add random suffix to clientID
+	clientID = clientID + "-" + rand.Text()
+	log.Printf("Client ID: %s", clientID)
+	opts.SetClientID(clientID)
+	opts.SetTLSConfig(tlsConfig)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(maxReconnectInterval)
+	// Clean sessions drop subscriptions on disconnect, so OnConnect subscribes every time
+	opts.SetCleanSession(true)
+
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Printf("MQTT connection lost, reconnecting: %v", err)
+		c.mu.Lock()
+		c.status.State = StateReconnecting
+		c.status.DisconnectedAt = time.Now().UTC()
+		c.status.ReconnectAttempts = 0
+		c.status.LastError = err.Error()
+		c.mu.Unlock()
+		c.reportError(fmt.Errorf("connection lost: %w", err))
+	})
+
+	opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+		c.mu.Lock()
+		c.status.ReconnectAttempts++
+		attempt := c.status.ReconnectAttempts
+		c.mu.Unlock()
+		log.Printf("Reconnecting to MQTT broker (attempt %d)...", attempt)
+	})
+
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		c.mu.Lock()
+		reconnected := !c.status.ConnectedAt.IsZero()
+		c.mu.Unlock()
+		if reconnected {
+			log.Println("Reconnected to MQTT broker.")
+		} else {
+			log.Println("Connected to MQTT broker.")
+		}
+		if !c.subscribe(client, maxReconnectInterval) {
+			return
+		}
+
+		c.mu.Lock()
+		if reconnected {
+			c.status.Reconnects++
+		}
+		c.status.State = StateConnected
+		c.status.ConnectedAt = time.Now().UTC()
+		c.status.ReconnectAttempts = 0
+		c.mu.Unlock()
+
+		// Updates may have been missed while disconnected; the full shadow brings state up to date
+		c.requestShadows()
+	})
+
+	client := mqtt.NewClient(opts)
+	c.client = client
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	return c, nil
+}
+
+// NewMockClient creates a mock client for testing purposes. Messages are fed to it
+// with PublishMockMessage, and updates it publishes are echoed back as accepted.
+func NewMockClient(shadowTopic, nmeaTopic string) *Client {
+	// Initialize the mock client if it hasn't been already.
+	if mockClient == nil {
+		mockClient = newClient(shadowTopic, nmeaTopic, StateMock)
+	}
+	return mockClient
+}
+
+// PublishMockMessage sends a message to the mock client as if it arrived on topic.
+// This is to be called by test harnesses or manual-testing endpoints.
+func PublishMockMessage(topic string, payload []byte) error {
+	c := mockClient
+	if c == nil {
+		return fmt.Errorf("mock MQTT channel is not initialized")
+	}
+	if err := c.deliverMock(Message{Topic: topic, Payload: payload}); err != nil {
+		return err
+	}
+	log.Printf("Published mock message to channel on topic %s: %s", topic, string(payload))
+	return nil
+}
+
+func newClient(shadowTopic, nmeaTopic string, state ConnectionState) *Client {
+	c := &Client{
+		shadowTopic: shadowTopic,
+		nmeaTopic:   nmeaTopic,
+		errors:      make(chan error, 1), // Buffered, older errors are dropped if nobody reads them
+		done:        make(chan struct{}),
+		status:      Status{State: state},
+		pending:     make(map[string]chan error),
+	}
+	if state == StateMock {
+		c.messages = make(chan Message, 10) // Buffered channel
+	} else {
+		c.messages = make(chan Message)
+	}
+	return c
+}
+
+// Run passes every message and error to handlers until Close is called.
+func (c *Client) Run(handlers Handlers) {
+	for {
+		select {
+		case message, ok := <-c.messages:
+			if !ok {
+				log.Println("MQTT message channel closed.")
+				return
+			}
+			c.dispatch(message, handlers)
+
+		case err, ok := <-c.errors:
+			if !ok {
+				log.Println("MQTT error channel closed.")
+				return
+			}
+			if handlers.Error != nil {
+				handlers.Error(err)
+			}
+		}
+	}
+}
+
+func (c *Client) dispatch(message Message, handlers Handlers) {
+	if c.nmeaTopic != "" {
+		if _, ok := MatchTopic(c.nmeaTopic, message.Topic); ok {
+			if handlers.NMEA != nil {
+				handlers.NMEA(message)
+			}
+			return
+		}
+	}
+
+	operation, _ := ShadowOperation(message.Topic)
+	var handler func(Message)
+	switch operation {
+	case ShadowUpdateAccepted:
+		handler = handlers.UpdateAccepted
+	case ShadowUpdateDelta:
+		handler = handlers.UpdateDelta
+	case ShadowGetAccepted:
+		handler = handlers.GetAccepted
+	case ShadowUpdateRejected, ShadowGetRejected:
+		if handlers.Error != nil {
+			handlers.Error(c.rejected(message, operation))
+		}
+		return
+	default:
+		log.Printf("Ignoring MQTT message on unexpected topic %s", message.Topic)
+		return
+	}
+	if handler != nil {
+		handler(message)
+	}
+}
+
+// rejected parses the error document of a rejected shadow request.
+func (c *Client) rejected(message Message, operation string) *RejectedError {
+	rejectedErr := &RejectedError{Operation: strings.TrimSuffix(operation, "/rejected")}
+	if err := json.Unmarshal(message.Payload, rejectedErr); err != nil {
+		rejectedErr.Message = fmt.Sprintf("unreadable error document %q", string(message.Payload))
+	}
+	rejectedErr.DeviceID, _ = DeviceIDFromTopic(message.Topic)
+	return rejectedErr
+}
+
+// UpdateDesired publishes desired state for a device and waits until the shadow service
+// accepts it. A rejected update is returned as a *RejectedError.
+func (c *Client) UpdateDesired(deviceID string, desired map[string]interface{}) error {
+	token := rand.Text()
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"state":       map[string]interface{}{"desired": desired},
+		"clientToken": token,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal shadow update payload: %w", err)
+	}
+
+	if c.client == nil {
+		return c.acceptMockUpdate(deviceID, desired, token)
+	}
+
+	response := make(chan error, 1)
+	c.mu.Lock()
+	c.pending[token] = response
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, token)
+		c.mu.Unlock()
+	}()
+
+	topic := ShadowTopic(TopicForDevice(c.shadowTopic, deviceID), ShadowUpdate)
+	if t := c.client.Publish(topic, 0, false, payloadBytes); t.Wait() && t.Error() != nil {
+		return fmt.Errorf("failed to publish shadow update: %w", t.Error())
+	}
+
+	select {
+	case err := <-response:
+		return err
+	case <-time.After(updateResponseTimeout):
+		return fmt.Errorf("shadow update for %s: %w", deviceID, ErrNoResponse)
+	case <-c.done:
+		return fmt.Errorf("shadow update for %s: client closed", deviceID)
+	}
+}
+
+// UpdateLockStatus publishes a lock status update to the shadow of the given device
+func (c *Client) UpdateLockStatus(deviceID, lockStatus string) error {
+	if err := c.UpdateDesired(deviceID, map[string]interface{}{"lock_status": lockStatus}); err != nil {
+		return fmt.Errorf("failed to update lock status: %w", err)
+	}
+	log.Printf("Successfully published lock status update for %s: %s", deviceID, lockStatus)
+	return nil
+}
+
+// RequestShadow asks the shadow service for the full shadow of a device. It arrives
+// on get/accepted, or get/rejected if the device has no shadow yet.
+func (c *Client) RequestShadow(deviceID string) error {
+	if c.client == nil {
+		return nil
+	}
+	topic := ShadowTopic(TopicForDevice(c.shadowTopic, deviceID), ShadowGet)
+	if t := c.client.Publish(topic, 0, false, []byte("{}")); t.Wait() && t.Error() != nil {
+		return fmt.Errorf("failed to request shadow for %s: %w", deviceID, t.Error())
+	}
+	return nil
+}
+
+// Status returns the current connection state and counters.
+func (c *Client) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Close unsubscribes, disconnects and stops Run.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		if c.client == nil {
+			log.Println("Closing mock MQTT client.")
+		} else {
+			log.Println("Disconnecting MQTT client...")
+		}
+		close(c.done) // Unblocks handlers waiting to deliver
+
+		if c.client != nil {
+			c.client.Unsubscribe(c.topics()...)
+			c.client.Disconnect(250)
+			log.Println("MQTT client disconnected.")
+		}
+
+		c.closeMu.Lock()
+		c.closed = true
+		close(c.messages)
+		close(c.errors)
+		c.closeMu.Unlock()
+
+		c.mu.Lock()
+		if c.status.State != StateMock {
+			c.status.State = StateClosed
+		}
+		c.mu.Unlock()
+	})
+}
+
+func (c *Client) topics() []string {
+	topics := []string{
+		ShadowTopic(c.shadowTopic, ShadowUpdateAccepted),
+		ShadowTopic(c.shadowTopic, ShadowUpdateRejected),
+		ShadowTopic(c.shadowTopic, ShadowUpdateDelta),
+		ShadowTopic(c.shadowTopic, ShadowGetAccepted),
+		ShadowTopic(c.shadowTopic, ShadowGetRejected),
+	}
+	if c.nmeaTopic != "" {
+		topics = append(topics, c.nmeaTopic)
+	}
+	return topics
+}
+
+// subscribe subscribes to the shadow topics, retrying with backoff while the connection is up.
+// It reports whether the subscription was made.
+func (c *Client) subscribe(client mqtt.Client, maxBackoff time.Duration) bool {
+	filters := make(map[string]byte)
+	for _, topic := range c.topics() {
+		filters[topic] = 0
+	}
+
+	backoff := time.Second
+	for {
+		token := client.SubscribeMultiple(filters, c.handleMessage)
+		if token.Wait() && token.Error() == nil {
+			log.Printf("Successfully subscribed to topics: %s", strings.Join(c.topics(), ", "))
+			return true
+		}
+		log.Printf("Failed to subscribe to topics, retrying in %v: %v", backoff, token.Error())
+		c.mu.Lock()
+		c.status.LastError = token.Error().Error()
+		c.mu.Unlock()
+		c.reportError(fmt.Errorf("failed to subscribe: %w", token.Error()))
+
+		select {
+		case <-time.After(backoff):
+		case <-c.done:
+			return false
+		}
+		if !client.IsConnectionOpen() {
+			return false // OnConnect runs again after the reconnect
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// requestShadows requests the full shadow of the configured thing, or of every known
+// device when following several.
+func (c *Client) requestShadows() {
+	var deviceIDs []string
+	if deviceID, ok := DeviceIDFromTopic(c.shadowTopic); ok {
+		deviceIDs = []string{deviceID}
+	} else if c.knownDevices != nil {
+		deviceIDs = c.knownDevices()
+	}
+	for _, deviceID := range deviceIDs {
+		if err := c.RequestShadow(deviceID); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+func (c *Client) handleMessage(client mqtt.Client, msg mqtt.Message) {
+	log.Printf("Received message on topic %s", msg.Topic())
+	// Send a copy of the payload to avoid issues if the underlying buffer is reused
+	payloadCopy := make([]byte, len(msg.Payload()))
+	copy(payloadCopy, msg.Payload())
+	message := Message{Topic: msg.Topic(), Payload: payloadCopy}
+	c.markMessage(message)
+
+	c.closeMu.RLock()
+	defer c.closeMu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.messages <- message:
+	case <-c.done:
+	}
+}
+
+// markMessage records the arrival of a message and answers the UpdateDesired call
+// waiting for it, if any.
+func (c *Client) markMessage(message Message) {
+	operation, _ := ShadowOperation(message.Topic)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.LastMessageAt = time.Now().UTC()
+	if operation == ShadowUpdateRejected || operation == ShadowGetRejected {
+		c.status.RejectedUpdates++
+	}
+	if operation != ShadowUpdateAccepted && operation != ShadowUpdateRejected {
+		return
+	}
+
+	var tokenDoc struct {
+		ClientToken string `json:"clientToken"`
+	}
+	if json.Unmarshal(message.Payload, &tokenDoc) != nil || tokenDoc.ClientToken == "" {
+		return
+	}
+	response, ok := c.pending[tokenDoc.ClientToken]
+	if !ok {
+		return
+	}
+	var err error
+	if operation == ShadowUpdateRejected {
+		err = c.rejected(message, operation)
+	}
+	response <- err // Buffered, and each token is answered once
+	delete(c.pending, tokenDoc.ClientToken)
+}
+
+// acceptMockUpdate plays the shadow service for the mock client by echoing an
+// update back on update/accepted.
+func (c *Client) acceptMockUpdate(deviceID string, desired map[string]interface{}, token string) error {
+	c.mu.Lock()
+	c.version++
+	version := c.version
+	c.mu.Unlock()
+
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"state":       map[string]interface{}{"desired": desired},
+		"version":     version,
+		"timestamp":   time.Now().Unix(),
+		"clientToken": token,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal shadow update payload: %w", err)
+	}
+	topic := ShadowTopic(TopicForDevice(c.shadowTopic, deviceID), ShadowUpdateAccepted)
+	return c.deliverMock(Message{Topic: topic, Payload: payloadBytes})
+}
+
+func (c *Client) deliverMock(message Message) error {
+	c.closeMu.RLock()
+	defer c.closeMu.RUnlock()
+	if c.closed {
+		return fmt.Errorf("mock MQTT client is closed")
+	}
+	select {
+	case c.messages <- message:
+		c.markMessage(message)
+		return nil
+	default:
+		return fmt.Errorf("mock MQTT channel is full")
+	}
+}
+
+// reportError passes err to Run without ever blocking the MQTT client.
+func (c *Client) reportError(err error) {
+	c.closeMu.RLock()
+	defer c.closeMu.RUnlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.errors <- err:
+	default:
+		log.Printf("MQTT error channel full, dropping: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package mqttsubscriber
+
+import "strings"
+
+// Message is a payload received from the broker together with the topic it arrived on.
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// DeviceIDFromTopic extracts the thing name from an AWS IoT shadow topic
+// such as "$aws/things/<thing>/shadow/update/accepted".
+func DeviceIDFromTopic(topic string) (string, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || parts[0] != "$aws" || parts[1] != "things" || parts[3] != "shadow" {
+		return "", false
+	}
+	if parts[2] == "" || parts[2] == "+" {
+		return "", false
+	}
+	return parts[2], true
+}
+
+// TopicForDevice fills the "+" wildcard in a shadow topic with the given device ID.
+// Topics that name a single thing are returned unchanged.
+func TopicForDevice(topic, deviceID string) string {
+	parts := strings.Split(topic, "/")
+	for i, part := range parts {
+		if part == "+" {
+			parts[i] = deviceID
+			break
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Shadow operations, appended to a shadow topic such as "$aws/things/<thing>/shadow".
+const (
+	ShadowUpdate         = "update"
+	ShadowUpdateAccepted = "update/accepted"
+	ShadowUpdateRejected = "update/rejected"
+	ShadowUpdateDelta    = "update/delta"
+	ShadowGet            = "get"
+	ShadowGetAccepted    = "get/accepted"
+	ShadowGetRejected    = "get/rejected"
+)
+
+// ShadowTopic appends an operation such as ShadowUpdateAccepted to a shadow topic.
+func ShadowTopic(shadowTopic, operation string) string {
+	return strings.TrimSuffix(shadowTopic, "/") + "/" + operation
+}
+
+// ShadowOperation returns the part of a shadow topic after "shadow/",
+// e.g. "update/accepted" for "$aws/things/<thing>/shadow/update/accepted".
+func ShadowOperation(topic string) (string, bool) {
+	parts := strings.SplitN(topic, "/", 5)
+	if len(parts) < 5 || parts[0] != "$aws" || parts[1] != "things" || parts[3] != "shadow" {
+		return "", false
+	}
+	return parts[4], true
+}
+
+// MatchTopic reports whether topic matches an MQTT topic filter, which may use "+"
+// for a single level and a trailing "#" for any number of levels. The level matched
+// by the first "+" is returned as the device ID, if there is one.
+func MatchTopic(filter, topic string) (deviceID string, ok bool) {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return deviceID, true
+		}
+		if i >= len(topicParts) {
+			return "", false
+		}
+		switch part {
+		case "+":
+			if deviceID == "" {
+				deviceID = topicParts[i]
+			}
+		case topicParts[i]:
+		default:
+			return "", false
+		}
+	}
+	return deviceID, len(filterParts) == len(topicParts)
+}
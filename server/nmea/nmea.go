@@ -0,0 +1,301 @@
+// Package nmea decodes the NMEA 0183 sentences GPS receivers emit ($GPRMC, $GPGGA
+// and $GPVTG, from any talker) into a single timestamped fix.
+package nmea
+
+import (
+	"b3/server/util"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentences is one or more raw NMEA sentences. In JSON it may be a single string with
+// one sentence per line or an array of strings.
+type Sentences []string
+
+// UnmarshalJSON accepts either a string or an array of strings.
+func (s *Sentences) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*s = Split(text)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return fmt.Errorf("nmea must be a string or an array of strings: %w", err)
+	}
+	*s = nil
+	for _, line := range lines {
+		*s = append(*s, Split(line)...)
+	}
+	return nil
+}
+
+// Split breaks text into sentences, one per non-empty line.
+func Split(text string) []string {
+	var sentences []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		if line = strings.TrimSpace(line); line != "" {
+			sentences = append(sentences, line)
+		}
+	}
+	return sentences
+}
+
+// Fix is the position decoded from the sentences of one GPS epoch.
+type Fix struct {
+	Time          time.Time // UTC
+	Latitude      float64
+	Longitude     float64
+	SpeedKnots    float64
+	CourseDegrees float64
+	Valid         bool // RMC status A, or GGA fix quality above 0
+	DateFromRMC   bool // False if the date was reconstructed from the reference time
+}
+
+// sentence is the part of a parsed RMC, GGA or VTG sentence a Fix is built from.
+type sentence struct {
+	kind        string // "RMC", "GGA" or "VTG"
+	timeOfDay   string // HHMMSS.SS, empty for VTG
+	date        string // DDMMYY, RMC only
+	hasPosition bool
+	latitude    float64
+	longitude   float64
+	hasSpeed    bool
+	speedKnots  float64
+	hasCourse   bool
+	course      float64
+	valid       bool
+}
+
+// Decode builds a fix from the sentences of one report. Sentences that are not RMC, GGA
+// or VTG are ignored. When the report holds several epochs, the last one is used.
+//
+// The date comes from the RMC sentence. When only the time of day is known, the date is
+// the one that puts the fix closest to reference, usually when the report was delivered,
+// so a fix recorded just before midnight UTC and delivered just after keeps its day.
+func Decode(sentences []string, reference time.Time) (Fix, error) {
+	var parsed []sentence
+	for _, raw := range sentences {
+		s, ok, err := parse(raw)
+		if err != nil {
+			return Fix{}, err
+		}
+		if ok {
+			parsed = append(parsed, s)
+		}
+	}
+
+	// The epoch is the time of day of the last timed sentence
+	epoch := ""
+	for _, s := range parsed {
+		if s.timeOfDay != "" {
+			epoch = s.timeOfDay
+		}
+	}
+	if epoch == "" {
+		return Fix{}, fmt.Errorf("no RMC or GGA sentence with a time")
+	}
+	timeOfDay, err := util.ParseTimeOfDay(epoch)
+	if err != nil {
+		return Fix{}, err
+	}
+
+	var fix Fix
+	var hasPosition, hasRMC bool
+	date := ""
+	for _, s := range parsed {
+		// VTG has no time and belongs to whichever epoch it arrived with
+		if s.timeOfDay != "" && s.timeOfDay != epoch {
+			continue
+		}
+		if s.date != "" {
+			date = s.date
+		}
+		// RMC carries the fix status the receiver reports; it wins over GGA
+		if s.kind == "RMC" {
+			fix.Valid = s.valid
+			hasRMC = true
+		} else if s.kind == "GGA" && !hasRMC {
+			fix.Valid = s.valid
+		}
+		if s.hasPosition && (!hasPosition || s.kind == "RMC") {
+			fix.Latitude, fix.Longitude = s.latitude, s.longitude
+			hasPosition = true
+		}
+		if s.hasSpeed {
+			fix.SpeedKnots = s.speedKnots
+		}
+		if s.hasCourse {
+			fix.CourseDegrees = s.course
+		}
+	}
+	if !hasPosition {
+		return Fix{}, fmt.Errorf("no RMC or GGA sentence with a position")
+	}
+
+	if date != "" {
+		day, err := time.Parse("020106", date)
+		if err != nil {
+			return Fix{}, fmt.Errorf("invalid RMC date %q: %w", date, err)
+		}
+		fix.Time = day.Add(timeOfDay)
+		fix.DateFromRMC = true
+	} else {
+		fix.Time = util.NearestTimeOfDay(reference, timeOfDay)
+	}
+	return fix, nil
+}
+
+// parse decodes one sentence. ok is false for sentence types Decode does not use.
+func parse(raw string) (s sentence, ok bool, err error) {
+	body, err := checkFraming(raw)
+	if err != nil {
+		return sentence{}, false, err
+	}
+	fields := strings.Split(body, ",")
+	if len(fields[0]) < 3 {
+		return sentence{}, false, fmt.Errorf("invalid NMEA address in %q", raw)
+	}
+	// The first two letters name the talker (GP, GN, GL...), the rest the sentence type
+	s.kind = fields[0][len(fields[0])-3:]
+
+	switch s.kind {
+	case "RMC":
+		// $GPRMC,time,status,lat,N/S,lon,E/W,speed knots,course,date,...
+		if len(fields) < 10 {
+			return sentence{}, false, fmt.Errorf("RMC sentence has %d fields, expected at least 10", len(fields))
+		}
+		s.timeOfDay, s.date = fields[1], fields[9]
+		s.valid = fields[2] == "A"
+		if err := s.setPosition(fields[3], fields[4], fields[5], fields[6]); err != nil {
+			return sentence{}, false, err
+		}
+		if s.hasSpeed, s.speedKnots, err = optionalFloat(fields[7]); err != nil {
+			return sentence{}, false, fmt.Errorf("invalid RMC speed: %w", err)
+		}
+		if s.hasCourse, s.course, err = optionalFloat(fields[8]); err != nil {
+			return sentence{}, false, fmt.Errorf("invalid RMC course: %w", err)
+		}
+	case "GGA":
+		// $GPGGA,time,lat,N/S,lon,E/W,quality,satellites,hdop,altitude,...
+		if len(fields) < 7 {
+			return sentence{}, false, fmt.Errorf("GGA sentence has %d fields, expected at least 7", len(fields))
+		}
+		s.timeOfDay = fields[1]
+		quality, _ := strconv.Atoi(fields[6])
+		s.valid = quality > 0
+		if err := s.setPosition(fields[2], fields[3], fields[4], fields[5]); err != nil {
+			return sentence{}, false, err
+		}
+	case "VTG":
+		// $GPVTG,course true,T,course magnetic,M,speed knots,N,speed km/h,K,...
+		if len(fields) < 8 {
+			return sentence{}, false, fmt.Errorf("VTG sentence has %d fields, expected at least 8", len(fields))
+		}
+		if s.hasCourse, s.course, err = optionalFloat(fields[1]); err != nil {
+			return sentence{}, false, fmt.Errorf("invalid VTG course: %w", err)
+		}
+		if s.hasSpeed, s.speedKnots, err = optionalFloat(fields[5]); err != nil {
+			return sentence{}, false, fmt.Errorf("invalid VTG speed: %w", err)
+		}
+	default:
+		return sentence{}, false, nil
+	}
+
+	if s.timeOfDay != "" {
+		if _, err := util.ParseTimeOfDay(s.timeOfDay); err != nil {
+			return sentence{}, false, fmt.Errorf("invalid %s time: %w", s.kind, err)
+		}
+	}
+	return s, true, nil
+}
+
+// checkFraming strips the leading "$" and, if present, verifies and strips the "*hh" checksum.
+func checkFraming(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, "$") {
+		return "", fmt.Errorf("NMEA sentence must start with $: %q", raw)
+	}
+	body, checksum, hasChecksum := strings.Cut(raw[1:], "*")
+	if !hasChecksum {
+		return body, nil
+	}
+
+	want, err := strconv.ParseUint(checksum, 16, 8)
+	if err != nil || len(checksum) != 2 {
+		return "", fmt.Errorf("invalid NMEA checksum %q", checksum)
+	}
+	var got byte
+	for i := 0; i < len(body); i++ {
+		got ^= body[i]
+	}
+	if got != byte(want) {
+		return "", fmt.Errorf("NMEA checksum mismatch in %q: computed %02X", raw, got)
+	}
+	return body, nil
+}
+
+// setPosition parses latitude (ddmm.mmmm) and longitude (dddmm.mmmm) with their hemispheres.
+// Empty fields, sent while the receiver has no fix, leave the position unset.
+func (s *sentence) setPosition(lat, latHemisphere, lon, lonHemisphere string) error {
+	if lat == "" || lon == "" {
+		return nil
+	}
+	latitude, err := parseCoordinate(lat, 2)
+	if err != nil {
+		return fmt.Errorf("invalid latitude %q: %w", lat, err)
+	}
+	longitude, err := parseCoordinate(lon, 3)
+	if err != nil {
+		return fmt.Errorf("invalid longitude %q: %w", lon, err)
+	}
+	switch latHemisphere {
+	case "N":
+	case "S":
+		latitude = -latitude
+	default:
+		return fmt.Errorf("invalid latitude hemisphere %q", latHemisphere)
+	}
+	switch lonHemisphere {
+	case "E":
+	case "W":
+		longitude = -longitude
+	default:
+		return fmt.Errorf("invalid longitude hemisphere %q", lonHemisphere)
+	}
+	s.latitude, s.longitude, s.hasPosition = latitude, longitude, true
+	return nil
+}
+
+// parseCoordinate converts degrees and decimal minutes, with degreeDigits digits of degrees, to decimal degrees.
+func parseCoordinate(value string, degreeDigits int) (float64, error) {
+	if len(value) < degreeDigits+2 {
+		return 0, fmt.Errorf("too short")
+	}
+	degrees, err := strconv.Atoi(value[:degreeDigits])
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.ParseFloat(value[degreeDigits:], 64)
+	if err != nil {
+		return 0, err
+	}
+	if minutes >= 60 {
+		return 0, fmt.Errorf("minutes out of range")
+	}
+	return float64(degrees) + minutes/60, nil
+}
+
+func optionalFloat(value string) (bool, float64, error) {
+	if value == "" {
+		return false, 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return true, f, nil
+}
@@ -0,0 +1,241 @@
+package nmea
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withChecksum frames a sentence body as "$body*hh" with its correct checksum.
+func withChecksum(body string) string {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X", body, sum)
+}
+
+func TestDecode(t *testing.T) {
+	reference := time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		sentences []string
+		reference time.Time // Defaults to reference
+		want      Fix
+		wantErr   string
+	}{
+		{
+			name:      "RMC north east",
+			sentences: []string{withChecksum("GPRMC,123519.50,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W")},
+			want: Fix{
+				Time:     time.Date(1994, 3, 23, 12, 35, 19, 500e6, time.UTC),
+				Latitude: 48 + 7.038/60, Longitude: 11 + 31.0/60,
+				SpeedKnots: 22.4, CourseDegrees: 84.4, Valid: true, DateFromRMC: true,
+			},
+		},
+		{
+			name:      "RMC south west",
+			sentences: []string{withChecksum("GNRMC,083000,A,3351.600,S,15112.300,W,0.5,,010125,,")},
+			want: Fix{
+				Time:     time.Date(2025, 1, 1, 8, 30, 0, 0, time.UTC),
+				Latitude: -(33 + 51.6/60), Longitude: -(151 + 12.3/60),
+				SpeedKnots: 0.5, Valid: true, DateFromRMC: true,
+			},
+		},
+		{
+			name:      "without a checksum",
+			sentences: []string{"$GPRMC,120000,A,5200.000,N,00400.000,E,,,280525,,"},
+			want: Fix{
+				Time:     time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC),
+				Latitude: 52, Longitude: 4, Valid: true, DateFromRMC: true,
+			},
+		},
+		{
+			name:      "void RMC with a position",
+			sentences: []string{withChecksum("GPRMC,120000,V,5200.000,N,00400.000,E,,,280525,,")},
+			want: Fix{
+				Time:     time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC),
+				Latitude: 52, Longitude: 4, DateFromRMC: true,
+			},
+		},
+		{
+			name:      "void RMC with empty fields",
+			sentences: []string{withChecksum("GPRMC,120000,V,,,,,,,280525,,")},
+			wantErr:   "no RMC or GGA sentence with a position",
+		},
+		{
+			name:      "GGA without a fix",
+			sentences: []string{withChecksum("GPGGA,120000,,,,,0,00,,,M,,M,,")},
+			wantErr:   "no RMC or GGA sentence with a position",
+		},
+		{
+			name: "GGA and VTG, dated by the reference",
+			sentences: []string{
+				withChecksum("GPGGA,113000,5200.000,N,00400.000,W,1,08,0.9,545.4,M,46.9,M,,"),
+				withChecksum("GPVTG,054.7,T,034.4,M,005.5,N,010.2,K"),
+			},
+			want: Fix{
+				Time:     time.Date(2025, 5, 28, 11, 30, 0, 0, time.UTC),
+				Latitude: 52, Longitude: -4, SpeedKnots: 5.5, CourseDegrees: 54.7, Valid: true,
+			},
+		},
+		{
+			name:      "fix before midnight delivered after it",
+			sentences: []string{withChecksum("GPGGA,235950,5200.000,N,00400.000,E,1,08,0.9,0,M,0,M,,")},
+			reference: time.Date(2025, 5, 29, 0, 0, 5, 0, time.UTC),
+			want:      Fix{Time: time.Date(2025, 5, 28, 23, 59, 50, 0, time.UTC), Latitude: 52, Longitude: 4, Valid: true},
+		},
+		{
+			name:      "fix after midnight against a reference before it",
+			sentences: []string{withChecksum("GPGGA,000005,5200.000,N,00400.000,E,1,08,0.9,0,M,0,M,,")},
+			reference: time.Date(2025, 5, 28, 23, 59, 50, 0, time.UTC),
+			want:      Fix{Time: time.Date(2025, 5, 29, 0, 0, 5, 0, time.UTC), Latitude: 52, Longitude: 4, Valid: true},
+		},
+		{
+			name: "RMC position and status win over GGA",
+			sentences: []string{
+				withChecksum("GPGGA,120000,5100.000,N,00300.000,E,1,08,0.9,0,M,0,M,,"),
+				withChecksum("GPRMC,120000,V,5200.000,N,00400.000,E,1.0,90.0,280525,,"),
+			},
+			want: Fix{
+				Time:     time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC),
+				Latitude: 52, Longitude: 4, SpeedKnots: 1, CourseDegrees: 90, DateFromRMC: true,
+			},
+		},
+		{
+			name: "the last epoch is used",
+			sentences: []string{
+				withChecksum("GPRMC,120000,A,5200.000,N,00400.000,E,1.0,,280525,,"),
+				withChecksum("GPRMC,120001,A,5200.060,N,00400.000,E,2.0,,280525,,"),
+			},
+			want: Fix{
+				Time:     time.Date(2025, 5, 28, 12, 0, 1, 0, time.UTC),
+				Latitude: 52.001, Longitude: 4, SpeedKnots: 2, Valid: true, DateFromRMC: true,
+			},
+		},
+		{
+			name: "other sentences are ignored",
+			sentences: []string{
+				withChecksum("GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,13,06,292,00"),
+				withChecksum("GPRMC,120000,A,5200.000,N,00400.000,E,,,280525,,"),
+			},
+			want: Fix{
+				Time:     time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC),
+				Latitude: 52, Longitude: 4, Valid: true, DateFromRMC: true,
+			},
+		},
+		{
+			name:      "checksum mismatch",
+			sentences: []string{"$GPRMC,120000,A,5200.000,N,00400.000,E,,,280525,,*00"},
+			wantErr:   "checksum mismatch",
+		},
+		{
+			name:      "malformed checksum",
+			sentences: []string{"$GPRMC,120000,A,5200.000,N,00400.000,E,,,280525,,*G1"},
+			wantErr:   "invalid NMEA checksum",
+		},
+		{
+			name:      "missing $",
+			sentences: []string{"GPRMC,120000,A,5200.000,N,00400.000,E,,,280525,,"},
+			wantErr:   "must start with $",
+		},
+		{
+			name:      "unknown hemisphere",
+			sentences: []string{withChecksum("GPRMC,120000,A,5200.000,X,00400.000,E,,,280525,,")},
+			wantErr:   "invalid latitude hemisphere",
+		},
+		{
+			name:      "minutes out of range",
+			sentences: []string{withChecksum("GPRMC,120000,A,5260.000,N,00400.000,E,,,280525,,")},
+			wantErr:   "invalid latitude",
+		},
+		{
+			name:      "invalid time",
+			sentences: []string{withChecksum("GPRMC,250000,A,5200.000,N,00400.000,E,,,280525,,")},
+			wantErr:   "invalid RMC time",
+		},
+		{
+			name:      "invalid date",
+			sentences: []string{withChecksum("GPRMC,120000,A,5200.000,N,00400.000,E,,,320525,,")},
+			wantErr:   "invalid RMC date",
+		},
+		{
+			name:      "short RMC",
+			sentences: []string{withChecksum("GPRMC,120000,A")},
+			wantErr:   "expected at least 10",
+		},
+		{
+			name:      "only VTG",
+			sentences: []string{withChecksum("GPVTG,054.7,T,034.4,M,005.5,N,010.2,K")},
+			wantErr:   "no RMC or GGA sentence with a time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := tt.reference
+			if ref.IsZero() {
+				ref = reference
+			}
+			fix, err := Decode(tt.sentences, ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !fix.Time.Equal(tt.want.Time) {
+				t.Errorf("time %v, want %v", fix.Time, tt.want.Time)
+			}
+			for _, c := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"latitude", fix.Latitude, tt.want.Latitude},
+				{"longitude", fix.Longitude, tt.want.Longitude},
+				{"speed", fix.SpeedKnots, tt.want.SpeedKnots},
+				{"course", fix.CourseDegrees, tt.want.CourseDegrees},
+			} {
+				if math.Abs(c.got-c.want) > 1e-9 {
+					t.Errorf("%s %v, want %v", c.name, c.got, c.want)
+				}
+			}
+			if fix.Valid != tt.want.Valid || fix.DateFromRMC != tt.want.DateFromRMC {
+				t.Errorf("valid %v, date from RMC %v, want %v and %v", fix.Valid, fix.DateFromRMC, tt.want.Valid, tt.want.DateFromRMC)
+			}
+		})
+	}
+}
+
+func TestSentencesUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want []string
+	}{
+		{"string", `"$GPGGA,1\r\n\n  $GPVTG,2  \n"`, []string{"$GPGGA,1", "$GPVTG,2"}},
+		{"array", `["$GPGGA,1", "$GPVTG,2\n$GPRMC,3", ""]`, []string{"$GPGGA,1", "$GPVTG,2", "$GPRMC,3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Sentences
+			if err := s.UnmarshalJSON([]byte(tt.json)); err != nil {
+				t.Fatalf("UnmarshalJSON: %v", err)
+			}
+			if strings.Join(s, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("sentences %q, want %q", s, tt.want)
+			}
+		})
+	}
+
+	var s Sentences
+	if err := s.UnmarshalJSON([]byte(`42`)); err == nil {
+		t.Error("UnmarshalJSON accepted a number")
+	}
+}
@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"b3/server/config"
+	"b3/server/models"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// e164 matches a phone number in international format, e.g. +14155550100.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// alertTypes are the alert types a contact can receive.
+var alertTypes = []string{models.AlertCrash, models.AlertTheft, models.AlertGeofence}
+
+// ValidateContact checks a contact's name, that its address suits its channel and that it
+// only lists known alert types. An empty list of alert types is filled in with every type.
+func ValidateContact(contact *models.Contact) error {
+	contact.Name = strings.TrimSpace(contact.Name)
+	contact.Address = strings.TrimSpace(contact.Address)
+	if contact.Name == "" {
+		return errors.New("name is required")
+	}
+	switch contact.Channel {
+	case models.ContactEmail:
+		if _, err := mail.ParseAddress(contact.Address); err != nil {
+			return fmt.Errorf("invalid email address %q", contact.Address)
+		}
+	case models.ContactSMS:
+		if !e164.MatchString(contact.Address) {
+			return fmt.Errorf("invalid phone number %q, it must be in international format such as +14155550100", contact.Address)
+		}
+	case models.ContactPush:
+		if _, err := NewPush(config.NotificationChannel{URL: contact.Address}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown channel %q, must be %q, %q or %q", contact.Channel, models.ContactEmail, models.ContactSMS, models.ContactPush)
+	}
+
+	if len(contact.AlertTypes) == 0 {
+		contact.AlertTypes = append([]string(nil), alertTypes...)
+	}
+	for _, alertType := range contact.AlertTypes {
+		if !slices.Contains(alertTypes, alertType) {
+			return fmt.Errorf("unknown alert type %q, must be one of %s", alertType, strings.Join(alertTypes, ", "))
+		}
+	}
+	slices.Sort(contact.AlertTypes)
+	contact.AlertTypes = slices.Compact(contact.AlertTypes)
+	return nil
+}
+
+// Dispatcher delivers messages to individual contacts. It borrows the transport of the
+// configured channels: email goes through the first email channel's SMTP server and SMS
+// through the first SNS channel's AWS account. Push contacts are posted to directly.
+type Dispatcher struct {
+	email *Email
+	sms   *SNS
+}
+
+// NewDispatcher picks the channels contacts are delivered through from notifiers.
+func NewDispatcher(notifiers []Notifier) *Dispatcher {
+	d := &Dispatcher{}
+	for _, notifier := range notifiers {
+		switch n := notifier.(type) {
+		case *Email:
+			if d.email == nil {
+				d.email = n
+			}
+		case *SNS:
+			if d.sms == nil {
+				d.sms = n
+			}
+		}
+	}
+	return d
+}
+
+// Deliver sends msg to one contact.
+func (d *Dispatcher) Deliver(channel, address string, msg Message) error {
+	switch channel {
+	case models.ContactEmail:
+		if d.email == nil {
+			return errors.New("email contacts need an email notification channel to send through")
+		}
+		return d.email.SendTo([]string{address}, msg)
+	case models.ContactSMS:
+		if d.sms == nil {
+			return errors.New("SMS contacts need an sns notification channel to send through")
+		}
+		return d.sms.SendSMS(address, msg)
+	case models.ContactPush:
+		push, err := NewPush(config.NotificationChannel{URL: address})
+		if err != nil {
+			return err
+		}
+		return push.Send(msg)
+	default:
+		return fmt.Errorf("unknown contact channel %q", channel)
+	}
+}
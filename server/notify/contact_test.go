@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"b3/server/config"
+	"b3/server/models"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newSNSServer starts a stand-in SNS endpoint that records the form of each Publish call,
+// and returns an SNS channel that publishes to it with dummy credentials.
+func newSNSServer(t *testing.T) (*SNS, *[]url.Values) {
+	t.Helper()
+	var published []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		published = append(published, form)
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, `<PublishResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">`+
+			`<PublishResult><MessageId>m-1</MessageId></PublishResult>`+
+			`<ResponseMetadata><RequestId>r-1</RequestId></ResponseMetadata></PublishResponse>`)
+	}))
+	t.Cleanup(server.Close)
+
+	// Keep the SDK away from the real AWS configuration of whoever runs the tests
+	missing := filepath.Join(t.TempDir(), "missing")
+	t.Setenv("AWS_CONFIG_FILE", missing)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", missing)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	sns, err := NewSNS(context.Background(), config.NotificationChannel{
+		Name:     "aws",
+		TopicArn: "arn:aws:sns:eu-west-1:000000000000:b3-alerts",
+		Region:   "eu-west-1",
+		Endpoint: server.URL,
+	})
+	if err != nil {
+		t.Fatalf("NewSNS: %v", err)
+	}
+	return sns, &published
+}
+
+func TestDispatcherDeliver(t *testing.T) {
+	smtp := newSMTPServer(t)
+	email, err := NewEmail(smtp.channel())
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+	sns, published := newSNSServer(t)
+	push, pushed := newPushServer(t, http.StatusOK)
+	// A second email channel must not be used: contacts go through the first one
+	other, err := NewEmail(config.NotificationChannel{SMTPHost: "192.0.2.1", From: "other@example.com", To: []string{"x@example.com"}})
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+	dispatcher := NewDispatcher([]Notifier{unusedPush(t), email, other, sns})
+	msg := Message{Subject: "Crash detected", Body: "bike1 crashed"}
+
+	if err := dispatcher.Deliver(models.ContactEmail, "mum@example.com", msg); err != nil {
+		t.Fatalf("Deliver email: %v", err)
+	}
+	if err := dispatcher.Deliver(models.ContactSMS, "+31612345678", msg); err != nil {
+		t.Fatalf("Deliver SMS: %v", err)
+	}
+	if err := dispatcher.Deliver(models.ContactPush, push.URL+"/dad", msg); err != nil {
+		t.Fatalf("Deliver push: %v", err)
+	}
+
+	// Each contact reached only its own channel
+	commands, data, _ := smtp.received()
+	if !slices.Contains(commands, "RCPT TO:<mum@example.com>") || containsPrefix(commands, "RCPT TO:<owner@example.com>") {
+		t.Errorf("SMTP recipients %q, want only the contact", commands)
+	}
+	if len(data) != 1 || !strings.Contains(data[0], "bike1 crashed") {
+		t.Errorf("SMTP messages %q, want one with the body", data)
+	}
+	if len(*published) != 1 {
+		t.Fatalf("SNS got %d publishes, want 1", len(*published))
+	}
+	form := (*published)[0]
+	if form.Get("Action") != "Publish" || form.Get("PhoneNumber") != "+31612345678" || form.Get("Message") != "bike1 crashed" || form.Get("TopicArn") != "" {
+		t.Errorf("SNS publish %v, want an SMS to the contact's number", form)
+	}
+	if len(*pushed) != 1 || (*pushed)[0].path != "/dad" || (*pushed)[0].body != "bike1 crashed" {
+		t.Errorf("push requests %+v, want one to the contact's URL", *pushed)
+	}
+}
+
+// unusedPush returns a push channel that fails the test if anything is sent to it, since
+// dispatching to contacts never uses configured push channels.
+func unusedPush(t *testing.T) *Push {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("configured push channel got %s %s", r.Method, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	push, err := NewPush(config.NotificationChannel{URL: server.URL})
+	if err != nil {
+		t.Fatalf("NewPush: %v", err)
+	}
+	return push
+}
+
+func TestDispatcherDeliverErrors(t *testing.T) {
+	dispatcher := NewDispatcher(nil)
+	tests := []struct {
+		channel string
+		address string
+		wantErr string
+	}{
+		{models.ContactEmail, "mum@example.com", "need an email notification channel"},
+		{models.ContactSMS, "+31612345678", "need an sns notification channel"},
+		{models.ContactPush, "not a url", "http or https"},
+		{"pigeon", "loft 3", "unknown contact channel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			err := dispatcher.Deliver(tt.channel, tt.address, Message{Subject: "s", Body: "b"})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Deliver error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateContact(t *testing.T) {
+	tests := []struct {
+		name           string
+		contact        models.Contact
+		wantErr        string
+		wantAlertTypes []string
+	}{
+		{
+			name:           "email gets every alert type by default",
+			contact:        models.Contact{Name: " Mum ", Channel: models.ContactEmail, Address: " mum@example.com "},
+			wantAlertTypes: []string{models.AlertCrash, models.AlertGeofence, models.AlertTheft},
+		},
+		{
+			name:           "alert types are sorted and deduplicated",
+			contact:        models.Contact{Name: "Dad", Channel: models.ContactSMS, Address: "+31612345678", AlertTypes: []string{models.AlertTheft, models.AlertCrash, models.AlertTheft}},
+			wantAlertTypes: []string{models.AlertCrash, models.AlertTheft},
+		},
+		{
+			name:           "push",
+			contact:        models.Contact{Name: "Phone", Channel: models.ContactPush, Address: "https://ntfy.sh/b3-mum"},
+			wantAlertTypes: []string{models.AlertCrash, models.AlertGeofence, models.AlertTheft},
+		},
+		{name: "no name", contact: models.Contact{Name: " ", Channel: models.ContactEmail, Address: "mum@example.com"}, wantErr: "name is required"},
+		{name: "bad email", contact: models.Contact{Name: "Mum", Channel: models.ContactEmail, Address: "mum"}, wantErr: "invalid email address"},
+		{name: "local phone number", contact: models.Contact{Name: "Dad", Channel: models.ContactSMS, Address: "0612345678"}, wantErr: "international format"},
+		{name: "bad push URL", contact: models.Contact{Name: "Phone", Channel: models.ContactPush, Address: "ntfy.sh/b3"}, wantErr: "http or https"},
+		{name: "unknown channel", contact: models.Contact{Name: "Mum", Channel: "fax", Address: "123"}, wantErr: "unknown channel"},
+		{name: "unknown alert type", contact: models.Contact{Name: "Mum", Channel: models.ContactEmail, Address: "mum@example.com", AlertTypes: []string{"flat tyre"}}, wantErr: "unknown alert type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contact := tt.contact
+			err := ValidateContact(&contact)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ValidateContact error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateContact: %v", err)
+			}
+			if strings.TrimSpace(contact.Name) != contact.Name || strings.TrimSpace(contact.Address) != contact.Address {
+				t.Errorf("name %q and address %q not trimmed", contact.Name, contact.Address)
+			}
+			if !slices.Equal(contact.AlertTypes, tt.wantAlertTypes) {
+				t.Errorf("alert types %v, want %v", contact.AlertTypes, tt.wantAlertTypes)
+			}
+		})
+	}
+}
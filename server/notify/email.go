@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"b3/server/config"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpTimeout bounds a whole SMTP conversation.
+const smtpTimeout = 15 * time.Second
+
+// Email sends notifications by SMTP.
+type Email struct {
+	name     string
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+// NewEmail creates an email channel. Pointing smtp_host at a local stand-in such as
+// MailHog (port 1025) works without TLS; credentials are only sent over TLS or to localhost.
+func NewEmail(channel config.NotificationChannel) (*Email, error) {
+	if channel.SMTPHost == "" {
+		return nil, errors.New("smtp_host is required")
+	}
+	if _, err := mail.ParseAddress(channel.From); err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %w", channel.From, err)
+	}
+	if len(channel.To) == 0 {
+		return nil, errors.New("at least one to address is required")
+	}
+	for _, to := range channel.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("invalid to address %q: %w", to, err)
+		}
+	}
+	port := channel.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	return &Email{
+		name:     channel.Name,
+		host:     channel.SMTPHost,
+		port:     port,
+		username: channel.SMTPUsername,
+		password: channel.SMTPPassword,
+		from:     channel.From,
+		to:       channel.To,
+	}, nil
+}
+
+func (e *Email) Name() string { return e.name }
+
+func (e *Email) Type() string { return TypeEmail }
+
+// Send emails the message to every recipient of the channel.
+func (e *Email) Send(msg Message) error {
+	return e.SendTo(e.to, msg)
+}
+
+// SendTo emails the message to the given recipients instead of the channel's own.
+func (e *Email) SendTo(to []string, msg Message) error {
+	address := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	var conn net.Conn
+	var err error
+	if e.port == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", address, &tls.Config{ServerName: e.host})
+	} else {
+		conn, err = net.DialTimeout("tcp", address, smtpTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server %s: %w", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session with %s: %w", address, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && e.port != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return fmt.Errorf("failed to start TLS with %s: %w", address, err)
+		}
+	}
+	if e.username != "" {
+		// PlainAuth refuses to send the password unencrypted to anything but localhost
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(e.from)
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected sender %s: %w", from.Address, err)
+	}
+	for _, recipient := range to {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
+		if err := client.Rcpt(address.Address); err != nil {
+			return fmt.Errorf("SMTP server rejected recipient %s: %w", address.Address, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP server refused the message: %w", err)
+	}
+	if _, err := writer.Write(e.compose(to, msg)); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("SMTP server did not accept the message: %w", err)
+	}
+	return client.Quit()
+}
+
+// compose formats msg as a plain text UTF-8 email.
+func (e *Email) compose(to []string, msg Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
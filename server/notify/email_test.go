@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"b3/server/config"
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpServer is a stand-in SMTP server on a local listener. It accepts everything
+// except the recipients in reject, and records the conversation.
+type smtpServer struct {
+	listener net.Listener
+	reject   map[string]bool
+
+	mu       sync.Mutex
+	commands []string // Every command received, e.g. "MAIL FROM:<b3@example.com>"
+	data     []string // The message of each DATA command
+	auth     string   // Decoded AUTH PLAIN credentials
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T, reject ...string) *smtpServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &smtpServer{listener: listener, reject: make(map[string]bool), done: make(chan struct{})}
+	for _, address := range reject {
+		s.reject["RCPT TO:<"+address+">"] = true
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		<-s.done
+	})
+	return s
+}
+
+// channel returns an email channel sending through the server.
+func (s *smtpServer) channel() config.NotificationChannel {
+	port := s.listener.Addr().(*net.TCPAddr).Port
+	return config.NotificationChannel{
+		Type:     TypeEmail,
+		Name:     "mail",
+		SMTPHost: "127.0.0.1",
+		SMTPPort: port,
+		From:     "B3 <b3@example.com>",
+		To:       []string{"owner@example.com"},
+	}
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+		switch {
+		case verb == "EHLO":
+			reply("250-localhost")
+			reply("250-8BITMIME")
+			reply("250 AUTH PLAIN")
+		case verb == "AUTH":
+			fields := strings.Fields(command)
+			decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			s.mu.Lock()
+			s.auth = string(decoded)
+			s.mu.Unlock()
+			reply("235 2.7.0 Authentication successful")
+		case verb == "RCPT" && s.reject[command]:
+			reply("550 5.1.1 No such user")
+		case verb == "MAIL", verb == "RCPT", verb == "RSET", verb == "NOOP":
+			reply("250 OK")
+		case verb == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var message strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			s.mu.Lock()
+			s.data = append(s.data, message.String())
+			s.mu.Unlock()
+			reply("250 OK queued")
+		case verb == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// received returns the commands and messages received so far.
+func (s *smtpServer) received() ([]string, []string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...), append([]string(nil), s.data...), s.auth
+}
+
+func TestEmailSend(t *testing.T) {
+	server := newSMTPServer(t)
+	channel := server.channel()
+	channel.To = []string{"owner@example.com", "Friend <friend@example.com>"}
+	channel.SMTPUsername = "b3"
+	channel.SMTPPassword = "hunter22"
+	email, err := NewEmail(channel)
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+
+	if err := email.Send(Message{Subject: "🚨 Crash detected", Body: "bike1 crashed\nat 52.1, 4.3"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	commands, data, auth := server.received()
+	for _, want := range []string{"MAIL FROM:<b3@example.com>", "RCPT TO:<owner@example.com>", "RCPT TO:<friend@example.com>", "DATA", "QUIT"} {
+		if !containsPrefix(commands, want) {
+			t.Errorf("commands %q lack %q", commands, want)
+		}
+	}
+	if auth != "\x00b3\x00hunter22" {
+		t.Errorf("AUTH PLAIN credentials %q, want user b3 and its password", auth)
+	}
+	if len(data) != 1 {
+		t.Fatalf("server got %d messages, want 1", len(data))
+	}
+	message := data[0]
+	for _, want := range []string{
+		"From: B3 <b3@example.com>\r\n",
+		"To: owner@example.com, Friend <friend@example.com>\r\n",
+		"Subject: =?utf-8?q?=F0=9F=9A=A8_Crash_detected?=\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nbike1 crashed\r\nat 52.1, 4.3\r\n",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message lacks %q:\n%s", want, message)
+		}
+	}
+}
+
+func TestEmailSendWithoutCredentials(t *testing.T) {
+	server := newSMTPServer(t)
+	email, err := NewEmail(server.channel())
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+	if err := email.Send(Message{Subject: "s", Body: "b"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	commands, _, _ := server.received()
+	if containsPrefix(commands, "AUTH") {
+		t.Errorf("commands %q include AUTH without a username", commands)
+	}
+}
+
+func TestEmailRejectedRecipient(t *testing.T) {
+	server := newSMTPServer(t, "nobody@example.com")
+	email, err := NewEmail(server.channel())
+	if err != nil {
+		t.Fatalf("NewEmail: %v", err)
+	}
+	err = email.SendTo([]string{"nobody@example.com"}, Message{Subject: "s", Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "rejected recipient nobody@example.com") {
+		t.Errorf("SendTo error %v, want the rejected recipient", err)
+	}
+	if _, data, _ := server.received(); len(data) != 0 {
+		t.Errorf("server got %d messages, want none", len(data))
+	}
+}
+
+func TestNewEmailValidation(t *testing.T) {
+	valid := config.NotificationChannel{SMTPHost: "localhost", From: "b3@example.com", To: []string{"owner@example.com"}}
+	tests := []struct {
+		name    string
+		change  func(*config.NotificationChannel)
+		wantErr string
+	}{
+		{"valid", func(*config.NotificationChannel) {}, ""},
+		{"no host", func(c *config.NotificationChannel) { c.SMTPHost = "" }, "smtp_host is required"},
+		{"bad from", func(c *config.NotificationChannel) { c.From = "not an address" }, "invalid from address"},
+		{"no recipients", func(c *config.NotificationChannel) { c.To = nil }, "at least one to address"},
+		{"bad recipient", func(c *config.NotificationChannel) { c.To = []string{"owner"} }, "invalid to address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel := valid
+			tt.change(&channel)
+			email, err := NewEmail(channel)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewEmail: %v", err)
+				}
+				if email.port != 587 {
+					t.Errorf("port %d, want the default 587", email.port)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewEmail error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func containsPrefix(lines []string, prefix string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+// Package notify sends alert notifications through the channels listed in config.json:
+// SNS topics, SMTP email and ntfy/Gotify-style HTTP push.
+package notify
+
+import (
+	"b3/server/config"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Channel types.
+const (
+	TypeSNS   = "sns"
+	TypeEmail = "email"
+	TypePush  = "push"
+)
+
+// Message is a notification, sent the same way through every channel.
+type Message struct {
+	Subject string // One line, used as the email subject or push title
+	Body    string // Plain text; channels without a subject get the whole message from it
+}
+
+// Notifier is a channel alerts can be sent through.
+type Notifier interface {
+	// Name identifies the channel in logs and the test endpoint.
+	Name() string
+	// Type is TypeSNS, TypeEmail or TypePush.
+	Type() string
+	// Send delivers a message. It may block for several seconds on a slow server.
+	Send(msg Message) error
+}
+
+// FromConfig creates the notifiers for the configured notification channels. When none
+// are configured, the SNS topic from the older sns_* settings is used if SNS is enabled.
+func FromConfig(ctx context.Context, cfg config.Config) ([]Notifier, error) {
+	channels := cfg.NotificationChannels
+	if len(channels) == 0 && cfg.SNSEnabled {
+		channels = []config.NotificationChannel{{Type: TypeSNS, TopicArn: cfg.SNSTopicArn, Region: cfg.SNSRegion}}
+	}
+
+	var notifiers []Notifier
+	names := make(map[string]bool)
+	for i, channel := range channels {
+		if channel.Name == "" {
+			channel.Name = channel.Type
+		}
+		if names[channel.Name] {
+			return nil, fmt.Errorf("notification channel %d: name %q is used twice, give the channels distinct names", i, channel.Name)
+		}
+		names[channel.Name] = true
+
+		notifier, err := newNotifier(ctx, channel)
+		if err != nil {
+			return nil, fmt.Errorf("notification channel %q: %w", channel.Name, err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers, nil
+}
+
+func newNotifier(ctx context.Context, channel config.NotificationChannel) (Notifier, error) {
+	switch channel.Type {
+	case TypeSNS:
+		return NewSNS(ctx, channel)
+	case TypeEmail:
+		return NewEmail(channel)
+	case TypePush:
+		return NewPush(channel)
+	default:
+		return nil, fmt.Errorf("unknown type %q, must be %q, %q or %q", channel.Type, TypeSNS, TypeEmail, TypePush)
+	}
+}
+
+// Broadcast sends a message through every notifier and returns the errors of those that
+// failed. Every notifier is tried even if an earlier one fails.
+func Broadcast(notifiers []Notifier, msg Message) error {
+	if len(notifiers) == 0 {
+		log.Printf("Notification %q not sent, no notification channels are configured.", msg.Subject)
+		return nil
+	}
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := notifier.Send(msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", notifier.Name(), err))
+			continue
+		}
+		log.Printf("Sent notification %q through %s.", msg.Subject, notifier.Name())
+	}
+	return errors.Join(errs...)
+}
+
+// Find returns the notifier with the given name.
+func Find(notifiers []Notifier, name string) (Notifier, bool) {
+	for _, notifier := range notifiers {
+		if strings.EqualFold(notifier.Name(), name) {
+			return notifier, true
+		}
+	}
+	return nil, false
+}
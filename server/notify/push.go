@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"b3/server/config"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Push formats.
+const (
+	FormatNtfy   = "ntfy"
+	FormatGotify = "gotify"
+)
+
+// Push sends notifications to an ntfy or Gotify server over HTTP.
+type Push struct {
+	name     string
+	url      string
+	format   string
+	token    string
+	priority int
+	client   *http.Client
+}
+
+// NewPush creates an HTTP push channel. Any server speaking the ntfy or Gotify protocol
+// works, including a local stand-in.
+func NewPush(channel config.NotificationChannel) (*Push, error) {
+	target, err := url.Parse(channel.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL, got %q", channel.URL)
+	}
+	format := channel.Format
+	if format == "" {
+		format = FormatNtfy
+	}
+	if format != FormatNtfy && format != FormatGotify {
+		return nil, fmt.Errorf("unknown format %q, must be %q or %q", format, FormatNtfy, FormatGotify)
+	}
+	if format == FormatGotify && channel.Token == "" {
+		return nil, errors.New("token is required for gotify")
+	}
+	return &Push{
+		name:     channel.Name,
+		url:      channel.URL,
+		format:   format,
+		token:    channel.Token,
+		priority: channel.Priority,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *Push) Name() string { return p.name }
+
+func (p *Push) Type() string { return TypePush }
+
+// Send posts the message to the channel's URL.
+func (p *Push) Send(msg Message) error {
+	request, err := p.request(msg)
+	if err != nil {
+		return err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to send push notification: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("push server responded %s: %s", response.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (p *Push) request(msg Message) (*http.Request, error) {
+	if p.format == FormatGotify {
+		payload := map[string]interface{}{"title": msg.Subject, "message": msg.Body}
+		if p.priority != 0 {
+			payload["priority"] = p.priority
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		request, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Gotify-Key", p.token)
+		return request, nil
+	}
+
+	// ntfy takes the body as the message and everything else as headers
+	request, err := http.NewRequest(http.MethodPost, p.url, bytes.NewBufferString(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	request.Header.Set("Title", mime.QEncoding.Encode("utf-8", msg.Subject))
+	if p.priority != 0 {
+		request.Header.Set("Priority", strconv.Itoa(p.priority))
+	}
+	if p.token != "" {
+		request.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	return request, nil
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"b3/server/config"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pushRequest is what a stand-in push server received.
+type pushRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+// newPushServer starts a stand-in push server that records the requests it receives and
+// responds with status.
+func newPushServer(t *testing.T, status int) (*httptest.Server, *[]pushRequest) {
+	t.Helper()
+	var requests []pushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, pushRequest{method: r.Method, path: r.URL.Path, header: r.Header.Clone(), body: string(body)})
+		w.WriteHeader(status)
+		io.WriteString(w, "server says no")
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestPushNtfy(t *testing.T) {
+	server, requests := newPushServer(t, http.StatusOK)
+	push, err := NewPush(config.NotificationChannel{Name: "phone", URL: server.URL + "/b3-alerts", Token: "tk_secret", Priority: 5})
+	if err != nil {
+		t.Fatalf("NewPush: %v", err)
+	}
+
+	if err := push.Send(Message{Subject: "🚨 Crash detected", Body: "bike1 crashed\nat 52.1, 4.3"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(*requests))
+	}
+	got := (*requests)[0]
+	if got.method != http.MethodPost || got.path != "/b3-alerts" {
+		t.Errorf("request %s %s, want POST /b3-alerts", got.method, got.path)
+	}
+	for header, want := range map[string]string{
+		"Title":         "=?utf-8?q?=F0=9F=9A=A8_Crash_detected?=",
+		"Priority":      "5",
+		"Authorization": "Bearer tk_secret",
+		"Content-Type":  "text/plain; charset=utf-8",
+		"X-Gotify-Key":  "",
+	} {
+		if value := got.header.Get(header); value != want {
+			t.Errorf("header %s = %q, want %q", header, value, want)
+		}
+	}
+	if got.body != "bike1 crashed\nat 52.1, 4.3" {
+		t.Errorf("body %q, want the message body", got.body)
+	}
+}
+
+func TestPushNtfyWithoutOptionalHeaders(t *testing.T) {
+	server, requests := newPushServer(t, http.StatusOK)
+	push, err := NewPush(config.NotificationChannel{URL: server.URL})
+	if err != nil {
+		t.Fatalf("NewPush: %v", err)
+	}
+	if err := push.Send(Message{Subject: "Plain", Body: "body"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got := (*requests)[0]
+	for _, header := range []string{"Priority", "Authorization"} {
+		if value := got.header.Get(header); value != "" {
+			t.Errorf("header %s = %q, want none", header, value)
+		}
+	}
+	if title := got.header.Get("Title"); title != "Plain" {
+		t.Errorf("Title = %q, want ASCII titles unencoded", title)
+	}
+}
+
+func TestPushGotify(t *testing.T) {
+	server, requests := newPushServer(t, http.StatusOK)
+	push, err := NewPush(config.NotificationChannel{URL: server.URL + "/message", Format: FormatGotify, Token: "app-token", Priority: 8})
+	if err != nil {
+		t.Fatalf("NewPush: %v", err)
+	}
+
+	if err := push.Send(Message{Subject: "Theft", Body: "bike1 is moving"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got := (*requests)[0]
+	if got.method != http.MethodPost || got.path != "/message" {
+		t.Errorf("request %s %s, want POST /message", got.method, got.path)
+	}
+	if key := got.header.Get("X-Gotify-Key"); key != "app-token" {
+		t.Errorf("X-Gotify-Key = %q, want app-token", key)
+	}
+	if auth := got.header.Get("Authorization"); auth != "" {
+		t.Errorf("Authorization = %q, want none", auth)
+	}
+	if contentType := got.header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(got.body), &body); err != nil {
+		t.Fatalf("body %q is not JSON: %v", got.body, err)
+	}
+	want := map[string]interface{}{"title": "Theft", "message": "bike1 is moving", "priority": float64(8)}
+	if len(body) != len(want) {
+		t.Errorf("body %v, want %v", body, want)
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("body[%q] = %v, want %v", key, body[key], value)
+		}
+	}
+}
+
+func TestPushServerError(t *testing.T) {
+	server, _ := newPushServer(t, http.StatusForbidden)
+	push, err := NewPush(config.NotificationChannel{URL: server.URL})
+	if err != nil {
+		t.Fatalf("NewPush: %v", err)
+	}
+	err = push.Send(Message{Subject: "s", Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "server says no") {
+		t.Errorf("Send error %v, want the status and response body", err)
+	}
+}
+
+func TestNewPushValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel config.NotificationChannel
+		wantErr string
+	}{
+		{"ntfy", config.NotificationChannel{URL: "https://ntfy.sh/b3"}, ""},
+		{"gotify", config.NotificationChannel{URL: "http://localhost/message", Format: FormatGotify, Token: "t"}, ""},
+		{"not http", config.NotificationChannel{URL: "ftp://ntfy.sh/b3"}, "http or https"},
+		{"no host", config.NotificationChannel{URL: "https:///b3"}, "http or https"},
+		{"unknown format", config.NotificationChannel{URL: "https://ntfy.sh/b3", Format: "pushover"}, "unknown format"},
+		{"gotify without token", config.NotificationChannel{URL: "http://localhost/message", Format: FormatGotify}, "token is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPush(tt.channel)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("NewPush: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewPush error %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
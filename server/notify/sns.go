@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"b3/server/config"
+	"b3/server/snsnotifier"
+	"context"
+	"errors"
+)
+
+// SNS publishes notifications to an SNS topic.
+type SNS struct {
+	name     string
+	topicArn string
+	client   *snsnotifier.Notifier
+}
+
+// NewSNS creates an SNS channel. AWS credentials are loaded the usual way, from the
+// environment, the credentials file or an IAM role.
+func NewSNS(ctx context.Context, channel config.NotificationChannel) (*SNS, error) {
+	if channel.TopicArn == "" {
+		return nil, errors.New("topic_arn is required")
+	}
+	client, err := snsnotifier.NewNotifierWithOptions(ctx, channel.Region, channel.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &SNS{name: channel.Name, topicArn: channel.TopicArn, client: client}, nil
+}
+
+func (s *SNS) Name() string { return s.name }
+
+func (s *SNS) Type() string { return TypeSNS }
+
+// Send publishes the body only. SNS subjects must be plain ASCII, which alert titles
+// with emoji are not, and SMS subscribers never see the subject anyway.
+func (s *SNS) Send(msg Message) error {
+	return s.client.PublishSimple(s.topicArn, msg.Body)
+}
+
+// SendSMS texts the body to a phone number in E.164 format, using the channel's AWS
+// credentials and region.
+func (s *SNS) SendSMS(phoneNumber string, msg Message) error {
+	return s.client.PublishSMS(phoneNumber, msg.Body)
+}
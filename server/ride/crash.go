@@ -0,0 +1,57 @@
+package ride
+
+import (
+	"b3/server/models"
+
+	"log"
+	"time"
+)
+
+// crashRepeatWindow is how long after a CRASH_DETECTED report the next one is taken to be
+// the same crash. Devices keep the status in every shadow update until they are reset, so
+// a crash lasts as long as those reports keep coming.
+const crashRepeatWindow = time.Minute
+
+// RecordCrash stores and broadcasts a crash reported by the device, along with the ride in
+// progress. A report without a fix is placed at the device's last known position. Reports
+// within crashRepeatWindow of the previous one repeat the same crash and are not recorded
+// again; for them, and if the crash can't be stored, the event is returned without an ID
+// and recorded is false.
+func (rm *RideManager) RecordCrash(position models.Position) (event models.CrashEvent, recorded bool, err error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if position.Latitude == 0 && position.Longitude == 0 && rm.lastPosition != nil {
+		position.Latitude, position.Longitude = rm.lastPosition.Latitude, rm.lastPosition.Longitude
+	}
+	event = models.CrashEvent{
+		DeviceID:  rm.deviceID,
+		RideID:    rm.currentRideID,
+		Latitude:  position.Latitude,
+		Longitude: position.Longitude,
+		Timestamp: position.Timestamp.UTC(),
+	}
+	repeat := !rm.lastCrashAt.IsZero() && position.Timestamp.Sub(rm.lastCrashAt).Abs() < crashRepeatWindow
+	rm.lastCrashAt = position.Timestamp
+	if repeat {
+		return event, false, nil
+	}
+
+	id, err := rm.store.AddCrashEvent(event)
+	if err != nil {
+		return event, false, err
+	}
+	event.ID = id
+
+	log.Printf("RideManager[%s]: Recorded crash %d during ride %d at lat %f, lon %f.", rm.deviceID, id, event.RideID, event.Latitude, event.Longitude)
+	rm.hub.BroadcastCrashDetected(event)
+	return event, true, nil
+}
+
+// ResetCrash forgets the last crash report, so the next one is recorded as a new crash.
+// It is called when the rider cancels a crash from the device.
+func (rm *RideManager) ResetCrash() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.lastCrashAt = time.Time{}
+}
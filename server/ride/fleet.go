@@ -0,0 +1,227 @@
+package ride
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/geofence"
+	"b3/server/models"
+	"b3/server/writebuffer"
+	"b3/server/ws"
+
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fleet keeps an independent RideManager for every device reporting to the server.
+// Managers are created the first time a device is seen.
+type Fleet struct {
+	mu             sync.Mutex
+	managers       map[string]*RideManager
+	store          database.RideStore
+	positions      *writebuffer.Buffer
+	cfg            config.Config
+	hub            *ws.Hub
+	theftAlertFunc func(deviceID string, incidentID int64, lat, lon float64, timestamp time.Time) // Function to call for theft alerts
+	lastCommandID  int64                                                                          // IDs of lock commands are unique across devices
+
+	zones             *geofence.Registry
+	geofenceAlertFunc func(event models.GeofenceEvent) // Function to call for geofence notifications
+}
+
+// NewFleet creates an empty Fleet. Managers share the ride store, position buffer, config,
+// WebSocket hub and geofences.
+func NewFleet(store database.RideStore, positions *writebuffer.Buffer, appConfig config.Config, hub *ws.Hub, zones *geofence.Registry) *Fleet {
+	return &Fleet{
+		managers:  make(map[string]*RideManager),
+		store:     store,
+		positions: positions,
+		cfg:       appConfig,
+		hub:       hub,
+		zones:     zones,
+	}
+}
+
+// Manager returns the RideManager for deviceID, creating it if needed.
+func (f *Fleet) Manager(deviceID string) *RideManager {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if rm, ok := f.managers[deviceID]; ok {
+		return rm
+	}
+
+	rm := NewRideManager(f.store, f.positions, f.cfg, f.hub, f.zones, deviceID)
+	if f.theftAlertFunc != nil {
+		rm.SetTheftAlertFunc(f.deviceTheftAlertFunc(deviceID))
+	}
+	if f.geofenceAlertFunc != nil {
+		rm.SetGeofenceAlertFunc(f.geofenceAlertFunc)
+	}
+	f.managers[deviceID] = rm
+	log.Printf("Fleet: Created RideManager for device %s", deviceID)
+	return rm
+}
+
+// Lookup returns the RideManager for deviceID without creating one.
+func (f *Fleet) Lookup(deviceID string) (*RideManager, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rm, ok := f.managers[deviceID]
+	return rm, ok
+}
+
+// DeviceIDs returns the IDs of all known devices in sorted order.
+func (f *Fleet) DeviceIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deviceIDs := make([]string, 0, len(f.managers))
+	for deviceID := range f.managers {
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	sort.Strings(deviceIDs)
+	return deviceIDs
+}
+
+// DefaultDeviceID returns the device used when a request does not name one.
+func (f *Fleet) DefaultDeviceID() string {
+	return f.cfg.DefaultDeviceID
+}
+
+// RequestLock starts tracking a lock command published to deviceID. It times out
+// after lock_command_timeout_seconds unless the device reports the status first.
+func (f *Fleet) RequestLock(deviceID, status string) models.LockCommand {
+	f.mu.Lock()
+	f.lastCommandID++
+	id := f.lastCommandID
+	f.mu.Unlock()
+
+	timeout := time.Duration(f.cfg.LockCommandTimeoutSecs) * time.Second
+	return f.Manager(deviceID).RequestLock(id, status, timeout)
+}
+
+// LockCommand returns the lock command with the given ID from any device.
+func (f *Fleet) LockCommand(id int64) (models.LockCommand, bool) {
+	for _, rm := range f.managerList() {
+		if command, ok := rm.LockCommand(id); ok {
+			return command, true
+		}
+	}
+	return models.LockCommand{}, false
+}
+
+// SetTheftAlertFunc sets the function to call when theft is detected on any device.
+// incidentID is 0 if the theft incident could not be opened.
+func (f *Fleet) SetTheftAlertFunc(alertFunc func(deviceID string, incidentID int64, lat, lon float64, timestamp time.Time)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.theftAlertFunc = alertFunc
+	for deviceID, rm := range f.managers {
+		rm.SetTheftAlertFunc(f.deviceTheftAlertFunc(deviceID))
+	}
+}
+
+// SetGeofenceAlertFunc sets the function to call when any device enters or exits a
+// geofence that has notifications enabled
+func (f *Fleet) SetGeofenceAlertFunc(alertFunc func(event models.GeofenceEvent)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.geofenceAlertFunc = alertFunc
+	for _, rm := range f.managers {
+		rm.SetGeofenceAlertFunc(alertFunc)
+	}
+}
+
+func (f *Fleet) deviceTheftAlertFunc(deviceID string) func(incidentID int64, lat, lon float64, timestamp time.Time) {
+	// This function assumes f.mu is already locked.
+	alertFunc := f.theftAlertFunc
+	return func(incidentID int64, lat, lon float64, timestamp time.Time) {
+		alertFunc(deviceID, incidentID, lat, lon, timestamp)
+	}
+}
+
+// managerList returns a snapshot of the current managers so they can be
+// visited without holding f.mu.
+func (f *Fleet) managerList() []*RideManager {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	managers := make([]*RideManager, 0, len(f.managers))
+	for _, rm := range f.managers {
+		managers = append(managers, rm)
+	}
+	return managers
+}
+
+// RecoverOpenRides finds rides left open by a previous server run and hands each to
+// its device's manager, which resumes it or closes it depending on the age of its last point.
+// It should be called once on startup, before GPS data is processed.
+func (f *Fleet) RecoverOpenRides() error {
+	rides, err := f.store.GetOpenRides()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	resumed, closed := 0, 0
+	// Newest first, so only the latest open ride of a device can be resumed
+	for i := len(rides) - 1; i >= 0; i-- {
+		openRide := rides[i]
+		deviceID := openRide.DeviceID
+		if deviceID == "" {
+			deviceID = f.cfg.DefaultDeviceID
+		}
+		lastPosition, err := f.store.GetLastRidePosition(openRide.ID)
+		if err != nil {
+			log.Printf("Fleet: Failed to load last position of open ride %d: %v", openRide.ID, err)
+			continue
+		}
+		if f.Manager(deviceID).RecoverRide(openRide, lastPosition, now) {
+			resumed++
+		} else {
+			closed++
+		}
+	}
+	if len(rides) > 0 {
+		log.Printf("Fleet: Recovered %d open rides, %d resumed and %d closed", len(rides), resumed, closed)
+	}
+	return nil
+}
+
+// RecoverTheftIncidents resumes recording for theft incidents left open by a previous
+// server run. It should be called once on startup, before GPS data is processed.
+func (f *Fleet) RecoverTheftIncidents() error {
+	incidents, err := f.store.GetTheftIncidents("", true)
+	if err != nil {
+		return err
+	}
+	// Newest first, so a device with several open incidents keeps recording the latest
+	for _, incident := range incidents {
+		if f.Manager(incident.DeviceID).restoreTheftIncident(incident.ID) {
+			log.Printf("Fleet: Theft incident %d of device %s is still open, recording its positions", incident.ID, incident.DeviceID)
+		}
+	}
+	return nil
+}
+
+// ResolveTheftIncident closes a theft incident of deviceID on the owner's request.
+func (f *Fleet) ResolveTheftIncident(deviceID string, id int64, note string) error {
+	return f.Manager(deviceID).ResolveTheftIncident(id, note)
+}
+
+// CheckInactivityLoop is intended to be run as a goroutine to periodically
+// check every device for ride endings due to prolonged inactivity.
+func (f *Fleet) CheckInactivityLoop(tickerDuration time.Duration) {
+	ticker := time.NewTicker(tickerDuration)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, rm := range f.managerList() {
+			rm.checkInactivity()
+		}
+	}
+}
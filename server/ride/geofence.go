@@ -0,0 +1,114 @@
+package ride
+
+import (
+	"b3/server/models"
+	"log"
+	"sort"
+)
+
+// zoneCrossing is a device entering or exiting a zone, waiting to be stored and broadcast
+// once rm.mu is released.
+type zoneCrossing struct {
+	event     models.GeofenceEvent
+	alertFunc func(event models.GeofenceEvent) // Set if the zone asks for a notification
+}
+
+// checkGeofences returns the geofences the device entered or exited with this point, for
+// the caller to store with storeZoneCrossings once rm.mu is released. It assumes rm.mu is held.
+func (rm *RideManager) checkGeofences(point models.Position) []zoneCrossing {
+	if rm.zones == nil {
+		return nil
+	}
+	inside := make(map[int64]bool)
+	var entered []models.Geofence
+	for _, zone := range rm.zones.Match(point.Latitude, point.Longitude) {
+		inside[zone.ID] = true
+		if !rm.insideZones[zone.ID] {
+			entered = append(entered, zone)
+		}
+	}
+	var exited []models.Geofence
+	for id := range rm.insideZones {
+		// Deleted zones are forgotten without an exit event
+		if zone, ok := rm.zones.Get(id); ok && !inside[id] {
+			exited = append(exited, zone)
+		}
+	}
+
+	// Where the device was before its first point is unknown, so the zones it starts in are not entered
+	first := rm.insideZones == nil
+	rm.insideZones = inside
+	if first {
+		return nil
+	}
+
+	sort.Slice(exited, func(i, j int) bool { return exited[i].ID < exited[j].ID })
+	var crossings []zoneCrossing
+	for _, zone := range exited {
+		crossings = append(crossings, rm.zoneCrossing(zone, models.GeofenceExit, point))
+	}
+	for _, zone := range entered {
+		crossings = append(crossings, rm.zoneCrossing(zone, models.GeofenceEnter, point))
+	}
+	return crossings
+}
+
+// zoneCrossing builds the event of a device entering or exiting a zone. It assumes rm.mu is held.
+func (rm *RideManager) zoneCrossing(zone models.Geofence, eventType string, point models.Position) zoneCrossing {
+	crossing := zoneCrossing{event: models.GeofenceEvent{
+		GeofenceID:   zone.ID,
+		GeofenceName: zone.Name,
+		DeviceID:     rm.deviceID,
+		RideID:       rm.currentRideID,
+		Type:         eventType,
+		Latitude:     point.Latitude,
+		Longitude:    point.Longitude,
+		Timestamp:    point.Timestamp,
+	}}
+	if zone.Notify {
+		crossing.alertFunc = rm.geofenceAlertFunc
+	}
+	return crossing
+}
+
+// storeZoneCrossings stores, broadcasts and, if the zone asks for it, sends a notification
+// for each zone the device entered or exited. It is called without rm.mu held, so a slow
+// database doesn't hold up lock commands and reads of the device.
+func (rm *RideManager) storeZoneCrossings(crossings []zoneCrossing) {
+	for _, crossing := range crossings {
+		event := crossing.event
+		id, err := rm.store.AddGeofenceEvent(event)
+		if err != nil {
+			log.Printf("RideManager[%s]: Failed to store %s event for geofence %d: %v", rm.deviceID, event.Type, event.GeofenceID, err)
+		}
+		event.ID = id
+
+		log.Printf("RideManager[%s]: %s geofence %q at lat %f, lon %f.", rm.deviceID, event.Type, event.GeofenceName, event.Latitude, event.Longitude)
+		rm.hub.BroadcastGeofenceEvent(event)
+		if crossing.alertFunc != nil {
+			// Sending can take seconds; points keep being processed meanwhile
+			go crossing.alertFunc(event)
+		}
+	}
+}
+
+// zoneName returns the name of the smallest geofence containing position, or an empty
+// string if there is none.
+func (rm *RideManager) zoneName(position models.Position) string {
+	if rm.zones == nil {
+		return ""
+	}
+	zone, ok := rm.zones.ZoneAt(position.Latitude, position.Longitude)
+	if !ok {
+		return ""
+	}
+	return zone.Name
+}
+
+// SetGeofenceAlertFunc sets the function to call when the device enters or exits a
+// geofence that has notifications enabled.
+func (rm *RideManager) SetGeofenceAlertFunc(alertFunc func(event models.GeofenceEvent)) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.geofenceAlertFunc = alertFunc
+}
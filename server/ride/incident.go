@@ -0,0 +1,83 @@
+package ride
+
+import (
+	"b3/server/models"
+
+	"fmt"
+	"log"
+	"time"
+)
+
+// openTheftIncident starts recording the movement of a locked bike. It assumes rm.mu is held.
+func (rm *RideManager) openTheftIncident(point models.Position) error {
+	id, err := rm.store.CreateTheftIncident(rm.deviceID, point.Timestamp)
+	if err != nil {
+		return err
+	}
+	rm.incidentID = id
+	log.Printf("RideManager[%s]: Opened theft incident %d.", rm.deviceID, id)
+	rm.hub.BroadcastTheftIncident("THEFT_INCIDENT_OPENED", models.TheftIncident{
+		ID:       id,
+		DeviceID: rm.deviceID,
+		Status:   models.IncidentOpen,
+		OpenedAt: point.Timestamp.UTC(),
+	})
+	return nil
+}
+
+// recordIncidentPosition streams a position of the bike while its theft incident is open.
+// It returns the incident, for the caller to store the position with storeIncidentPosition
+// once rm.mu is released. It assumes rm.mu is held.
+func (rm *RideManager) recordIncidentPosition(point models.Position) int64 {
+	rm.hub.BroadcastIncidentPosition(rm.deviceID, rm.incidentID, point)
+	return rm.incidentID
+}
+
+// storeIncidentPosition writes a position recorded for a theft incident. It is called
+// without rm.mu held, so a slow database doesn't hold up lock commands and reads of the device.
+func (rm *RideManager) storeIncidentPosition(incidentID int64, point models.Position) {
+	if err := rm.store.AddTheftIncidentPosition(incidentID, point); err != nil {
+		log.Printf("RideManager[%s]: Failed to record position for theft incident %d: %v", rm.deviceID, incidentID, err)
+	}
+}
+
+// ResolveTheftIncident closes a theft incident of this device on the owner's request.
+// Positions are no longer recorded for it afterwards.
+func (rm *RideManager) ResolveTheftIncident(id int64, note string) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if err := rm.store.ResolveTheftIncident(id, time.Now().UTC(), note); err != nil {
+		return err
+	}
+	if rm.incidentID == id {
+		rm.incidentID = 0
+	}
+	log.Printf("RideManager[%s]: Theft incident %d resolved by the owner.", rm.deviceID, id)
+
+	incident, err := rm.store.GetTheftIncident(id)
+	if err != nil {
+		return fmt.Errorf("theft incident %d resolved but could not be reloaded: %w", id, err)
+	}
+	rm.hub.BroadcastTheftIncident("THEFT_INCIDENT_RESOLVED", incident.TheftIncident)
+	return nil
+}
+
+// OpenTheftIncidentID returns the ID of the device's open theft incident, or 0 if there is none.
+func (rm *RideManager) OpenTheftIncidentID() int64 {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.incidentID
+}
+
+// restoreTheftIncident resumes recording for an incident left open by a previous run.
+// It reports false if the device already has an open incident.
+func (rm *RideManager) restoreTheftIncident(id int64) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.incidentID != 0 {
+		return false
+	}
+	rm.incidentID = id
+	return true
+}
@@ -0,0 +1,106 @@
+package ride
+
+import (
+	"b3/server/models"
+
+	"log"
+	"time"
+)
+
+// maxLockCommands is how many lock commands each manager remembers.
+const maxLockCommands = 20
+
+// RequestLock starts tracking a lock command that was published to the device. It stays
+// pending until the device reports the requested status, or times out after timeout.
+func (rm *RideManager) RequestLock(id int64, status string, timeout time.Duration) models.LockCommand {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	now := time.Now().UTC()
+	command := &models.LockCommand{
+		ID:          id,
+		DeviceID:    rm.deviceID,
+		Status:      status,
+		State:       models.LockCommandPending,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(timeout),
+	}
+	// The shadow sends no delta when desired already matches reported, so the device
+	// will not report again; the command is already in effect.
+	if rm.reportedLock == status {
+		command.State = models.LockCommandConfirmed
+		command.ConfirmedAt = now
+	} else {
+		time.AfterFunc(timeout, func() { rm.expireLockCommand(id) })
+	}
+
+	rm.lockCommands = append(rm.lockCommands, command)
+	if len(rm.lockCommands) > maxLockCommands {
+		rm.lockCommands = rm.lockCommands[len(rm.lockCommands)-maxLockCommands:]
+	}
+	log.Printf("RideManager[%s]: Lock command %d (%s) is %s.", rm.deviceID, id, status, command.State)
+	rm.hub.BroadcastLockCommand(*command)
+	return *command
+}
+
+// ReportLockStatus records the lock status reported by the device and confirms the
+// pending commands that requested it.
+func (rm *RideManager) ReportLockStatus(status string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.reportedLock = status
+	now := time.Now().UTC()
+	for _, command := range rm.lockCommands {
+		if command.State != models.LockCommandPending || command.Status != status {
+			continue
+		}
+		command.State = models.LockCommandConfirmed
+		command.ConfirmedAt = now
+		log.Printf("RideManager[%s]: Lock command %d (%s) confirmed by the device.", rm.deviceID, command.ID, command.Status)
+		rm.hub.BroadcastLockCommand(*command)
+	}
+}
+
+// ReportedLockStatus returns the lock status last reported by the device, or an
+// empty string if it has not reported one.
+func (rm *RideManager) ReportedLockStatus() string {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.reportedLock
+}
+
+// LatestLockCommand returns the most recent lock command, if any.
+func (rm *RideManager) LatestLockCommand() (models.LockCommand, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if len(rm.lockCommands) == 0 {
+		return models.LockCommand{}, false
+	}
+	return *rm.lockCommands[len(rm.lockCommands)-1], true
+}
+
+// LockCommand returns the lock command with the given ID, if it is still remembered.
+func (rm *RideManager) LockCommand(id int64) (models.LockCommand, bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for _, command := range rm.lockCommands {
+		if command.ID == id {
+			return *command, true
+		}
+	}
+	return models.LockCommand{}, false
+}
+
+func (rm *RideManager) expireLockCommand(id int64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for _, command := range rm.lockCommands {
+		if command.ID != id || command.State != models.LockCommandPending {
+			continue
+		}
+		command.State = models.LockCommandTimedOut
+		log.Printf("RideManager[%s]: Lock command %d (%s) timed out waiting for the device.", rm.deviceID, command.ID, command.Status)
+		rm.hub.BroadcastLockCommand(*command)
+	}
+}
@@ -3,40 +3,56 @@ package ride
 import (
 	"b3/server/config"
 	"b3/server/database"
+	"b3/server/geofence"
 	"b3/server/models"
+	"b3/server/writebuffer"
 	"b3/server/ws"
 
-	"database/sql"
 	"log"
 	"sync"
 	"time"
 )
 
-// RideManager handles the business logic of ride tracking.
+// RideManager handles the business logic of ride tracking for a single device.
 type RideManager struct {
 	mu             sync.Mutex
+	deviceID       string // Thing name the manager tracks
 	currentState   RideState
 	currentRideID  int64
 	lastPosition   *models.Position
 	rideStartTime  time.Time
 	pausedSince    time.Time // When the ride entered PAUSED state
 	lastUpdateTime time.Time // Timestamp of the last processed GPS point
-	db             *sql.DB
+	store          database.RideStore
+	positions      *writebuffer.Buffer // Positions are written through this buffer, never directly
 	cfg            config.Config
-	hub            *ws.Hub                                     // WebSocket hub for broadcasting
-	lockStatus     string                                      // Current lock status: "LOCKED" or "UNLOCKED"
-	theftAlertFunc func(lat, lon float64, timestamp time.Time) // Function to call for theft alerts
+	hub            *ws.Hub                                                       // WebSocket hub for broadcasting
+	lockStatus     string                                                        // Current lock status: "LOCKED" or "UNLOCKED"
+	reportedLock   string                                                        // Lock status last reported by the device, empty until it reports
+	lockCommands   []*models.LockCommand                                         // Recent lock commands, oldest first
+	theftAlertFunc func(incidentID int64, lat, lon float64, timestamp time.Time) // Function to call for theft alerts
+	incidentID     int64                                                         // Open theft incident, 0 if none
+	lastCrashAt    time.Time                                                     // When the last crash was reported, zero if none
+
+	// Geofences
+	zones             *geofence.Registry
+	insideZones       map[int64]bool                   // Zones the last point was in, nil until the first point
+	geofenceAlertFunc func(event models.GeofenceEvent) // Function to call for zones with notifications enabled
 }
 
-// NewRideManager creates a new RideManager.
-func NewRideManager(db *sql.DB, appConfig config.Config, hub *ws.Hub) *RideManager { // Added hub parameter
+// NewRideManager creates a new RideManager for the given device. Points are checked against
+// the geofences in zones, which may be nil.
+func NewRideManager(store database.RideStore, positions *writebuffer.Buffer, appConfig config.Config, hub *ws.Hub, zones *geofence.Registry, deviceID string) *RideManager {
 	return &RideManager{
+		deviceID:       deviceID,
 		currentState:   StateIdle,
-		db:             db,
+		store:          store,
+		positions:      positions,
 		cfg:            appConfig,
 		hub:            hub,        // Assign hub
 		lockStatus:     "UNLOCKED", // Initialize to unlocked
 		theftAlertFunc: nil,        // Will be set separately if needed
+		zones:          zones,
 	}
 }
 
@@ -44,7 +60,15 @@ func NewRideManager(db *sql.DB, appConfig config.Config, hub *ws.Hub) *RideManag
 // This is the main entry point for new data from MQTT.
 func (rm *RideManager) HandleGPSData(point models.Position) {
 	rm.mu.Lock()
-	defer rm.mu.Unlock()
+	var incidentID int64         // Set if the point is recorded for a theft incident
+	var crossings []zoneCrossing // Geofences entered or exited with the point
+	defer func() {
+		rm.mu.Unlock()
+		if incidentID != 0 {
+			rm.storeIncidentPosition(incidentID, point)
+		}
+		rm.storeZoneCrossings(crossings)
+	}()
 
 	cfg := rm.cfg // Use the stored config
 
@@ -52,9 +76,9 @@ func (rm *RideManager) HandleGPSData(point models.Position) {
 	// This handles cases where GPS data stops entirely for a while.
 	if rm.currentState != StateIdle && !rm.lastUpdateTime.IsZero() {
 		if ShouldEndRideDueToInactivity(rm.currentState, rm.lastUpdateTime, rm.pausedSince, cfg) {
-			log.Printf("RideManager: Ride %d ending due to inactivity.", rm.currentRideID)
-			rm.endCurrentRide(rm.lastUpdateTime)                           // End ride with the timestamp of the last known point
-			rm.hub.BroadcastRideEnded(rm.currentRideID, rm.lastUpdateTime) // Uncommented
+			log.Printf("RideManager[%s]: Ride %d ending due to inactivity.", rm.deviceID, rm.currentRideID)
+			rm.endCurrentRide(rm.lastUpdateTime)                                        // End ride with the timestamp of the last known point
+			rm.hub.BroadcastRideEnded(rm.deviceID, rm.currentRideID, rm.lastUpdateTime) // Uncommented
 			rm.resetRideState()
 			// After resetting, we might still process the current point if it's a new start
 		}
@@ -63,8 +87,16 @@ func (rm *RideManager) HandleGPSData(point models.Position) {
 	// Update lastUpdateTime with the current point's timestamp
 	rm.lastUpdateTime = point.Timestamp
 
+	// Checked once the point is handled, so leaving a zone is recorded with the ride it started
+	defer func() { crossings = rm.checkGeofences(point) }()
+
 	// Broadcast current location to all WebSocket clients
-	rm.hub.BroadcastCurrentLocation(point)
+	rm.hub.BroadcastCurrentLocation(rm.deviceID, point)
+
+	// Once a theft incident is open, every point is recorded until the owner resolves it
+	if rm.incidentID != 0 {
+		incidentID = rm.recordIncidentPosition(point)
+	}
 
 	// 2. Process the current GPS point using the stateless service logic
 	previousState := rm.currentState
@@ -82,11 +114,22 @@ func (rm *RideManager) HandleGPSData(point models.Position) {
 		if eventType == "ride_started" {
 			// Check if bike is locked - if so, this is potential theft
 			if rm.lockStatus == "LOCKED" {
-				log.Printf("THEFT DETECTION: Movement detected while bike is locked! Location: lat %f, lon %f",
-					point.Latitude, point.Longitude)
-				// Send theft alert if alert function is set
+				// No ride is started in lock mode; the movement is recorded by the theft incident instead
+				rm.currentState = StateIdle
+				rm.lastPosition = &point
+				if rm.incidentID != 0 {
+					return // Already recorded above
+				}
+				log.Printf("THEFT DETECTION: Movement detected while bike %s is locked! Location: lat %f, lon %f",
+					rm.deviceID, point.Latitude, point.Longitude)
+				if err := rm.openTheftIncident(point); err != nil {
+					log.Printf("RideManager[%s]: Failed to open theft incident: %v", rm.deviceID, err)
+				} else {
+					incidentID = rm.recordIncidentPosition(point)
+				}
+				// Send theft alert if alert function is set, without holding up the points that follow
 				if rm.theftAlertFunc != nil {
-					rm.theftAlertFunc(point.Latitude, point.Longitude, point.Timestamp)
+					go rm.theftAlertFunc(rm.incidentID, point.Latitude, point.Longitude, point.Timestamp)
 				}
 				return // Exit early, don't start a ride in lock mode
 			}
@@ -101,14 +144,10 @@ func (rm *RideManager) HandleGPSData(point models.Position) {
 	// If we are tracking or paused, add the point to the current ride
 	if rm.currentState == StateTracking || rm.currentState == StatePaused {
 		if rm.currentRideID != 0 { // Ensure ride has been created
-			err := database.AddPositionToRide(rm.db, rm.currentRideID, point)
-			if err != nil {
-				log.Printf("Error adding position to ride %d: %v", rm.currentRideID, err)
-				// Decide on error handling: continue, try to rollback, etc. For now, just log.
-			} else {
-				rm.hub.BroadcastRidePositionAdded(rm.currentRideID, point) // Uncommented
-				log.Printf("Added position (%f, %f) to ride %d", point.Latitude, point.Longitude, rm.currentRideID)
-			}
+			// Queued, not written yet: the buffer retries and spills to disk if the database is down
+			rm.positions.Add(rm.currentRideID, point)
+			rm.hub.BroadcastRidePositionAdded(rm.deviceID, rm.currentRideID, point) // Uncommented
+			log.Printf("Added position (%f, %f) to ride %d", point.Latitude, point.Longitude, rm.currentRideID)
 		} else {
 			log.Println("Warning: In tracking/paused state but no currentRideID. GPS point not saved.")
 		}
@@ -123,8 +162,8 @@ func (rm *RideManager) HandleGPSData(point models.Position) {
 		// Still in paused state, check if static timeout is exceeded
 		if !rm.pausedSince.IsZero() && time.Now().UTC().Sub(rm.pausedSince) > time.Duration(cfg.RideEndStaticSecs)*time.Second {
 			log.Printf("RideManager: Ride %d ending due to being static for too long (paused). Paused since: %v", rm.currentRideID, rm.pausedSince)
-			rm.endCurrentRide(point.Timestamp)                           // End with current point's timestamp
-			rm.hub.BroadcastRideEnded(rm.currentRideID, point.Timestamp) // Uncommented
+			rm.endCurrentRide(point.Timestamp)                                        // End with current point's timestamp
+			rm.hub.BroadcastRideEnded(rm.deviceID, rm.currentRideID, point.Timestamp) // Uncommented
 			rm.resetRideState()
 		}
 	}
@@ -139,33 +178,43 @@ func (rm *RideManager) HandleGPSData(point models.Position) {
 	rm.lastPosition = &point
 }
 
-// CheckInactivityLoop is intended to be run as a goroutine to periodically
-// check for ride endings due to prolonged inactivity, even if no new GPS points are coming in.
-func (rm *RideManager) CheckInactivityLoop(tickerDuration time.Duration) {
-	ticker := time.NewTicker(tickerDuration)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		rm.mu.Lock()
-		if rm.currentState != StateIdle && !rm.lastUpdateTime.IsZero() {
-			if ShouldEndRideDueToInactivity(rm.currentState, rm.lastUpdateTime, rm.pausedSince, rm.cfg) {
-				log.Printf("RideManager (InactivityLoop): Ride %d ending due to inactivity.", rm.currentRideID)
-
-				// Determine end time: if paused, use pausedSince + static duration, else lastUpdateTime + inactivity duration
-				// Or simply, the last effective point's time before timeout. For now, use lastUpdateTime.
-				endTime := rm.lastUpdateTime
-				if rm.currentState == StatePaused && !rm.pausedSince.IsZero() {
-					// If it ended due to static timeout, the effective end time is when that timeout was breached.
-					// This might be slightly different from just lastUpdateTime if no new points came in.
-					// However, ShouldEndRideDueToInactivity uses time.Now(), so using lastUpdateTime is simpler.
-				}
+// AddLatePosition adds a point that arrived after later points were already processed.
+// It is stored in the current ride if it falls within it, without touching the ride state,
+// last position or theft detection. It reports whether the point was stored.
+func (rm *RideManager) AddLatePosition(point models.Position) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 
-				rm.endCurrentRide(endTime)
-				rm.hub.BroadcastRideEnded(rm.currentRideID, endTime) // Uncommented
-				rm.resetRideState()
+	if rm.currentState == StateIdle || rm.currentRideID == 0 || point.Timestamp.Before(rm.rideStartTime) {
+		return false
+	}
+	rm.positions.Add(rm.currentRideID, point)
+	return true
+}
+
+// checkInactivity ends the current ride if the device has gone quiet for too long.
+// It is called periodically by Fleet.CheckInactivityLoop, even if no new GPS points are coming in.
+func (rm *RideManager) checkInactivity() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.currentState != StateIdle && !rm.lastUpdateTime.IsZero() {
+		if ShouldEndRideDueToInactivity(rm.currentState, rm.lastUpdateTime, rm.pausedSince, rm.cfg) {
+			log.Printf("RideManager[%s] (InactivityLoop): Ride %d ending due to inactivity.", rm.deviceID, rm.currentRideID)
+
+			// Determine end time: if paused, use pausedSince + static duration, else lastUpdateTime + inactivity duration
+			// Or simply, the last effective point's time before timeout. For now, use lastUpdateTime.
+			endTime := rm.lastUpdateTime
+			if rm.currentState == StatePaused && !rm.pausedSince.IsZero() {
+				// If it ended due to static timeout, the effective end time is when that timeout was breached.
+				// This might be slightly different from just lastUpdateTime if no new points came in.
+				// However, ShouldEndRideDueToInactivity uses time.Now(), so using lastUpdateTime is simpler.
 			}
+
+			rm.endCurrentRide(endTime)
+			rm.hub.BroadcastRideEnded(rm.deviceID, rm.currentRideID, endTime) // Uncommented
+			rm.resetRideState()
 		}
-		rm.mu.Unlock()
 	}
 }
 
@@ -174,7 +223,7 @@ func (rm *RideManager) startNewRide(currentPosition models.Position) {
 	rm.rideStartTime = currentPosition.Timestamp
 	rideName := DetermineRideName(rm.rideStartTime, rm.cfg)
 
-	id, err := database.CreateRide(rm.db, rideName, rm.rideStartTime)
+	id, err := rm.store.CreateRide(rm.deviceID, rideName, rm.rideStartTime)
 	if err != nil {
 		log.Printf("Error creating new ride in database: %v", err)
 		rm.resetRideState() // Go back to idle if DB operation fails
@@ -184,17 +233,18 @@ func (rm *RideManager) startNewRide(currentPosition models.Position) {
 	rm.currentState = StateTracking
 	rm.pausedSince = time.Time{} // Clear any previous paused time
 
-	log.Printf("Started new ride: ID %d, Name: %s, StartTime: %v", id, rideName, rm.rideStartTime)
-	rm.hub.BroadcastRideStarted(rm.currentRideID, rideName, rm.rideStartTime, currentPosition) // Uncommented
+	if zone := rm.zoneName(currentPosition); zone != "" {
+		if err := rm.store.SetRideStartZone(id, zone); err != nil {
+			log.Printf("Error storing start zone of ride %d: %v", id, err)
+		}
+	}
+
+	log.Printf("Started new ride for %s: ID %d, Name: %s, StartTime: %v", rm.deviceID, id, rideName, rm.rideStartTime)
+	rm.hub.BroadcastRideStarted(rm.deviceID, rm.currentRideID, rideName, rm.rideStartTime, currentPosition) // Uncommented
 
 	// Add the first point to this new ride
-	err = database.AddPositionToRide(rm.db, rm.currentRideID, currentPosition)
-	if err != nil {
-		log.Printf("Error adding initial position to ride %d: %v", rm.currentRideID, err)
-		// Potentially rollback ride creation or mark it as problematic
-	} else {
-		log.Printf("Added initial position (%f, %f) to ride %d", currentPosition.Latitude, currentPosition.Longitude, rm.currentRideID)
-	}
+	rm.positions.Add(rm.currentRideID, currentPosition)
+	log.Printf("Added initial position (%f, %f) to ride %d", currentPosition.Latitude, currentPosition.Longitude, rm.currentRideID)
 }
 
 func (rm *RideManager) endCurrentRide(endTime time.Time) {
@@ -203,12 +253,73 @@ func (rm *RideManager) endCurrentRide(endTime time.Time) {
 		log.Println("endCurrentRide called but no current ride ID.")
 		return
 	}
-	err := database.EndRide(rm.db, rm.currentRideID, endTime)
+	rm.endRide(rm.currentRideID, endTime, rm.lastPosition)
+}
+
+// endRide stores the end time of a ride, the zone of its last position if known, and computes its stats.
+func (rm *RideManager) endRide(rideID int64, endTime time.Time, lastPosition *models.Position) {
+	err := rm.store.EndRide(rideID, endTime)
+	if err != nil {
+		log.Printf("Error ending ride %d in database: %v", rideID, err)
+		return
+	}
+	log.Printf("Ended ride: ID %d, EndTime: %v", rideID, endTime)
+
+	if lastPosition != nil {
+		if zone := rm.zoneName(*lastPosition); zone != "" {
+			if err := rm.store.SetRideEndZone(rideID, zone); err != nil {
+				log.Printf("Error storing end zone of ride %d: %v", rideID, err)
+			}
+		}
+	}
+
+	// Stats are computed from stored positions, so the buffered ones must be written first.
+	// That happens in the background, without rm.mu, as the database may be slow or down. If
+	// the server stops first, the stats stay empty for the backfill-stats command.
+	rm.positions.OnDrained(func() { rm.storeStats(rideID) })
+}
+
+// storeStats computes and stores the stats of an ended ride. It only uses fields that
+// never change, so it does not need rm.mu.
+func (rm *RideManager) storeStats(rideID int64) {
+	stats, err := UpdateRideStats(rm.store, rideID, rm.cfg)
 	if err != nil {
-		log.Printf("Error ending ride %d in database: %v", rm.currentRideID, err)
-	} else {
-		log.Printf("Ended ride: ID %d, EndTime: %v", rm.currentRideID, endTime)
+		log.Printf("Error computing stats for ride %d: %v", rideID, err)
+		return
 	}
+	log.Printf("Ride %d stats: %.0fm over %ds moving (%ds elapsed), %d points",
+		rideID, stats.DistanceMeters, stats.MovingSeconds, stats.ElapsedSeconds, stats.PointCount)
+}
+
+// RecoverRide restores a ride left open by a previous server run. If its last stored
+// point is no older than RideEndInactivity and no other ride is being tracked, tracking
+// resumes from that point. Otherwise the ride is closed at the time of its last point.
+// It returns true if the ride was resumed.
+func (rm *RideManager) RecoverRide(ride models.RideSummary, lastPosition *models.Position, now time.Time) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	inactivity := time.Duration(rm.cfg.RideEndInactivity) * time.Second
+	if rm.currentRideID == 0 && lastPosition != nil && now.Sub(lastPosition.Timestamp) <= inactivity {
+		rm.currentState = StateTracking
+		rm.currentRideID = ride.ID
+		rm.rideStartTime = ride.StartTime
+		rm.pausedSince = time.Time{}
+		rm.lastUpdateTime = lastPosition.Timestamp
+		last := *lastPosition
+		rm.lastPosition = &last
+		log.Printf("RideManager[%s]: Resumed ride %d from last point at %v.", rm.deviceID, ride.ID, lastPosition.Timestamp)
+		return true
+	}
+
+	// A ride without points never got past its first moment
+	endTime := ride.StartTime
+	if lastPosition != nil {
+		endTime = lastPosition.Timestamp
+	}
+	log.Printf("RideManager[%s]: Closing ride %d left open by a previous run, last point at %v.", rm.deviceID, ride.ID, endTime)
+	rm.endRide(ride.ID, endTime, lastPosition)
+	return false
 }
 
 func (rm *RideManager) resetRideState() {
@@ -217,7 +328,7 @@ func (rm *RideManager) resetRideState() {
 	rm.currentRideID = 0
 	rm.rideStartTime = time.Time{}
 	rm.pausedSince = time.Time{}
-	log.Println("RideManager state reset to Idle.")
+	log.Printf("RideManager[%s] state reset to Idle.", rm.deviceID)
 }
 
 // SetLockStatus updates the lock status of the bike
@@ -225,7 +336,12 @@ func (rm *RideManager) SetLockStatus(status string) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 	rm.lockStatus = status
-	log.Printf("Lock status updated to: %s", status)
+	log.Printf("Lock status for %s updated to: %s", rm.deviceID, status)
+}
+
+// DeviceID returns the device this manager tracks
+func (rm *RideManager) DeviceID() string {
+	return rm.deviceID
 }
 
 // GetLockStatus returns the current lock status
@@ -236,7 +352,7 @@ func (rm *RideManager) GetLockStatus() string {
 }
 
 // SetTheftAlertFunc sets the function to call when theft is detected
-func (rm *RideManager) SetTheftAlertFunc(alertFunc func(lat, lon float64, timestamp time.Time)) {
+func (rm *RideManager) SetTheftAlertFunc(alertFunc func(incidentID int64, lat, lon float64, timestamp time.Time)) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 	rm.theftAlertFunc = alertFunc
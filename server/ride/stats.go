@@ -0,0 +1,64 @@
+package ride
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/models"
+	"b3/server/util"
+	"time"
+)
+
+// ComputeRideStats derives distance, timing and speed statistics from a ride's positions.
+// Positions must be in timestamp order. A segment counts towards moving time when the
+// speed implied by its distance and duration is at least cfg.MovingSpeedKnots.
+func ComputeRideStats(startTime, endTime time.Time, positions []models.Position, cfg config.Config) models.RideStats {
+	stats := models.RideStats{
+		PointCount: len(positions),
+	}
+	if !endTime.IsZero() && endTime.After(startTime) {
+		stats.ElapsedSeconds = int64(endTime.Sub(startTime).Seconds())
+	}
+
+	var movingDuration time.Duration
+	for i, pos := range positions {
+		if pos.SpeedKnots > stats.MaxSpeedKnots {
+			stats.MaxSpeedKnots = pos.SpeedKnots
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := positions[i-1]
+		distance := util.HaversineDistance(prev.Latitude, prev.Longitude, pos.Latitude, pos.Longitude)
+		stats.DistanceMeters += distance
+
+		dt := pos.Timestamp.Sub(prev.Timestamp)
+		if dt <= 0 {
+			continue
+		}
+		segmentSpeedKnots := distance / dt.Seconds() * util.MetersPerSecondToKnots
+		if segmentSpeedKnots >= cfg.MovingSpeedKnots {
+			movingDuration += dt
+		}
+	}
+
+	stats.MovingSeconds = int64(movingDuration.Seconds())
+	if movingDuration > 0 {
+		stats.AvgSpeedKnots = stats.DistanceMeters / movingDuration.Seconds() * util.MetersPerSecondToKnots
+	}
+	return stats
+}
+
+// UpdateRideStats recomputes the statistics of a finished ride from its stored
+// positions and saves them with the ride.
+func UpdateRideStats(store database.RideStore, rideID int64, cfg config.Config) (models.RideStats, error) {
+	detail, err := store.GetRideDetails(rideID)
+	if err != nil {
+		return models.RideStats{}, err
+	}
+	stats := ComputeRideStats(detail.StartTime, detail.EndTime, detail.Positions, cfg)
+	if err := store.SaveRideStats(rideID, stats); err != nil {
+		return models.RideStats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,93 @@
+package share
+
+import (
+	"b3/server/config"
+	"b3/server/models"
+	"b3/server/ride"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned by Signer.Parse for tokens it did not sign.
+var ErrInvalidToken = errors.New("invalid share token")
+
+// Signer creates and checks share tokens. A token is "<share ID>.<expiry>.<signature>",
+// with the expiry in Unix seconds and an HMAC-SHA256 signature of the first two parts, so
+// forged or altered tokens are rejected without a database lookup.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a Signer with the configured key. Without one a random key is used,
+// which makes every link stop working when the server restarts.
+func NewSigner(cfg config.Config) *Signer {
+	if cfg.ShareSigningKey != "" {
+		return &Signer{key: []byte(cfg.ShareSigningKey)}
+	}
+	log.Println("share_signing_key is not set, share links will stop working when the server restarts")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate share signing key: %v", err)
+	}
+	return &Signer{key: key}
+}
+
+// Token returns the token of a share link. The same share always gets the same token.
+func (s *Signer) Token(share models.RideShare) string {
+	payload := strconv.FormatInt(share.ID, 10) + "." + strconv.FormatInt(share.ExpiresAt.Unix(), 10)
+	return payload + "." + s.sign(payload)
+}
+
+// Parse checks the signature of a token and returns the share ID and expiry it carries.
+// It does not check whether the share expired or was revoked.
+func (s *Signer) Parse(token string) (int64, time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(s.sign(payload))) {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, ErrInvalidToken
+	}
+	return id, time.Unix(expires, 0).UTC(), nil
+}
+
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// View builds the public version of a ride for a share link: the positions without the
+// trimmed start and end, and stats and times of what is left. Positions must be in
+// timestamp order.
+func View(detail *models.RideDetail, share models.RideShare, cfg config.Config) models.SharedRide {
+	positions := Trim(detail.Positions, share.TrimStartMeters, share.TrimEndMeters)
+	view := models.SharedRide{
+		Name:      detail.Name,
+		Positions: positions,
+		ExpiresAt: share.ExpiresAt,
+	}
+	if len(positions) > 0 {
+		view.StartTime = positions[0].Timestamp
+		view.EndTime = positions[len(positions)-1].Timestamp
+	}
+	stats := ride.ComputeRideStats(view.StartTime, view.EndTime, positions, cfg)
+	view.Stats = &stats
+	return view
+}
@@ -0,0 +1,109 @@
+package share
+
+import (
+	"b3/server/models"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	signer := &Signer{key: []byte("test key")}
+	expiresAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	share := models.RideShare{ID: 42, ExpiresAt: expiresAt}
+
+	token := signer.Token(share)
+	if again := signer.Token(share); again != token {
+		t.Errorf("second token %q differs from %q", again, token)
+	}
+	id, expires, err := signer.Parse(token)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", token, err)
+	}
+	if id != 42 || !expires.Equal(expiresAt) {
+		t.Errorf("Parse = %d, %v, want 42, %v", id, expires, expiresAt)
+	}
+}
+
+func TestSignerRejects(t *testing.T) {
+	signer := &Signer{key: []byte("test key")}
+	token := signer.Token(models.RideShare{ID: 42, ExpiresAt: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)})
+	parts := strings.Split(token, ".")
+
+	// A valid signature for other contents, to check that a signature isn't accepted for them
+	other := strings.Split(signer.Token(models.RideShare{ID: 43, ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}), ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other ID", "43." + parts[1] + "." + parts[2]},
+		{"later expiry", parts[0] + "." + other[1] + "." + parts[2]},
+		{"signature of another share", parts[0] + "." + parts[1] + "." + other[2]},
+		{"altered signature", parts[0] + "." + parts[1] + "." + flipLast(parts[2])},
+		{"no signature", parts[0] + "." + parts[1] + "."},
+		{"too few parts", parts[0] + "." + parts[2]},
+		{"too many parts", token + ".x"},
+		{"empty", ""},
+		{"signed by another key", (&Signer{key: []byte("other key")}).Token(models.RideShare{ID: 42, ExpiresAt: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)})},
+		{"signed non-numeric ID", signed(signer, "abc."+parts[1])},
+		{"signed non-numeric expiry", signed(signer, parts[0]+".soon")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := signer.Parse(tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Parse(%q) error %v, want ErrInvalidToken", tt.token, err)
+			}
+		})
+	}
+}
+
+// TestExpiredToken checks that an expired share still parses, so the handler can tell it
+// apart from a forged one, and that the share is then not active.
+func TestExpiredToken(t *testing.T) {
+	signer := &Signer{key: []byte("test key")}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	share := models.RideShare{ID: 7, ExpiresAt: now.Add(-time.Minute)}
+
+	_, expires, err := signer.Parse(signer.Token(share))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !expires.Before(now) {
+		t.Errorf("expiry %v, want before %v", expires, now)
+	}
+
+	tests := []struct {
+		name  string
+		share models.RideShare
+		want  bool
+	}{
+		{"active", models.RideShare{ExpiresAt: now.Add(time.Second)}, true},
+		{"expired", share, false},
+		{"expires now", models.RideShare{ExpiresAt: now}, false},
+		{"revoked", models.RideShare{ExpiresAt: now.Add(time.Hour), RevokedAt: now.Add(-time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.share.Active(now); got != tt.want {
+				t.Errorf("Active = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// signed returns payload with a valid signature.
+func signed(s *Signer, payload string) string {
+	return payload + "." + s.sign(payload)
+}
+
+// flipLast changes the last character of a base64 string to another valid one.
+func flipLast(s string) string {
+	last := s[len(s)-1]
+	replacement := byte('A')
+	if last == 'A' {
+		replacement = 'B'
+	}
+	return s[:len(s)-1] + string(replacement)
+}
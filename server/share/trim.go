@@ -0,0 +1,31 @@
+package share
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+)
+
+// Trim drops the positions within startMeters of the start of a track and within endMeters
+// of its end, measured along the track, so a shared ride doesn't lead back to where it
+// began or ended. Tracks shorter than both together come back empty.
+func Trim(positions []models.Position, startMeters, endMeters float64) []models.Position {
+	if len(positions) == 0 || (startMeters <= 0 && endMeters <= 0) {
+		return append([]models.Position(nil), positions...)
+	}
+
+	// along[i] is the distance from the first position to position i
+	along := make([]float64, len(positions))
+	for i := 1; i < len(positions); i++ {
+		prev, pos := positions[i-1], positions[i]
+		along[i] = along[i-1] + util.HaversineDistance(prev.Latitude, prev.Longitude, pos.Latitude, pos.Longitude)
+	}
+	total := along[len(along)-1]
+
+	trimmed := []models.Position{}
+	for i, pos := range positions {
+		if along[i] >= startMeters && total-along[i] >= endMeters {
+			trimmed = append(trimmed, pos)
+		}
+	}
+	return trimmed
+}
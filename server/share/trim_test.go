@@ -0,0 +1,71 @@
+package share
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"slices"
+	"testing"
+	"time"
+)
+
+// track returns n positions heading north, each about 100 meters after the one before.
+func track(n int) []models.Position {
+	start := time.Date(2025, 5, 28, 12, 0, 0, 0, time.UTC)
+	positions := make([]models.Position, n)
+	for i := range positions {
+		positions[i] = models.Position{
+			Latitude:  52 + float64(i)*0.0009,
+			Longitude: 4,
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return positions
+}
+
+func TestTrim(t *testing.T) {
+	// Positions are about 100 meters apart, so a trim of 150 meters drops two of them
+	positions := track(10)
+	if step := util.HaversineDistance(positions[0].Latitude, 4, positions[1].Latitude, 4); step < 99 || step > 101 {
+		t.Fatalf("positions are %.1f meters apart, want about 100", step)
+	}
+
+	tests := []struct {
+		name        string
+		positions   []models.Position
+		start, end  float64
+		wantIndexes []int // Of the positions kept
+	}{
+		{"no trim", positions, 0, 0, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"start", positions, 150, 0, []int{2, 3, 4, 5, 6, 7, 8, 9}},
+		{"end", positions, 0, 150, []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"both", positions, 150, 250, []int{2, 3, 4, 5, 6}},
+		{"exactly at a position", positions, util.HaversineDistance(52, 4, positions[1].Latitude, 4), 0, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"start longer than the track", positions, 2000, 0, nil},
+		{"end longer than the track", positions, 0, 2000, nil},
+		{"shorter than both together", positions, 500, 500, nil},
+		{"single position", positions[:1], 0, 100, nil},
+		{"empty", nil, 100, 100, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Trim(tt.positions, tt.start, tt.end)
+			var want []models.Position
+			for _, i := range tt.wantIndexes {
+				want = append(want, positions[i])
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("Trim kept %d positions %v, want %v", len(got), got, tt.wantIndexes)
+			}
+		})
+	}
+}
+
+// TestTrimCopies checks that the result can be changed without changing the ride.
+func TestTrimCopies(t *testing.T) {
+	positions := track(3)
+	trimmed := Trim(positions, 0, 0)
+	trimmed[0].Latitude = 0
+	if positions[0].Latitude == 0 {
+		t.Error("changing the trimmed track changed the original")
+	}
+}
@@ -0,0 +1,67 @@
+package simplify
+
+import (
+	"b3/server/models"
+	"container/list"
+	"sync"
+)
+
+// CacheKey identifies one simplified version of a ride.
+type CacheKey struct {
+	RideID  int64
+	Options Options
+}
+
+// Cache is a fixed-size LRU cache of simplified rides. Only finished rides with all their
+// positions written should be stored since their positions no longer change.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[CacheKey]*list.Element
+	order    *list.List // Front is most recently used
+}
+
+type cacheEntry struct {
+	key  CacheKey
+	ride *models.RideDetail
+}
+
+// NewCache creates a Cache holding at most capacity simplified rides.
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[CacheKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns a cached simplified ride.
+func (c *Cache) Get(key CacheKey) (*models.RideDetail, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).ride, true
+}
+
+// Put stores a simplified ride, evicting the least recently used entry if full.
+func (c *Cache) Put(key CacheKey, ride *models.RideDetail) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).ride = ride
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, ride: ride})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
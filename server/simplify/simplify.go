@@ -0,0 +1,247 @@
+package simplify
+
+import (
+	"b3/server/models"
+	"b3/server/util"
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Method is a line simplification algorithm.
+type Method string
+
+const (
+	DouglasPeucker Method = "dp" // Drops points closer than the tolerance to the simplified line
+	Visvalingam    Method = "vw" // Drops points whose triangle area is below tolerance²
+)
+
+// ParseMethod validates a method name, defaulting to Douglas-Peucker.
+func ParseMethod(name string) (Method, error) {
+	switch strings.ToLower(name) {
+	case "", "dp", "douglas-peucker":
+		return DouglasPeucker, nil
+	case "vw", "visvalingam":
+		return Visvalingam, nil
+	default:
+		return "", fmt.Errorf("unsupported simplification method %q, must be dp or vw", name)
+	}
+}
+
+// Options controls how a track is simplified. A zero ToleranceMeters skips the
+// tolerance pass and a zero MaxPoints means no limit.
+type Options struct {
+	Method           Method
+	ToleranceMeters  float64
+	MaxPoints        int
+	MovingSpeedKnots float64 // Segments slower than this are stops; their boundaries are always kept
+}
+
+// Track simplifies positions, always keeping the first and last point and the
+// points where the rider stops or starts moving again.
+func Track(positions []models.Position, opts Options) []models.Position {
+	n := len(positions)
+	if n <= 2 {
+		return positions
+	}
+
+	xs, ys := project(positions)
+	keep := stopBoundaries(positions, opts.MovingSpeedKnots)
+	keep[0], keep[n-1] = true, true
+
+	var importance []float64
+	threshold := opts.ToleranceMeters
+	switch opts.Method {
+	case Visvalingam:
+		importance = visvalingamImportance(xs, ys, keep)
+		threshold = opts.ToleranceMeters * opts.ToleranceMeters
+	default:
+		importance = douglasPeuckerImportance(xs, ys, keep)
+	}
+
+	// Pick the most important points that pass the tolerance, up to MaxPoints.
+	// Forced points have infinite importance so they always survive.
+	order := make([]int, 0, n)
+	for i := range positions {
+		if importance[i] >= threshold {
+			order = append(order, i)
+		}
+	}
+	if opts.MaxPoints > 0 && len(order) > opts.MaxPoints {
+		sort.SliceStable(order, func(a, b int) bool { return importance[order[a]] > importance[order[b]] })
+		limit := opts.MaxPoints
+		// Never drop forced points, even if that exceeds MaxPoints
+		for limit < len(order) && math.IsInf(importance[order[limit]], 1) {
+			limit++
+		}
+		order = order[:limit]
+		sort.Ints(order)
+	}
+
+	simplified := make([]models.Position, 0, len(order))
+	for _, i := range order {
+		simplified = append(simplified, positions[i])
+	}
+	return simplified
+}
+
+// project converts positions to local x/y meters around the first point using
+// an equirectangular projection, which is accurate enough over a ride.
+func project(positions []models.Position) ([]float64, []float64) {
+	const earthRadius = 6371e3
+	lat0 := positions[0].Latitude * math.Pi / 180
+	xs := make([]float64, len(positions))
+	ys := make([]float64, len(positions))
+	for i, pos := range positions {
+		xs[i] = (pos.Longitude - positions[0].Longitude) * math.Pi / 180 * earthRadius * math.Cos(lat0)
+		ys[i] = (pos.Latitude - positions[0].Latitude) * math.Pi / 180 * earthRadius
+	}
+	return xs, ys
+}
+
+// stopBoundaries marks the points where the rider goes from moving to stopped or back,
+// using the same segment speed rule as ride stats.
+func stopBoundaries(positions []models.Position, movingSpeedKnots float64) []bool {
+	keep := make([]bool, len(positions))
+	if movingSpeedKnots <= 0 {
+		return keep
+	}
+	prevMoving := true
+	for i := 1; i < len(positions); i++ {
+		prev, pos := positions[i-1], positions[i]
+		dt := pos.Timestamp.Sub(prev.Timestamp).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		distance := util.HaversineDistance(prev.Latitude, prev.Longitude, pos.Latitude, pos.Longitude)
+		moving := distance/dt*util.MetersPerSecondToKnots >= movingSpeedKnots
+		if moving != prevMoving {
+			keep[i-1] = true
+		}
+		prevMoving = moving
+	}
+	return keep
+}
+
+// douglasPeuckerImportance returns, for every point, the largest tolerance at which
+// Douglas-Peucker would still keep it. The track is split at forced points first.
+func douglasPeuckerImportance(xs, ys []float64, keep []bool) []float64 {
+	importance := make([]float64, len(xs))
+	start := 0
+	for i := range xs {
+		if !keep[i] {
+			continue
+		}
+		importance[i] = math.Inf(1)
+		if i > start {
+			dpRank(xs, ys, start, i, math.Inf(1), importance)
+		}
+		start = i
+	}
+	return importance
+}
+
+func dpRank(xs, ys []float64, first, last int, parent float64, importance []float64) {
+	if last-first < 2 {
+		return
+	}
+	maxDist, index := -1.0, first
+	for i := first + 1; i < last; i++ {
+		d := segmentDistance(xs[i], ys[i], xs[first], ys[first], xs[last], ys[last])
+		if d > maxDist {
+			maxDist, index = d, i
+		}
+	}
+	// A point can't outrank the split that exposed it
+	rank := math.Min(maxDist, parent)
+	importance[index] = rank
+	dpRank(xs, ys, first, index, rank, importance)
+	dpRank(xs, ys, index, last, rank, importance)
+}
+
+// segmentDistance returns the distance from (px, py) to the segment (ax, ay)-(bx, by).
+func segmentDistance(px, py, ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	if dx == 0 && dy == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	t := ((px-ax)*dx + (py-ay)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
+
+// visvalingamImportance returns each point's effective area in m²: the area of the
+// triangle it forms with its neighbours at the moment Visvalingam-Whyatt removes it.
+func visvalingamImportance(xs, ys []float64, keep []bool) []float64 {
+	n := len(xs)
+	importance := make([]float64, n)
+	prev := make([]int, n)
+	next := make([]int, n)
+	items := make([]*vwItem, n)
+	h := &vwHeap{}
+	for i := 0; i < n; i++ {
+		prev[i], next[i] = i-1, i+1
+		if keep[i] {
+			importance[i] = math.Inf(1)
+			continue
+		}
+		items[i] = &vwItem{index: i, area: triangleArea(xs, ys, i-1, i, i+1)}
+		heap.Push(h, items[i])
+	}
+
+	maxArea := 0.0
+	for h.Len() > 0 {
+		item := heap.Pop(h).(*vwItem)
+		i := item.index
+		// Keep areas monotonic so removing a point never makes a neighbour look less important
+		maxArea = math.Max(maxArea, item.area)
+		importance[i] = maxArea
+
+		p, nx := prev[i], next[i]
+		next[p], prev[nx] = nx, p
+		for _, j := range []int{p, nx} {
+			if items[j] != nil && items[j].heapIndex >= 0 {
+				items[j].area = triangleArea(xs, ys, prev[j], j, next[j])
+				heap.Fix(h, items[j].heapIndex)
+			}
+		}
+	}
+	return importance
+}
+
+func triangleArea(xs, ys []float64, a, b, c int) float64 {
+	return math.Abs((xs[b]-xs[a])*(ys[c]-ys[a])-(xs[c]-xs[a])*(ys[b]-ys[a])) / 2
+}
+
+type vwItem struct {
+	index     int
+	area      float64
+	heapIndex int
+}
+
+// vwHeap is a min-heap of points by triangle area.
+type vwHeap []*vwItem
+
+func (h vwHeap) Len() int           { return len(h) }
+func (h vwHeap) Less(i, j int) bool { return h[i].area < h[j].area }
+func (h vwHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *vwHeap) Push(x interface{}) {
+	item := x.(*vwItem)
+	item.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *vwHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	item.heapIndex = -1
+	*h = old[:len(old)-1]
+	return item
+}
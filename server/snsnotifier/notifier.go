@@ -47,6 +47,29 @@ func NewNotifier(ctx context.Context) (*Notifier, error) {
 	return notifier, nil
 }
 
+// NewNotifierWithOptions creates a new SNS notifier like NewNotifier, for a specific region
+// and endpoint when they are not empty. A custom endpoint lets it talk to a local stand-in
+// such as LocalStack.
+func NewNotifierWithOptions(ctx context.Context, region, endpoint string) (*Notifier, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+
+	snsClient := sns.NewFromConfig(cfg, func(o *sns.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	log.Println("SNS Notifier initialized successfully")
+	return &Notifier{snsClient: snsClient, ctx: ctx}, nil
+}
+
 // NewNotifierWithConfig creates a new SNS notifier with custom AWS configuration
 func NewNotifierWithConfig(ctx context.Context, awsConfig aws.Config) *Notifier {
 	snsClient := sns.NewFromConfig(awsConfig)
@@ -98,7 +121,7 @@ func (n *Notifier) PublishMessage(msg NotificationMessage) error {
 		return fmt.Errorf("failed to publish SNS message: %w", err)
 	}
 
-	log.Printf("Successfully published message to topic %s, MessageId: %s", msg.TopicArn, *result.MessageId)
+	log.Printf("Successfully published message to topic %s, MessageId: %s", msg.TopicArn, aws.ToString(result.MessageId))
 	return nil
 }
 
@@ -138,6 +161,21 @@ func (n *Notifier) PublishWithFilter(topicArn, message string, attributes map[st
 	})
 }
 
+// PublishSMS sends a text message straight to a phone number in E.164 format, without a topic
+func (n *Notifier) PublishSMS(phoneNumber, message string) error {
+	result, err := n.snsClient.Publish(n.ctx, &sns.PublishInput{
+		PhoneNumber: aws.String(phoneNumber),
+		Message:     aws.String(message),
+	})
+	if err != nil {
+		log.Printf("Failed to send SMS to %s: %v", phoneNumber, err)
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+
+	log.Printf("Successfully sent SMS to %s, MessageId: %s", phoneNumber, aws.ToString(result.MessageId))
+	return nil
+}
+
 // ListTopics returns all SNS topics in the account
 func (n *Notifier) ListTopics() ([]string, error) {
 	var topicArns []string
@@ -2,6 +2,9 @@ package util
 
 import "math"
 
+// MetersPerSecondToKnots converts a speed in m/s to knots, nautical miles of 1852 meters per hour.
+const MetersPerSecondToKnots = 3600.0 / 1852.0
+
 // haversineDistance calculates the distance between two GPS coordinates
 // (lat1, lon1) and (lat2, lon2) in meters.
 // Latitude and longitude are expected in degrees.
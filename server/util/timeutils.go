@@ -17,53 +17,70 @@ func init() {
 	pstLocation = time.FixedZone("PST", pstOffsetSeconds)
 }
 
-// CombineDateTime takes a baseTime (primarily for its date part in UTC)
-// and a timeStr in "HHMMSS.SS" format (assumed UTC),
-// and returns a new time.Time object in UTC.
-func CombineDateTime(baseTime time.Time, timeStr string) (time.Time, error) {
-	parts := strings.Split(timeStr, ".")
-	if len(parts) == 0 {
-		return time.Time{}, fmt.Errorf("invalid time string format: %s", timeStr)
-	}
-	hmsStr := parts[0]
+// ParseTimeOfDay parses a UTC time of day in "HHMMSS" or "HHMMSS.SS" format, as sent
+// by GPS receivers, into the time elapsed since midnight. Any number of fractional
+// digits is accepted.
+func ParseTimeOfDay(timeStr string) (time.Duration, error) {
+	hmsStr, fracStr, _ := strings.Cut(timeStr, ".")
 	if len(hmsStr) != 6 {
-		return time.Time{}, fmt.Errorf("invalid HHMSS part of time string: %s", hmsStr)
+		return 0, fmt.Errorf("invalid HHMSS part of time string: %s", hmsStr)
 	}
 
 	hour, err := strconv.Atoi(hmsStr[0:2])
-	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid hour: %s", hmsStr[0:2])
+	if err != nil || hour > 23 {
+		return 0, fmt.Errorf("invalid hour: %s", hmsStr[0:2])
 	}
 	minute, err := strconv.Atoi(hmsStr[2:4])
-	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid minute: %s", hmsStr[2:4])
+	if err != nil || minute > 59 {
+		return 0, fmt.Errorf("invalid minute: %s", hmsStr[2:4])
 	}
 	second, err := strconv.Atoi(hmsStr[4:6])
-	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid second: %s", hmsStr[4:6])
+	if err != nil || second > 60 { // 60 allows for a leap second
+		return 0, fmt.Errorf("invalid second: %s", hmsStr[4:6])
 	}
 
-	var millisecond int
-	if len(parts) > 1 && len(parts[1]) > 0 {
-		centiSeconds, err := strconv.Atoi(parts[1])
-		if err != nil {
-			if len(parts[1]) == 3 {
-				millisecond, err = strconv.Atoi(parts[1])
-				if err != nil {
-					return time.Time{}, fmt.Errorf("invalid fractional second: %s", parts[1])
-				}
-			} else {
-				return time.Time{}, fmt.Errorf("invalid fractional second: %s", parts[1])
-			}
-		} else {
-			millisecond = centiSeconds * 10 // convert centiseconds to milliseconds
+	var fraction time.Duration
+	if fracStr != "" {
+		// "5" is half a second and "05" five hundredths, so scale by the number of digits
+		digits, err := strconv.ParseUint(fracStr, 10, 64)
+		if err != nil || len(fracStr) > 9 {
+			return 0, fmt.Errorf("invalid fractional second: %s", fracStr)
+		}
+		fraction = time.Duration(digits)
+		for i := len(fracStr); i < 9; i++ {
+			fraction *= 10
 		}
 	}
 
-	return time.Date(
-		baseTime.Year(), baseTime.Month(), baseTime.Day(),
-		hour, minute, second, millisecond*int(time.Millisecond),
-		time.UTC), nil
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(second)*time.Second + fraction, nil
+}
+
+// CombineDateTime takes a baseTime, usually when the point was delivered, and a
+// timeStr in "HHMMSS.SS" format (assumed UTC), and returns the UTC time on the day
+// closest to baseTime. A point recorded at 23:59:50 and delivered at 00:00:05 the
+// next day is dated the previous day, and one from 00:00:05 compared against
+// 23:59:50 is dated the next day.
+func CombineDateTime(baseTime time.Time, timeStr string) (time.Time, error) {
+	timeOfDay, err := ParseTimeOfDay(timeStr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return NearestTimeOfDay(baseTime, timeOfDay), nil
+}
+
+// NearestTimeOfDay returns the UTC time with the given time of day that is closest
+// to baseTime, which is on the day before, of, or after baseTime.
+func NearestTimeOfDay(baseTime time.Time, timeOfDay time.Duration) time.Time {
+	baseTime = baseTime.UTC()
+	midnight := time.Date(baseTime.Year(), baseTime.Month(), baseTime.Day(), 0, 0, 0, 0, time.UTC)
+	best := midnight.Add(timeOfDay)
+	for _, candidate := range []time.Time{best.AddDate(0, 0, -1), best.AddDate(0, 0, 1)} {
+		if candidate.Sub(baseTime).Abs() < best.Sub(baseTime).Abs() {
+			best = candidate
+		}
+	}
+	return best
 }
 
 // GetRideName determines the ride name based on the start time in PST.
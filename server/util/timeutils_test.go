@@ -0,0 +1,99 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr string
+	}{
+		{in: "000000", want: 0},
+		{in: "123519", want: 12*time.Hour + 35*time.Minute + 19*time.Second},
+		{in: "235959", want: 24*time.Hour - time.Second},
+		{in: "235960", want: 24 * time.Hour}, // Leap second
+		{in: "120000.5", want: 12*time.Hour + 500*time.Millisecond},
+		{in: "120000.05", want: 12*time.Hour + 50*time.Millisecond},
+		{in: "120000.123456789", want: 12*time.Hour + 123456789},
+		{in: "120000.", want: 12 * time.Hour},
+		{in: "", wantErr: "invalid HHMSS"},
+		{in: "12000", wantErr: "invalid HHMSS"},
+		{in: "1200000", wantErr: "invalid HHMSS"},
+		{in: "240000", wantErr: "invalid hour"},
+		{in: "126000", wantErr: "invalid minute"},
+		{in: "120061", wantErr: "invalid second"},
+		{in: "12a000", wantErr: "invalid minute"},
+		{in: "120000.x", wantErr: "invalid fractional second"},
+		{in: "120000.1234567890", wantErr: "invalid fractional second"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTimeOfDay(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseTimeOfDay(%q) error %v, want one mentioning %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTimeOfDay(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimeOfDay(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearestTimeOfDay(t *testing.T) {
+	day := func(d, h, m, s int) time.Time { return time.Date(2025, 5, d, h, m, s, 0, time.UTC) }
+	clock := func(h, m, s int) time.Duration {
+		return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	}
+
+	tests := []struct {
+		name      string
+		base      time.Time
+		timeOfDay time.Duration
+		want      time.Time
+	}{
+		{"same day", day(28, 12, 0, 5), clock(12, 0, 0), day(28, 12, 0, 0)},
+		{"same day, later", day(28, 12, 0, 0), clock(12, 0, 5), day(28, 12, 0, 5)},
+		{"recorded before midnight, base after", day(29, 0, 0, 5), clock(23, 59, 50), day(28, 23, 59, 50)},
+		{"recorded after midnight, base before", day(28, 23, 59, 50), clock(0, 0, 5), day(29, 0, 0, 5)},
+		{"rollover into the next month", day(31, 23, 59, 0), clock(0, 1, 0), time.Date(2025, 6, 1, 0, 1, 0, 0, time.UTC)},
+		{"half a day back stays on the day", day(28, 23, 0, 0), clock(11, 30, 0), day(28, 11, 30, 0)},
+		{
+			"base in another zone",
+			time.Date(2025, 5, 28, 17, 0, 5, 0, time.FixedZone("PDT", -7*60*60)), // 00:00:05 UTC on the 29th
+			clock(23, 59, 50),
+			day(28, 23, 59, 50),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NearestTimeOfDay(tt.base, tt.timeOfDay)
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("NearestTimeOfDay(%v, %v) = %v, want %v", tt.base, tt.timeOfDay, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombineDateTime(t *testing.T) {
+	base := time.Date(2025, 5, 29, 0, 0, 5, 0, time.UTC)
+	got, err := CombineDateTime(base, "235950.25")
+	if err != nil {
+		t.Fatalf("CombineDateTime: %v", err)
+	}
+	if want := time.Date(2025, 5, 28, 23, 59, 50, 250e6, time.UTC); !got.Equal(want) {
+		t.Errorf("CombineDateTime = %v, want %v", got, want)
+	}
+	if _, err := CombineDateTime(base, "2359"); err == nil {
+		t.Error("CombineDateTime accepted a short time")
+	}
+}
@@ -0,0 +1,361 @@
+package writebuffer
+
+import (
+	"b3/server/config"
+	"b3/server/database"
+	"b3/server/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Options controls batching, retries and spilling.
+type Options struct {
+	BatchSize       int           // Points per batch insert
+	FlushInterval   time.Duration // How often buffered points are written, and the first retry delay
+	MaxBackoff      time.Duration // Longest wait between retries
+	MaxMemoryPoints int           // Points kept in memory before they are spilled to disk
+	QueuePath       string        // On-disk queue file, empty keeps everything in memory
+}
+
+// OptionsFromConfig reads the position buffer settings from the app config.
+func OptionsFromConfig(cfg config.Config) Options {
+	opts := Options{
+		BatchSize:       cfg.PositionBatchSize,
+		FlushInterval:   time.Duration(cfg.PositionFlushIntervalMillis) * time.Millisecond,
+		MaxBackoff:      time.Duration(cfg.PositionRetryMaxSeconds) * time.Second,
+		MaxMemoryPoints: cfg.PositionBufferMaxPoints,
+		QueuePath:       cfg.PositionQueuePath,
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.MaxBackoff < opts.FlushInterval {
+		opts.MaxBackoff = opts.FlushInterval
+	}
+	return opts
+}
+
+// Stats reports the state of the buffer.
+type Stats struct {
+	QueueDepth          int       `json:"queue_depth"` // Points not yet written, in memory and on disk
+	InMemory            int       `json:"in_memory"`
+	OnDisk              int       `json:"on_disk"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastFlushAt         time.Time `json:"last_flush_at,omitzero"` // Last time the queue was fully written
+	NextRetryAt         time.Time `json:"next_retry_at,omitzero"` // Set while backing off after a failure
+}
+
+// Buffer queues ride positions and writes them to the store in batches from a
+// background goroutine, so GPS processing never waits on the database.
+//
+// While the store is unavailable, writes are retried with exponential backoff. Points
+// beyond MaxMemoryPoints, and everything still queued on Close, are spilled to an
+// on-disk queue that is written first on the next flush, including after a restart.
+type Buffer struct {
+	store database.RideStore
+	opts  Options
+	queue *diskQueue // nil if spilling is disabled
+
+	mu          sync.Mutex
+	pending     []database.RidePosition // Oldest first, all newer than what is on disk
+	onDisk      int
+	failures    int
+	lastErr     error
+	lastFlushAt time.Time
+	nextRetryAt time.Time
+	drained     []func() // Waiting for the queue to be empty, see OnDrained
+
+	flushMu sync.Mutex // Serialises writes to the store and the disk queue
+
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a Buffer, picking up points left in the on-disk queue by a previous run.
+// Call Start to begin writing in the background.
+func New(store database.RideStore, opts Options) (*Buffer, error) {
+	b := &Buffer{
+		store: store,
+		opts:  opts,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if opts.QueuePath != "" {
+		b.queue = &diskQueue{path: opts.QueuePath}
+		spilled, err := b.queue.Load()
+		if err != nil {
+			return nil, err
+		}
+		b.onDisk = len(spilled)
+		if b.onDisk > 0 {
+			log.Printf("Position buffer: %d points waiting in %s from a previous run", b.onDisk, opts.QueuePath)
+		}
+	}
+	return b, nil
+}
+
+// Start runs the background writer.
+func (b *Buffer) Start() {
+	go b.run()
+}
+
+// Add queues a position for a ride. It never blocks on the database.
+func (b *Buffer) Add(rideID int64, position models.Position) {
+	b.mu.Lock()
+	b.pending = append(b.pending, database.RidePosition{RideID: rideID, Position: position})
+	full := len(b.pending) >= b.opts.BatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes everything queued so far, ignoring any retry backoff. It blocks until
+// the store has the points, or fails, so GPS processing must use OnDrained instead.
+func (b *Buffer) Flush() error {
+	return b.flush()
+}
+
+// OnDrained calls fn once every position queued so far has been written, e.g. to compute
+// stats from them when a ride ends. fn runs on the goroutine that wrote the last point, with
+// no lock held. Callbacks still waiting when the buffer is closed are dropped.
+func (b *Buffer) OnDrained(fn func()) {
+	b.mu.Lock()
+	b.drained = append(b.drained, fn)
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the background writer, makes a last attempt to write everything and
+// spills whatever is left to disk.
+func (b *Buffer) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+
+	err := b.flush()
+	if err == nil {
+		return nil
+	}
+
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	if b.queue == nil {
+		return fmt.Errorf("%d positions could not be written and no position_queue_path is set: %w", len(b.pending), err)
+	}
+	if spillErr := b.queue.Append(b.pending); spillErr != nil {
+		return fmt.Errorf("failed to spill %d positions to disk: %w", len(b.pending), spillErr)
+	}
+	log.Printf("Position buffer: spilled %d unwritten points to %s", len(b.pending), b.queue.path)
+	b.onDisk += len(b.pending)
+	b.pending = nil
+	if len(b.drained) > 0 {
+		log.Printf("Position buffer: dropped %d callbacks waiting for points to be written", len(b.drained))
+		b.drained = nil
+	}
+	return nil
+}
+
+// Stats returns the current queue depth and write status.
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := Stats{
+		QueueDepth:          len(b.pending) + b.onDisk,
+		InMemory:            len(b.pending),
+		OnDisk:              b.onDisk,
+		ConsecutiveFailures: b.failures,
+		LastFlushAt:         b.lastFlushAt,
+		NextRetryAt:         b.nextRetryAt,
+	}
+	if b.lastErr != nil {
+		stats.LastError = b.lastErr.Error()
+	}
+	return stats
+}
+
+func (b *Buffer) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		case <-b.wake:
+		}
+
+		b.mu.Lock()
+		backingOff := time.Now().Before(b.nextRetryAt)
+		b.mu.Unlock()
+		if backingOff {
+			continue
+		}
+
+		if err := b.flush(); err != nil {
+			stats := b.Stats()
+			log.Printf("Position buffer: write failed, %d points queued (%d on disk), retrying at %v: %v",
+				stats.QueueDepth, stats.OnDisk, stats.NextRetryAt.Format(time.RFC3339), err)
+		}
+	}
+}
+
+// flush writes the disk queue and then the in-memory points, oldest first.
+func (b *Buffer) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	// Spilled points are older than anything in memory, so they go first
+	if err := b.flushDisk(); err != nil {
+		b.spillOverflow()
+		return b.failed(err)
+	}
+
+	for {
+		b.mu.Lock()
+		n := len(b.pending)
+		if n > b.opts.BatchSize {
+			n = b.opts.BatchSize
+		}
+		batch := b.pending[:n:n]
+		b.mu.Unlock()
+		if n == 0 {
+			break
+		}
+
+		if err := b.store.AddPositions(batch); err != nil {
+			b.spillOverflow()
+			return b.failed(err)
+		}
+		// Add only appends, so the batch is still at the front of pending
+		b.mu.Lock()
+		b.pending = b.pending[n:]
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	if b.failures > 0 {
+		log.Printf("Position buffer: writes recovered after %d failed attempts", b.failures)
+	}
+	b.failures = 0
+	b.lastErr = nil
+	b.nextRetryAt = time.Time{}
+	b.lastFlushAt = time.Now().UTC()
+	// Points added since the loop above are not written yet, and may have been queued
+	// before a callback registered meanwhile, so callbacks wait for a flush that ends empty
+	var drained []func()
+	if len(b.pending) == 0 && b.onDisk == 0 {
+		drained, b.drained = b.drained, nil
+	}
+	b.mu.Unlock()
+
+	for _, fn := range drained {
+		fn()
+	}
+	return nil
+}
+
+// flushDisk writes the on-disk queue in batches, keeping whatever is left on failure.
+func (b *Buffer) flushDisk() error {
+	b.mu.Lock()
+	onDisk := b.onDisk
+	b.mu.Unlock()
+	if b.queue == nil || onDisk == 0 {
+		return nil
+	}
+
+	spilled, err := b.queue.Load()
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(spilled); start += b.opts.BatchSize {
+		end := start + b.opts.BatchSize
+		if end > len(spilled) {
+			end = len(spilled)
+		}
+		if err := b.store.AddPositions(spilled[start:end]); err != nil {
+			if start > 0 {
+				if rewriteErr := b.queue.Replace(spilled[start:]); rewriteErr != nil {
+					// The written points would be replayed; better duplicates than losing the rest
+					log.Printf("Position buffer: failed to rewrite %s: %v", b.queue.path, rewriteErr)
+					return err
+				}
+				b.setOnDisk(len(spilled) - start)
+			}
+			return err
+		}
+	}
+
+	if err := b.queue.Replace(nil); err != nil {
+		return err
+	}
+	b.setOnDisk(0)
+	log.Printf("Position buffer: wrote %d points from %s", len(spilled), b.queue.path)
+	return nil
+}
+
+// spillOverflow moves the in-memory points to disk once there are more than MaxMemoryPoints.
+func (b *Buffer) spillOverflow() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queue == nil || b.opts.MaxMemoryPoints <= 0 || len(b.pending) <= b.opts.MaxMemoryPoints {
+		return
+	}
+	if err := b.queue.Append(b.pending); err != nil {
+		log.Printf("Position buffer: failed to spill %d points to disk, keeping them in memory: %v", len(b.pending), err)
+		return
+	}
+	log.Printf("Position buffer: spilled %d points to %s", len(b.pending), b.queue.path)
+	b.onDisk += len(b.pending)
+	b.pending = nil
+}
+
+// failed records a write failure and schedules the next retry with exponential backoff.
+func (b *Buffer) failed(err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastErr = err
+	backoff := b.opts.FlushInterval
+	for i := 1; i < b.failures && backoff < b.opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > b.opts.MaxBackoff {
+		backoff = b.opts.MaxBackoff
+	}
+	b.nextRetryAt = time.Now().Add(backoff)
+	return err
+}
+
+func (b *Buffer) setOnDisk(n int) {
+	b.mu.Lock()
+	b.onDisk = n
+	b.mu.Unlock()
+}
@@ -0,0 +1,113 @@
+package writebuffer
+
+import (
+	"b3/server/database"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// diskQueue is an append-only file of positions, one JSON object per line.
+// It is only used with Buffer.flushMu held.
+type diskQueue struct {
+	path string
+}
+
+// Append adds positions to the end of the queue and syncs the file.
+func (q *diskQueue) Append(positions []database.RidePosition) error {
+	if dir := filepath.Dir(q.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create position queue directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open position queue: %w", err)
+	}
+	if err := writePositions(f, positions); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads every queued position, oldest first. A missing file is an empty queue.
+func (q *diskQueue) Load() ([]database.RidePosition, error) {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open position queue: %w", err)
+	}
+	defer f.Close()
+
+	var positions []database.RidePosition
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rp database.RidePosition
+		if err := json.Unmarshal(scanner.Bytes(), &rp); err != nil {
+			// Most likely a line torn by a crash mid-append; the other lines are still intact
+			log.Printf("Position buffer: skipping unreadable line %d of %s: %v", line, q.path, err)
+			continue
+		}
+		positions = append(positions, rp)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read position queue: %w", err)
+	}
+	return positions, nil
+}
+
+// Replace atomically swaps the queue contents for positions. An empty slice removes the file.
+func (q *diskQueue) Replace(positions []database.RidePosition) error {
+	if len(positions) == 0 {
+		if err := os.Remove(q.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear position queue: %w", err)
+		}
+		return nil
+	}
+
+	tmpPath := q.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create position queue: %w", err)
+	}
+	if err := writePositions(f, positions); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write position queue: %w", err)
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		return fmt.Errorf("failed to replace position queue: %w", err)
+	}
+	return nil
+}
+
+func writePositions(f *os.File, positions []database.RidePosition) error {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rp := range positions {
+		if err := enc.Encode(rp); err != nil {
+			return fmt.Errorf("failed to encode queued position: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write position queue: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync position queue: %w", err)
+	}
+	return nil
+}
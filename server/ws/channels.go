@@ -0,0 +1,137 @@
+package ws
+
+import (
+	"b3/server/auth"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Channels a client can subscribe to. Every message is sent on exactly one of them.
+const (
+	ChannelLocation = "location" // current_location, GEOFENCE_ENTER and GEOFENCE_EXIT
+	ChannelAlerts   = "alerts"   // ALERT_*, CRASH_DETECTED and THEFT_INCIDENT_*
+	ChannelLock     = "lock"     // LOCK_COMMAND_UPDATE
+	ChannelAllRides = "ride:*"   // Every ride:<id> channel
+)
+
+// ridePrefix starts the channel of a single ride, which carries its RIDE_STARTED,
+// RIDE_POSITION_UPDATE and RIDE_ENDED messages.
+const ridePrefix = "ride:"
+
+// defaultChannels are the subscriptions of a client that did not ask for any, so clients
+// predating subscriptions keep receiving everything their token allows.
+var defaultChannels = []string{ChannelLocation, ChannelAllRides, ChannelAlerts, ChannelLock}
+
+// maxThrottle is the longest current_location throttle a client can ask for.
+const maxThrottle = time.Hour
+
+// RideChannel returns the channel of a ride.
+func RideChannel(rideID int64) string {
+	return ridePrefix + strconv.FormatInt(rideID, 10)
+}
+
+// validateChannel checks that a channel exists.
+func validateChannel(channel string) error {
+	switch channel {
+	case ChannelLocation, ChannelAlerts, ChannelLock, ChannelAllRides:
+		return nil
+	}
+	if id, ok := strings.CutPrefix(channel, ridePrefix); ok {
+		if rideID, err := strconv.ParseInt(id, 10, 64); err == nil && rideID > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown channel %q, must be %s, %s, %s or ride:<id>", channel, ChannelLocation, ChannelAlerts, ChannelLock)
+}
+
+// channelScope returns the scope a token needs to subscribe to a channel.
+func channelScope(channel string) string {
+	switch channel {
+	case ChannelLocation:
+		return auth.ScopeDevicesRead
+	case ChannelAlerts:
+		return auth.ScopeAlertsRead
+	case ChannelLock:
+		return auth.ScopeLockRead
+	}
+	return auth.ScopeRidesRead
+}
+
+// authorizeChannel checks that principal may subscribe to a valid channel.
+func authorizeChannel(principal *auth.Principal, channel string) error {
+	if scope := channelScope(channel); !principal.Can(scope) {
+		return fmt.Errorf("token lacks the %s scope needed for channel %s", scope, channel)
+	}
+	return nil
+}
+
+// allowedChannels returns the default channels principal may subscribe to.
+func allowedChannels(principal *auth.Principal) []string {
+	var channels []string
+	for _, channel := range defaultChannels {
+		if authorizeChannel(principal, channel) == nil {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// parseChannels reads a comma-separated list of channels, e.g. from the channels query parameter.
+func parseChannels(list string) ([]string, error) {
+	var channels []string
+	for _, channel := range strings.Split(list, ",") {
+		if channel = strings.TrimSpace(channel); channel == "" {
+			continue
+		}
+		if err := validateChannel(channel); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// parseThrottle validates a current_location throttle in milliseconds.
+func parseThrottle(millis int) (time.Duration, error) {
+	throttle := time.Duration(millis) * time.Millisecond
+	if throttle < 0 || throttle > maxThrottle {
+		return 0, fmt.Errorf("throttle_ms must be between 0 and %d", maxThrottle.Milliseconds())
+	}
+	return throttle, nil
+}
+
+// subscribed reports whether the client receives messages sent on channel. It assumes
+// the hub's lock is held.
+func (c *Client) subscribed(channel string) bool {
+	if c.channels[channel] {
+		return true
+	}
+	return strings.HasPrefix(channel, ridePrefix) && c.channels[ChannelAllRides]
+}
+
+// subscriptions returns the client's channels, sorted. It assumes the hub's lock is held.
+func (c *Client) subscriptions() []string {
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	slices.Sort(channels)
+	return channels
+}
+
+// throttled reports whether a current_location of deviceID should be skipped because the
+// client got one for that device less than its throttle ago, and otherwise records that it
+// is sent now. It assumes the hub's lock is held.
+func (c *Client) throttled(deviceID string, now time.Time) bool {
+	if c.throttle <= 0 {
+		return false
+	}
+	if last, ok := c.lastLocation[deviceID]; ok && now.Sub(last) < c.throttle {
+		return true
+	}
+	c.lastLocation[deviceID] = now
+	return false
+}
@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"b3/server/auth"
+	"encoding/json"
 	"log"
 	"time"
 
@@ -21,6 +23,60 @@ type Client struct {
 	conn *websocket.Conn
 	// Buffered channel of outbound messages.
 	send chan []byte
+	// Who opened the connection. Only messages about devices they may access are sent.
+	principal *auth.Principal
+	// Device the client follows. Empty means every device.
+	deviceID string
+	// Theft incident the client follows. When set, the client only receives that incident's messages.
+	incidentID int64
+
+	// The fields below are guarded by the hub's lock.
+
+	// Channels the client subscribed to.
+	channels map[string]bool
+	// Minimum time between two current_location messages of the same device. Zero sends every update.
+	throttle time.Duration
+	// When the last current_location of each device was sent, for throttling.
+	lastLocation map[string]time.Time
+	// Set once the hub closed send.
+	closed bool
+}
+
+// Command is a message sent by a client to change its subscriptions, e.g.
+// {"action":"subscribe","channels":["ride:42","alerts"]}.
+type Command struct {
+	Action   string   `json:"action"`   // "subscribe" or "unsubscribe"
+	Channel  string   `json:"channel"`  // A single channel
+	Channels []string `json:"channels"` // Several channels at once
+	// Minimum time between two current_location messages of the same device, set when
+	// subscribing. Left out, the current throttle is kept; 0 sends every update.
+	ThrottleMs *int `json:"throttle_ms"`
+}
+
+// wants reports whether a message on channel about deviceID, and incidentID if it belongs
+// to a theft incident, should be sent to this client. Messages that are not tied to a device
+// are sent to every admin subscriber not following an incident. It assumes the hub's lock
+// is held.
+func (c *Client) wants(channel, deviceID string, incidentID int64) bool {
+	if !c.subscribed(channel) {
+		return false
+	}
+	if deviceID == "" {
+		if !c.principal.IsAdmin() {
+			return false
+		}
+	} else if !c.principal.CanAccessDevice(deviceID) {
+		return false
+	}
+	if c.incidentID != 0 {
+		return incidentID == c.incidentID
+	}
+	return c.deviceID == "" || deviceID == "" || c.deviceID == deviceID
+}
+
+// expired reports whether the session or API token the client connected with has expired.
+func (c *Client) expired(now time.Time) bool {
+	return !c.principal.Token.ExpiresAt.IsZero() && now.After(c.principal.Token.ExpiresAt)
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -44,7 +100,12 @@ func (c *Client) readPump() {
 			}
 			break
 		}
-		log.Printf("Received unexpected message from client: %s", message)
+		var command Command
+		if err := json.Unmarshal(message, &command); err != nil {
+			c.hub.reply(c, "ERROR", ErrorPayload{Error: "invalid command: " + err.Error()})
+			continue
+		}
+		c.hub.apply(c, command)
 	}
 }
 
@@ -1,10 +1,16 @@
 package ws
 
 import (
+	"b3/server/auth"
 	"b3/server/models"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,8 +25,12 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// Hub maintains the set of active clients and broadcasts messages to the
+// Hub maintains the set of active clients and broadcasts messages to the clients
+// subscribed to their channel.
 type Hub struct {
+	// Guards clients and their subscriptions, which are used from the broadcasting goroutines.
+	mu sync.Mutex
+
 	// Registered clients.
 	clients map[*Client]bool
 
@@ -45,52 +55,262 @@ func (h *Hub) Run() {
 	for {
 		select {
 		case client := <-h.register:
-			h.clients[client] = true
+			h.mu.Lock()
+			if !client.closed {
+				h.clients[client] = true
+			}
+			h.mu.Unlock()
 			log.Println("Client registered to hub")
 		case client := <-h.unregister:
+			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+				h.remove(client)
 				log.Println("Client unregistered from hub")
 			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// BroadcastMessage sends a message to all clients subscribed to channel.
+func (h *Hub) BroadcastMessage(channel, messageType string, payload interface{}) {
+	h.BroadcastDeviceMessage(channel, "", messageType, payload)
+}
+
+// BroadcastDeviceMessage sends a message about a single device to every client subscribed
+// to channel and following that device, or following all devices.
+func (h *Hub) BroadcastDeviceMessage(channel, deviceID, messageType string, payload interface{}) {
+	h.broadcast(channel, deviceID, 0, messageType, payload)
+}
+
+// broadcast sends a message to the clients that want it; see Client.wants. current_location
+// messages are skipped for clients that got one for the device within their throttle.
+func (h *Hub) broadcast(channel, deviceID string, incidentID int64, messageType string, payload interface{}) {
+	now := time.Now().UTC()
+	jsonMessage, err := encodeMessage(messageType, deviceID, payload, now)
+	if err != nil {
+		log.Printf("Error marshalling broadcast message: %v", err)
+		return
+	}
+	throttled := messageType == "current_location"
+
+	log.Printf("Broadcasting message on %s: %s", channel, string(jsonMessage))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if client.expired(now) {
+			log.Printf("Closing WebSocket connection of user %d, its token expired.", client.principal.User.ID)
+			h.remove(client)
+			continue
+		}
+		if !client.wants(channel, deviceID, incidentID) {
+			continue
 		}
+		if throttled && client.throttled(deviceID, now) {
+			continue
+		}
+		h.deliver(client, jsonMessage)
 	}
 }
 
-// BroadcastMessage sends a message to all connected clients.
-func (h *Hub) BroadcastMessage(messageType string, payload interface{}) {
+func encodeMessage(messageType, deviceID string, payload interface{}, now time.Time) ([]byte, error) {
 	message := map[string]interface{}{
 		"type":      messageType,
 		"payload":   payload,
-		"timestamp": time.Now().UTC(),
+		"timestamp": now,
+	}
+	if deviceID != "" {
+		message["device_id"] = deviceID
+	}
+	return json.Marshal(message)
+}
+
+// deliver queues a message for a client. It assumes the hub's lock is held.
+func (h *Hub) deliver(client *Client, message []byte) {
+	select {
+	case client.send <- message:
+	default: // If client's send buffer is full, assume it's dead/stuck.
+		log.Printf("Client send channel full or closed. Unregistering client.")
+		h.remove(client) // Important to prevent leaks and repeated attempts
 	}
-	jsonMessage, err := json.Marshal(message)
+}
+
+// remove unregisters a client and closes its send channel, which makes its writePump close
+// the connection. It assumes the hub's lock is held.
+func (h *Hub) remove(client *Client) {
+	delete(h.clients, client)
+	close(client.send)
+	client.closed = true
+}
+
+// DisconnectToken closes the connections opened with a session or API token, e.g. once it
+// is revoked.
+func (h *Hub) DisconnectToken(tokenID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		if client.principal.Token.ID == tokenID {
+			log.Printf("Closing WebSocket connection of user %d, its token was revoked.", client.principal.User.ID)
+			h.remove(client)
+		}
+	}
+}
+
+// SubscriptionPayload is the payload of the SUBSCRIBED and UNSUBSCRIBED replies to a
+// client's commands: every channel the client is now subscribed to.
+type SubscriptionPayload struct {
+	Channels   []string `json:"channels"`
+	ThrottleMs int64    `json:"throttle_ms"`
+}
+
+// ErrorPayload is the payload of the ERROR reply to a command that was not applied.
+type ErrorPayload struct {
+	Error string `json:"error"`
+}
+
+// reply sends a message to a single client, unless the hub closed it meanwhile. The client
+// may not be registered yet when its first command arrives.
+func (h *Hub) reply(client *Client, messageType string, payload interface{}) {
+	jsonMessage, err := encodeMessage(messageType, "", payload, time.Now().UTC())
 	if err != nil {
-		log.Printf("Error marshalling broadcast message: %v", err)
+		log.Printf("Error marshalling reply: %v", err)
 		return
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !client.closed {
+		h.deliver(client, jsonMessage)
+	}
+}
 
-	log.Printf("Broadcasting message: %s", string(jsonMessage))
-	for client := range h.clients {
-		select {
-		case client.send <- jsonMessage:
-		default: // If client's send buffer is full, assume it's dead/stuck.
-			log.Printf("Client send channel full or closed. Unregistering client.")
-			close(client.send)
-			delete(h.clients, client) // Important to prevent leaks and repeated attempts
+// apply changes a client's subscriptions as asked by a command and replies with the
+// subscriptions that result, or with an ERROR leaving them unchanged.
+func (h *Hub) apply(client *Client, command Command) {
+	channels := command.Channels
+	if command.Channel != "" {
+		channels = append(channels, command.Channel)
+	}
+	err := validateCommand(client.principal, command, channels)
+	if err != nil {
+		h.reply(client, "ERROR", ErrorPayload{Error: err.Error()})
+		return
+	}
+
+	h.mu.Lock()
+	replyType := "SUBSCRIBED"
+	for _, channel := range channels {
+		if command.Action == "subscribe" {
+			client.channels[channel] = true
+		} else {
+			replyType = "UNSUBSCRIBED"
+			delete(client.channels, channel)
 		}
 	}
+	if command.ThrottleMs != nil {
+		// Validated above
+		client.throttle, _ = parseThrottle(*command.ThrottleMs)
+	}
+	payload := SubscriptionPayload{Channels: client.subscriptions(), ThrottleMs: client.throttle.Milliseconds()}
+	h.mu.Unlock()
+
+	h.reply(client, replyType, payload)
 }
 
-// ServeWs handles websocket requests from the peer.
-func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
+// validateCommand checks a command's action, the channels it names and its throttle, and
+// that principal may subscribe to the channels.
+func validateCommand(principal *auth.Principal, command Command, channels []string) error {
+	switch command.Action {
+	case "subscribe":
+		if command.ThrottleMs != nil {
+			if _, err := parseThrottle(*command.ThrottleMs); err != nil {
+				return err
+			}
+		}
+	case "unsubscribe":
+		if command.ThrottleMs != nil {
+			return errors.New("throttle_ms can only be set when subscribing")
+		}
+	default:
+		return fmt.Errorf("unknown action %q, must be subscribe or unsubscribe", command.Action)
+	}
+	if len(channels) == 0 && command.ThrottleMs == nil {
+		return errors.New("channel or channels is required")
+	}
+	for _, channel := range channels {
+		if err := validateChannel(channel); err != nil {
+			return err
+		}
+		if command.Action == "subscribe" {
+			if err := authorizeChannel(principal, channel); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ServeWs handles websocket requests from the peer, authenticated as principal.
+// An optional device_id query parameter limits the feed to a single device, and an
+// optional incident_id to the live updates of one theft incident; the caller checks that
+// principal may access them. An optional channels query parameter, e.g.
+// channels=location,ride:42, sets the initial subscriptions, which default to every channel
+// principal may use, and throttle_ms the current_location throttle.
+func ServeWs(hub *Hub, principal *auth.Principal, w http.ResponseWriter, r *http.Request) {
+	var incidentID int64
+	if value := r.URL.Query().Get("incident_id"); value != "" {
+		var err error
+		if incidentID, err = strconv.ParseInt(value, 10, 64); err != nil || incidentID <= 0 {
+			http.Error(w, "invalid incident_id", http.StatusBadRequest)
+			return
+		}
+	}
+	channels := allowedChannels(principal)
+	if r.URL.Query().Has("channels") {
+		var err error
+		if channels, err = parseChannels(r.URL.Query().Get("channels")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, channel := range channels {
+			if err := authorizeChannel(principal, channel); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+	}
+	var throttle time.Duration
+	if value := r.URL.Query().Get("throttle_ms"); value != "" {
+		millis, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil {
+			throttle, err = parseThrottle(millis)
+		}
+		if err != nil {
+			http.Error(w, "invalid throttle_ms", http.StatusBadRequest)
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
 		return
 	}
 
-	client := &Client{hub: hub, conn: conn, send: make(chan []byte, 256)}
+	deviceID := r.URL.Query().Get("device_id")
+	client := &Client{
+		hub:          hub,
+		conn:         conn,
+		send:         make(chan []byte, 256),
+		principal:    principal,
+		deviceID:     deviceID,
+		incidentID:   incidentID,
+		channels:     make(map[string]bool, len(channels)),
+		throttle:     throttle,
+		lastLocation: make(map[string]time.Time),
+	}
+	for _, channel := range channels {
+		client.channels[channel] = true
+	}
 	hub.register <- client
 
 	go client.writePump()
@@ -100,6 +320,7 @@ func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 
 // RideEventPayload is a generic structure for ride event payloads
 type RideEventPayload struct {
+	DeviceID  string           `json:"device_id"`
 	RideID    int64            `json:"ride_id"`
 	RideName  string           `json:"ride_name,omitempty"`
 	Timestamp time.Time        `json:"timestamp"`
@@ -107,42 +328,91 @@ type RideEventPayload struct {
 }
 
 // BroadcastRideStarted sends a message when a new ride starts.
-func (h *Hub) BroadcastRideStarted(rideID int64, rideName string, startTime time.Time, initialPosition models.Position) {
+func (h *Hub) BroadcastRideStarted(deviceID string, rideID int64, rideName string, startTime time.Time, initialPosition models.Position) {
 	payload := RideEventPayload{
+		DeviceID:  deviceID,
 		RideID:    rideID,
 		RideName:  rideName,
 		Timestamp: startTime,
 		Position:  &initialPosition,
 	}
-	h.BroadcastMessage("RIDE_STARTED", payload)
+	h.BroadcastDeviceMessage(RideChannel(rideID), deviceID, "RIDE_STARTED", payload)
 }
 
 // BroadcastRideEnded sends a message when a ride ends.
-func (h *Hub) BroadcastRideEnded(rideID int64, endTime time.Time) {
+func (h *Hub) BroadcastRideEnded(deviceID string, rideID int64, endTime time.Time) {
 	payload := RideEventPayload{
+		DeviceID:  deviceID,
 		RideID:    rideID,
 		Timestamp: endTime,
 	}
-	h.BroadcastMessage("RIDE_ENDED", payload)
+	h.BroadcastDeviceMessage(RideChannel(rideID), deviceID, "RIDE_ENDED", payload)
 }
 
 // BroadcastRidePositionAdded sends a message when a new position is added to an ongoing ride.
-func (h *Hub) BroadcastRidePositionAdded(rideID int64, position models.Position) {
+func (h *Hub) BroadcastRidePositionAdded(deviceID string, rideID int64, position models.Position) {
 	payload := RideEventPayload{
+		DeviceID:  deviceID,
 		RideID:    rideID,
 		Timestamp: position.Timestamp,
 		Position:  &position,
 	}
-	h.BroadcastMessage("RIDE_POSITION_UPDATE", payload)
+	h.BroadcastDeviceMessage(RideChannel(rideID), deviceID, "RIDE_POSITION_UPDATE", payload)
 }
 
 // BroadcastCurrentLocation sends a message for every valid GPS update received from MQTT.
-func (h *Hub) BroadcastCurrentLocation(position models.Position) {
+func (h *Hub) BroadcastCurrentLocation(deviceID string, position models.Position) {
 	payload := models.WSLocationPayload{
+		DeviceID:   deviceID,
 		Latitude:   position.Latitude,
 		Longitude:  position.Longitude,
 		Timestamp:  position.Timestamp,
 		SpeedKnots: position.SpeedKnots,
 	}
-	h.BroadcastMessage("current_location", payload)
+	h.BroadcastDeviceMessage(ChannelLocation, deviceID, "current_location", payload)
+}
+
+// BroadcastLockCommand sends a message when a lock command is created, confirmed or times out.
+func (h *Hub) BroadcastLockCommand(command models.LockCommand) {
+	h.BroadcastDeviceMessage(ChannelLock, command.DeviceID, "LOCK_COMMAND_UPDATE", command)
+}
+
+// BroadcastGeofenceEvent sends a GEOFENCE_ENTER or GEOFENCE_EXIT message when a device crosses a geofence boundary.
+func (h *Hub) BroadcastGeofenceEvent(event models.GeofenceEvent) {
+	messageType := "GEOFENCE_ENTER"
+	if event.Type == models.GeofenceExit {
+		messageType = "GEOFENCE_EXIT"
+	}
+	h.BroadcastDeviceMessage(ChannelLocation, event.DeviceID, messageType, event)
+}
+
+// BroadcastCrashDetected sends a CRASH_DETECTED message when a device reports a crash.
+func (h *Hub) BroadcastCrashDetected(event models.CrashEvent) {
+	h.BroadcastDeviceMessage(ChannelAlerts, event.DeviceID, "CRASH_DETECTED", event)
+}
+
+// BroadcastAlert sends ALERT_PENDING, ALERT_OPENED, ALERT_CANCELLED, ALERT_ACKNOWLEDGED or
+// ALERT_RESOLVED when an alert changes state. ALERT_PENDING asks the rider to cancel a
+// crash alert if they are OK.
+func (h *Hub) BroadcastAlert(messageType string, alert models.Alert) {
+	h.BroadcastDeviceMessage(ChannelAlerts, alert.DeviceID, messageType, alert)
+}
+
+// IncidentPositionPayload is the payload of a THEFT_INCIDENT_POSITION message.
+type IncidentPositionPayload struct {
+	IncidentID int64           `json:"incident_id"`
+	DeviceID   string          `json:"device_id"`
+	Position   models.Position `json:"position"`
+}
+
+// BroadcastTheftIncident sends THEFT_INCIDENT_OPENED or THEFT_INCIDENT_RESOLVED to the
+// clients following the device or the incident.
+func (h *Hub) BroadcastTheftIncident(messageType string, incident models.TheftIncident) {
+	h.broadcast(ChannelAlerts, incident.DeviceID, incident.ID, messageType, incident)
+}
+
+// BroadcastIncidentPosition sends every position recorded for an open theft incident.
+func (h *Hub) BroadcastIncidentPosition(deviceID string, incidentID int64, position models.Position) {
+	payload := IncidentPositionPayload{IncidentID: incidentID, DeviceID: deviceID, Position: position}
+	h.broadcast(ChannelAlerts, deviceID, incidentID, "THEFT_INCIDENT_POSITION", payload)
 }